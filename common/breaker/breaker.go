@@ -0,0 +1,161 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 熔断器状态
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// Config 熔断参数
+type Config struct {
+	FailureThreshold   int     // 连续失败次数达到该值时熔断
+	ErrorRateThreshold float64 // 滑动窗口内错误率达到该值时熔断
+	MinRequests        int     // 计算错误率所需的最少请求数
+	WindowSeconds      int     // 滑动窗口长度
+	CooldownSeconds    int     // 熔断后的冷却时间，结束后进入半开状态
+	HalfOpenProbes     int     // 半开状态允许的探测请求数，全部成功后恢复
+}
+
+// Breaker 熔断器，单机使用内存实现，启用 Redis 时多节点共享状态
+type Breaker interface {
+	// Allow 判断 key 当前是否可以接收请求，不改变状态
+	Allow(ctx context.Context, keys []string, cfg Config) []bool
+	// Acquire 请求实际发出前调用，冷却结束时切换到半开状态并占用一个探测名额，
+	// 半开状态的探测名额已满时返回 ErrProbesExhausted
+	Acquire(ctx context.Context, key string, cfg Config) error
+	// Record 记录请求结果，返回状态变化前后的值
+	Record(ctx context.Context, key string, success bool, cfg Config) (from string, to string, err error)
+	// Release 请求被主动取消时调用，释放占用的探测名额，结果不计入成功或失败
+	Release(ctx context.Context, key string, cfg Config) error
+}
+
+// ErrProbesExhausted 半开状态下探测名额已被其他请求占满
+var ErrProbesExhausted = errors.New("circuit breaker half-open probes exhausted")
+
+var (
+	instance Breaker
+	once     sync.Once
+)
+
+// Get 获取全局熔断器
+func Get() Breaker {
+	once.Do(func() {
+		if common.RedisEnabled && common.RDB != nil {
+			instance = newRedisBreaker(common.RDB)
+		} else {
+			instance = newMemoryBreaker()
+		}
+	})
+	return instance
+}
+
+// state 单个 key 的熔断状态，滑动窗口用前后两个固定窗口近似
+type state struct {
+	State         string
+	OpenUntil     int64 // 毫秒
+	Consecutive   int
+	WindowStart   int64 // 毫秒
+	CurTotal      int
+	CurFailures   int
+	PrevTotal     int
+	PrevFailures  int
+	ProbeInFlight int
+	ProbeSuccess  int
+}
+
+func (s *state) allow(now int64, cfg Config) bool {
+	switch s.State {
+	case StateOpen:
+		return now >= s.OpenUntil
+	case StateHalfOpen:
+		return s.ProbeInFlight < cfg.HalfOpenProbes
+	}
+	return true
+}
+
+// acquire 占用探测名额，半开状态下名额已满时返回 false
+func (s *state) acquire(now int64, cfg Config) bool {
+	if s.State == StateOpen && now >= s.OpenUntil {
+		s.State = StateHalfOpen
+		s.ProbeInFlight = 0
+		s.ProbeSuccess = 0
+	}
+	if s.State == StateHalfOpen {
+		if s.ProbeInFlight >= cfg.HalfOpenProbes {
+			return false
+		}
+		s.ProbeInFlight++
+	}
+	return true
+}
+
+func (s *state) release() {
+	if s.State == StateHalfOpen && s.ProbeInFlight > 0 {
+		s.ProbeInFlight--
+	}
+}
+
+func (s *state) open(now int64, cfg Config) {
+	*s = state{
+		State:       StateOpen,
+		OpenUntil:   now + int64(cfg.CooldownSeconds)*1000,
+		WindowStart: now,
+	}
+}
+
+func (s *state) record(success bool, now int64, cfg Config) {
+	switch s.State {
+	case StateHalfOpen:
+		if s.ProbeInFlight > 0 {
+			s.ProbeInFlight--
+		}
+		if !success {
+			s.open(now, cfg)
+			return
+		}
+		s.ProbeSuccess++
+		if s.ProbeSuccess >= cfg.HalfOpenProbes {
+			*s = state{State: StateClosed, WindowStart: now}
+		}
+		return
+	case StateOpen:
+		// 熔断前发出的请求，结果不再计入
+		return
+	}
+
+	s.State = StateClosed
+	windowMs := int64(cfg.WindowSeconds) * 1000
+	if now-s.WindowStart >= 2*windowMs {
+		s.WindowStart, s.CurTotal, s.CurFailures, s.PrevTotal, s.PrevFailures = now, 0, 0, 0, 0
+	} else if now-s.WindowStart >= windowMs {
+		s.WindowStart, s.PrevTotal, s.PrevFailures, s.CurTotal, s.CurFailures = s.WindowStart+windowMs, s.CurTotal, s.CurFailures, 0, 0
+	}
+	s.CurTotal++
+	if success {
+		s.Consecutive = 0
+		return
+	}
+	s.CurFailures++
+	s.Consecutive++
+
+	if cfg.FailureThreshold > 0 && s.Consecutive >= cfg.FailureThreshold {
+		s.open(now, cfg)
+		return
+	}
+	// 上一窗口按剩余时间比例计入，近似滑动窗口
+	weight := 1 - float64(now-s.WindowStart)/float64(windowMs)
+	total := float64(s.CurTotal) + float64(s.PrevTotal)*weight
+	failures := float64(s.CurFailures) + float64(s.PrevFailures)*weight
+	if cfg.ErrorRateThreshold > 0 && total >= float64(cfg.MinRequests) && total > 0 && failures/total >= cfg.ErrorRateThreshold {
+		s.open(now, cfg)
+	}
+}
@@ -0,0 +1,130 @@
+-- 熔断器，逻辑与内存实现保持一致
+-- KEYS[1]: 熔断器唯一标识
+-- ARGV[1]: 操作类型 acquire / record / release
+-- ARGV[2]: 当前时间（毫秒）
+-- ARGV[3]: 请求是否成功 (1/0)
+-- ARGV[4]: 连续失败阈值
+-- ARGV[5]: 错误率阈值
+-- ARGV[6]: 计算错误率的最少请求数
+-- ARGV[7]: 滑动窗口长度（毫秒）
+-- ARGV[8]: 冷却时间（毫秒）
+-- ARGV[9]: 半开状态探测请求数
+-- ARGV[10]: 状态过期时间（毫秒）
+-- 返回: { 变化前状态, 变化后状态, acquire 是否占用到探测名额 (1/0) }
+
+local key = KEYS[1]
+local op = ARGV[1]
+local now = tonumber(ARGV[2])
+local success = ARGV[3] == '1'
+local failureThreshold = tonumber(ARGV[4])
+local errorRateThreshold = tonumber(ARGV[5])
+local minRequests = tonumber(ARGV[6])
+local windowMs = tonumber(ARGV[7])
+local cooldownMs = tonumber(ARGV[8])
+local halfOpenProbes = tonumber(ARGV[9])
+local ttl = tonumber(ARGV[10])
+
+local s = redis.call('HMGET', key, 'state', 'open_until', 'consecutive', 'window_start',
+    'cur_total', 'cur_failures', 'prev_total', 'prev_failures', 'probe_in_flight', 'probe_success')
+local state = s[1] or 'closed'
+local openUntil = tonumber(s[2]) or 0
+local consecutive = tonumber(s[3]) or 0
+local windowStart = tonumber(s[4]) or now
+local curTotal = tonumber(s[5]) or 0
+local curFailures = tonumber(s[6]) or 0
+local prevTotal = tonumber(s[7]) or 0
+local prevFailures = tonumber(s[8]) or 0
+local probeInFlight = tonumber(s[9]) or 0
+local probeSuccess = tonumber(s[10]) or 0
+local from = state
+
+-- 关闭状态下的 acquire 和 release 无需写入
+if (op == 'acquire' or op == 'release') and state == 'closed' then
+    return { from, state, '1' }
+end
+
+local function reset(newState)
+    state = newState
+    openUntil = 0
+    consecutive = 0
+    windowStart = now
+    curTotal = 0
+    curFailures = 0
+    prevTotal = 0
+    prevFailures = 0
+    probeInFlight = 0
+    probeSuccess = 0
+end
+
+local function open()
+    reset('open')
+    openUntil = now + cooldownMs
+end
+
+if op == 'acquire' then
+    if state == 'open' and now >= openUntil then
+        state = 'half_open'
+        probeInFlight = 0
+        probeSuccess = 0
+    end
+    if state == 'half_open' then
+        -- 探测名额已满，不占用也不写入
+        if probeInFlight >= halfOpenProbes then
+            return { from, state, '0' }
+        end
+        probeInFlight = probeInFlight + 1
+    end
+elseif op == 'release' then
+    if state == 'half_open' and probeInFlight > 0 then
+        probeInFlight = probeInFlight - 1
+    end
+elseif op == 'record' then
+    if state == 'half_open' then
+        if probeInFlight > 0 then
+            probeInFlight = probeInFlight - 1
+        end
+        if not success then
+            open()
+        else
+            probeSuccess = probeSuccess + 1
+            if probeSuccess >= halfOpenProbes then
+                reset('closed')
+            end
+        end
+    elseif state ~= 'open' then
+        state = 'closed'
+        if now - windowStart >= 2 * windowMs then
+            windowStart = now
+            curTotal, curFailures, prevTotal, prevFailures = 0, 0, 0, 0
+        elseif now - windowStart >= windowMs then
+            windowStart = windowStart + windowMs
+            prevTotal, prevFailures = curTotal, curFailures
+            curTotal, curFailures = 0, 0
+        end
+        curTotal = curTotal + 1
+        if success then
+            consecutive = 0
+        else
+            curFailures = curFailures + 1
+            consecutive = consecutive + 1
+            if failureThreshold > 0 and consecutive >= failureThreshold then
+                open()
+            else
+                local weight = 1 - (now - windowStart) / windowMs
+                local total = curTotal + prevTotal * weight
+                local failures = curFailures + prevFailures * weight
+                if errorRateThreshold > 0 and total >= minRequests and total > 0 and failures / total >= errorRateThreshold then
+                    open()
+                end
+            end
+        end
+    end
+end
+
+redis.call('HMSET', key, 'state', state, 'open_until', openUntil, 'consecutive', consecutive,
+    'window_start', windowStart, 'cur_total', curTotal, 'cur_failures', curFailures,
+    'prev_total', prevTotal, 'prev_failures', prevFailures,
+    'probe_in_flight', probeInFlight, 'probe_success', probeSuccess)
+redis.call('PEXPIRE', key, ttl)
+
+return { from, state, '1' }
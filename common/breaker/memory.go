@@ -0,0 +1,64 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryBreaker struct {
+	mutex  sync.Mutex
+	states map[string]*state
+}
+
+func newMemoryBreaker() *memoryBreaker {
+	return &memoryBreaker{states: make(map[string]*state)}
+}
+
+func (b *memoryBreaker) get(key string) *state {
+	s, ok := b.states[key]
+	if !ok {
+		s = &state{State: StateClosed, WindowStart: time.Now().UnixMilli()}
+		b.states[key] = s
+	}
+	return s
+}
+
+func (b *memoryBreaker) Allow(_ context.Context, keys []string, cfg Config) []bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now().UnixMilli()
+	result := make([]bool, len(keys))
+	for i, key := range keys {
+		s, ok := b.states[key]
+		result[i] = !ok || s.allow(now, cfg)
+	}
+	return result
+}
+
+func (b *memoryBreaker) Acquire(_ context.Context, key string, cfg Config) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if s, ok := b.states[key]; ok && !s.acquire(time.Now().UnixMilli(), cfg) {
+		return ErrProbesExhausted
+	}
+	return nil
+}
+
+func (b *memoryBreaker) Record(_ context.Context, key string, success bool, cfg Config) (string, string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	s := b.get(key)
+	from := s.State
+	s.record(success, time.Now().UnixMilli(), cfg)
+	return from, s.State, nil
+}
+
+func (b *memoryBreaker) Release(_ context.Context, key string, _ Config) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if s, ok := b.states[key]; ok {
+		s.release()
+	}
+	return nil
+}
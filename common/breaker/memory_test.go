@@ -0,0 +1,51 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryBreakerHalfOpenProbeCap(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{FailureThreshold: 1, WindowSeconds: 60, CooldownSeconds: 0, HalfOpenProbes: 2}
+	b := newMemoryBreaker()
+	const key = "channel:1"
+
+	if _, to, _ := b.Record(ctx, key, false, cfg); to != StateOpen {
+		t.Fatalf("expected open after failure, got %s", to)
+	}
+
+	// 冷却结束后只允许 HalfOpenProbes 个探测请求
+	for i := 0; i < cfg.HalfOpenProbes; i++ {
+		if err := b.Acquire(ctx, key, cfg); err != nil {
+			t.Fatalf("probe %d should be acquired: %v", i, err)
+		}
+	}
+	if err := b.Acquire(ctx, key, cfg); !errors.Is(err, ErrProbesExhausted) {
+		t.Fatalf("expected probes exhausted, got %v", err)
+	}
+	if allowed := b.Allow(ctx, []string{key}, cfg); allowed[0] {
+		t.Fatal("channel should not be allowed while probes are exhausted")
+	}
+
+	// 释放一个名额后可以再次占用
+	if err := b.Release(ctx, key, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx, key, cfg); err != nil {
+		t.Fatalf("released probe should be acquirable: %v", err)
+	}
+
+	for i := 0; i < cfg.HalfOpenProbes; i++ {
+		b.Record(ctx, key, true, cfg)
+	}
+	if allowed := b.Allow(ctx, []string{key}, cfg); !allowed[0] {
+		t.Fatal("channel should be closed after successful probes")
+	}
+	for i := 0; i < 5; i++ {
+		if err := b.Acquire(ctx, key, cfg); err != nil {
+			t.Fatalf("closed breaker should not limit requests: %v", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package breaker
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/circuit_breaker.lua
+var circuitBreakerScript string
+
+const redisKeyPrefix = "circuit_breaker:"
+
+type redisBreaker struct {
+	client *redis.Client
+	script *redis.Script
+}
+
+func newRedisBreaker(client *redis.Client) *redisBreaker {
+	return &redisBreaker{
+		client: client,
+		script: redis.NewScript(circuitBreakerScript),
+	}
+}
+
+func (b *redisBreaker) Allow(ctx context.Context, keys []string, cfg Config) []bool {
+	result := make([]bool, len(keys))
+	pipe := b.client.Pipeline()
+	cmds := make([]*redis.SliceCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HMGet(ctx, redisKeyPrefix+key, "state", "open_until", "probe_in_flight")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		// Redis 不可用时不阻断请求
+		common.SysError(fmt.Sprintf("circuit breaker: failed to read state: %v", err))
+		for i := range result {
+			result[i] = true
+		}
+		return result
+	}
+	now := time.Now().UnixMilli()
+	for i, cmd := range cmds {
+		values := cmd.Val()
+		s := &state{State: StateClosed}
+		if len(values) == 3 {
+			if v, ok := values[0].(string); ok {
+				s.State = v
+			}
+			if v, ok := values[1].(string); ok {
+				s.OpenUntil, _ = strconv.ParseInt(v, 10, 64)
+			}
+			if v, ok := values[2].(string); ok {
+				s.ProbeInFlight, _ = strconv.Atoi(v)
+			}
+		}
+		result[i] = s.allow(now, cfg)
+	}
+	return result
+}
+
+// eval 执行熔断脚本，返回状态变化前后的值以及 acquire 是否占用到探测名额
+func (b *redisBreaker) eval(ctx context.Context, op string, key string, success bool, cfg Config) (string, string, bool, error) {
+	successArg := "0"
+	if success {
+		successArg = "1"
+	}
+	windowMs := int64(cfg.WindowSeconds) * 1000
+	cooldownMs := int64(cfg.CooldownSeconds) * 1000
+	// 状态至少保留两个窗口，且覆盖冷却时间
+	ttl := max(2*windowMs, cooldownMs) + 60*1000
+	values, err := b.script.Run(ctx, b.client, []string{redisKeyPrefix + key},
+		op,
+		time.Now().UnixMilli(),
+		successArg,
+		cfg.FailureThreshold,
+		strconv.FormatFloat(cfg.ErrorRateThreshold, 'f', -1, 64),
+		cfg.MinRequests,
+		windowMs,
+		cooldownMs,
+		cfg.HalfOpenProbes,
+		ttl,
+	).StringSlice()
+	if err != nil {
+		return "", "", false, fmt.Errorf("circuit breaker %s failed: %w", op, err)
+	}
+	if len(values) != 3 {
+		return "", "", false, fmt.Errorf("circuit breaker %s returned unexpected result: %v", op, values)
+	}
+	return values[0], values[1], values[2] == "1", nil
+}
+
+func (b *redisBreaker) Acquire(ctx context.Context, key string, cfg Config) error {
+	_, _, acquired, err := b.eval(ctx, "acquire", key, true, cfg)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrProbesExhausted
+	}
+	return nil
+}
+
+func (b *redisBreaker) Record(ctx context.Context, key string, success bool, cfg Config) (string, string, error) {
+	from, to, _, err := b.eval(ctx, "record", key, success, cfg)
+	return from, to, err
+}
+
+func (b *redisBreaker) Release(ctx context.Context, key string, cfg Config) error {
+	_, _, _, err := b.eval(ctx, "release", key, true, cfg)
+	return err
+}
@@ -134,6 +134,24 @@ func initConstantEnv() {
 	// 任务轮询时查询的最大数量
 	constant.TaskQueryLimit = GetEnvOrDefault("TASK_QUERY_LIMIT", 1000)
 
+	// 文件存储，local 为本地磁盘，s3 为 S3 兼容的对象存储
+	constant.FileStorageType = GetEnvOrDefaultString("FILE_STORAGE_TYPE", "local")
+	constant.FileStoragePath = GetEnvOrDefaultString("FILE_STORAGE_PATH", "./data/files")
+	constant.MaxUploadFileMB = GetEnvOrDefault("MAX_UPLOAD_FILE_MB", 512)
+	constant.S3Endpoint = GetEnvOrDefaultString("S3_ENDPOINT", "")
+	constant.S3Region = GetEnvOrDefaultString("S3_REGION", "us-east-1")
+	constant.S3Bucket = GetEnvOrDefaultString("S3_BUCKET", "")
+	constant.S3AccessKeyId = GetEnvOrDefaultString("S3_ACCESS_KEY_ID", "")
+	constant.S3SecretAccessKey = GetEnvOrDefaultString("S3_SECRET_ACCESS_KEY", "")
+	constant.S3ForcePathStyle = GetEnvOrDefaultBool("S3_FORCE_PATH_STYLE", true)
+
+	// Prometheus 指标，设置 METRICS_TOKEN 后采集时需要携带 Bearer Token
+	constant.MetricsEnabled = GetEnvOrDefaultBool("METRICS_ENABLED", false)
+	constant.MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
+
+	// OpenTelemetry 链路追踪，通过 OTEL_EXPORTER_OTLP_ENDPOINT 等标准环境变量配置导出
+	constant.TracingEnabled = GetEnvOrDefaultBool("TRACING_ENABLED", false)
+
 	soraPatchStr := GetEnvOrDefaultString("TASK_PRICE_PATCH", "")
 	if soraPatchStr != "" {
 		var taskPricePatches []string
@@ -0,0 +1,219 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/concurrency.lua
+var concurrencyScript string
+
+const concurrencyKeyPrefix = "concurrency:"
+
+// ConcurrencyLimiter 并发数限制，单机使用内存实现，启用 Redis 时多节点共享计数
+type ConcurrencyLimiter interface {
+	// Acquire 占用一个名额，达到上限时返回 false；lease 为名额的最长占用时间
+	Acquire(ctx context.Context, key string, member string, limit int, lease time.Duration) (bool, error)
+	// Renew 将名额的过期时间延长到 lease 之后，名额已过期或已释放时返回 false
+	Renew(ctx context.Context, key string, member string, lease time.Duration) (bool, error)
+	// Release 释放名额
+	Release(ctx context.Context, key string, member string) error
+	// Count 获取各 key 当前占用的名额数
+	Count(ctx context.Context, keys []string) ([]int, error)
+}
+
+var (
+	concurrencyInstance ConcurrencyLimiter
+	concurrencyOnce     sync.Once
+)
+
+// GetConcurrencyLimiter 获取全局并发限制器
+func GetConcurrencyLimiter() ConcurrencyLimiter {
+	concurrencyOnce.Do(func() {
+		if common.RedisEnabled && common.RDB != nil {
+			concurrencyInstance = &redisConcurrencyLimiter{
+				client: common.RDB,
+				script: redis.NewScript(concurrencyScript),
+			}
+		} else {
+			concurrencyInstance = &memoryConcurrencyLimiter{
+				slots: make(map[string]map[string]time.Time),
+			}
+		}
+	})
+	return concurrencyInstance
+}
+
+type redisConcurrencyLimiter struct {
+	client *redis.Client
+	script *redis.Script
+}
+
+func (l *redisConcurrencyLimiter) Acquire(ctx context.Context, key string, member string, limit int, lease time.Duration) (bool, error) {
+	result, err := l.script.Run(ctx, l.client, []string{concurrencyKeyPrefix + key}, "acquire", member, limit, lease.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+func (l *redisConcurrencyLimiter) Renew(ctx context.Context, key string, member string, lease time.Duration) (bool, error) {
+	result, err := l.script.Run(ctx, l.client, []string{concurrencyKeyPrefix + key}, "renew", member, lease.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+func (l *redisConcurrencyLimiter) Release(ctx context.Context, key string, member string) error {
+	return l.client.ZRem(ctx, concurrencyKeyPrefix+key, member).Err()
+}
+
+// Count 在脚本中使用 Redis 服务器时间判断名额是否过期，与 Acquire 使用同一时钟
+func (l *redisConcurrencyLimiter) Count(ctx context.Context, keys []string) ([]int, error) {
+	if len(keys) == 0 {
+		return []int{}, nil
+	}
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = concurrencyKeyPrefix + key
+	}
+	values, err := l.script.Run(ctx, l.client, redisKeys, "count").Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(keys) {
+		return nil, fmt.Errorf("concurrency count returned %d values for %d keys", len(values), len(keys))
+	}
+	counts := make([]int, len(keys))
+	for i, value := range values {
+		counts[i] = int(value)
+	}
+	return counts, nil
+}
+
+type memoryConcurrencyLimiter struct {
+	mutex sync.Mutex
+	slots map[string]map[string]time.Time // key -> member -> 过期时间
+}
+
+// activeSlots 清理过期名额后返回 key 的名额集合，调用方需持有锁
+func (l *memoryConcurrencyLimiter) activeSlots(key string, now time.Time) map[string]time.Time {
+	slots := l.slots[key]
+	for member, expireAt := range slots {
+		if now.After(expireAt) {
+			delete(slots, member)
+		}
+	}
+	return slots
+}
+
+func (l *memoryConcurrencyLimiter) Acquire(_ context.Context, key string, member string, limit int, lease time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	slots := l.activeSlots(key, now)
+	if len(slots) >= limit {
+		return false, nil
+	}
+	if slots == nil {
+		slots = make(map[string]time.Time)
+		l.slots[key] = slots
+	}
+	slots[member] = now.Add(lease)
+	return true, nil
+}
+
+func (l *memoryConcurrencyLimiter) Renew(_ context.Context, key string, member string, lease time.Duration) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	slots := l.activeSlots(key, now)
+	if _, ok := slots[member]; !ok {
+		return false, nil
+	}
+	slots[member] = now.Add(lease)
+	return true, nil
+}
+
+func (l *memoryConcurrencyLimiter) Release(_ context.Context, key string, member string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if slots, ok := l.slots[key]; ok {
+		delete(slots, member)
+		if len(slots) == 0 {
+			delete(l.slots, key)
+		}
+	}
+	return nil
+}
+
+func (l *memoryConcurrencyLimiter) Count(_ context.Context, keys []string) ([]int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	counts := make([]int, len(keys))
+	for i, key := range keys {
+		counts[i] = len(l.activeSlots(key, now))
+	}
+	return counts, nil
+}
+
+// AcquireWithWait 占用名额，达到上限时在 wait 时间内轮询等待，ctx 结束时停止等待
+func AcquireWithWait(ctx context.Context, l ConcurrencyLimiter, key string, member string, limit int, lease time.Duration, wait time.Duration) (bool, error) {
+	deadline := time.Now().Add(wait)
+	interval := 50 * time.Millisecond
+	for {
+		ok, err := l.Acquire(ctx, key, member, limit, lease)
+		if err != nil || ok {
+			return ok, err
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(min(interval, time.Until(deadline))):
+		}
+		interval = min(interval*2, time.Second)
+	}
+}
+
+// KeepAlive 每隔 lease/3 续期一次名额，直到调用返回的函数，
+// 避免流式响应等长时间请求占用超过 lease 后名额被提前回收
+func KeepAlive(l ConcurrencyLimiter, keys []string, member string, lease time.Duration) func() {
+	if len(keys) == 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, key := range keys {
+					ok, err := l.Renew(context.Background(), key, member, lease)
+					if err != nil {
+						common.SysError("failed to renew concurrency lease: " + err.Error())
+					} else if !ok {
+						common.SysLog(fmt.Sprintf("concurrency lease of %s expired before renewal", key))
+					}
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
@@ -0,0 +1,64 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestMemoryLimiter() *memoryConcurrencyLimiter {
+	return &memoryConcurrencyLimiter{slots: make(map[string]map[string]time.Time)}
+}
+
+func TestMemoryConcurrencyLimiterRenew(t *testing.T) {
+	ctx := context.Background()
+	l := newTestMemoryLimiter()
+	lease := 50 * time.Millisecond
+
+	if ok, _ := l.Acquire(ctx, "token:1", "req-1", 1, lease); !ok {
+		t.Fatal("first acquire should succeed")
+	}
+	if ok, _ := l.Acquire(ctx, "token:1", "req-2", 1, lease); ok {
+		t.Fatal("second acquire should be rejected at limit")
+	}
+	if ok, _ := l.Renew(ctx, "token:1", "req-1", time.Minute); !ok {
+		t.Fatal("active slot should be renewed")
+	}
+	time.Sleep(2 * lease)
+	if counts, _ := l.Count(ctx, []string{"token:1"}); counts[0] != 1 {
+		t.Fatalf("renewed slot should still be counted, got %d", counts[0])
+	}
+
+	if ok, _ := l.Acquire(ctx, "token:2", "req-3", 1, lease); !ok {
+		t.Fatal("acquire should succeed")
+	}
+	time.Sleep(2 * lease)
+	if ok, _ := l.Renew(ctx, "token:2", "req-3", time.Minute); ok {
+		t.Fatal("expired slot should not be renewed")
+	}
+	if ok, _ := l.Renew(ctx, "token:2", "missing", time.Minute); ok {
+		t.Fatal("unknown member should not be renewed")
+	}
+}
+
+func TestKeepAliveHoldsSlotBeyondLease(t *testing.T) {
+	ctx := context.Background()
+	l := newTestMemoryLimiter()
+	lease := 90 * time.Millisecond
+
+	if ok, _ := l.Acquire(ctx, "channel:1", "req-1", 1, lease); !ok {
+		t.Fatal("acquire should succeed")
+	}
+	stop := KeepAlive(l, []string{"channel:1"}, "req-1", lease)
+	time.Sleep(4 * lease)
+	if ok, _ := l.Acquire(ctx, "channel:1", "req-2", 1, lease); ok {
+		t.Fatal("slot held by keep alive should not be taken over")
+	}
+
+	stop()
+	stop()
+	time.Sleep(2 * lease)
+	if ok, _ := l.Acquire(ctx, "channel:1", "req-2", 1, lease); !ok {
+		t.Fatal("slot should expire after keep alive stops")
+	}
+}
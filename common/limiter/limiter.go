@@ -53,13 +53,19 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	}
 
 	// 执行限流
+	args := []interface{}{config.Requested, config.Rate, config.Capacity}
+	if config.Force || config.TTLSeconds > 0 {
+		force := 0
+		if config.Force {
+			force = 1
+		}
+		args = append(args, force, config.TTLSeconds)
+	}
 	result, err := rl.client.EvalSha(
 		ctx,
 		rl.limitScriptSHA,
 		[]string{key},
-		config.Requested,
-		config.Rate,
-		config.Capacity,
+		args...,
 	).Int()
 
 	if err != nil {
@@ -70,9 +76,11 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 
 // Config 配置选项模式
 type Config struct {
-	Capacity  int64
-	Rate      int64
-	Requested int64
+	Capacity   int64
+	Rate       int64
+	Requested  int64
+	Force      bool  // 强制扣减，允许余量为负数
+	TTLSeconds int64 // key 过期时间，0 表示不过期
 }
 
 type Option func(*Config)
@@ -88,3 +96,12 @@ func WithRate(r int64) Option {
 func WithRequested(n int64) Option {
 	return func(cfg *Config) { cfg.Requested = n }
 }
+
+// WithForce 强制扣减，用于按实际用量校正，requested 为负数时表示退还
+func WithForce() Option {
+	return func(cfg *Config) { cfg.Force = true }
+}
+
+func WithTTL(seconds int64) Option {
+	return func(cfg *Config) { cfg.TTLSeconds = seconds }
+}
@@ -0,0 +1,48 @@
+-- 并发数限制，使用有序集合保存占用的名额，score 为名额的过期时间
+-- 过期时间统一使用 Redis 服务器时间，避免各节点时钟不一致
+-- ARGV[1]: 操作类型 acquire / renew / count
+-- acquire: KEYS[1] 限制对象唯一标识，ARGV[2] 名额唯一标识，ARGV[3] 最大并发数，
+--          ARGV[4] 名额最长占用时间（毫秒），防止节点异常退出后名额无法释放；返回 1 表示占用成功
+-- renew:   KEYS[1] 限制对象唯一标识，ARGV[2] 名额唯一标识，ARGV[3] 名额最长占用时间（毫秒）；
+--          名额已过期或已释放时返回 0
+-- count:   KEYS 为所有限制对象，返回各 key 当前占用的名额数
+
+local op = ARGV[1]
+local now = redis.call('TIME')
+local nowInMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+if op == 'count' then
+    local counts = {}
+    for i, key in ipairs(KEYS) do
+        counts[i] = redis.call('ZCOUNT', key, '(' .. nowInMs, '+inf')
+    end
+    return counts
+end
+
+local key = KEYS[1]
+local member = ARGV[2]
+
+if op == 'renew' then
+    local lease = tonumber(ARGV[3])
+    local score = redis.call('ZSCORE', key, member)
+    if not score or tonumber(score) <= nowInMs then
+        return 0
+    end
+    redis.call('ZADD', key, nowInMs + lease, member)
+    redis.call('PEXPIRE', key, lease)
+    return 1
+end
+
+local limit = tonumber(ARGV[3])
+local lease = tonumber(ARGV[4])
+
+-- 清理过期的名额
+redis.call('ZREMRANGEBYSCORE', key, '-inf', nowInMs)
+
+if redis.call('ZCARD', key) >= limit then
+    return 0
+end
+
+redis.call('ZADD', key, nowInMs + lease, member)
+redis.call('PEXPIRE', key, lease)
+return 1
@@ -3,11 +3,15 @@
 -- ARGV[1]: 请求令牌数 (通常为1)
 -- ARGV[2]: 令牌生成速率 (每秒)
 -- ARGV[3]: 桶容量
+-- ARGV[4]: 可选，为 1 时强制扣减，允许余量为负数，requested 为负数时表示退还
+-- ARGV[5]: 可选，key 过期时间（秒）
 
 local key = KEYS[1]
 local requested = tonumber(ARGV[1])
 local rate = tonumber(ARGV[2])
 local capacity = tonumber(ARGV[3])
+local force = ARGV[4] == '1'
+local ttl = tonumber(ARGV[5])
 
 -- 获取当前时间（Redis服务器时间）
 local now = redis.call('TIME')
@@ -32,7 +36,10 @@ end
 
 -- 判断是否允许请求
 local allowed = false
-if tokens >= requested then
+if force then
+    tokens = math.min(capacity, tokens - requested)
+    allowed = true
+elseif tokens >= requested then
     tokens = tokens - requested
     allowed = true
 end
@@ -40,5 +47,8 @@ end
 ---- 更新桶状态并设置过期时间
 redis.call('HMSET', key, 'tokens', tokens, 'last_time', last_time)
 --redis.call('EXPIRE', key, math.ceil(capacity / rate) + 60) -- 适当延长过期时间
+if ttl and ttl > 0 then
+    redis.call('EXPIRE', key, ttl)
+end
 
 return allowed and 1 or 0
\ No newline at end of file
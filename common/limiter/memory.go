@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryBucket struct {
+	tokens   int64
+	lastTime int64
+	expireAt int64
+}
+
+// MemoryLimiter 未启用 Redis 时使用的单机令牌桶，语义与 lua/rate_limit.lua 一致
+type MemoryLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep int64
+	now       func() int64 // 当前时间（秒），为空时使用系统时间
+}
+
+var (
+	memoryInstance *MemoryLimiter
+	memoryOnce     sync.Once
+)
+
+func NewMemory() *MemoryLimiter {
+	memoryOnce.Do(func() {
+		memoryInstance = &MemoryLimiter{
+			buckets: make(map[string]*memoryBucket),
+		}
+	})
+	return memoryInstance
+}
+
+func (ml *MemoryLimiter) Allow(key string, opts ...Option) bool {
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	now := time.Now().Unix()
+	if ml.now != nil {
+		now = ml.now()
+	}
+	ml.mutex.Lock()
+	defer ml.mutex.Unlock()
+	ml.sweep(now)
+
+	bucket, ok := ml.buckets[key]
+	if !ok || (bucket.expireAt > 0 && now > bucket.expireAt) {
+		bucket = &memoryBucket{tokens: config.Capacity, lastTime: now}
+		ml.buckets[key] = bucket
+	} else {
+		bucket.tokens = min(config.Capacity, bucket.tokens+(now-bucket.lastTime)*config.Rate)
+		bucket.lastTime = now
+	}
+
+	allowed := false
+	if config.Force {
+		bucket.tokens = min(config.Capacity, bucket.tokens-config.Requested)
+		allowed = true
+	} else if bucket.tokens >= config.Requested {
+		bucket.tokens -= config.Requested
+		allowed = true
+	}
+	if config.TTLSeconds > 0 {
+		bucket.expireAt = now + config.TTLSeconds
+	}
+	return allowed
+}
+
+// sweep 每分钟清理一次过期的 bucket
+func (ml *MemoryLimiter) sweep(now int64) {
+	if now-ml.lastSweep < 60 {
+		return
+	}
+	ml.lastSweep = now
+	for key, bucket := range ml.buckets {
+		if bucket.expireAt > 0 && now > bucket.expireAt {
+			delete(ml.buckets, key)
+		}
+	}
+}
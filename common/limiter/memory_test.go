@@ -0,0 +1,73 @@
+package limiter
+
+import "testing"
+
+func TestMemoryLimiterTokenBucket(t *testing.T) {
+	// 与 TPM 限流用法一致：TPM=100 时容量为 6000 token·秒，每秒补充 100
+	bucketOpts := []Option{WithCapacity(6000), WithRate(100), WithTTL(120)}
+	type step struct {
+		elapsed   int64 // 距离上一步经过的秒数
+		requested int64
+		force     bool
+		allowed   bool
+		tokens    int64 // 本步之后桶内余量
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"drain and reject", []step{
+			{0, 6000, false, true, 0},
+			{0, 60, false, false, 0},
+		}},
+		{"refill per second", []step{
+			{0, 6000, false, true, 0},
+			{1, 60, false, true, 40},
+			{0, 60, false, false, 40},
+			{30, 3000, false, true, 40},
+		}},
+		{"refill capped at capacity", []step{
+			{0, 600, false, true, 5400},
+			{3600, 0, false, true, 6000},
+		}},
+		{"forced refund", []step{
+			{0, 6000, false, true, 0},
+			{0, -3000, true, true, 3000},
+			{0, 3000, false, true, 0},
+		}},
+		{"forced refund capped at capacity", []step{
+			{0, 600, false, true, 5400},
+			{0, -6000, true, true, 6000},
+		}},
+		{"forced overdraft", []step{
+			{0, 12000, true, true, -6000},
+			// 透支时零消耗的检查也不通过，直到补充回非负
+			{0, 0, false, false, -6000},
+			{59, 0, false, false, -100},
+			{1, 0, false, true, 0},
+		}},
+		{"expired bucket resets", []step{
+			{0, 6000, false, true, 0},
+			{121, 6000, false, true, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := int64(1_000_000)
+			ml := &MemoryLimiter{buckets: make(map[string]*memoryBucket), now: func() int64 { return now }}
+			for i, s := range tt.steps {
+				now += s.elapsed
+				opts := append([]Option{WithRequested(s.requested)}, bucketOpts...)
+				if s.force {
+					opts = append(opts, WithForce())
+				}
+				if allowed := ml.Allow("tpm", opts...); allowed != s.allowed {
+					t.Fatalf("step %d: expected allowed=%v, got %v", i, s.allowed, allowed)
+				}
+				if tokens := ml.buckets["tpm"].tokens; tokens != s.tokens {
+					t.Fatalf("step %d: expected %d tokens, got %d", i, s.tokens, tokens)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "new_api"
+
+var (
+	relayRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Relay attempts by model, channel, group, relay format and response status code.",
+	}, []string{"model", "channel", "group", "relay_format", "status_code"})
+
+	relayDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_request_duration_seconds",
+		Help:      "Duration of relay attempts.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"model", "channel", "group", "relay_format"})
+
+	relayFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_first_token_seconds",
+		Help:      "Time to first token of successful streaming relay attempts.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"model", "channel", "group", "relay_format"})
+
+	relayRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_retries_total",
+		Help:      "Retries onto another channel, counted from the use_channel path.",
+	}, []string{"model", "group"})
+
+	upstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_total",
+		Help:      "Upstream HTTP responses by channel and status code.",
+	}, []string{"channel", "status_code"})
+
+	quotaPreConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_pre_consumed_total",
+		Help:      "Quota pre-consumed before relaying.",
+	}, []string{"model", "group"})
+
+	quotaReturned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_returned_total",
+		Help:      "Pre-consumed quota returned after failed requests.",
+	}, []string{"model", "group"})
+
+	quotaSettled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_settled_total",
+		Help:      "Quota settled by consume logs.",
+	}, []string{"model", "group"})
+
+	channelDisabled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_auto_disabled_total",
+		Help:      "Automatic disable events; multi_key is true when a single key of a multi-key channel was disabled.",
+	}, []string{"channel", "multi_key"})
+
+	channelCacheSync = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "channel_cache_sync_duration_seconds",
+		Help:      "Duration of channel cache synchronization from the database.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// Handler 返回 Prometheus 格式的 /metrics 处理器
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterGaugeFunc 注册一个在采集时取值的指标
+func RegisterGaugeFunc(name string, help string, fn func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn)
+}
+
+// RecordRelayRequest 记录一次渠道请求尝试，statusCode 为返回给客户端的状态码
+func RecordRelayRequest(model string, channelId int, group string, relayFormat string, statusCode int, duration time.Duration) {
+	channel := strconv.Itoa(channelId)
+	relayRequests.WithLabelValues(model, channel, group, relayFormat, strconv.Itoa(statusCode)).Inc()
+	relayDuration.WithLabelValues(model, channel, group, relayFormat).Observe(duration.Seconds())
+}
+
+// RecordFirstToken 记录流式请求的首字时间
+func RecordFirstToken(model string, channelId int, group string, relayFormat string, duration time.Duration) {
+	relayFirstToken.WithLabelValues(model, strconv.Itoa(channelId), group, relayFormat).Observe(duration.Seconds())
+}
+
+// RecordRelayRetries 记录一次请求中切换渠道重试的次数
+func RecordRelayRetries(model string, group string, retries int) {
+	if retries <= 0 {
+		return
+	}
+	relayRetries.WithLabelValues(model, group).Add(float64(retries))
+}
+
+// RecordUpstreamResponse 记录上游返回的状态码
+func RecordUpstreamResponse(channelId int, statusCode int) {
+	upstreamResponses.WithLabelValues(strconv.Itoa(channelId), strconv.Itoa(statusCode)).Inc()
+}
+
+// RecordQuotaPreConsumed 记录预扣费额度
+func RecordQuotaPreConsumed(model string, group string, quota int) {
+	if quota <= 0 {
+		return
+	}
+	quotaPreConsumed.WithLabelValues(model, group).Add(float64(quota))
+}
+
+// RecordQuotaReturned 记录请求失败后退还的预扣费额度
+func RecordQuotaReturned(model string, group string, quota int) {
+	if quota <= 0 {
+		return
+	}
+	quotaReturned.WithLabelValues(model, group).Add(float64(quota))
+}
+
+// RecordQuotaSettled 记录最终结算的额度
+func RecordQuotaSettled(model string, group string, quota int) {
+	if quota <= 0 {
+		return
+	}
+	quotaSettled.WithLabelValues(model, group).Add(float64(quota))
+}
+
+// RecordChannelDisabled 记录渠道或多 key 渠道中单个 key 被自动禁用
+func RecordChannelDisabled(channelId int, multiKey bool) {
+	channelDisabled.WithLabelValues(strconv.Itoa(channelId), strconv.FormatBool(multiKey)).Inc()
+}
+
+// RecordChannelCacheSync 记录渠道缓存同步耗时
+func RecordChannelCacheSync(duration time.Duration) {
+	channelCacheSync.Observe(duration.Seconds())
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// expectedLabels 每个指标允许的标签，避免引入用户、令牌等高基数标签
+var expectedLabels = map[string][]string{
+	"new_api_relay_requests_total":                {"channel", "group", "model", "relay_format", "status_code"},
+	"new_api_relay_request_duration_seconds":      {"channel", "group", "model", "relay_format"},
+	"new_api_relay_first_token_seconds":           {"channel", "group", "model", "relay_format"},
+	"new_api_relay_retries_total":                 {"group", "model"},
+	"new_api_upstream_responses_total":            {"channel", "status_code"},
+	"new_api_quota_pre_consumed_total":            {"group", "model"},
+	"new_api_quota_returned_total":                {"group", "model"},
+	"new_api_quota_settled_total":                 {"group", "model"},
+	"new_api_channel_auto_disabled_total":         {"channel", "multi_key"},
+	"new_api_channel_cache_sync_duration_seconds": {},
+	"new_api_test_gauge":                          {},
+}
+
+var registerTestGauge sync.Once
+
+func recordTestMetrics() {
+	for i := 0; i < 3; i++ {
+		RecordRelayRequest("gpt-4o", 1, "default", "openai", 200, time.Second)
+		RecordRelayRequest("gpt-4o", 2, "default", "openai", 429, time.Second)
+	}
+	RecordFirstToken("gpt-4o", 1, "default", "openai", 100*time.Millisecond)
+	RecordRelayRetries("gpt-4o", "default", 2)
+	// 没有重试时不产生序列
+	RecordRelayRetries("gpt-4o-mini", "default", 0)
+	RecordUpstreamResponse(1, 200)
+	RecordQuotaPreConsumed("gpt-4o", "default", 100)
+	RecordQuotaReturned("gpt-4o", "default", 50)
+	RecordQuotaSettled("gpt-4o", "default", 80)
+	RecordChannelDisabled(1, true)
+	RecordChannelCacheSync(10 * time.Millisecond)
+	// 同名指标只能注册一次
+	registerTestGauge.Do(func() {
+		RegisterGaugeFunc("test_gauge", "Gauge registered by tests.", func() float64 { return 1 })
+	})
+}
+
+func TestMetricsLabelCardinality(t *testing.T) {
+	recordTestMetrics()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, family := range families {
+		name := family.GetName()
+		if !strings.HasPrefix(name, namespace+"_") {
+			continue
+		}
+		labels, ok := expectedLabels[name]
+		if !ok {
+			t.Fatalf("unexpected metric %s, add it to expectedLabels after checking its labels", name)
+		}
+		found[name] = true
+		// 重复记录相同的标签值不会产生新的序列
+		if name == "new_api_relay_requests_total" && len(family.GetMetric()) != 2 {
+			t.Fatalf("%s: expected 2 series, got %d", name, len(family.GetMetric()))
+		}
+		for _, metric := range family.GetMetric() {
+			var names []string
+			for _, label := range metric.GetLabel() {
+				names = append(names, label.GetName())
+				if label.GetName() == "channel" && strings.Trim(label.GetValue(), "0123456789") != "" {
+					t.Fatalf("%s: channel label should be the channel id, got %q", name, label.GetValue())
+				}
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, labels) {
+				t.Fatalf("%s: expected labels %v, got %v", name, labels, names)
+			}
+		}
+	}
+	for name := range expectedLabels {
+		if !found[name] {
+			t.Fatalf("metric %s is not registered", name)
+		}
+	}
+}
+
+func TestMetricsHandlerExposesSeries(t *testing.T) {
+	recordTestMetrics()
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(recorder.Body)
+	output := string(body)
+	for _, series := range []string{
+		`new_api_relay_requests_total{channel="2",group="default",model="gpt-4o",relay_format="openai",status_code="429"}`,
+		`new_api_relay_request_duration_seconds_count{channel="1",group="default",model="gpt-4o",relay_format="openai"}`,
+		`new_api_relay_first_token_seconds_count{channel="1",group="default",model="gpt-4o",relay_format="openai"}`,
+		`new_api_relay_retries_total{group="default",model="gpt-4o"}`,
+		`new_api_upstream_responses_total{channel="1",status_code="200"}`,
+		`new_api_quota_pre_consumed_total{group="default",model="gpt-4o"}`,
+		`new_api_quota_returned_total{group="default",model="gpt-4o"}`,
+		`new_api_quota_settled_total{group="default",model="gpt-4o"}`,
+		`new_api_channel_auto_disabled_total{channel="1",multi_key="true"}`,
+		`new_api_channel_cache_sync_duration_seconds_count`,
+		`new_api_test_gauge 1`,
+	} {
+		if !strings.Contains(output, series) {
+			t.Fatalf("/metrics should expose %s", series)
+		}
+	}
+	if strings.Contains(output, `model="gpt-4o-mini"`) {
+		t.Fatal("zero retries should not create a series")
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "github.com/QuantumNous/new-api"
+	serviceName = "new-api"
+)
+
+// span 属性
+const (
+	AttrRequestId     = attribute.Key("new_api.request_id")
+	AttrChannelId     = attribute.Key("new_api.channel_id")
+	AttrUpstreamModel = attribute.Key("new_api.upstream_model")
+	AttrOriginModel   = attribute.Key("new_api.origin_model")
+	AttrRetry         = attribute.Key("new_api.retry")
+	AttrCancelled     = attribute.Key("new_api.cancelled")
+)
+
+// 未初始化时全局 TracerProvider 为 noop 实现，创建 span 几乎没有开销
+var tracer = otel.Tracer(tracerName)
+
+// Init 初始化 OTLP/HTTP 导出，导出地址、采样率等使用 OpenTelemetry 标准环境变量配置，
+// 如 OTEL_EXPORTER_OTLP_ENDPOINT、OTEL_TRACES_SAMPLER、OTEL_SERVICE_NAME
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName), semconv.ServiceVersion(common.Version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start 创建子 span，context 中带有请求 ID 时自动记录
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if requestId, ok := ctx.Value(common.RequestIdKey).(string); ok && requestId != "" {
+		attrs = append(attrs, AttrRequestId.String(requestId))
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServer 为入站请求创建根 span，并接收调用方通过 traceparent 传入的链路
+func StartServer(ctx context.Context, header http.Header, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+	if requestId, ok := ctx.Value(common.RequestIdKey).(string); ok && requestId != "" {
+		attrs = append(attrs, AttrRequestId.String(requestId))
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// ChannelAttributes 渠道相关的 span 属性
+func ChannelAttributes(channelId int, upstreamModel string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		AttrChannelId.Int(channelId),
+		AttrUpstreamModel.String(upstreamModel),
+	}
+}
+
+// End 结束 span，err 不为空时记录错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
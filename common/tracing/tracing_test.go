@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	collectortracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector 本地 OTLP/HTTP 采集端，保存收到的 span
+type otlpCollector struct {
+	mu    sync.Mutex
+	spans []*tracev1.Span
+}
+
+func newOTLPCollector(t *testing.T) (*otlpCollector, *httptest.Server) {
+	t.Helper()
+	collector := &otlpCollector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		request := &collectortracev1.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		collector.mu.Lock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				collector.spans = append(collector.spans, scopeSpans.Spans...)
+			}
+		}
+		collector.mu.Unlock()
+		response, _ := proto.Marshal(&collectortracev1.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+	return collector, server
+}
+
+func (c *otlpCollector) span(name string) *tracev1.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func spanAttribute(span *tracev1.Span, key string) any {
+	for _, attr := range span.Attributes {
+		if attr.Key != key {
+			continue
+		}
+		switch value := attr.Value.Value.(type) {
+		case *commonv1.AnyValue_StringValue:
+			return value.StringValue
+		case *commonv1.AnyValue_IntValue:
+			return value.IntValue
+		}
+	}
+	return nil
+}
+
+func TestInitExportsSpansToCollector(t *testing.T) {
+	collector, server := newOTLPCollector(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("OTEL_TRACES_SAMPLER", "always_on")
+
+	shutdown, err := Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := context.WithValue(context.Background(), common.RequestIdKey, "req-1")
+	ctx, root := StartServer(ctx, header, "POST /v1/chat/completions")
+	_, child := Start(ctx, "adaptor.DoRequest", ChannelAttributes(3, "gpt-4o-mini")...)
+	End(child, errors.New("upstream timeout"))
+	End(root, nil)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rootSpan := collector.span("POST /v1/chat/completions")
+	if rootSpan == nil {
+		t.Fatal("root span was not exported")
+	}
+	if rootSpan.Kind != tracev1.Span_SPAN_KIND_SERVER {
+		t.Fatalf("expected server span, got %v", rootSpan.Kind)
+	}
+	if got := spanAttribute(rootSpan, string(AttrRequestId)); got != "req-1" {
+		t.Fatalf("expected request id attribute, got %v", got)
+	}
+	// 入站 traceparent 应作为父链路
+	if traceId := rootSpan.TraceId; len(traceId) != 16 || traceId[0] != 0x4b || traceId[15] != 0x36 {
+		t.Fatalf("root span should continue incoming trace, got %x", traceId)
+	}
+
+	childSpan := collector.span("adaptor.DoRequest")
+	if childSpan == nil {
+		t.Fatal("child span was not exported")
+	}
+	if string(childSpan.ParentSpanId) != string(rootSpan.SpanId) {
+		t.Fatalf("child span parent mismatch")
+	}
+	if got := spanAttribute(childSpan, string(AttrChannelId)); got != int64(3) {
+		t.Fatalf("expected channel id attribute, got %v", got)
+	}
+	if got := spanAttribute(childSpan, string(AttrUpstreamModel)); got != "gpt-4o-mini" {
+		t.Fatalf("expected upstream model attribute, got %v", got)
+	}
+	if childSpan.Status.GetCode() != tracev1.Status_STATUS_CODE_ERROR || childSpan.Status.GetMessage() != "upstream timeout" {
+		t.Fatalf("expected error status, got %v", childSpan.Status)
+	}
+}
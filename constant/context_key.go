@@ -19,6 +19,8 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenBudgetLimited     ContextKey = "token_budget_limited"
+	ContextKeyTokenOrganizationId    ContextKey = "token_organization_id"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -51,8 +53,22 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+	// 用户拥有的后台管理权限，仅在按权限鉴权的路由中设置
+	ContextKeyUserPermissions ContextKey = "user_permissions"
 
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	// ContextKeyBatchRequest 标记请求来自批处理任务的逐行回放，用于应用批处理折扣
+	ContextKeyBatchRequest ContextKey = "batch_request"
+
+	// ContextKeyHedgeState 对冲请求中本次尝试的状态，主请求与对冲请求的上下文各自持有
+	ContextKeyHedgeState ContextKey = "hedge_state"
+
+	// ContextKeyGuardrailState 外部护栏钩子的审核结果，对冲请求与主请求共用
+	ContextKeyGuardrailState ContextKey = "guardrail_state"
+
+	// ContextKeyCompletionModeration 输出敏感词检测的状态，结算时按实际返回给客户端的内容计费
+	ContextKeyCompletionModeration ContextKey = "completion_moderation"
 )
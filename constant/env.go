@@ -17,5 +17,23 @@ var GenerateDefaultToken bool
 var ErrorLogEnabled bool
 var TaskQueryLimit int
 
+// 文件存储相关配置
+var FileStorageType string
+var FileStoragePath string
+var MaxUploadFileMB int
+var S3Endpoint string
+var S3Region string
+var S3Bucket string
+var S3AccessKeyId string
+var S3SecretAccessKey string
+var S3ForcePathStyle bool
+
+// Prometheus 指标相关配置
+var MetricsEnabled bool
+var MetricsToken string
+
+// 链路追踪相关配置，导出地址等使用 OpenTelemetry 标准环境变量
+var TracingEnabled bool
+
 // temporary variable for sora patch, will be removed in future
 var TaskPricePatches []string
@@ -0,0 +1,44 @@
+package constant
+
+// 后台管理权限，在路由组上按权限鉴权
+const (
+	PermissionChannelRead  = "channel.read"  // 查看渠道
+	PermissionChannelWrite = "channel.write" // 新增、修改、测试渠道，包括修改渠道密钥
+	PermissionChannelKey   = "channel.key"   // 查看渠道密钥
+	PermissionUserRead     = "user.read"     // 查看用户与组织
+	PermissionUserWrite    = "user.write"    // 新增、修改、禁用用户
+	PermissionBillingRead  = "billing.read"  // 查看充值记录、订阅与账单
+	PermissionBillingWrite = "billing.write" // 管理兑换码、补单、订阅套餐与组织额度
+	PermissionLogRead      = "log.read"      // 查看使用日志、统计、绘图与异步任务
+	PermissionLogWrite     = "log.write"     // 删除历史日志、执行日志归档与恢复
+	PermissionModelRead    = "model.read"    // 查看模型、供应商与预填分组
+	PermissionModelWrite   = "model.write"   // 管理模型、供应商与预填分组
+	PermissionPricingWrite = "pricing.write" // 修改模型倍率、价格与分组倍率，同步上游倍率
+	PermissionSettingWrite = "setting.write" // 查看和修改全部系统设置
+	PermissionRoleManage   = "role.manage"   // 管理角色并为用户分配角色
+	PermissionAuditRead    = "audit.read"    // 查看、导出和校验审计日志
+)
+
+var AllPermissions = []string{
+	PermissionChannelRead,
+	PermissionChannelWrite,
+	PermissionChannelKey,
+	PermissionUserRead,
+	PermissionUserWrite,
+	PermissionBillingRead,
+	PermissionBillingWrite,
+	PermissionLogRead,
+	PermissionLogWrite,
+	PermissionModelRead,
+	PermissionModelWrite,
+	PermissionPricingWrite,
+	PermissionSettingWrite,
+	PermissionRoleManage,
+	PermissionAuditRead,
+}
+
+// RootOnlyPermissions 只属于超级管理员的权限，不能通过命名角色授予
+var RootOnlyPermissions = []string{
+	PermissionChannelKey,
+	PermissionRoleManage,
+}
@@ -5,6 +5,7 @@ type TaskPlatform string
 const (
 	TaskPlatformSuno       TaskPlatform = "suno"
 	TaskPlatformMidjourney              = "mj"
+	TaskPlatformBatch      TaskPlatform = "batch"
 )
 
 const (
@@ -16,6 +17,10 @@ const (
 	TaskActionFirstTailGenerate = "firstTailGenerate"
 	TaskActionReferenceGenerate = "referenceGenerate"
 	TaskActionRemix             = "remixGenerate"
+
+	// 批处理任务：本地逐行执行或转发到支持批处理的上游
+	TaskActionBatchLocal    = "batchLocal"
+	TaskActionBatchUpstream = "batchUpstream"
 )
 
 var SunoModel2Action = map[string]string{
@@ -0,0 +1,943 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+// batchFlushInterval 本地批处理保存中间结果、检查取消和过期的间隔
+const batchFlushInterval = 5 * time.Second
+
+// batchLeaseSeconds 本地批处理的执行租约，执行节点每次保存中间结果时续期，超时后其他节点可以接手
+const batchLeaseSeconds = 60
+
+// runningBatches 当前节点正在执行的本地批处理，避免轮询时重复启动，多节点之间由任务的执行租约保证只有一个节点执行
+var runningBatches sync.Map
+
+// errBatchAlreadySettled 上游批处理已被之前的轮询或其他节点结算
+var errBatchAlreadySettled = errors.New("batch already settled")
+
+// applyBatchToTask 将批处理对象写入任务，并同步任务状态和进度
+func applyBatchToTask(task *model.Task, batch *dto.OpenAIBatch) {
+	now := time.Now().Unix()
+	task.SetData(batch)
+	task.UpdatedAt = now
+	switch batch.Status {
+	case dto.BatchStatusValidating:
+		task.Status = model.TaskStatusQueued
+	case dto.BatchStatusInProgress, dto.BatchStatusFinalizing, dto.BatchStatusCancelling:
+		task.Status = model.TaskStatusInProgress
+		if task.StartTime == 0 {
+			task.StartTime = now
+		}
+	case dto.BatchStatusCompleted:
+		task.Status = model.TaskStatusSuccess
+	default:
+		task.Status = model.TaskStatusFailure
+		task.FailReason = batch.Status
+		if batch.Errors != nil && len(batch.Errors.Data) > 0 {
+			task.FailReason = batch.Errors.Data[0].Message
+		}
+	}
+	if batch.IsTerminal() {
+		task.Progress = "100%"
+		task.FinishTime = now
+		return
+	}
+	// 未结束的任务进度不能为 100%，否则会被轮询忽略
+	progress := 0
+	if batch.RequestCounts.Total > 0 {
+		progress = (batch.RequestCounts.Completed + batch.RequestCounts.Failed) * 100 / batch.RequestCounts.Total
+	}
+	task.Progress = fmt.Sprintf("%d%%", min(progress, 99))
+}
+
+func getUserBatchTaskOrAbort(c *gin.Context) (*model.Task, *dto.OpenAIBatch) {
+	task, exist, err := model.GetByTaskId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to query batch")
+		return nil, nil
+	}
+	if !exist || task.Platform != constant.TaskPlatformBatch {
+		fileError(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+		return nil, nil
+	}
+	batch := &dto.OpenAIBatch{}
+	if err = task.GetData(batch); err != nil {
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to parse batch")
+		return nil, nil
+	}
+	return task, batch
+}
+
+// CreateBatch 处理 POST /v1/batches
+// 输入文件绑定的渠道支持批处理时转发到上游，否则由本地逐行执行
+func CreateBatch(c *gin.Context) {
+	batchSetting := operation_setting.GetBatchSetting()
+	if !batchSetting.Enabled {
+		fileError(c, http.StatusForbidden, "invalid_request_error", "batch api is disabled")
+		return
+	}
+	var req dto.OpenAIBatchRequest
+	if err := common.UnmarshalBodyReusable(c, &req); err != nil {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", "invalid request body: "+err.Error())
+		return
+	}
+	if !dto.IsValidBatchEndpoint(req.Endpoint) {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("unsupported endpoint: %s", req.Endpoint))
+		return
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = dto.BatchCompletionWindow
+	}
+	if req.CompletionWindow != dto.BatchCompletionWindow {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("unsupported completion_window: %s", req.CompletionWindow))
+		return
+	}
+
+	userId := c.GetInt("id")
+	inputFile, err := model.GetUserFileByFileId(userId, req.InputFileID)
+	if err != nil {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("No such File object: %s", req.InputFileID))
+		return
+	}
+	if inputFile.Purpose != dto.FilePurposeBatch {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", "input file must be uploaded with purpose batch")
+		return
+	}
+	data, err := service.GetFileContent(c.Request.Context(), inputFile)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("read batch input file %s failed: %s", inputFile.FileId, err.Error()))
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to read input file")
+		return
+	}
+	lines, err := service.ParseBatchInput(data, req.Endpoint, batchSetting.MaxRequestsPerBatch)
+	if err != nil {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	now := time.Now().Unix()
+	batch := &dto.OpenAIBatch{
+		Object:           "batch",
+		Endpoint:         req.Endpoint,
+		InputFileID:      inputFile.FileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           dto.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + 24*3600,
+		RequestCounts: dto.OpenAIBatchRequestCounts{
+			Total: len(lines),
+		},
+		Metadata: req.Metadata,
+	}
+	task := &model.Task{
+		TaskID:      "batch_" + common.GetRandomString(24),
+		Platform:    constant.TaskPlatformBatch,
+		UserId:      userId,
+		Group:       common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		Action:      constant.TaskActionBatchLocal,
+		SubmitTime:  now,
+		Properties:  model.Properties{Input: inputFile.FileId},
+		PrivateData: model.TaskPrivateData{TokenId: c.GetInt("token_id")},
+	}
+
+	if batchSetting.PreferUpstream && inputFile.ChannelId != 0 && inputFile.UpstreamFileId != "" {
+		channel, err := model.CacheGetChannel(inputFile.ChannelId)
+		if err == nil && channel.Status == common.ChannelStatusEnabled && service.IsBatchSupportedChannel(channel.Type) {
+			// 上游批处理不经过逐行转发，提交时按预估用量预扣费，结算时多退少补
+			relayInfo, apiErr := preConsumeUpstreamBatch(c, lines)
+			if apiErr != nil {
+				fileError(c, apiErr.StatusCode, "insufficient_quota", apiErr.Error())
+				return
+			}
+			upstreamBatch, err := service.CreateUpstreamBatch(c.Request.Context(), channel, inputFile.ChannelKeyIndex, &dto.OpenAIBatchRequest{
+				InputFileID:      inputFile.UpstreamFileId,
+				Endpoint:         req.Endpoint,
+				CompletionWindow: req.CompletionWindow,
+				Metadata:         req.Metadata,
+			})
+			if err != nil {
+				service.ReturnPreConsumedQuota(c, relayInfo)
+				logger.LogError(c, fmt.Sprintf("create batch on channel #%d failed: %s", channel.Id, err.Error()))
+				fileError(c, http.StatusBadGateway, "upstream_error", "failed to create batch on upstream")
+				return
+			}
+			task.Quota = relayInfo.FinalPreConsumedQuota
+			upstreamBatch.InputFileID = inputFile.FileId
+			upstreamBatch.OutputFileID = ""
+			upstreamBatch.ErrorFileID = ""
+			batch = upstreamBatch
+			task.TaskID = upstreamBatch.ID
+			task.ChannelId = channel.Id
+			task.PrivateData.ChannelKeyIndex = inputFile.ChannelKeyIndex
+			task.Action = constant.TaskActionBatchUpstream
+		}
+	}
+	batch.ID = task.TaskID
+	applyBatchToTask(task, batch)
+	if err = task.Insert(); err != nil {
+		logger.LogError(c, "insert batch task failed: "+err.Error())
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to create batch")
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// RetrieveBatch 处理 GET /v1/batches/:id
+func RetrieveBatch(c *gin.Context) {
+	task, batch := getUserBatchTaskOrAbort(c)
+	if task == nil {
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// ListBatches 处理 GET /v1/batches
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	tasks, err := model.GetUserBatchTasks(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to list batches")
+		return
+	}
+	resp := dto.OpenAIBatchList{
+		Object: "list",
+		Data:   make([]dto.OpenAIBatch, 0, len(tasks)),
+	}
+	if len(tasks) > limit {
+		resp.HasMore = true
+		tasks = tasks[:limit]
+	}
+	for _, task := range tasks {
+		var batch dto.OpenAIBatch
+		if err := task.GetData(&batch); err != nil {
+			continue
+		}
+		resp.Data = append(resp.Data, batch)
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstID = resp.Data[0].ID
+		resp.LastID = resp.Data[len(resp.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// CancelBatch 处理 POST /v1/batches/:id/cancel
+func CancelBatch(c *gin.Context) {
+	task, batch := getUserBatchTaskOrAbort(c)
+	if task == nil {
+		return
+	}
+	if batch.IsTerminal() {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Cannot cancel a batch with status %s", batch.Status))
+		return
+	}
+	if batch.Status == dto.BatchStatusCancelling {
+		c.JSON(http.StatusOK, batch)
+		return
+	}
+	if task.Action == constant.TaskActionBatchUpstream {
+		channel, err := model.CacheGetChannel(task.ChannelId)
+		if err != nil {
+			fileError(c, http.StatusServiceUnavailable, "new_api_error", "failed to get channel: "+err.Error())
+			return
+		}
+		upstreamBatch, err := service.CancelUpstreamBatch(c.Request.Context(), channel, task.PrivateData.ChannelKeyIndex, task.TaskID)
+		if err != nil {
+			logger.LogError(c, fmt.Sprintf("cancel batch %s on channel #%d failed: %s", task.TaskID, channel.Id, err.Error()))
+			fileError(c, http.StatusBadGateway, "upstream_error", "failed to cancel batch on upstream")
+			return
+		}
+		batch.Status = upstreamBatch.Status
+		batch.CancellingAt = upstreamBatch.CancellingAt
+		batch.CancelledAt = upstreamBatch.CancelledAt
+		// 上游已取消的批处理仍需同步结果文件并结算，由轮询任务完成
+		if batch.IsTerminal() {
+			batch.Status = dto.BatchStatusCancelling
+		}
+	} else {
+		// 本地批处理由执行协程在下次检查时停止
+		batch.Status = dto.BatchStatusCancelling
+		batch.CancellingAt = time.Now().Unix()
+	}
+	applyBatchToTask(task, batch)
+	if err := task.Update(); err != nil {
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to cancel batch")
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// UpdateBatchTaskAll 轮询未完成的批处理，渠道 ID 为 0 的是本地批处理
+func UpdateBatchTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+	for channelId, taskIds := range taskChannelM {
+		if channelId == 0 {
+			for _, taskId := range taskIds {
+				startLocalBatch(taskM[taskId])
+			}
+			continue
+		}
+		if err := updateUpstreamBatchTasks(ctx, channelId, taskIds, taskM); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("渠道 #%d 更新批处理任务失败: %s", channelId, err.Error()))
+		}
+	}
+	return nil
+}
+
+// preConsumeUpstreamBatch 按输入的预估 token 数和 max_tokens 计算整个批处理的预扣费额度并预扣
+func preConsumeUpstreamBatch(c *gin.Context, lines []dto.BatchRequestLine) (*relaycommon.RelayInfo, *types.NewAPIError) {
+	common.SetContextKey(c, constant.ContextKeyBatchRequest, true)
+	relayInfo, err := relaycommon.GenRelayInfo(c, types.RelayFormatTask, nil, nil)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+	}
+	quota := 0
+	for _, line := range lines {
+		modelName := gjson.GetBytes(line.Body, "model").String()
+		relayInfo.OriginModelName = modelName
+		meta := &types.TokenCountMeta{}
+		for _, key := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+			if value := gjson.GetBytes(line.Body, key); value.Exists() {
+				meta.MaxTokens = int(value.Int())
+				break
+			}
+		}
+		promptTokens := service.EstimateTokenByModel(modelName, string(line.Body))
+		priceData, err := helper.ModelPriceHelper(c, relayInfo, promptTokens, meta)
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeModelPriceError)
+		}
+		quota += priceData.QuotaToPreConsume
+	}
+	if apiErr := service.PreConsumeQuota(c, quota, relayInfo); apiErr != nil {
+		return nil, apiErr
+	}
+	return relayInfo, nil
+}
+
+// getBatchOwner 获取批处理所属的令牌和用户，用于构造执行和计费的上下文
+func getBatchOwner(task *model.Task) (*model.Token, *model.UserBase, error) {
+	token, err := model.GetTokenById(task.PrivateData.TokenId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("批处理所用的令牌不存在: %w", err)
+	}
+	if token.UserId != task.UserId {
+		return nil, nil, errors.New("批处理所用的令牌不属于该用户")
+	}
+	user, err := model.GetUserCache(task.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, user, nil
+}
+
+// newBatchContext 构造批处理请求的上下文，等价于经过 TokenAuth 后的请求
+func newBatchContext(ctx context.Context, task *model.Task, token *model.Token, user *model.UserBase, path string, body []byte) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	requestId := common.GetTimeString() + common.GetRandomString(8)
+	ctx = context.WithValue(ctx, common.RequestIdKey, requestId)
+	c.Request = httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)).WithContext(ctx)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(common.RequestIdKey, requestId)
+	user.WriteContext(c)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, task.Group)
+	_ = middleware.SetupContextForToken(c, token)
+	common.SetContextKey(c, constant.ContextKeyBatchRequest, true)
+	return c, w
+}
+
+func batchRelayFormat(endpoint string) types.RelayFormat {
+	switch endpoint {
+	case "/v1/embeddings":
+		return types.RelayFormatEmbedding
+	case "/v1/responses":
+		return types.RelayFormatOpenAIResponses
+	}
+	return types.RelayFormatOpenAI
+}
+
+// executeBatchLine 通过正常的转发流程执行一行批处理请求，计费与同步请求一致
+func executeBatchLine(ctx context.Context, task *model.Task, token *model.Token, user *model.UserBase, line dto.BatchRequestLine) (dto.BatchResponseLine, bool) {
+	c, w := newBatchContext(ctx, task, token, user, line.URL, line.Body)
+	middleware.Distribute()(c)
+	if !c.IsAborted() {
+		Relay(c, batchRelayFormat(line.URL))
+	}
+	body := w.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = common.Marshal(string(body))
+	}
+	result := dto.BatchResponseLine{
+		ID:       "batch_req_" + common.GetRandomString(24),
+		CustomID: line.CustomID,
+		Response: &dto.BatchResponseBody{
+			StatusCode: w.Code,
+			RequestID:  c.GetString(common.RequestIdKey),
+			Body:       body,
+		},
+	}
+	return result, w.Code/100 == 2
+}
+
+func loadBatchPartial(ctx context.Context, key string) []dto.BatchResponseLine {
+	reader, err := service.GetFileStorage().Get(ctx, key)
+	if err != nil {
+		return make([]dto.BatchResponseLine, 0)
+	}
+	defer reader.Close()
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(reader); err != nil {
+		return make([]dto.BatchResponseLine, 0)
+	}
+	return service.DecodeBatchLines(buf.Bytes())
+}
+
+func saveBatchPartial(ctx context.Context, key string, lines []dto.BatchResponseLine) error {
+	data, err := service.EncodeBatchLines(lines)
+	if err != nil {
+		return err
+	}
+	return service.GetFileStorage().Put(ctx, key, data, "application/jsonl")
+}
+
+// batchLineKey 单行结果的存储路径，按输入文件中的请求序号区分
+func batchLineKey(task *model.Task, index int) string {
+	return service.GenerateBatchStorageKey(task.UserId, task.TaskID, fmt.Sprintf("lines/%d.json", index))
+}
+
+func saveBatchLine(ctx context.Context, key string, line dto.BatchResponseLine) error {
+	data, err := common.Marshal(line)
+	if err != nil {
+		return err
+	}
+	return service.GetFileStorage().Put(ctx, key, data, "application/json")
+}
+
+// loadBatchLines 读取尚未合并到中间结果的单行结果，返回的键与结果一一对应。
+// 列举失败时返回错误，避免把已执行的请求当作未执行而重复计费
+func loadBatchLines(ctx context.Context, prefix string) ([]string, []dto.BatchResponseLine, error) {
+	storage := service.GetFileStorage()
+	objects, err := storage.List(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0, len(objects))
+	lines := make([]dto.BatchResponseLine, 0, len(objects))
+	for _, object := range objects {
+		reader, err := storage.Get(ctx, object.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(reader)
+		reader.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		var line dto.BatchResponseLine
+		if err := common.Unmarshal(buf.Bytes(), &line); err != nil || line.CustomID == "" {
+			continue
+		}
+		keys = append(keys, object.Key)
+		lines = append(lines, line)
+	}
+	return keys, lines, nil
+}
+
+// isBatchLineSucceeded 2xx 响应写入输出文件，其余写入错误文件
+func isBatchLineSucceeded(line dto.BatchResponseLine) bool {
+	return line.Error == nil && line.Response != nil && line.Response.StatusCode/100 == 2
+}
+
+func failBatch(task *model.Task, batch *dto.OpenAIBatch, code string, message string) {
+	batch.Status = dto.BatchStatusFailed
+	batch.FailedAt = time.Now().Unix()
+	batch.Errors = &dto.OpenAIBatchErrors{
+		Object: "list",
+		Data:   []dto.OpenAIBatchError{{Code: code, Message: message}},
+	}
+	applyBatchToTask(task, batch)
+	if err := task.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", task.TaskID, err.Error()))
+	}
+}
+
+func startLocalBatch(task *model.Task) {
+	if task == nil {
+		return
+	}
+	if _, loaded := runningBatches.LoadOrStore(task.TaskID, true); loaded {
+		return
+	}
+	claimed, err := model.ClaimTaskExecution(task.ID, batchLeaseSeconds)
+	if err != nil || !claimed {
+		runningBatches.Delete(task.TaskID)
+		return
+	}
+	gopool.Go(func() {
+		defer runningBatches.Delete(task.TaskID)
+		runLocalBatch(task)
+	})
+}
+
+// runLocalBatch 本地执行批处理，每行请求执行前后都会保存到对象存储，并定期合并为中间结果，
+// 重启或其他节点接手后从中断处继续，已执行过的请求不会重复执行和计费
+func runLocalBatch(task *model.Task) {
+	ctx := context.Background()
+	batch := &dto.OpenAIBatch{}
+	if err := task.GetData(batch); err != nil {
+		failBatch(task, batch, "invalid_batch", "failed to parse batch: "+err.Error())
+		return
+	}
+	token, user, err := getBatchOwner(task)
+	if err != nil {
+		failBatch(task, batch, "invalid_token", err.Error())
+		return
+	}
+	if token.Status != common.TokenStatusEnabled || user.Status != common.UserStatusEnabled {
+		failBatch(task, batch, "invalid_token", "令牌或用户已被禁用")
+		return
+	}
+	inputFile, err := model.GetUserFileByFileId(task.UserId, batch.InputFileID)
+	if err != nil {
+		failBatch(task, batch, "invalid_input_file", fmt.Sprintf("input file %s not found", batch.InputFileID))
+		return
+	}
+	data, err := service.GetFileContent(ctx, inputFile)
+	if err != nil {
+		failBatch(task, batch, "invalid_input_file", "failed to read input file: "+err.Error())
+		return
+	}
+	lines, err := service.ParseBatchInput(data, batch.Endpoint, 0)
+	if err != nil {
+		failBatch(task, batch, "invalid_input_file", err.Error())
+		return
+	}
+
+	outputKey := service.GenerateBatchStorageKey(task.UserId, task.TaskID, "output.jsonl")
+	errorKey := service.GenerateBatchStorageKey(task.UserId, task.TaskID, "errors.jsonl")
+	outputs := loadBatchPartial(ctx, outputKey)
+	failures := loadBatchPartial(ctx, errorKey)
+	done := make(map[string]bool, len(outputs)+len(failures))
+	for _, line := range outputs {
+		done[line.CustomID] = true
+	}
+	for _, line := range failures {
+		done[line.CustomID] = true
+	}
+	// 合并上次中断前已保存但尚未写入中间结果的单行结果
+	lineKeys, storedLines, err := loadBatchLines(ctx, service.GenerateBatchStorageKey(task.UserId, task.TaskID, "lines/"))
+	if err != nil {
+		// 等待租约过期后重试，不能在无法确认执行进度时继续执行
+		common.SysError(fmt.Sprintf("failed to load batch %s lines: %s", task.TaskID, err.Error()))
+		return
+	}
+	for _, line := range storedLines {
+		if done[line.CustomID] {
+			continue
+		}
+		done[line.CustomID] = true
+		if isBatchLineSucceeded(line) {
+			outputs = append(outputs, line)
+		} else {
+			failures = append(failures, line)
+		}
+	}
+
+	if batch.Status == dto.BatchStatusValidating {
+		batch.Status = dto.BatchStatusInProgress
+		batch.InProgressAt = time.Now().Unix()
+	}
+	batch.RequestCounts = dto.OpenAIBatchRequestCounts{
+		Total:     len(lines),
+		Completed: len(outputs),
+		Failed:    len(failures),
+	}
+	applyBatchToTask(task, batch)
+	if err = task.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", task.TaskID, err.Error()))
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	finalStatus := dto.BatchStatusCompleted
+
+	flush := func() {
+		mu.Lock()
+		outputSnapshot := append([]dto.BatchResponseLine(nil), outputs...)
+		failureSnapshot := append([]dto.BatchResponseLine(nil), failures...)
+		keySnapshot := append([]string(nil), lineKeys...)
+		mu.Unlock()
+		outputErr := saveBatchPartial(ctx, outputKey, outputSnapshot)
+		if outputErr != nil {
+			common.SysError(fmt.Sprintf("failed to save batch %s output: %s", task.TaskID, outputErr.Error()))
+		}
+		failureErr := saveBatchPartial(ctx, errorKey, failureSnapshot)
+		if failureErr != nil {
+			common.SysError(fmt.Sprintf("failed to save batch %s errors: %s", task.TaskID, failureErr.Error()))
+		}
+		// 单行结果已合并到中间结果，删除后恢复时无需逐个读取
+		if outputErr == nil && failureErr == nil {
+			for _, key := range keySnapshot {
+				_ = service.GetFileStorage().Delete(ctx, key)
+			}
+			mu.Lock()
+			lineKeys = lineKeys[len(keySnapshot):]
+			mu.Unlock()
+		}
+		// 取消请求可能由其他节点写入，需要重新读取
+		if latest, exist, err := model.GetByOnlyTaskId(task.TaskID); err == nil && exist {
+			latestBatch := &dto.OpenAIBatch{}
+			if latest.GetData(latestBatch) == nil && latestBatch.Status == dto.BatchStatusCancelling {
+				batch.Status = dto.BatchStatusCancelling
+				batch.CancellingAt = latestBatch.CancellingAt
+			}
+		}
+		if batch.Status == dto.BatchStatusCancelling {
+			finalStatus = dto.BatchStatusCancelled
+			cancel()
+		} else if batch.ExpiresAt > 0 && time.Now().Unix() > batch.ExpiresAt {
+			finalStatus = dto.BatchStatusExpired
+			cancel()
+		}
+		batch.RequestCounts.Completed = len(outputSnapshot)
+		batch.RequestCounts.Failed = len(failureSnapshot)
+		applyBatchToTask(task, batch)
+		if err := task.Update(); err != nil {
+			common.SysError(fmt.Sprintf("failed to update batch %s: %s", task.TaskID, err.Error()))
+		}
+	}
+
+	stopFlush := make(chan struct{})
+	flushStopped := make(chan struct{})
+	go func() {
+		defer close(flushStopped)
+		ticker := time.NewTicker(batchFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush()
+			case <-stopFlush:
+				return
+			}
+		}
+	}()
+
+	concurrency := common.Max(operation_setting.GetBatchSetting().MaxConcurrency, 1)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+dispatch:
+	for i, line := range lines {
+		if done[line.CustomID] {
+			continue
+		}
+		select {
+		case <-runCtx.Done():
+			break dispatch
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(key string, line dto.BatchRequestLine) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// 执行前先保存中断占位，节点崩溃或租约被接管后该行按失败处理，不会重复执行和计费
+			result := dto.BatchResponseLine{
+				ID:       "batch_req_" + common.GetRandomString(24),
+				CustomID: line.CustomID,
+				Error:    &dto.BatchResponseError{Code: "batch_interrupted", Message: "the request was interrupted before its result was saved"},
+			}
+			ok := false
+			if err := saveBatchLine(ctx, key, result); err != nil {
+				result.Error = &dto.BatchResponseError{Code: "batch_storage_error", Message: "failed to save batch progress: " + err.Error()}
+			} else {
+				// 已开始的请求不随取消中断，保证计费与结果一致
+				result, ok = executeBatchLine(ctx, task, token, user, line)
+				if err := saveBatchLine(ctx, key, result); err != nil {
+					common.SysError(fmt.Sprintf("failed to save batch %s line %s: %s", task.TaskID, line.CustomID, err.Error()))
+				}
+			}
+			mu.Lock()
+			if ok {
+				outputs = append(outputs, result)
+			} else {
+				failures = append(failures, result)
+			}
+			lineKeys = append(lineKeys, key)
+			mu.Unlock()
+		}(batchLineKey(task, i), line)
+	}
+	wg.Wait()
+	close(stopFlush)
+	<-flushStopped
+	flush()
+
+	finalizeLocalBatch(ctx, task, batch, token, finalStatus, outputs, failures)
+	_ = service.GetFileStorage().Delete(ctx, outputKey)
+	_ = service.GetFileStorage().Delete(ctx, errorKey)
+	for _, key := range lineKeys {
+		_ = service.GetFileStorage().Delete(ctx, key)
+	}
+}
+
+func finalizeLocalBatch(ctx context.Context, task *model.Task, batch *dto.OpenAIBatch, token *model.Token, status string, outputs []dto.BatchResponseLine, failures []dto.BatchResponseLine) {
+	batch.FinalizingAt = time.Now().Unix()
+	if len(outputs) > 0 {
+		data, err := service.EncodeBatchLines(outputs)
+		if err == nil {
+			var file *model.File
+			file, err = service.SaveBatchOutputFile(ctx, task.UserId, token.Id, task.TaskID+"_output.jsonl", data)
+			if err == nil {
+				batch.OutputFileID = file.FileId
+			}
+		}
+		if err != nil {
+			failBatch(task, batch, "output_file_error", "failed to save output file: "+err.Error())
+			return
+		}
+	}
+	if len(failures) > 0 {
+		data, err := service.EncodeBatchLines(failures)
+		if err == nil {
+			var file *model.File
+			file, err = service.SaveBatchOutputFile(ctx, task.UserId, token.Id, task.TaskID+"_error.jsonl", data)
+			if err == nil {
+				batch.ErrorFileID = file.FileId
+			}
+		}
+		if err != nil {
+			failBatch(task, batch, "output_file_error", "failed to save error file: "+err.Error())
+			return
+		}
+	}
+	now := time.Now().Unix()
+	batch.Status = status
+	switch status {
+	case dto.BatchStatusCancelled:
+		batch.CancelledAt = now
+	case dto.BatchStatusExpired:
+		batch.ExpiredAt = now
+	default:
+		batch.CompletedAt = now
+	}
+	applyBatchToTask(task, batch)
+	if err := task.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", task.TaskID, err.Error()))
+	}
+}
+
+func updateUpstreamBatchTasks(ctx context.Context, channelId int, taskIds []string, taskM map[string]*model.Task) error {
+	logger.LogInfo(ctx, fmt.Sprintf("渠道 #%d 未完成的批处理有: %d", channelId, len(taskIds)))
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		err = model.TaskBulkUpdate(taskIds, map[string]any{
+			"fail_reason": fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId),
+			"status":      "FAILURE",
+			"progress":    "100%",
+		})
+		return err
+	}
+	for _, taskId := range taskIds {
+		task := taskM[taskId]
+		batch := &dto.OpenAIBatch{}
+		if err := task.GetData(batch); err != nil {
+			continue
+		}
+		upstreamBatch, err := service.RetrieveUpstreamBatch(ctx, channel, task.PrivateData.ChannelKeyIndex, task.TaskID)
+		if err != nil {
+			logger.LogError(ctx, fmt.Sprintf("retrieve batch %s failed: %s", task.TaskID, err.Error()))
+			continue
+		}
+		// 保留本地的文件 ID，上游的结果文件同步后再写入
+		upstreamBatch.InputFileID = batch.InputFileID
+		upstreamBatch.OutputFileID = ""
+		upstreamBatch.ErrorFileID = ""
+		if upstreamBatch.IsTerminal() {
+			if err := settleUpstreamBatch(ctx, task, channel, batch, upstreamBatch); err != nil {
+				if !errors.Is(err, errBatchAlreadySettled) {
+					logger.LogError(ctx, fmt.Sprintf("settle batch %s failed: %s", task.TaskID, err.Error()))
+				}
+				continue
+			}
+		}
+		applyBatchToTask(task, upstreamBatch)
+		if err := task.Update(); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("update batch %s failed: %s", task.TaskID, err.Error()))
+		}
+	}
+	return nil
+}
+
+// settleUpstreamBatch 同步上游批处理的结果文件，按行记录费用，并与提交时的预扣费多退少补
+// 结果文件下载完成后先原子地领取结算，保证重复轮询或多个节点同时轮询时只结算一次
+func settleUpstreamBatch(ctx context.Context, task *model.Task, channel *model.Channel, batch *dto.OpenAIBatch, upstreamBatch *dto.OpenAIBatch) error {
+	token, user, err := getBatchOwner(task)
+	if err != nil {
+		return err
+	}
+	var outputData, errorData []byte
+	if upstreamBatch.OutputFileID != "" {
+		if outputData, err = service.DownloadUpstreamFile(ctx, channel, task.PrivateData.ChannelKeyIndex, upstreamBatch.OutputFileID); err != nil {
+			return err
+		}
+	}
+	if upstreamBatch.ErrorFileID != "" {
+		if errorData, err = service.DownloadUpstreamFile(ctx, channel, task.PrivateData.ChannelKeyIndex, upstreamBatch.ErrorFileID); err != nil {
+			return err
+		}
+	}
+	claimed, err := model.ClaimTaskSettlement(task.ID)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return errBatchAlreadySettled
+	}
+
+	// 领取结算后不再返回错误，保证任务状态一定会被更新
+	if outputData != nil {
+		if file, err := service.SaveBatchOutputFile(ctx, task.UserId, token.Id, task.TaskID+"_output.jsonl", outputData); err == nil {
+			upstreamBatch.OutputFileID = file.FileId
+		} else {
+			logger.LogError(ctx, fmt.Sprintf("save batch %s output file failed: %s", task.TaskID, err.Error()))
+		}
+	}
+	if errorData != nil {
+		if file, err := service.SaveBatchOutputFile(ctx, task.UserId, token.Id, task.TaskID+"_error.jsonl", errorData); err == nil {
+			upstreamBatch.ErrorFileID = file.FileId
+		} else {
+			logger.LogError(ctx, fmt.Sprintf("save batch %s error file failed: %s", task.TaskID, err.Error()))
+		}
+	}
+
+	// 计费使用请求中的模型名，上游返回的通常是带日期的具体版本
+	requestModels := make(map[string]string)
+	if inputFile, err := model.GetUserFileByFileId(task.UserId, batch.InputFileID); err == nil {
+		if data, err := service.GetFileContent(ctx, inputFile); err == nil {
+			if lines, err := service.ParseBatchInput(data, batch.Endpoint, 0); err == nil {
+				for _, line := range lines {
+					requestModels[line.CustomID] = gjson.GetBytes(line.Body, "model").String()
+				}
+			}
+		}
+	}
+	quota := 0
+	for _, line := range service.DecodeBatchLines(outputData) {
+		quota += settleUpstreamBatchLine(ctx, task, channel, token, user, batch.Endpoint, requestModels[line.CustomID], line)
+	}
+
+	c, _ := newBatchContext(ctx, task, token, user, batch.Endpoint, nil)
+	relayInfo, err := relaycommon.GenRelayInfo(c, types.RelayFormatTask, nil, nil)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("batch %s gen relay info failed: %s", task.TaskID, err.Error()))
+		return nil
+	}
+	if delta := quota - task.Quota; delta != 0 {
+		if err := service.PostConsumeQuota(relayInfo, delta, task.Quota, true); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("batch %s consume quota failed: %s", task.TaskID, err.Error()))
+		}
+	}
+	task.Quota = quota
+	return nil
+}
+
+// settleUpstreamBatchLine 计算单行结果的费用并记录消费日志，返回该行的额度，实际扣费由 settleUpstreamBatch 统一完成
+func settleUpstreamBatchLine(ctx context.Context, task *model.Task, channel *model.Channel, token *model.Token, user *model.UserBase, endpoint string, modelName string, line dto.BatchResponseLine) int {
+	if line.Response == nil || line.Response.StatusCode/100 != 2 {
+		return 0
+	}
+	body := line.Response.Body
+	if modelName == "" {
+		modelName = gjson.GetBytes(body, "model").String()
+	}
+	usage := gjson.GetBytes(body, "usage")
+	promptTokens := int(usage.Get("prompt_tokens").Int())
+	if !usage.Get("prompt_tokens").Exists() {
+		promptTokens = int(usage.Get("input_tokens").Int())
+	}
+	completionTokens := int(usage.Get("completion_tokens").Int())
+	if !usage.Get("completion_tokens").Exists() {
+		completionTokens = int(usage.Get("output_tokens").Int())
+	}
+
+	c, _ := newBatchContext(ctx, task, token, user, endpoint, nil)
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	relayInfo, err := relaycommon.GenRelayInfo(c, types.RelayFormatTask, nil, nil)
+	if err != nil {
+		logger.LogError(c, "gen relay info failed: "+err.Error())
+		return 0
+	}
+	relayInfo.ChannelMeta = &relaycommon.ChannelMeta{
+		ChannelType: channel.Type,
+		ChannelId:   channel.Id,
+	}
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, promptTokens, &types.TokenCountMeta{})
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("batch %s line %s price error: %s", task.TaskID, line.CustomID, err.Error()))
+		return 0
+	}
+	groupRatio := priceData.GroupRatioInfo.GroupRatio
+	var quotaDecimal decimal.Decimal
+	if priceData.UsePrice {
+		quotaDecimal = decimal.NewFromFloat(priceData.ModelPrice).Mul(decimal.NewFromFloat(common.QuotaPerUnit)).Mul(decimal.NewFromFloat(groupRatio))
+	} else {
+		quotaDecimal = decimal.NewFromInt(int64(promptTokens)).
+			Add(decimal.NewFromInt(int64(completionTokens)).Mul(decimal.NewFromFloat(priceData.CompletionRatio))).
+			Mul(decimal.NewFromFloat(priceData.ModelRatio)).Mul(decimal.NewFromFloat(groupRatio))
+	}
+	quota := int(quotaDecimal.Round(0).IntPart())
+	if quota == 0 && priceData.ModelRatio*groupRatio != 0 && promptTokens+completionTokens > 0 {
+		quota = 1
+	}
+	if quota > 0 {
+		model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quota)
+		model.UpdateChannelUsedQuota(channel.Id, quota)
+	}
+	other := service.GenerateTextOtherInfo(c, relayInfo, priceData.ModelRatio, groupRatio, priceData.CompletionRatio,
+		0, priceData.CacheRatio, priceData.ModelPrice, priceData.GroupRatioInfo.GroupSpecialRatio)
+	model.RecordConsumeLog(c, task.UserId, model.RecordConsumeLogParams{
+		ChannelId:        channel.Id,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		ModelName:        modelName,
+		TokenName:        token.Name,
+		Quota:            quota,
+		Content:          fmt.Sprintf("批处理 %s，请求 %s", task.TaskID, line.CustomID),
+		TokenId:          token.Id,
+		Group:            relayInfo.UsingGroup,
+		OrganizationId:   token.OrganizationId,
+		Other:            other,
+	})
+	return quota
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+)
+
+func readBatchResultFile(t *testing.T, userId int, fileId string) []dto.BatchResponseLine {
+	t.Helper()
+	file, err := model.GetUserFileByFileId(userId, fileId)
+	if err != nil {
+		t.Fatalf("result file %q not found: %v", fileId, err)
+	}
+	data, err := service.GetFileContent(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service.DecodeBatchLines(data)
+}
+
+func TestRunLocalBatchResumesFromSavedLines(t *testing.T) {
+	setupFileTestDB(t)
+	if err := model.DB.AutoMigrate(&model.User{}, &model.Token{}, &model.Task{}, &model.Ability{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	user := &model.User{Username: "batch_resume", Status: common.UserStatusEnabled, Group: "default"}
+	if err := model.DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{UserId: user.Id, Key: common.GetRandomString(48), Name: "batch", Status: common.TokenStatusEnabled, UnlimitedQuota: true}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	input := `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+{"custom_id":"b","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+{"custom_id":"c","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o"}}
+`
+	fileId := "file-" + common.GetRandomString(24)
+	storageType, storageKey, err := service.SaveFileContent(ctx, user.Id, fileId, []byte(input), "application/jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile := &model.File{FileId: fileId, UserId: user.Id, Purpose: dto.FilePurposeBatch, StorageType: storageType, StorageKey: storageKey}
+	if err := inputFile.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &model.Task{
+		TaskID:      "batch_" + common.GetRandomString(24),
+		Platform:    constant.TaskPlatformBatch,
+		UserId:      user.Id,
+		Group:       "default",
+		Action:      constant.TaskActionBatchLocal,
+		PrivateData: model.TaskPrivateData{TokenId: token.Id},
+	}
+	batch := &dto.OpenAIBatch{ID: task.TaskID, Object: "batch", Endpoint: "/v1/chat/completions", InputFileID: fileId, Status: dto.BatchStatusInProgress}
+	applyBatchToTask(task, batch)
+	if err := task.Insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟上次执行中断：a 已完成但未合并到中间结果，b 执行中节点崩溃，c 尚未执行
+	completed := dto.BatchResponseLine{ID: "batch_req_saved", CustomID: "a", Response: &dto.BatchResponseBody{StatusCode: 200, Body: []byte(`{}`)}}
+	if err := saveBatchLine(ctx, batchLineKey(task, 0), completed); err != nil {
+		t.Fatal(err)
+	}
+	interrupted := dto.BatchResponseLine{ID: "batch_req_interrupted", CustomID: "b", Error: &dto.BatchResponseError{Code: "batch_interrupted"}}
+	if err := saveBatchLine(ctx, batchLineKey(task, 1), interrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	runLocalBatch(task)
+
+	latest, exist, err := model.GetByOnlyTaskId(task.TaskID)
+	if err != nil || !exist {
+		t.Fatalf("batch task not found: %v", err)
+	}
+	result := &dto.OpenAIBatch{}
+	if err := latest.GetData(result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != dto.BatchStatusCompleted {
+		t.Fatalf("expected completed batch, got %s", result.Status)
+	}
+	outputs := readBatchResultFile(t, user.Id, result.OutputFileID)
+	if len(outputs) != 1 || outputs[0].ID != completed.ID {
+		t.Fatalf("saved output should be reused without re-running, got %+v", outputs)
+	}
+	failures := readBatchResultFile(t, user.Id, result.ErrorFileID)
+	if len(failures) != 2 {
+		t.Fatalf("expected 2 failed lines, got %+v", failures)
+	}
+	for _, line := range failures {
+		switch line.CustomID {
+		case "b":
+			// 中断的请求可能已经计费，不能重新执行
+			if line.ID != interrupted.ID || line.Error == nil || line.Error.Code != "batch_interrupted" {
+				t.Fatalf("interrupted line should be reported as failed, got %+v", line)
+			}
+		case "c":
+			// 没有可用渠道，执行失败
+			if line.Response == nil || line.Response.StatusCode/100 == 2 {
+				t.Fatalf("line c should be executed and fail, got %+v", line)
+			}
+		default:
+			t.Fatalf("unexpected failed line %+v", line)
+		}
+	}
+	objects, err := service.GetFileStorage().List(ctx, service.GenerateBatchStorageKey(user.Id, task.TaskID, ""))
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("intermediate results should be deleted, got %+v %v", objects, err)
+	}
+}
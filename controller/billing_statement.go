@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+type billingStatementGenerateRequest struct {
+	Period string `json:"period"` // 账单月份，如 2026-09，留空为上个月
+}
+
+// getSelfBillingStatementOwner 默认为当前用户的账单，指定 organization_id 时需要是组织的所有者或财务
+func getSelfBillingStatementOwner(c *gin.Context) (string, int, error) {
+	organizationId, _ := strconv.Atoi(c.Query("organization_id"))
+	if organizationId == 0 {
+		return model.BillingStatementOwnerUser, c.GetInt("id"), nil
+	}
+	member, err := model.GetOrganizationMember(organizationId, c.GetInt("id"))
+	if err != nil || !member.CanManageBilling() {
+		return "", 0, errors.New("无权查看该组织的账单")
+	}
+	return model.BillingStatementOwnerOrganization, organizationId, nil
+}
+
+// writeBillingStatement 按 format 参数输出账单：json（默认）、html 或 csv，download=true 时作为附件下载
+func writeBillingStatement(c *gin.Context, statement *model.BillingStatement) {
+	filename := fmt.Sprintf("statement-%s-%d-%s", statement.OwnerType, statement.OwnerId, statement.Period)
+	c.Header("X-Statement-Hash", statement.Hash)
+	switch c.DefaultQuery("format", "json") {
+	case "html":
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		if c.Query("download") == "true" {
+			c.Header("Content-Disposition", "attachment; filename="+filename+".html")
+		}
+		if err := service.RenderBillingStatementHTML(statement, c.Writer); err != nil {
+			common.SysError("failed to render billing statement: " + err.Error())
+		}
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename="+filename+".csv")
+		if err := service.RenderBillingStatementCSV(statement, c.Writer); err != nil {
+			common.SysError("failed to render billing statement: " + err.Error())
+		}
+	default:
+		detail, err := service.GetBillingStatementDetail(statement)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		verified := statement.Verify()
+		summary := *statement
+		summary.Detail = ""
+		common.ApiSuccess(c, gin.H{
+			"statement": summary,
+			"detail":    detail,
+			"verified":  verified,
+		})
+	}
+}
+
+// GetSelfBillingStatements 查询当前用户或组织已生成的账单列表
+func GetSelfBillingStatements(c *gin.Context) {
+	ownerType, ownerId, err := getSelfBillingStatementOwner(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	statements, total, err := model.GetBillingStatements(ownerType, ownerId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(statements)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetSelfBillingStatement 获取指定月份的账单，尚未生成时立即生成，只能获取已结束的月份
+func GetSelfBillingStatement(c *gin.Context) {
+	ownerType, ownerId, err := getSelfBillingStatementOwner(c)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	statement, err := service.GetOrCreateBillingStatement(ownerType, ownerId, c.Param("period"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	writeBillingStatement(c, statement)
+}
+
+// GetBillingStatement 管理员获取任意用户或组织的账单
+func GetBillingStatement(c *gin.Context) {
+	ownerType := c.DefaultQuery("owner_type", model.BillingStatementOwnerUser)
+	if ownerType != model.BillingStatementOwnerUser && ownerType != model.BillingStatementOwnerOrganization {
+		common.ApiErrorMsg(c, "无效的账单主体类型")
+		return
+	}
+	ownerId, _ := strconv.Atoi(c.Query("owner_id"))
+	statement, err := service.GetOrCreateBillingStatement(ownerType, ownerId, c.Param("period"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	writeBillingStatement(c, statement)
+}
+
+// GenerateBillingStatements 管理员在后台为指定月份批量生成账单
+func GenerateBillingStatements(c *gin.Context) {
+	var req billingStatementGenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Period == "" {
+		req.Period = service.PreviousBillingStatementPeriod(time.Now())
+	}
+	_, end, err := service.ParseBillingStatementPeriod(req.Period)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if end.After(time.Now()) {
+		common.ApiErrorMsg(c, "账单月份尚未结束")
+		return
+	}
+	if service.IsBillingStatementGenerating() {
+		common.ApiErrorMsg(c, "账单正在生成中")
+		return
+	}
+	gopool.Go(func() {
+		runGenerateBillingStatements(req.Period)
+	})
+	common.ApiSuccess(c, nil)
+}
+
+func runGenerateBillingStatements(period string) {
+	startTime := time.Now()
+	generated, err := service.GenerateBillingStatements(period)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to generate billing statements for %s: %s", period, err.Error()))
+		return
+	}
+	common.SysLog(fmt.Sprintf("generated %d billing statements for %s in %s", generated, period, time.Since(startTime)))
+}
+
+// AutomaticallyGenerateBillingStatements 每月 1 日凌晨 1 点后为上个月生成账单
+func AutomaticallyGenerateBillingStatements() {
+	lastPeriod := ""
+	for {
+		time.Sleep(10 * time.Minute)
+		if !operation_setting.GetBillingStatementSetting().AutoGenerate {
+			continue
+		}
+		now := time.Now()
+		period := service.PreviousBillingStatementPeriod(now)
+		// 留出 1 小时等待上月末的异步任务结算和日志写入
+		if (now.Day() == 1 && now.Hour() < 1) || lastPeriod == period {
+			continue
+		}
+		lastPeriod = period
+		if service.IsBillingStatementGenerating() {
+			continue
+		}
+		runGenerateBillingStatements(period)
+	}
+}
@@ -658,6 +658,9 @@ func AddChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	for i := range channels {
+		service.RecordAudit(c, "channel.create", "channel", channels[i].Id, nil, &channels[i])
+	}
 	service.ResetProxyClientCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -668,12 +671,16 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	originChannel, _ := model.GetChannelById(id, true)
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	if originChannel != nil {
+		service.RecordAudit(c, "channel.delete", "channel", id, originChannel, nil)
+	}
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -794,11 +801,17 @@ func EditTagChannels(c *gin.Context) {
 		}
 		channelTag.HeaderOverride = common.GetPointer[string](trimmed)
 	}
+	originChannels, _ := model.GetChannelsByTag(channelTag.Tag, true, false)
 	err = model.EditChannelByTag(channelTag.Tag, channelTag.NewTag, channelTag.ModelMapping, channelTag.Models, channelTag.Groups, channelTag.Priority, channelTag.Weight, channelTag.ParamOverride, channelTag.HeaderOverride)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	for _, originChannel := range originChannels {
+		if updatedChannel, err := model.GetChannelById(originChannel.Id, false); err == nil {
+			service.RecordAudit(c, "channel.edit_tag", "channel", originChannel.Id, originChannel, updatedChannel)
+		}
+	}
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -943,6 +956,9 @@ func UpdateChannel(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if updatedChannel, err := model.GetChannelById(channel.Id, true); err == nil {
+		service.RecordAudit(c, "channel.update", "channel", channel.Id, originChannel, updatedChannel)
+	}
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
 	channel.Key = ""
@@ -1212,6 +1228,15 @@ func ManageMultiKeys(c *gin.Context) {
 	lock.Lock()
 	defer lock.Unlock()
 
+	if request.Action != "get_key_status" {
+		before := service.AuditSnapshot(channel)
+		defer func() {
+			if updatedChannel, err := model.GetChannelById(channel.Id, true); err == nil {
+				service.RecordAudit(c, "channel.multi_key."+request.Action, "channel", channel.Id, before, updatedChannel)
+			}
+		}()
+	}
+
 	switch request.Action {
 	case "get_key_status":
 		keys := channel.GetKeys()
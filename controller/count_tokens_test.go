@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+type countTokensUpstream struct {
+	requests []*http.Request
+	bodies   []string
+}
+
+// newCountTokensUpstream 启动模拟上游，按 status 和 body 返回固定响应并记录收到的请求
+func newCountTokensUpstream(t *testing.T, status int, body string) (*countTokensUpstream, string) {
+	t.Helper()
+	upstream := &countTokensUpstream{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		upstream.requests = append(upstream.requests, r)
+		upstream.bodies = append(upstream.bodies, string(data))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return upstream, server.URL
+}
+
+// newCountTokensContext 模拟 Distribute 选中渠道后的上下文
+func newCountTokensContext(path string, body string, modelName string, channelType int, baseURL string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	common.SetContextKey(c, constant.ContextKeyOriginalModel, modelName)
+	common.SetContextKey(c, constant.ContextKeyChannelId, 1)
+	common.SetContextKey(c, constant.ContextKeyChannelType, channelType)
+	common.SetContextKey(c, constant.ContextKeyChannelBaseUrl, baseURL)
+	common.SetContextKey(c, constant.ContextKeyChannelKey, "sk-test")
+	return c, recorder
+}
+
+const claudeCountTokensBody = `{"model":"claude-sonnet-4","system":"Answer briefly.","messages":[{"role":"user","content":"What is the weather in Paris today?"}]}`
+
+const geminiCountTokensBody = `{"contents":[{"role":"user","parts":[{"text":"What is the weather in Paris today?"}]}]}`
+
+func TestCountTokensUpstream(t *testing.T) {
+	service.InitHttpClient()
+	tests := []struct {
+		name         string
+		format       types.RelayFormat
+		channelType  int
+		path         string
+		body         string
+		modelName    string
+		response     string
+		upstreamPath string
+		authHeader   string
+	}{
+		{
+			name:         "anthropic",
+			format:       types.RelayFormatClaude,
+			channelType:  constant.ChannelTypeAnthropic,
+			path:         "/v1/messages/count_tokens",
+			body:         claudeCountTokensBody,
+			modelName:    "claude-sonnet-4",
+			response:     `{"input_tokens":42}`,
+			upstreamPath: "/v1/messages/count_tokens",
+			authHeader:   "x-api-key",
+		},
+		{
+			name:         "gemini",
+			format:       types.RelayFormatGemini,
+			channelType:  constant.ChannelTypeGemini,
+			path:         "/v1beta/models/gemini-2.0-flash:countTokens",
+			body:         geminiCountTokensBody,
+			modelName:    "gemini-2.0-flash",
+			response:     `{"totalTokens":17}`,
+			upstreamPath: "/models/gemini-2.0-flash:countTokens",
+			authHeader:   "x-goog-api-key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, baseURL := newCountTokensUpstream(t, http.StatusOK, tt.response)
+			c, recorder := newCountTokensContext(tt.path, tt.body, tt.modelName, tt.channelType, baseURL)
+			CountTokens(c, tt.format)
+
+			if recorder.Code != http.StatusOK || recorder.Body.String() != tt.response {
+				t.Fatalf("upstream response should be returned as is, got %d %s", recorder.Code, recorder.Body.String())
+			}
+			if len(upstream.requests) != 1 {
+				t.Fatalf("expected one upstream request, got %d", len(upstream.requests))
+			}
+			request := upstream.requests[0]
+			if !strings.HasSuffix(request.URL.Path, tt.upstreamPath) {
+				t.Fatalf("unexpected upstream path %s", request.URL.Path)
+			}
+			if request.Header.Get(tt.authHeader) != "sk-test" {
+				t.Fatalf("channel key should be sent in %s, got %v", tt.authHeader, request.Header)
+			}
+			if tt.format == types.RelayFormatClaude && gjson.Get(upstream.bodies[0], "model").String() != tt.modelName {
+				t.Fatalf("model should be forwarded, got %s", upstream.bodies[0])
+			}
+		})
+	}
+}
+
+func TestCountTokensLocalEstimate(t *testing.T) {
+	service.InitHttpClient()
+	tests := []struct {
+		name        string
+		format      types.RelayFormat
+		channelType int
+		path        string
+		body        string
+		modelName   string
+		field       string
+		status      int // 模拟上游的响应状态，0 表示渠道不支持，不应请求上游
+	}{
+		{"claude on openai channel", types.RelayFormatClaude, constant.ChannelTypeOpenAI, "/v1/messages/count_tokens", claudeCountTokensBody, "claude-sonnet-4", "input_tokens", 0},
+		{"gemini on openai channel", types.RelayFormatGemini, constant.ChannelTypeOpenAI, "/v1beta/models/gemini-2.0-flash:countTokens", geminiCountTokensBody, "gemini-2.0-flash", "totalTokens", 0},
+		{"anthropic upstream failure", types.RelayFormatClaude, constant.ChannelTypeAnthropic, "/v1/messages/count_tokens", claudeCountTokensBody, "claude-sonnet-4", "input_tokens", http.StatusInternalServerError},
+		{"gemini upstream failure", types.RelayFormatGemini, constant.ChannelTypeGemini, "/v1beta/models/gemini-2.0-flash:countTokens", geminiCountTokensBody, "gemini-2.0-flash", "totalTokens", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, baseURL := newCountTokensUpstream(t, tt.status, `{"error":{"message":"unavailable"}}`)
+			c, recorder := newCountTokensContext(tt.path, tt.body, tt.modelName, tt.channelType, baseURL)
+			CountTokens(c, tt.format)
+
+			if recorder.Code != http.StatusOK || gjson.Get(recorder.Body.String(), tt.field).Int() <= 0 {
+				t.Fatalf("expected local estimate in %s, got %d %s", tt.field, recorder.Code, recorder.Body.String())
+			}
+			expectedRequests := 1
+			if tt.status == 0 {
+				expectedRequests = 0
+			}
+			if len(upstream.requests) != expectedRequests {
+				t.Fatalf("expected %d upstream requests, got %d", expectedRequests, len(upstream.requests))
+			}
+		})
+	}
+}
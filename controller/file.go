@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func fileError(c *gin.Context, statusCode int, errType string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": gin.H{
+			"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			"type":    errType,
+		},
+	})
+}
+
+// selectFileChannel 选择上传文件所用的渠道
+// 优先使用令牌指定的渠道，其次根据表单中的 model 字段选择，都没有时返回 nil，文件仅保存在本地
+// 与 Distribute 一致，按 model 选择时校验令牌的模型限制，并只在令牌分组下选择渠道
+func selectFileChannel(c *gin.Context, modelName string) (*model.Channel, error) {
+	if channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId); ok {
+		id, err := strconv.Atoi(fmt.Sprintf("%v", channelId))
+		if err != nil {
+			return nil, errors.New("无效的渠道 Id")
+		}
+		channel, err := model.CacheGetChannel(id)
+		if err != nil {
+			return nil, err
+		}
+		if channel.Status != common.ChannelStatusEnabled {
+			return nil, errors.New("该渠道已被禁用")
+		}
+		return channel, nil
+	}
+	if modelName == "" {
+		return nil, nil
+	}
+	if err := middleware.CheckTokenModelLimit(c, modelName); err != nil {
+		return nil, err
+	}
+	channel, _, err := service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+		Ctx:        c,
+		ModelName:  modelName,
+		TokenGroup: common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		Retry:      common.GetPointer(0),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if channel == nil {
+		return nil, fmt.Errorf("分组 %s 下模型 %s 无可用渠道", common.GetContextKeyString(c, constant.ContextKeyUsingGroup), modelName)
+	}
+	return channel, nil
+}
+
+// UploadFile 处理 POST /v1/files
+func UploadFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if !dto.IsValidFilePurpose(purpose) {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid purpose: %s", purpose))
+		return
+	}
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", "file is required")
+		return
+	}
+	if formFile.Size > int64(constant.MaxUploadFileMB)<<20 {
+		fileError(c, http.StatusRequestEntityTooLarge, "invalid_request_error", fmt.Sprintf("file is too large, max %d MB", constant.MaxUploadFileMB))
+		return
+	}
+	reader, err := formFile.Open()
+	if err != nil {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", "failed to read file: "+err.Error())
+		return
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		fileError(c, http.StatusBadRequest, "invalid_request_error", "failed to read file: "+err.Error())
+		return
+	}
+	contentType := formFile.Header.Get("Content-Type")
+
+	userId := c.GetInt("id")
+	now := time.Now().Unix()
+	file := &model.File{
+		FileId:      "file-" + common.GetRandomString(24),
+		UserId:      userId,
+		TokenId:     c.GetInt("token_id"),
+		Filename:    formFile.Filename,
+		Purpose:     purpose,
+		Bytes:       int64(len(data)),
+		ContentType: contentType,
+		Status:      model.FileStatusProcessed,
+		CreatedAt:   now,
+	}
+	if seconds, err := strconv.ParseInt(c.PostForm("expires_after[seconds]"), 10, 64); err == nil && seconds > 0 {
+		file.ExpiresAt = now + seconds
+	}
+
+	channel, err := selectFileChannel(c, c.PostForm("model"))
+	if err != nil {
+		fileError(c, http.StatusServiceUnavailable, "new_api_error", "failed to select channel: "+err.Error())
+		return
+	}
+	if channel != nil && service.IsFileUploadSupportedChannel(channel.Type) {
+		_, keyIndex, newAPIError := channel.GetNextEnabledKey()
+		if newAPIError != nil {
+			fileError(c, http.StatusServiceUnavailable, "new_api_error", "failed to select channel key: "+newAPIError.Error())
+			return
+		}
+		upstreamFile, err := service.UploadFileToChannel(c.Request.Context(), channel, keyIndex, formFile.Filename, purpose, contentType, data)
+		if err != nil {
+			logger.LogError(c, fmt.Sprintf("upload file to channel #%d failed: %s", channel.Id, err.Error()))
+			fileError(c, http.StatusBadGateway, "upstream_error", "failed to upload file to upstream")
+			return
+		}
+		// 使用上游的文件 ID，后续请求引用该 ID 时可以直接透传给上游
+		file.FileId = upstreamFile.ID
+		file.UpstreamFileId = upstreamFile.ID
+		file.ChannelId = channel.Id
+		file.ChannelKeyIndex = keyIndex
+		if upstreamFile.ExpiresAt > 0 {
+			file.ExpiresAt = upstreamFile.ExpiresAt
+		}
+	}
+
+	storageType, storageKey, err := service.SaveFileContent(c.Request.Context(), userId, file.FileId, data, contentType)
+	if err != nil {
+		logger.LogError(c, "save file content failed: "+err.Error())
+		cleanupUploadedFile(c, channel, file)
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to save file")
+		return
+	}
+	file.StorageType = storageType
+	file.StorageKey = storageKey
+	if err = file.Insert(); err != nil {
+		logger.LogError(c, "insert file failed: "+err.Error())
+		cleanupUploadedFile(c, channel, file)
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to save file")
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+// cleanupUploadedFile 上传流程中途失败时删除已上传到上游的文件和已保存的内容，避免留下无法访问的文件
+func cleanupUploadedFile(c *gin.Context, channel *model.Channel, file *model.File) {
+	if file.UpstreamFileId != "" {
+		if err := service.DeleteUpstreamFile(c.Request.Context(), channel, file.ChannelKeyIndex, file.UpstreamFileId); err != nil {
+			logger.LogError(c, fmt.Sprintf("delete upstream file %s on channel #%d failed: %s", file.UpstreamFileId, channel.Id, err.Error()))
+		}
+	}
+	if file.StorageKey != "" {
+		if err := service.GetFileStorage().Delete(c.Request.Context(), file.StorageKey); err != nil {
+			logger.LogError(c, fmt.Sprintf("delete content of file %s failed: %s", file.FileId, err.Error()))
+		}
+	}
+}
+
+// ListFiles 处理 GET /v1/files
+func ListFiles(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit+1, c.Query("order") == "asc")
+	if err != nil {
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to list files")
+		return
+	}
+	resp := dto.OpenAIFileList{
+		Object: "list",
+		Data:   make([]dto.OpenAIFile, 0, len(files)),
+	}
+	if len(files) > limit {
+		resp.HasMore = true
+		files = files[:limit]
+	}
+	for _, file := range files {
+		resp.Data = append(resp.Data, file.ToOpenAIFile())
+	}
+	if len(resp.Data) > 0 {
+		resp.FirstID = resp.Data[0].ID
+		resp.LastID = resp.Data[len(resp.Data)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func getUserFileOrAbort(c *gin.Context) *model.File {
+	file, err := model.GetUserFileByFileId(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fileError(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		} else {
+			fileError(c, http.StatusInternalServerError, "server_error", "failed to query file")
+		}
+		return nil
+	}
+	return file
+}
+
+// RetrieveFile 处理 GET /v1/files/:id
+func RetrieveFile(c *gin.Context) {
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, file.ToOpenAIFile())
+}
+
+// RetrieveFileContent 处理 GET /v1/files/:id/content
+func RetrieveFileContent(c *gin.Context) {
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	data, err := service.GetFileContent(c.Request.Context(), file)
+	if err != nil {
+		logger.LogError(c, fmt.Sprintf("read file %s content failed: %s", file.FileId, err.Error()))
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to read file content")
+		return
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, file.Filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// DeleteFile 处理 DELETE /v1/files/:id
+func DeleteFile(c *gin.Context) {
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	if err := service.DeleteFileContent(c.Request.Context(), file); err != nil {
+		logger.LogError(c, fmt.Sprintf("delete file %s content failed: %s", file.FileId, err.Error()))
+	}
+	if err := file.Delete(); err != nil {
+		fileError(c, http.StatusInternalServerError, "server_error", "failed to delete file")
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{
+		ID:      file.FileId,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+// AutomaticallyCleanupExpiredFiles 定期清理已过期的文件
+func AutomaticallyCleanupExpiredFiles() {
+	for {
+		time.Sleep(10 * time.Minute)
+		service.CleanupExpiredFiles(context.Background())
+	}
+}
+
+// AutomaticallyCleanupBodyCaptures 定期清理超过保留天数的请求/响应体采集记录
+func AutomaticallyCleanupBodyCaptures() {
+	for {
+		time.Sleep(time.Hour)
+		service.CleanupExpiredBodyCaptures(context.Background())
+	}
+}
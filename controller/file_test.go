@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func setupFileTestDB(t *testing.T) {
+	t.Helper()
+	originalDB, sqlitePath, usingSQLite, isMasterNode, redisEnabled := model.DB, common.SQLitePath, common.UsingSQLite, common.IsMasterNode, common.RedisEnabled
+	t.Cleanup(func() {
+		model.DB, common.SQLitePath, common.UsingSQLite, common.IsMasterNode, common.RedisEnabled = originalDB, sqlitePath, usingSQLite, isMasterNode, redisEnabled
+	})
+	t.Setenv("SQL_DSN", "")
+	common.SQLitePath = "file:controller_file?mode=memory&cache=shared"
+	common.IsMasterNode = false
+	common.RedisEnabled = false
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DB.AutoMigrate(&model.File{}, &model.Channel{}); err != nil {
+		t.Fatal(err)
+	}
+	// 文件存储只初始化一次，需在首次使用前指向临时目录
+	constant.FileStorageType = ""
+	constant.FileStoragePath = t.TempDir()
+	originalMaxUploadFileMB := constant.MaxUploadFileMB
+	t.Cleanup(func() { constant.MaxUploadFileMB = originalMaxUploadFileMB })
+	constant.MaxUploadFileMB = 1
+}
+
+func newFileTestContext(userId int, method string, path string, body *bytes.Buffer, contentType string) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	if body == nil {
+		body = &bytes.Buffer{}
+	}
+	c.Request = httptest.NewRequest(method, path, body)
+	if contentType != "" {
+		c.Request.Header.Set("Content-Type", contentType)
+	}
+	c.Set("id", userId)
+	return c, recorder
+}
+
+func newUploadFileTestContext(userId int, content []byte) (*gin.Context, *httptest.ResponseRecorder) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("purpose", "batch")
+	part, _ := writer.CreateFormFile("file", "input.jsonl")
+	_, _ = part.Write(content)
+	_ = writer.Close()
+	return newFileTestContext(userId, http.MethodPost, "/v1/files", &body, writer.FormDataContentType())
+}
+
+func TestFileLocalStorageRoundTripAndOwnership(t *testing.T) {
+	setupFileTestDB(t)
+	content := []byte(`{"custom_id":"1","method":"POST","url":"/v1/chat/completions","body":{}}` + "\n")
+
+	c, recorder := newUploadFileTestContext(1, content)
+	UploadFile(c)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", recorder.Code, recorder.Body.String())
+	}
+	var uploaded dto.OpenAIFile
+	if err := common.Unmarshal(recorder.Body.Bytes(), &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Bytes != int64(len(content)) || uploaded.Filename != "input.jsonl" {
+		t.Fatalf("unexpected file object: %+v", uploaded)
+	}
+
+	fileRequest := func(userId int, method string, path string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		c, recorder := newFileTestContext(userId, method, path, nil, "")
+		c.Params = gin.Params{{Key: "id", Value: uploaded.ID}}
+		handler(c)
+		return recorder
+	}
+
+	// 其他用户无法访问、下载或删除该文件
+	for _, tc := range []struct {
+		method  string
+		path    string
+		handler gin.HandlerFunc
+	}{
+		{http.MethodGet, "/v1/files/" + uploaded.ID, RetrieveFile},
+		{http.MethodGet, "/v1/files/" + uploaded.ID + "/content", RetrieveFileContent},
+		{http.MethodDelete, "/v1/files/" + uploaded.ID, DeleteFile},
+	} {
+		if recorder := fileRequest(2, tc.method, tc.path, tc.handler); recorder.Code != http.StatusNotFound {
+			t.Fatalf("%s %s by another user should be 404, got %d", tc.method, tc.path, recorder.Code)
+		}
+	}
+	c, recorder = newFileTestContext(2, http.MethodGet, "/v1/files", nil, "")
+	ListFiles(c)
+	if recorder.Code != http.StatusOK || bytes.Contains(recorder.Body.Bytes(), []byte(uploaded.ID)) {
+		t.Fatalf("files of other users should not be listed, got %s", recorder.Body.String())
+	}
+
+	recorder = fileRequest(1, http.MethodGet, "/v1/files/"+uploaded.ID+"/content", RetrieveFileContent)
+	if recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), content) {
+		t.Fatalf("content should round trip, got %d %q", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder = fileRequest(1, http.MethodDelete, "/v1/files/"+uploaded.ID, DeleteFile); recorder.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder = fileRequest(1, http.MethodGet, "/v1/files/"+uploaded.ID, RetrieveFile); recorder.Code != http.StatusNotFound {
+		t.Fatalf("deleted file should be 404, got %d", recorder.Code)
+	}
+}
+
+func TestUploadFileDeletesUpstreamFileOnFailure(t *testing.T) {
+	setupFileTestDB(t)
+	service.InitHttpClient()
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"id":"file-upstream","object":"file","bytes":2,"filename":"input.jsonl","purpose":"batch"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"file-upstream","object":"file","deleted":true}`))
+	}))
+	t.Cleanup(server.Close)
+	channel := &model.Channel{Type: constant.ChannelTypeOpenAI, Key: "sk-0\nsk-1", Status: common.ChannelStatusEnabled, BaseURL: common.GetPointer(server.URL)}
+	channel.ChannelInfo.IsMultiKey = true
+	channel.ChannelInfo.MultiKeySize = 2
+	channel.ChannelInfo.MultiKeyStatusList = map[int]int{0: common.ChannelStatusManuallyDisabled}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件记录写入失败
+	err := model.DB.Callback().Create().Before("gorm:create").Register("test:fail_file_insert", func(tx *gorm.DB) {
+		if tx.Statement.Table == "files" {
+			_ = tx.AddError(errors.New("file insert failed"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = model.DB.Callback().Create().Remove("test:fail_file_insert") })
+
+	c, recorder := newUploadFileTestContext(1, []byte("{}"))
+	common.SetContextKey(c, constant.ContextKeyTokenSpecificChannelId, strconv.Itoa(channel.Id))
+	UploadFile(c)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("upload should fail, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	expected := []string{"POST /v1/files Bearer sk-1", "DELETE /v1/files/file-upstream Bearer sk-1"}
+	if len(requests) != len(expected) || requests[0] != expected[0] || requests[1] != expected[1] {
+		t.Fatalf("upstream file should be deleted with the upload key, got %v", requests)
+	}
+}
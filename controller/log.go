@@ -6,6 +6,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -167,3 +168,14 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+// GetLogCaptures 按请求 ID 查看采集的请求体和响应体
+func GetLogCaptures(c *gin.Context) {
+	requestId := c.Param("request_id")
+	captures, err := service.GetBodyCaptures(requestId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, captures)
+}
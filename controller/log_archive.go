@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+func getLogArchiveFilter(c *gin.Context) *service.LogArchiveFilter {
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	return &service.LogArchiveFilter{
+		Type:           logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		Username:       c.Query("username"),
+		TokenName:      c.Query("token_name"),
+		ModelName:      c.Query("model_name"),
+		ChannelId:      channel,
+		Group:          c.Query("group"),
+	}
+}
+
+// ListLogArchives 列出时间范围内的日志归档文件
+func ListLogArchives(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	objects, err := service.ListLogArchives(c.Request.Context(), startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"items":   objects,
+		"running": service.IsLogArchiveRunning(),
+	})
+}
+
+// RunLogArchive 立即在后台执行一次日志归档
+func RunLogArchive(c *gin.Context) {
+	if service.IsLogArchiveRunning() {
+		common.ApiErrorMsg(c, "log archive is already running")
+		return
+	}
+	gopool.Go(func() {
+		runLogArchive()
+	})
+	common.ApiSuccess(c, nil)
+}
+
+// RestoreLogArchives 将时间范围内的归档日志写回数据库，必须指定时间范围
+func RestoreLogArchives(c *gin.Context) {
+	filter := getLogArchiveFilter(c)
+	if filter.StartTimestamp == 0 || filter.EndTimestamp == 0 {
+		common.ApiErrorMsg(c, "start timestamp and end timestamp are required")
+		return
+	}
+	if filter.EndTimestamp < filter.StartTimestamp {
+		common.ApiErrorMsg(c, "end timestamp must not be earlier than start timestamp")
+		return
+	}
+	count, err := service.RestoreLogArchives(c.Request.Context(), filter)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, count)
+}
+
+// QueryLogArchives 直接查询归档中的日志，无需写回数据库
+func QueryLogArchives(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	filter := getLogArchiveFilter(c)
+	if filter.StartTimestamp == 0 || filter.EndTimestamp == 0 {
+		common.ApiErrorMsg(c, "start timestamp and end timestamp are required")
+		return
+	}
+	result, err := service.QueryLogArchives(c.Request.Context(), filter, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"page":              pageInfo.GetPage(),
+		"page_size":         pageInfo.GetPageSize(),
+		"total":             result.Total,
+		"items":             result.Items,
+		"quota":             result.Quota,
+		"prompt_tokens":     result.PromptTokens,
+		"completion_tokens": result.CompletionTokens,
+	})
+}
+
+// ExportLogArchives 以 CSV 格式导出时间范围内的归档日志
+func ExportLogArchives(c *gin.Context) {
+	filter := getLogArchiveFilter(c)
+	if filter.StartTimestamp == 0 || filter.EndTimestamp == 0 {
+		common.ApiErrorMsg(c, "start timestamp and end timestamp are required")
+		return
+	}
+	filename := fmt.Sprintf("logs-%s-%s.csv",
+		time.Unix(filter.StartTimestamp, 0).Format("20060102"),
+		time.Unix(filter.EndTimestamp, 0).Format("20060102"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	if err := service.ExportLogArchives(c.Request.Context(), filter, c.Writer); err != nil {
+		// 响应头可能已经发出，只能记录错误
+		common.SysError("failed to export log archives: " + err.Error())
+	}
+}
+
+func runLogArchive() {
+	common.SysLog("log archive started")
+	count, err := service.ArchiveLogs(context.Background())
+	if err != nil {
+		common.SysError(fmt.Sprintf("log archive failed after archiving %d logs: %s", count, err.Error()))
+		return
+	}
+	common.SysLog(fmt.Sprintf("log archive finished, %d logs archived", count))
+}
+
+// AutomaticallyArchiveLogs 每天在配置的时间归档超过保留天数的日志
+func AutomaticallyArchiveLogs() {
+	lastRunDay := ""
+	for {
+		time.Sleep(10 * time.Minute)
+		setting := operation_setting.GetLogArchiveSetting()
+		if !setting.Enabled {
+			continue
+		}
+		now := time.Now()
+		today := now.Format("2006-01-02")
+		if now.Hour() < setting.RunHour || lastRunDay == today {
+			continue
+		}
+		lastRunDay = today
+		if service.IsLogArchiveRunning() {
+			continue
+		}
+		runLogArchive()
+	}
+}
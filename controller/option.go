@@ -7,15 +7,46 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/console_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
 
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 )
 
+// pricingOptionKeys 定价相关的配置项，拥有定价权限但没有系统设置权限的用户只能查看和修改这些配置
+var pricingOptionKeys = []string{
+	"ModelRatio",
+	"ModelPrice",
+	"CacheRatio",
+	"BatchRatio",
+	"GroupRatio",
+	"GroupGroupRatio",
+	"CompletionRatio",
+	"ImageRatio",
+	"AudioRatio",
+	"AudioCompletionRatio",
+	"ExposeRatioEnabled",
+}
+
+// canAccessOption 判断当前用户能否查看或修改指定配置项
+func canAccessOption(c *gin.Context, key string) bool {
+	permissions := common.GetContextKeyStringSlice(c, constant.ContextKeyUserPermissions)
+	if lo.Contains(permissions, constant.PermissionSettingWrite) {
+		return true
+	}
+	if !lo.Contains(permissions, constant.PermissionPricingWrite) {
+		return false
+	}
+	return lo.Contains(pricingOptionKeys, key) || strings.HasPrefix(key, "group_ratio_setting.")
+}
+
 func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.Lock()
@@ -23,6 +54,9 @@ func GetOptions(c *gin.Context) {
 		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Key") {
 			continue
 		}
+		if !canAccessOption(c, k) {
+			continue
+		}
 		options = append(options, &model.Option{
 			Key:   k,
 			Value: common.Interface2String(v),
@@ -62,6 +96,10 @@ func UpdateOption(c *gin.Context) {
 	default:
 		option.Value = fmt.Sprintf("%v", option.Value)
 	}
+	if !canAccessOption(c, option.Key) {
+		common.ApiErrorMsg(c, "无权修改该配置项")
+		return
+	}
 	switch option.Key {
 	case "GitHubOAuthEnabled":
 		if option.Value == "true" && common.GitHubClientId == "" {
@@ -200,6 +238,14 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "body_capture_setting.storage":
+		if option.Value.(string) == operation_setting.BodyCaptureStorageFile && !operation_setting.IsBodyCaptureFileStorageAllowed() {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "多节点部署时请求/响应体采集只能保存到数据库",
+			})
+			return
+		}
 	case "console_setting.uptime_kuma_groups":
 		err = console_setting.ValidateConsoleSettings(option.Value.(string), "UptimeKumaGroups")
 		if err != nil {
@@ -210,11 +256,15 @@ func UpdateOption(c *gin.Context) {
 			return
 		}
 	}
+	common.OptionMapRWMutex.RLock()
+	originValue := common.OptionMap[option.Key]
+	common.OptionMapRWMutex.RUnlock()
 	err = model.UpdateOption(option.Key, option.Value.(string))
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	service.RecordOptionAudit(c, option.Key, originValue, option.Value.(string))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,466 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type organizationRequest struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+	Quota  *int   `json:"quota"`
+}
+
+type organizationMemberRequest struct {
+	UserId            int    `json:"user_id"`
+	Username          string `json:"username"`
+	Role              string `json:"role"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit"`
+}
+
+// getOrganizationOperator 校验当前用户是否为路径中组织的成员，返回其成员信息
+func getOrganizationOperator(c *gin.Context) (*model.OrganizationMember, bool) {
+	organizationId, _ := strconv.Atoi(c.Param("id"))
+	member, err := model.GetOrganizationMember(organizationId, c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ApiErrorMsg(c, "组织不存在或您不是该组织的成员")
+		} else {
+			common.ApiError(c, err)
+		}
+		return nil, false
+	}
+	return member, true
+}
+
+// canAssignOrganizationRole 所有者可以分配除所有者外的任意角色，管理员只能分配普通成员和财务
+func canAssignOrganizationRole(operator *model.OrganizationMember, role string) bool {
+	if role == model.OrganizationRoleOwner || !model.IsValidOrganizationRole(role) {
+		return false
+	}
+	if operator.Role == model.OrganizationRoleOwner {
+		return true
+	}
+	return operator.Role == model.OrganizationRoleAdmin && role != model.OrganizationRoleAdmin
+}
+
+// canManageOrganizationMember 所有者可以管理所有成员，管理员只能管理普通成员和财务
+func canManageOrganizationMember(operator *model.OrganizationMember, target *model.OrganizationMember) bool {
+	if operator.Role == model.OrganizationRoleOwner {
+		return true
+	}
+	return operator.Role == model.OrganizationRoleAdmin &&
+		(target.Role == model.OrganizationRoleMember || target.Role == model.OrganizationRoleBilling)
+}
+
+func validateOrganizationName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("组织名称不能为空")
+	}
+	if len(name) > 64 {
+		return "", errors.New("组织名称过长")
+	}
+	return name, nil
+}
+
+// GetSelfOrganizations 查询当前用户加入的组织
+func GetSelfOrganizations(c *gin.Context) {
+	organizations, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, organizations)
+}
+
+// GetSelfOrganizationInvitations 查询当前用户收到的组织邀请
+func GetSelfOrganizationInvitations(c *gin.Context) {
+	organizations, err := model.GetUserOrganizationInvitations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, organizations)
+}
+
+// AcceptOrganizationInvitation 当前用户接受组织邀请
+func AcceptOrganizationInvitation(c *gin.Context) {
+	organizationId, _ := strconv.Atoi(c.Param("id"))
+	if err := model.AcceptOrganizationInvitation(organizationId, c.GetInt("id")); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// DeclineOrganizationInvitation 当前用户拒绝组织邀请
+func DeclineOrganizationInvitation(c *gin.Context) {
+	organizationId, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeclineOrganizationInvitation(organizationId, c.GetInt("id")); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	name, err := validateOrganizationName(req.Name)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	organization, err := model.CreateOrganization(name, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	organization.Role = model.OrganizationRoleOwner
+	common.ApiSuccess(c, organization)
+}
+
+func GetOrganization(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	organization, err := model.GetOrganizationById(operator.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	organization.Role = operator.Role
+	common.ApiSuccess(c, gin.H{
+		"organization":        organization,
+		"role":                operator.Role,
+		"monthly_quota_limit": operator.MonthlyQuotaLimit,
+		"monthly_used_quota":  operator.GetMonthlyUsedQuota(),
+	})
+}
+
+// UpdateOrganization 所有者和管理员可以修改组织名称
+func UpdateOrganization(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	if !operator.CanManageMembers() {
+		common.ApiErrorMsg(c, "无权修改该组织")
+		return
+	}
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	name, err := validateOrganizationName(req.Name)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	organization, err := model.GetOrganizationById(operator.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	organization.Name = name
+	if err := organization.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, organization)
+}
+
+// DeleteOrganization 仅所有者可以删除组织
+func DeleteOrganization(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	if operator.Role != model.OrganizationRoleOwner {
+		common.ApiErrorMsg(c, "仅组织所有者可以删除组织")
+		return
+	}
+	if err := model.DeleteOrganization(operator.OrganizationId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetOrganizationMembers 所有者、管理员和财务可以查看成员及其用量
+func GetOrganizationMembers(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	if !operator.CanManageMembers() && !operator.CanManageBilling() {
+		common.ApiErrorMsg(c, "无权查看组织成员")
+		return
+	}
+	members, err := model.GetOrganizationMembers(operator.OrganizationId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+// AddOrganizationMember 邀请用户加入组织，对方接受后才成为成员
+func AddOrganizationMember(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleMember
+	}
+	if !canAssignOrganizationRole(operator, req.Role) {
+		common.ApiErrorMsg(c, "无权添加该角色的成员")
+		return
+	}
+	if req.MonthlyQuotaLimit < 0 {
+		common.ApiErrorMsg(c, "每月消耗上限不能为负数")
+		return
+	}
+	userId, err := model.GetUserIdByUsername(req.Username)
+	if err != nil {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	member, err := model.InviteOrganizationMember(operator.OrganizationId, userId, operator.UserId, req.Role, req.MonthlyQuotaLimit)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, member)
+}
+
+// UpdateOrganizationMember 修改成员角色和每月消耗上限，所有者将角色设为 owner 表示转移所有权
+func UpdateOrganizationMember(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	var req organizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	target, err := model.GetOrganizationMember(operator.OrganizationId, req.UserId)
+	if err != nil {
+		common.ApiErrorMsg(c, "该用户不是组织成员")
+		return
+	}
+	if !canManageOrganizationMember(operator, target) {
+		common.ApiErrorMsg(c, "无权修改该成员")
+		return
+	}
+	if req.MonthlyQuotaLimit < 0 {
+		common.ApiErrorMsg(c, "每月消耗上限不能为负数")
+		return
+	}
+	if req.Role == model.OrganizationRoleOwner && target.Role != model.OrganizationRoleOwner {
+		if operator.Role != model.OrganizationRoleOwner {
+			common.ApiErrorMsg(c, "仅组织所有者可以转移所有权")
+			return
+		}
+		if err := model.TransferOrganizationOwnership(operator.OrganizationId, operator.UserId, target.UserId); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		target.Role = model.OrganizationRoleOwner
+	} else if req.Role != "" && req.Role != target.Role {
+		if target.Role == model.OrganizationRoleOwner {
+			common.ApiErrorMsg(c, "不能修改组织所有者的角色，请先转移所有权")
+			return
+		}
+		if !canAssignOrganizationRole(operator, req.Role) {
+			common.ApiErrorMsg(c, "无权分配该角色")
+			return
+		}
+		target.Role = req.Role
+	}
+	target.MonthlyQuotaLimit = req.MonthlyQuotaLimit
+	if err := target.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, target)
+}
+
+// RemoveOrganizationMember 移除成员、撤回邀请或退出组织，成员创建的组织令牌转交给所有者
+func RemoveOrganizationMember(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	if invitation, err := model.GetOrganizationInvitation(operator.OrganizationId, userId); err == nil {
+		if !canManageOrganizationMember(operator, invitation) {
+			common.ApiErrorMsg(c, "无权撤回该邀请")
+			return
+		}
+		if err := model.DeclineOrganizationInvitation(operator.OrganizationId, userId); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		common.ApiSuccess(c, nil)
+		return
+	}
+	if userId != operator.UserId {
+		target, err := model.GetOrganizationMember(operator.OrganizationId, userId)
+		if err != nil {
+			common.ApiErrorMsg(c, "该用户不是组织成员")
+			return
+		}
+		if !canManageOrganizationMember(operator, target) {
+			common.ApiErrorMsg(c, "无权移除该成员")
+			return
+		}
+	}
+	if err := model.RemoveOrganizationMember(operator.OrganizationId, userId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// FundOrganization 所有者和财务可以将个人额度转入组织额度池
+func FundOrganization(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	if !operator.CanManageBilling() {
+		common.ApiErrorMsg(c, "无权为组织充值")
+		return
+	}
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Quota == nil {
+		common.ApiErrorMsg(c, "额度必须大于 0")
+		return
+	}
+	if err := model.FundOrganization(operator.OrganizationId, operator.UserId, *req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(operator.UserId, model.LogTypeManage, fmt.Sprintf("向组织 #%d 转入额度 %s", operator.OrganizationId, logger.LogQuota(*req.Quota)))
+	common.ApiSuccess(c, nil)
+}
+
+// GetOrganizationTokens 所有者和管理员可以查看组织的全部令牌，不返回密钥
+func GetOrganizationTokens(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	if !operator.CanManageMembers() {
+		common.ApiErrorMsg(c, "无权查看组织令牌")
+		return
+	}
+	pageInfo := common.GetPageQuery(c)
+	tokens, total, err := model.GetOrganizationTokens(operator.OrganizationId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, token := range tokens {
+		token.Clean()
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(tokens)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func DeleteOrganizationToken(c *gin.Context) {
+	operator, ok := getOrganizationOperator(c)
+	if !ok {
+		return
+	}
+	if !operator.CanManageMembers() {
+		common.ApiErrorMsg(c, "无权删除组织令牌")
+		return
+	}
+	tokenId, _ := strconv.Atoi(c.Param("token_id"))
+	if err := model.DeleteOrganizationToken(operator.OrganizationId, tokenId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetAllOrganizations 管理员查询所有组织
+func GetAllOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	organizations, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(organizations)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AdminUpdateOrganization 管理员修改组织状态或直接设置组织额度池
+func AdminUpdateOrganization(c *gin.Context) {
+	var req organizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	organization, err := model.GetOrganizationById(req.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Status != 0 {
+		if req.Status != model.OrganizationStatusEnabled && req.Status != model.OrganizationStatusDisabled {
+			common.ApiErrorMsg(c, "无效的组织状态")
+			return
+		}
+		organization.Status = req.Status
+		if err := organization.Update(); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if req.Quota != nil {
+		if *req.Quota < 0 {
+			common.ApiErrorMsg(c, "额度不能为负数")
+			return
+		}
+		if err := model.SetOrganizationQuota(organization.Id, *req.Quota); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		organization.Quota = *req.Quota
+		model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("管理员将组织 #%d 的额度设置为 %s", organization.Id, logger.LogQuota(*req.Quota)))
+	}
+	common.ApiSuccess(c, organization)
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
@@ -51,6 +52,9 @@ func GetPricing(c *gin.Context) {
 
 func ResetModelRatio(c *gin.Context) {
 	defaultStr := ratio_setting.DefaultModelRatio2JSONString()
+	common.OptionMapRWMutex.RLock()
+	originValue := common.OptionMap["ModelRatio"]
+	common.OptionMapRWMutex.RUnlock()
 	err := model.UpdateOption("ModelRatio", defaultStr)
 	if err != nil {
 		c.JSON(200, gin.H{
@@ -67,6 +71,7 @@ func ResetModelRatio(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordOptionAudit(c, "ModelRatio", originValue, defaultStr)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "重置模型倍率成功",
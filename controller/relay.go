@@ -4,13 +4,17 @@ package controller
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
@@ -28,6 +32,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // relayHandler 根据不同的代理模式路由到对应的处理函数
@@ -132,6 +137,23 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		defer ws.Close()
 	}
 
+	// 开启请求/响应体采集时包装响应写入器，需在错误响应写出之后保存
+	var bodyCapture *relaycommon.BodyCapture
+	if relayFormat != types.RelayFormatOpenAIRealtime {
+		bodyCapture = service.StartBodyCapture(c)
+		if bodyCapture != nil {
+			defer service.SaveBodyCapture(c, bodyCapture)
+		}
+		// 开启输出敏感词检测时审核返回给客户端的内容，需在错误响应写出之后结束
+		if finishModeration := service.StartCompletionModeration(c); finishModeration != nil {
+			defer finishModeration()
+		}
+		// 存在响应阶段的护栏钩子时，非流式响应需审核通过后才写出
+		if finishGuardrails := service.StartGuardrails(c, relayFormat); finishGuardrails != nil {
+			defer finishGuardrails()
+		}
+	}
+
 	// defer 函数用于统一处理错误响应，根据不同的 API 格式返回适当的错误格式
 	defer func() {
 		if newAPIError != nil {
@@ -158,7 +180,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}()
 
 	// 获取并验证请求参数
+	_, span := tracing.Start(c.Request.Context(), "helper.GetAndValidateRequest")
 	request, err := helper.GetAndValidateRequest(c, relayFormat)
+	tracing.End(span, err)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeInvalidRequest)
 		return
@@ -170,6 +194,20 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		newAPIError = types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
 		return
 	}
+	relayInfo.BodyCapture = bodyCapture
+
+	// 记录切换渠道重试的次数，只统计重试循环的尝试次数，对冲请求虽然记录在 use_channel 中但不计入
+	attempts := 0
+	defer func() {
+		metrics.RecordRelayRetries(relayInfo.OriginModelName, relayInfo.UsingGroup, attempts-1)
+	}()
+
+	// 调用外部护栏钩子审核请求，钩子可以放行、拦截或改写请求
+	newAPIError = service.ApplyPreRequestGuardrails(c, relayInfo)
+	if newAPIError != nil {
+		return
+	}
+	request = relayInfo.Request
 
 	// 获取用于 token 计数的元数据
 	meta := request.GetTokenCountMeta()
@@ -185,7 +223,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}
 
 	// 估算请求会消耗的 token 数量
+	_, span = tracing.Start(c.Request.Context(), "service.EstimateRequestToken")
 	tokens, err := service.EstimateRequestToken(c, meta, relayInfo)
+	tracing.End(span, err)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
 		return
@@ -194,8 +234,21 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	// 设置预估的 prompt token 数
 	relayInfo.SetEstimatePromptTokens(tokens)
 
+	// 检查 TPM 限流，按估算的 token 数预先扣减输入额度，请求失败时退还
+	newAPIError = service.CheckTPMLimit(c, relayInfo, tokens)
+	if newAPIError != nil {
+		return
+	}
+	defer func() {
+		if newAPIError != nil {
+			service.RefundTPMLimit(relayInfo)
+		}
+	}()
+
 	// 计算模型价格和需要预扣的配额
+	_, span = tracing.Start(c.Request.Context(), "helper.ModelPriceHelper")
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
+	tracing.End(span, err)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError)
 		return
@@ -207,7 +260,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	if priceData.FreeModel {
 		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
 	} else {
+		_, span = tracing.Start(c.Request.Context(), "service.PreConsumeQuota")
 		newAPIError = service.PreConsumeQuota(c, priceData.QuotaToPreConsume, relayInfo)
+		endSpan(span, newAPIError)
 		if newAPIError != nil {
 			return
 		}
@@ -229,6 +284,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		Retry:      common.GetPointer(0),
 	}
 
+	// 每次重试在独立的 span 中进行，上游请求和响应处理的 span 挂在其下
+	requestCtx := c.Request.Context()
+
 	// 重试循环：尝试不同的渠道，直到成功或超过最大重试次数
 	for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
 		// 获取可用渠道
@@ -241,36 +299,94 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 		// 记录已使用的渠道 ID
 		addUsedChannel(c, channel.Id)
+		attempts++
 		// 重置请求 Body，因为重试时需要重新读取
 		requestBody, _ := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		// 根据不同的 API 格式调用对应的处理函数
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			// WebSocket 实时对话
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			// Claude API 格式
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			// Gemini API 格式
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			// 其他格式（OpenAI 等）
-			newAPIError = relayHandler(c, relayInfo)
+		// 占用渠道并发名额，渠道已满时排队等待，超时后换用其他渠道重试
+		isMultiKey := common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey)
+		keyIndex := common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+		releaseConcurrency, acquireErr := model.AcquireChannelConcurrency(requestCtx, channel, keyIndex, isMultiKey, fmt.Sprintf("%s:%d", c.GetString(common.RequestIdKey), retryParam.GetRetry()))
+		if acquireErr != nil {
+			if !errors.Is(acquireErr, model.ErrChannelConcurrencySaturated) {
+				// 客户端断开等原因导致等待中止
+				newAPIError = types.NewErrorWithStatusCode(acquireErr, types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry())
+				break
+			}
+			newAPIError = types.NewErrorWithStatusCode(acquireErr, types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests)
+			if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+				break
+			}
+			continue
 		}
 
-		// 如果成功，直接返回
-		if newAPIError == nil {
+		// 半开状态的熔断器探测名额已满时换用其他渠道
+		if circuitErr := model.AcquireChannelCircuit(channel.Id, keyIndex, isMultiKey); circuitErr != nil {
+			releaseConcurrency()
+			newAPIError = types.NewErrorWithStatusCode(circuitErr, types.ErrorCodeCircuitOpen, http.StatusServiceUnavailable)
+			if !handleAttemptFailure(c, channel, newAPIError, retryParam) {
+				break
+			}
+			continue
+		}
+
+		// 首次尝试时判断是否需要对冲请求，对冲请求使用主渠道开始前的上下文副本
+		var hedge *relayHedge
+		if retryParam.GetRetry() == 0 {
+			hedge = newRelayHedge(c, relayFormat, relayInfo, channel.Id)
+		}
+
+		attemptStart := time.Now()
+		attemptCtx, attemptSpan := tracing.Start(requestCtx, "relay.attempt", tracing.AttrChannelId.Int(channel.Id), tracing.AttrRetry.Int(retryParam.GetRetry()))
+		c.Request = c.Request.WithContext(attemptCtx)
+
+		newAPIError = func() *types.NewAPIError {
+			// 记录渠道进行中的请求数，供渠道选择策略使用；处理函数 panic 时也要释放并发名额和计数
+			model.IncreaseChannelInFlight(channel.Id)
+			defer model.DecreaseChannelInFlight(channel.Id)
+			defer releaseConcurrency()
+
+			// 根据不同的 API 格式调用对应的处理函数
+			if hedge != nil {
+				return hedge.run(func() *types.NewAPIError {
+					return dispatchRelay(c, relayFormat, relayInfo)
+				})
+			}
+			return dispatchRelay(c, relayFormat, relayInfo)
+		}()
+
+		attemptSpan.SetAttributes(tracing.AttrUpstreamModel.String(relayInfo.UpstreamModelName))
+		c.Request = c.Request.WithContext(requestCtx)
+
+		// 对冲请求胜出时主渠道已被取消，不计入渠道成功或失败；对冲请求已向客户端输出，失败时不再重试
+		if hedge != nil && hedge.hedgeWon() {
+			attemptSpan.SetAttributes(tracing.AttrCancelled.Bool(true))
+			endSpan(attemptSpan, nil)
+			model.ReleaseChannelCircuit(channel.Id, keyIndex, isMultiKey)
+			newAPIError = hedge.hedgeErr
+			if newAPIError == nil {
+				return
+			}
+			break
+		}
+
+		endSpan(attemptSpan, newAPIError)
+		// 命中响应缓存时没有请求上游，不计入渠道熔断和指标
+		if newAPIError == nil && relayInfo.ResponseCacheHit {
+			model.ReleaseChannelCircuit(channel.Id, keyIndex, isMultiKey)
 			return
 		}
+		model.RecordChannelCircuit(channel.Id, keyIndex, isMultiKey, !service.IsCircuitBreakerFailure(newAPIError))
+		recordRelayMetrics(channel.Id, relayInfo, attemptStart, newAPIError)
 
-		// 处理渠道错误（记录日志、禁用渠道等）
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		// 如果成功，记录渠道延迟后直接返回
+		if newAPIError == nil {
+			recordChannelLatency(channel.Id, relayInfo, attemptStart)
+			return
+		}
 
-		// 判断是否应该重试
-		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+		if !handleAttemptFailure(c, channel, newAPIError, retryParam) {
 			break
 		}
 	}
@@ -284,6 +400,61 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}
 }
 
+// handleAttemptFailure 处理失败的渠道尝试（记录日志、禁用渠道等），返回是否应该重试
+func handleAttemptFailure(c *gin.Context, channel *model.Channel, newAPIError *types.NewAPIError, retryParam *service.RetryParam) bool {
+	processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+	return shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry())
+}
+
+// dispatchRelay 根据不同的 API 格式调用对应的处理函数
+func dispatchRelay(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		// WebSocket 实时对话
+		return relay.WssHelper(c, relayInfo)
+	case types.RelayFormatClaude:
+		// Claude API 格式
+		return relay.ClaudeHelper(c, relayInfo)
+	case types.RelayFormatGemini:
+		// Gemini API 格式
+		return geminiRelayHandler(c, relayInfo)
+	default:
+		// 其他格式（OpenAI 等）
+		return relayHandler(c, relayInfo)
+	}
+}
+
+// endSpan 结束 span，请求失败时记录错误
+func endSpan(span trace.Span, newAPIError *types.NewAPIError) {
+	if newAPIError != nil {
+		tracing.End(span, newAPIError)
+		return
+	}
+	tracing.End(span, nil)
+}
+
+// recordRelayMetrics 记录本次渠道请求尝试的 Prometheus 指标
+func recordRelayMetrics(channelId int, relayInfo *relaycommon.RelayInfo, attemptStart time.Time, newAPIError *types.NewAPIError) {
+	statusCode := http.StatusOK
+	if newAPIError != nil {
+		statusCode = newAPIError.StatusCode
+	}
+	relayFormat := string(relayInfo.RelayFormat)
+	metrics.RecordRelayRequest(relayInfo.OriginModelName, channelId, relayInfo.UsingGroup, relayFormat, statusCode, time.Since(attemptStart))
+	if newAPIError == nil && relayInfo.IsStream && relayInfo.FirstResponseTime.After(attemptStart) {
+		metrics.RecordFirstToken(relayInfo.OriginModelName, channelId, relayInfo.UsingGroup, relayFormat, relayInfo.FirstResponseTime.Sub(attemptStart))
+	}
+}
+
+// recordChannelLatency 记录渠道本次请求的延迟，流式请求使用首字时间
+func recordChannelLatency(channelId int, relayInfo *relaycommon.RelayInfo, attemptStart time.Time) {
+	latency := time.Since(attemptStart)
+	if relayInfo.IsStream && relayInfo.FirstResponseTime.After(attemptStart) {
+		latency = relayInfo.FirstResponseTime.Sub(attemptStart)
+	}
+	model.RecordChannelLatency(channelId, latency.Milliseconds())
+}
+
 // upgrader WebSocket 协议升级器配置
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
@@ -0,0 +1,369 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hedgeRace 对冲请求的竞速状态，先向客户端写出数据的一方胜出
+type hedgeRace struct {
+	mutex   sync.Mutex
+	winner  *hedgeWriter
+	writers []*hedgeWriter
+	decided chan struct{}
+}
+
+func (r *hedgeRace) isWinner(w *hedgeWriter) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.winner == w
+}
+
+func (r *hedgeRace) getWinner() *hedgeWriter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.winner
+}
+
+// tryWin 尝试成为胜出方，胜出后取消其他尝试并标记为落败
+func (r *hedgeRace) tryWin(w *hedgeWriter) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.winner != nil {
+		return r.winner == w
+	}
+	r.winner = w
+	close(r.decided)
+	for _, other := range r.writers {
+		if other != w {
+			other.state.SetLost()
+			other.cancel()
+		}
+	}
+	return true
+}
+
+// hedgeWriter 暂存单次尝试的响应头，胜出后才写入真实的响应，落败方的输出全部丢弃
+type hedgeWriter struct {
+	gin.ResponseWriter
+	race   *hedgeRace
+	state  *relaycommon.HedgeState
+	cancel context.CancelFunc
+	header http.Header
+	status int
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.race.isWinner(w) {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.race.isWinner(w) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.race.isWinner(w) {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if w.race.isWinner(w) {
+		return w.ResponseWriter.Write(data)
+	}
+	// 首个内容之前的 ping 保活不参与竞速
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(":")) {
+		return len(data), nil
+	}
+	if !w.race.tryWin(w) {
+		return len(data), nil
+	}
+	for key, values := range w.header {
+		w.ResponseWriter.Header()[key] = values
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.race.isWinner(w) {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.race.isWinner(w) {
+		return w.ResponseWriter.Status()
+	}
+	if w.status != 0 {
+		return w.status
+	}
+	return http.StatusOK
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.race.isWinner(w) {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	if w.race.isWinner(w) {
+		return w.ResponseWriter.Written()
+	}
+	return false
+}
+
+func (r *hedgeRace) newWriter(real gin.ResponseWriter, state *relaycommon.HedgeState, cancel context.CancelFunc) *hedgeWriter {
+	w := &hedgeWriter{
+		ResponseWriter: real,
+		race:           r,
+		state:          state,
+		cancel:         cancel,
+		header:         make(http.Header),
+	}
+	r.mutex.Lock()
+	r.writers = append(r.writers, w)
+	r.mutex.Unlock()
+	return w
+}
+
+// relayHedge 一次对冲请求：主渠道在延迟内没有写出数据时，向另一个渠道发起相同的请求
+type relayHedge struct {
+	c           *gin.Context
+	hc          *gin.Context // 对冲请求使用的上下文副本
+	relayFormat types.RelayFormat
+	info        *relaycommon.RelayInfo
+	hedgeInfo   *relaycommon.RelayInfo
+	channelId   int
+	delay       time.Duration
+	race        *hedgeRace
+	hedge       *hedgeWriter
+	hedgeErr    *types.NewAPIError // 对冲请求的结果，仅在对冲请求胜出时使用
+}
+
+// newRelayHedge 判断本次请求是否需要对冲，需要时在主渠道开始请求前准备好对冲请求的上下文副本
+func newRelayHedge(c *gin.Context, relayFormat types.RelayFormat, relayInfo *relaycommon.RelayInfo, channelId int) *relayHedge {
+	hedgeSetting := operation_setting.GetHedgeSetting()
+	if !hedgeSetting.IsHedgeEnabled(relayInfo.UsingGroup, relayInfo.TokenId) {
+		return nil
+	}
+	if relayFormat == types.RelayFormatOpenAIRealtime || relayInfo.IsBatch {
+		return nil
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return nil
+	}
+	relayInfo.Hedge = &relaycommon.HedgeState{}
+	// 落败方的消费日志等结算出口通过上下文中的对冲状态跳过
+	common.SetContextKey(c, constant.ContextKeyHedgeState, relayInfo.Hedge)
+	hc := c.Copy()
+	hedgeInfo := relayInfo.CloneForHedge()
+	common.SetContextKey(hc, constant.ContextKeyHedgeState, hedgeInfo.Hedge)
+	latency := model.GetChannelLatencyPercentile(channelId, hedgeSetting.GetDelayPercentile())
+	return &relayHedge{
+		c:           c,
+		hc:          hc,
+		relayFormat: relayFormat,
+		info:        relayInfo,
+		hedgeInfo:   hedgeInfo,
+		channelId:   channelId,
+		delay:       hedgeSetting.GetHedgeDelay(latency),
+		race:        &hedgeRace{decided: make(chan struct{})},
+	}
+}
+
+// hedgeWon 判断对冲请求是否胜出
+func (h *relayHedge) hedgeWon() bool {
+	return h.hedge != nil && h.race.getWinner() == h.hedge
+}
+
+// run 执行主渠道请求，超过对冲延迟仍未写出数据时启动对冲请求，返回主渠道请求的结果
+func (h *relayHedge) run(primary func() *types.NewAPIError) *types.NewAPIError {
+	primaryCtx, cancelPrimary := context.WithCancel(h.c.Request.Context())
+	defer cancelPrimary()
+	realWriter := h.c.Writer
+	h.c.Request = h.c.Request.WithContext(primaryCtx)
+	h.c.Writer = h.race.newWriter(realWriter, h.info.Hedge, cancelPrimary)
+	defer func() {
+		h.c.Writer = realWriter
+	}()
+
+	primaryDone := make(chan *types.NewAPIError, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.LogError(h.c, fmt.Sprintf("hedge primary attempt panic: %v", r))
+				primaryDone <- types.NewError(fmt.Errorf("panic: %v", r), types.ErrorCodeBadResponse, types.ErrOptionWithSkipRetry())
+			}
+		}()
+		primaryDone <- primary()
+	}()
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	select {
+	case newAPIError := <-primaryDone:
+		return newAPIError
+	case <-h.race.decided:
+		return <-primaryDone
+	case <-timer.C:
+	}
+
+	hedgeDone := make(chan struct{})
+	go func() {
+		defer close(hedgeDone)
+		defer func() {
+			if r := recover(); r != nil {
+				logger.LogError(h.c, fmt.Sprintf("hedge attempt panic: %v", r))
+			}
+		}()
+		h.runHedge(realWriter)
+	}()
+	newAPIError := <-primaryDone
+	<-hedgeDone
+	return newAPIError
+}
+
+// runHedge 选择另一个渠道发起对冲请求，并自行完成该渠道的并发、熔断和指标记录
+func (h *relayHedge) runHedge(realWriter gin.ResponseWriter) {
+	hc := h.hc
+	channel, err := selectHedgeChannel(hc, h.hedgeInfo, h.channelId)
+	if err != nil {
+		logger.LogWarn(h.c, fmt.Sprintf("hedge skipped: %s", err.Error()))
+		return
+	}
+	h.info.Hedge.SetFired()
+	h.hedgeInfo.Hedge.SetFired()
+	logger.LogInfo(h.c, fmt.Sprintf("channel #%d no response within %s, hedging to channel #%d", h.channelId, h.delay, channel.Id))
+
+	// 两次尝试都记录到 use_channel，重新分配切片避免与主渠道共享底层数组
+	useChannel := append(slices.Clone(h.c.GetStringSlice("use_channel")), fmt.Sprintf("%d", channel.Id))
+	h.c.Set("use_channel", useChannel)
+	hc.Set("use_channel", slices.Clone(useChannel))
+
+	requestCtx := hc.Request.Context()
+	hedgeCtx, cancelHedge := context.WithCancel(requestCtx)
+	defer cancelHedge()
+	h.hedge = h.race.newWriter(realWriter, h.hedgeInfo.Hedge, cancelHedge)
+	select {
+	case <-h.race.decided:
+		// 主渠道已经胜出
+		h.hedgeInfo.Hedge.SetLost()
+		return
+	default:
+	}
+	hc.Writer = h.hedge
+	requestBody, _ := common.GetRequestBody(hc)
+	hc.Request = hc.Request.WithContext(hedgeCtx)
+	hc.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+
+	isMultiKey := common.GetContextKeyBool(hc, constant.ContextKeyChannelIsMultiKey)
+	keyIndex := common.GetContextKeyInt(hc, constant.ContextKeyChannelMultiKeyIndex)
+	releaseConcurrency, acquireErr := model.AcquireChannelConcurrency(hedgeCtx, channel, keyIndex, isMultiKey, fmt.Sprintf("%s:hedge", hc.GetString(common.RequestIdKey)))
+	if acquireErr != nil {
+		logger.LogWarn(h.c, fmt.Sprintf("hedge channel #%d concurrency unavailable: %s", channel.Id, acquireErr.Error()))
+		return
+	}
+	defer releaseConcurrency()
+
+	if circuitErr := model.AcquireChannelCircuit(channel.Id, keyIndex, isMultiKey); circuitErr != nil {
+		logger.LogWarn(h.c, fmt.Sprintf("hedge channel #%d circuit unavailable: %s", channel.Id, circuitErr.Error()))
+		return
+	}
+
+	attemptStart := time.Now()
+	model.IncreaseChannelInFlight(channel.Id)
+	defer model.DecreaseChannelInFlight(channel.Id)
+	attemptCtx, attemptSpan := tracing.Start(hedgeCtx, "relay.hedge", tracing.AttrChannelId.Int(channel.Id))
+	hc.Request = hc.Request.WithContext(attemptCtx)
+
+	newAPIError := dispatchRelay(hc, h.relayFormat, h.hedgeInfo)
+	h.hedgeErr = newAPIError
+
+	attemptSpan.SetAttributes(tracing.AttrUpstreamModel.String(h.hedgeInfo.UpstreamModelName))
+	if h.hedgeInfo.IsHedgeLost() {
+		// 被主渠道取消，不计入渠道成功或失败
+		attemptSpan.SetAttributes(tracing.AttrCancelled.Bool(true))
+		endSpan(attemptSpan, nil)
+		model.ReleaseChannelCircuit(channel.Id, keyIndex, isMultiKey)
+		return
+	}
+	endSpan(attemptSpan, newAPIError)
+	// 命中响应缓存时没有请求上游，不计入渠道熔断和指标
+	if newAPIError == nil && h.hedgeInfo.ResponseCacheHit {
+		model.ReleaseChannelCircuit(channel.Id, keyIndex, isMultiKey)
+		return
+	}
+	model.RecordChannelCircuit(channel.Id, keyIndex, isMultiKey, !service.IsCircuitBreakerFailure(newAPIError))
+	recordRelayMetrics(channel.Id, h.hedgeInfo, attemptStart, newAPIError)
+	if newAPIError == nil {
+		recordChannelLatency(channel.Id, h.hedgeInfo, attemptStart)
+		return
+	}
+	logger.LogError(h.c, fmt.Sprintf("hedge attempt on channel #%d failed: %s", channel.Id, newAPIError.Error()))
+	processChannelError(hc, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(hc, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+}
+
+// selectHedgeChannel 为对冲请求选择一个不同于主渠道的渠道
+func selectHedgeChannel(c *gin.Context, info *relaycommon.RelayInfo, excludeChannelId int) (*model.Channel, error) {
+	retryParam := &service.RetryParam{
+		Ctx:        c,
+		TokenGroup: info.TokenGroup,
+		ModelName:  info.OriginModelName,
+		Retry:      common.GetPointer(0),
+	}
+	// 渠道按权重随机选择，多尝试几次以避开主渠道
+	for i := 0; i < 3; i++ {
+		channel, selectGroup, err := service.CacheGetRandomSatisfiedChannel(retryParam)
+		if err != nil {
+			return nil, err
+		}
+		if channel == nil {
+			return nil, fmt.Errorf("分组 %s 下模型 %s 没有可用于对冲的渠道", selectGroup, info.OriginModelName)
+		}
+		if channel.Id == excludeChannelId {
+			continue
+		}
+		info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(c, info)
+		if newAPIError := middleware.SetupContextForSelectedChannel(c, channel, info.OriginModelName); newAPIError != nil {
+			return nil, newAPIError
+		}
+		return channel, nil
+	}
+	return nil, fmt.Errorf("模型 %s 没有其他可用于对冲的渠道", info.OriginModelName)
+}
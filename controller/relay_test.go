@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleAttemptFailureCircuitOpen(t *testing.T) {
+	retryTimes, errorLogEnabled, autoDisable := common.RetryTimes, constant.ErrorLogEnabled, common.AutomaticDisableChannelEnabled
+	t.Cleanup(func() {
+		common.RetryTimes, constant.ErrorLogEnabled, common.AutomaticDisableChannelEnabled = retryTimes, errorLogEnabled, autoDisable
+	})
+	common.RetryTimes = 2
+	constant.ErrorLogEnabled = false
+	common.AutomaticDisableChannelEnabled = true
+
+	channel := &model.Channel{Id: 1, Type: constant.ChannelTypeOpenAI, Name: "circuit"}
+	circuitErr := types.NewErrorWithStatusCode(errors.New("circuit open"), types.ErrorCodeCircuitOpen, http.StatusServiceUnavailable)
+	if service.ShouldDisableChannel(channel.Type, circuitErr) {
+		t.Fatal("open circuit should not disable the channel")
+	}
+
+	newContext := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		return c
+	}
+	retryParam := &service.RetryParam{Retry: common.GetPointer(0)}
+	if !handleAttemptFailure(newContext(), channel, circuitErr, retryParam) {
+		t.Fatal("open circuit should retry on another channel")
+	}
+	retryParam.SetRetry(common.RetryTimes)
+	if handleAttemptFailure(newContext(), channel, circuitErr, retryParam) {
+		t.Fatal("should not retry after the last attempt")
+	}
+	c := newContext()
+	c.Set("specific_channel_id", "1")
+	if handleAttemptFailure(c, channel, circuitErr, &service.RetryParam{Retry: common.GetPointer(0)}) {
+		t.Fatal("should not retry when the channel is specified")
+	}
+}
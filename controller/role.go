@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+type roleRequest struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"display_name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type roleAssignRequest struct {
+	UserId int `json:"user_id"`
+	RoleId int `json:"role_id"` // 为 0 时取消分配
+}
+
+// GetAllRoles 获取所有命名角色及其分配的用户数
+func GetAllRoles(c *gin.Context) {
+	roles, err := model.GetAllRoles()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, roles)
+}
+
+// GetAllPermissions 获取所有可分配的权限，以及原有角色等级对应的权限预设
+func GetAllPermissions(c *gin.Context) {
+	common.ApiSuccess(c, gin.H{
+		"permissions": constant.AllPermissions,
+		"presets": gin.H{
+			strconv.Itoa(common.RoleCommonUser): model.GetRolePresetPermissions(common.RoleCommonUser),
+			strconv.Itoa(common.RoleAdminUser):  model.GetRolePresetPermissions(common.RoleAdminUser),
+			strconv.Itoa(common.RoleRootUser):   model.GetRolePresetPermissions(common.RoleRootUser),
+		},
+	})
+}
+
+// checkGrantablePermissions 只能授予自己拥有的权限，只属于超级管理员的权限由 SetPermissions 拒绝
+func checkGrantablePermissions(c *gin.Context, permissions []string) error {
+	myPermissions := common.GetContextKeyStringSlice(c, constant.ContextKeyUserPermissions)
+	for _, permission := range permissions {
+		if !lo.Contains(myPermissions, permission) {
+			return fmt.Errorf("无权授予自己没有的权限：%s", permission)
+		}
+	}
+	return nil
+}
+
+// checkOwnRole 不能修改或删除自己所属的角色
+func checkOwnRole(c *gin.Context, roleId int) error {
+	me, err := model.GetUserById(c.GetInt("id"), false)
+	if err != nil {
+		return err
+	}
+	if me.RoleId == roleId {
+		return errors.New("不能修改自己所属的角色")
+	}
+	return nil
+}
+
+// CreateRole 创建自定义角色
+func CreateRole(c *gin.Context) {
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkGrantablePermissions(c, req.Permissions); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	role := &model.Role{
+		Name:        req.Name,
+		DisplayName: req.DisplayName,
+		Description: req.Description,
+	}
+	if err := role.SetPermissions(req.Permissions); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "role.create", "role", role.Id, nil, role)
+	common.ApiSuccess(c, role)
+}
+
+// UpdateRole 修改角色的显示名称、说明和权限，已分配该角色的用户在下一次请求时生效
+func UpdateRole(c *gin.Context) {
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkOwnRole(c, req.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkGrantablePermissions(c, req.Permissions); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	role, err := model.GetRoleById(req.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	before := service.AuditSnapshot(role)
+	role.DisplayName = req.DisplayName
+	role.Description = req.Description
+	if err := role.SetPermissions(req.Permissions); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := role.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "role.update", "role", role.Id, before, role)
+	common.ApiSuccess(c, role)
+}
+
+// DeleteRole 删除自定义角色，内置角色不能删除
+func DeleteRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := checkOwnRole(c, id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	role, err := model.GetRoleById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteRoleById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "role.delete", "role", id, role, nil)
+	common.ApiSuccess(c, nil)
+}
+
+// AssignUserRole 为用户分配或取消命名角色，不能修改自己和同级及以上用户的角色，也不能分配超出自己权限的角色
+func AssignUserRole(c *gin.Context) {
+	var req roleAssignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	user, err := model.GetUserById(req.UserId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if user.Id == c.GetInt("id") {
+		common.ApiErrorMsg(c, "不能修改自己的角色")
+		return
+	}
+	if !canManageUser(c, user) {
+		common.ApiErrorMsg(c, "无权修改同权限等级或更高权限等级用户的角色")
+		return
+	}
+	if req.RoleId != 0 {
+		role, err := model.GetRoleById(req.RoleId)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if err := checkGrantablePermissions(c, role.GetPermissions()); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if err := model.SetUserRoleId(user.Id, req.RoleId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.RecordAudit(c, "user.assign_role", "user", user.Id, gin.H{"role_id": user.RoleId}, gin.H{"role_id": req.RoleId})
+	common.ApiSuccess(c, nil)
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newRoleTestContext(id int, role int, permissions []string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("id", id)
+	c.Set("role", role)
+	common.SetContextKey(c, constant.ContextKeyUserPermissions, permissions)
+	return c
+}
+
+func TestCanManageUser(t *testing.T) {
+	commonUser := &model.User{Id: 2, Role: common.RoleCommonUser}
+	staffUser := &model.User{Id: 3, Role: common.RoleCommonUser, RoleId: 1}
+	adminUser := &model.User{Id: 4, Role: common.RoleAdminUser}
+
+	staff := newRoleTestContext(1, common.RoleCommonUser, []string{constant.PermissionUserWrite})
+	if !canManageUser(staff, commonUser) {
+		t.Fatal("staff should manage common users")
+	}
+	if canManageUser(staff, staffUser) || canManageUser(staff, adminUser) {
+		t.Fatal("staff must not manage users with a named role or admins")
+	}
+	if canManageUser(staff, &model.User{Id: 1, Role: common.RoleCommonUser}) {
+		t.Fatal("staff must not manage themselves")
+	}
+	if canGrantUserRole(staff, common.RoleAdminUser) || !canGrantUserRole(staff, common.RoleCommonUser) {
+		t.Fatal("staff may only keep users as common users")
+	}
+
+	admin := newRoleTestContext(5, common.RoleAdminUser, nil)
+	if !canManageUser(admin, staffUser) || canManageUser(admin, adminUser) {
+		t.Fatal("admin should only manage users below admin")
+	}
+}
+
+func TestCheckGrantablePermissions(t *testing.T) {
+	c := newRoleTestContext(1, common.RoleCommonUser, []string{constant.PermissionRoleManage, constant.PermissionLogRead})
+	if err := checkGrantablePermissions(c, []string{constant.PermissionLogRead}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkGrantablePermissions(c, []string{constant.PermissionSettingWrite}); err == nil {
+		t.Fatal("expected permissions the caller does not hold to be rejected")
+	}
+}
@@ -0,0 +1,325 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type subscriptionPlanChangeRequest struct {
+	PlanId int `json:"plan_id"`
+}
+
+type subscriptionGrantRequest struct {
+	UserId int `json:"user_id"`
+	PlanId int `json:"plan_id"`
+}
+
+type subscriptionCancelRequest struct {
+	Immediately bool `json:"immediately"` // 立即失效，否则在周期结束时取消
+}
+
+type subscriptionDetail struct {
+	*model.Subscription
+	Plan        *model.SubscriptionPlan `json:"plan"`
+	PendingPlan *model.SubscriptionPlan `json:"pending_plan,omitempty"`
+}
+
+func getSubscriptionDetail(subscription *model.Subscription) *subscriptionDetail {
+	detail := &subscriptionDetail{Subscription: subscription}
+	detail.Plan, _ = model.GetSubscriptionPlanById(subscription.PlanId)
+	if subscription.PendingPlanId != 0 {
+		detail.PendingPlan, _ = model.GetSubscriptionPlanById(subscription.PendingPlanId)
+	}
+	return detail
+}
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) error {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if plan.Quota < 0 || plan.Price < 0 {
+		return errors.New("套餐额度和价格不能为负数")
+	}
+	if plan.DurationDays <= 0 {
+		return errors.New("套餐周期天数必须大于 0")
+	}
+	return nil
+}
+
+// GetSubscriptionPlans 用户查看可订阅的套餐
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+// GetSelfSubscription 查询当前用户的有效订阅，没有订阅时返回 null
+func GetSelfSubscription(c *gin.Context) {
+	subscription, err := model.GetActiveSubscription(c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ApiSuccess(c, nil)
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, getSubscriptionDetail(subscription))
+}
+
+// ChangeSelfSubscription 变更套餐，升级立即生效并按剩余周期折算，降级在下个周期生效
+func ChangeSelfSubscription(c *gin.Context) {
+	var req subscriptionPlanChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	subscription, err := model.GetActiveSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "当前没有有效的订阅")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || !plan.Enabled {
+		common.ApiErrorMsg(c, "套餐不存在或已下架")
+		return
+	}
+	if subscription.PaymentMethod == model.SubscriptionPaymentStripe {
+		applied, err := changeStripeSubscriptionPlan(subscription, plan)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to change stripe subscription %s: %s", subscription.StripeSubscriptionId, err.Error()))
+			common.ApiErrorMsg(c, "变更 Stripe 订阅失败")
+			return
+		}
+		if !applied {
+			// 差价未支付成功，升级在 invoice.paid 中完成
+			common.ApiSuccess(c, gin.H{"prorated_quota": 0, "pending_payment": true})
+			return
+		}
+	}
+	extra, err := model.ChangeSubscriptionPlan(subscription.Id, plan)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"prorated_quota": extra})
+}
+
+// CancelSelfSubscription 用户取消订阅，在当前周期结束时失效
+func CancelSelfSubscription(c *gin.Context) {
+	subscription, err := model.GetActiveSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "当前没有有效的订阅")
+		return
+	}
+	if err := cancelSubscription(subscription, false); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func cancelSubscription(subscription *model.Subscription, immediately bool) error {
+	if subscription.PaymentMethod == model.SubscriptionPaymentStripe {
+		if err := cancelStripeSubscription(subscription, immediately); err != nil {
+			common.SysError(fmt.Sprintf("failed to cancel stripe subscription %s: %s", subscription.StripeSubscriptionId, err.Error()))
+			return errors.New("取消 Stripe 订阅失败")
+		}
+	}
+	if immediately {
+		return model.LapseSubscription(subscription.Id, "已取消")
+	}
+	return model.SetSubscriptionCancelAtPeriodEnd(subscription.Id, true)
+}
+
+// GetAllSubscriptionPlans 管理员查看所有套餐，包括已下架的
+func GetAllSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetSubscriptionPlans(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func AddSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan.Id = 0
+	if err := plan.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if plan.Id == 0 {
+		common.ApiErrorMsg(c, "套餐 ID 不能为空")
+		return
+	}
+	if err := validateSubscriptionPlan(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := plan.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plan)
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteSubscriptionPlanById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetAllSubscriptions 管理员查询订阅，可按状态和用户过滤
+func GetAllSubscriptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subscriptions, total, err := model.GetAllSubscriptions(c.Query("status"), userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	details := make([]*subscriptionDetail, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		details = append(details, getSubscriptionDetail(subscription))
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(details)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GrantSubscription 管理员为用户开通套餐，按套餐周期自动续期直到取消
+func GrantSubscription(c *gin.Context) {
+	var req subscriptionGrantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if _, err := model.GetUserById(req.UserId, false); err != nil {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	now := time.Now()
+	subscription, err := model.ActivateSubscription(req.UserId, plan, model.SubscriptionPaymentAdmin, "",
+		now.Unix(), now.AddDate(0, 0, plan.DurationDays).Unix())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, getSubscriptionDetail(subscription))
+}
+
+// AdminCancelSubscription 管理员取消订阅，可选择立即失效
+func AdminCancelSubscription(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req subscriptionCancelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	subscription, err := model.GetSubscriptionById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if subscription.Status == model.SubscriptionStatusCanceled {
+		common.ApiErrorMsg(c, "订阅已失效")
+		return
+	}
+	if err := cancelSubscription(subscription, req.Immediately); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// processDueSubscription 处理周期已结束的订阅：
+// 手动开通的订阅按套餐周期续期，设置了取消的订阅失效；
+// Stripe 订阅由 invoice.paid 续期，超过宽限期仍未续费时先在 Stripe 取消，避免之后扣款却不发放额度
+func processDueSubscription(subscription *model.Subscription, now time.Time) error {
+	if subscription.PaymentMethod == model.SubscriptionPaymentStripe {
+		graceHours := operation_setting.GetSubscriptionSetting().StripeGraceHours
+		if now.Unix() < subscription.CurrentPeriodEnd+int64(graceHours)*3600 {
+			return nil
+		}
+		if err := cancelStripeSubscription(subscription, true); err != nil && !isStripeResourceMissing(err) {
+			// 取消失败时保留订阅，下次检查时重试
+			return err
+		}
+		return model.LapseSubscription(subscription.Id, "续费未完成")
+	}
+	if subscription.CancelAtPeriodEnd {
+		return model.LapseSubscription(subscription.Id, "已取消")
+	}
+	planId := subscription.PlanId
+	if subscription.PendingPlanId != 0 {
+		planId = subscription.PendingPlanId
+	}
+	plan, err := model.GetSubscriptionPlanById(planId)
+	if err != nil {
+		return model.LapseSubscription(subscription.Id, "套餐已删除")
+	}
+	periodStart := subscription.CurrentPeriodEnd
+	periodEnd := time.Unix(periodStart, 0).AddDate(0, 0, plan.DurationDays).Unix()
+	return model.RenewSubscription(subscription.Id, periodStart, periodEnd)
+}
+
+// AutomaticallyRenewSubscriptions 定期为到期的订阅续期并发放额度，或在订阅失效时收回额度与分组
+func AutomaticallyRenewSubscriptions() {
+	for {
+		time.Sleep(10 * time.Minute)
+		if !operation_setting.GetSubscriptionSetting().Enabled {
+			continue
+		}
+		now := time.Now()
+		subscriptions, err := model.GetDueSubscriptions(now.Unix(), 500)
+		if err != nil {
+			common.SysError("failed to get due subscriptions: " + err.Error())
+			continue
+		}
+		for _, subscription := range subscriptions {
+			if err := processDueSubscription(subscription, now); err != nil {
+				common.SysError(fmt.Sprintf("failed to process subscription %d: %s", subscription.Id, err.Error()))
+			}
+		}
+	}
+}
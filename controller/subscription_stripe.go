@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/subscription"
+	"gorm.io/gorm"
+)
+
+// RequestStripeSubscription 创建 Stripe 订阅的 Checkout 链接，订阅的开通与续期由 webhook 完成
+func RequestStripeSubscription(c *gin.Context) {
+	if !operation_setting.GetSubscriptionSetting().Enabled {
+		common.ApiErrorMsg(c, "订阅功能未启用")
+		return
+	}
+	var req subscriptionPlanChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || !plan.Enabled {
+		common.ApiErrorMsg(c, "套餐不存在或已下架")
+		return
+	}
+	if plan.StripePriceId == "" {
+		common.ApiErrorMsg(c, "该套餐不支持 Stripe 订阅")
+		return
+	}
+	id := c.GetInt("id")
+	if _, err := model.GetActiveSubscription(id); err == nil {
+		common.ApiErrorMsg(c, "已有有效的订阅，请通过变更套餐升级或降级")
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	payLink, err := genStripeSubscriptionLink(user, plan)
+	if err != nil {
+		log.Println("获取Stripe订阅支付链接失败", err)
+		common.ApiErrorMsg(c, "拉起支付失败")
+		return
+	}
+	common.ApiSuccess(c, gin.H{"pay_link": payLink})
+}
+
+func genStripeSubscriptionLink(user *model.User, plan *model.SubscriptionPlan) (string, error) {
+	if err := initStripeKey(); err != nil {
+		return "", err
+	}
+	metadata := map[string]string{
+		"user_id": strconv.Itoa(user.Id),
+		"plan_id": strconv.Itoa(plan.Id),
+	}
+	params := &stripe.CheckoutSessionParams{
+		SuccessURL: stripe.String(system_setting.ServerAddress + "/console/topup"),
+		CancelURL:  stripe.String(system_setting.ServerAddress + "/console/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(plan.StripePriceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode:                stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+		// 订阅的元数据会带到每一张账单上，用于在 invoice.paid 中找到用户和套餐
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: metadata,
+		},
+	}
+	params.Metadata = metadata
+	if user.StripeCustomer == "" {
+		if user.Email != "" {
+			params.CustomerEmail = stripe.String(user.Email)
+		}
+	} else {
+		params.Customer = stripe.String(user.StripeCustomer)
+	}
+	result, err := session.New(params)
+	if err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+// changeStripeSubscriptionPlan 变更 Stripe 订阅的价格：升级立即按比例补收差价，降级不产生差价，从下个账单周期起按新价格收费
+// 升级使用 pending_if_incomplete，差价账单未支付成功时 Stripe 不应用变更，返回 false，由 invoice.paid 在支付后完成升级
+func changeStripeSubscriptionPlan(local *model.Subscription, plan *model.SubscriptionPlan) (bool, error) {
+	if plan.StripePriceId == "" {
+		return false, errors.New("该套餐不支持 Stripe 订阅")
+	}
+	if err := initStripeKey(); err != nil {
+		return false, err
+	}
+	remote, err := subscription.Get(local.StripeSubscriptionId, nil)
+	if err != nil {
+		return false, err
+	}
+	if remote.Items == nil || len(remote.Items.Data) == 0 {
+		return false, errors.New("Stripe 订阅没有订阅项")
+	}
+	currentPlan, err := model.GetSubscriptionPlanById(local.PlanId)
+	if err != nil {
+		return false, err
+	}
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(remote.Items.Data[0].ID),
+				Price: stripe.String(plan.StripePriceId),
+			},
+		},
+		ProrationBehavior: stripe.String("none"),
+	}
+	if model.IsSubscriptionUpgrade(currentPlan, plan) {
+		params.ProrationBehavior = stripe.String("always_invoice")
+		params.PaymentBehavior = stripe.String("pending_if_incomplete")
+	}
+	updated, err := subscription.Update(local.StripeSubscriptionId, params)
+	if err != nil {
+		return false, err
+	}
+	return updated.PendingUpdate == nil, nil
+}
+
+func cancelStripeSubscription(local *model.Subscription, immediately bool) error {
+	if err := initStripeKey(); err != nil {
+		return err
+	}
+	if immediately {
+		_, err := subscription.Cancel(local.StripeSubscriptionId, nil)
+		return err
+	}
+	_, err := subscription.Update(local.StripeSubscriptionId, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	})
+	return err
+}
+
+// stripeSubscriptionCheckoutCompleted 订阅的 Checkout 完成后只记录 Stripe 客户，开通由 invoice.paid 完成
+func stripeSubscriptionCheckoutCompleted(event stripe.Event) {
+	userId, _ := strconv.Atoi(event.GetObjectValue("metadata", "user_id"))
+	customerId := event.GetObjectValue("customer")
+	if userId == 0 || customerId == "" {
+		return
+	}
+	if err := model.UpdateUserStripeCustomer(userId, customerId); err != nil {
+		log.Println("更新Stripe客户失败", userId, err.Error())
+	}
+}
+
+// stripeInvoicePaid 订阅首期或续期账单支付成功后开通订阅或进入新的周期，升级的差价账单支付成功后完成升级
+func stripeInvoicePaid(event stripe.Event) {
+	var invoice stripe.Invoice
+	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+		log.Println("解析Stripe账单失败", err.Error())
+		return
+	}
+	if invoice.Subscription == nil || invoice.Subscription.ID == "" {
+		return
+	}
+	if invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionUpdate {
+		stripeSubscriptionUpgradePaid(&invoice)
+		return
+	}
+	if invoice.BillingReason != stripe.InvoiceBillingReasonSubscriptionCreate &&
+		invoice.BillingReason != stripe.InvoiceBillingReasonSubscriptionCycle {
+		return
+	}
+	line := getStripeSubscriptionLine(&invoice)
+	if line == nil || line.Period == nil {
+		log.Println("Stripe账单缺少订阅周期", invoice.ID)
+		return
+	}
+	stripeSubscriptionId := invoice.Subscription.ID
+	local, err := model.GetSubscriptionByStripeId(stripeSubscriptionId)
+	if err == nil {
+		if err := model.RenewSubscription(local.Id, line.Period.Start, line.Period.End); err != nil {
+			log.Println("Stripe订阅续期失败", stripeSubscriptionId, err.Error())
+		}
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("查询Stripe订阅失败", stripeSubscriptionId, err.Error())
+		return
+	}
+	var metadata map[string]string
+	if invoice.SubscriptionDetails != nil {
+		metadata = invoice.SubscriptionDetails.Metadata
+	}
+	userId, _ := strconv.Atoi(metadata["user_id"])
+	if userId == 0 {
+		log.Println("Stripe订阅缺少用户信息", stripeSubscriptionId)
+		return
+	}
+	var plan *model.SubscriptionPlan
+	if line.Price != nil {
+		plan, err = model.GetSubscriptionPlanByStripePriceId(line.Price.ID)
+	}
+	if plan == nil || err != nil {
+		planId, _ := strconv.Atoi(metadata["plan_id"])
+		plan, err = model.GetSubscriptionPlanById(planId)
+		if err != nil {
+			log.Println("Stripe订阅对应的套餐不存在", stripeSubscriptionId)
+			return
+		}
+	}
+	if invoice.Customer != nil && invoice.Customer.ID != "" {
+		_ = model.UpdateUserStripeCustomer(userId, invoice.Customer.ID)
+	}
+	if _, err := model.ActivateSubscription(userId, plan, model.SubscriptionPaymentStripe, stripeSubscriptionId, line.Period.Start, line.Period.End); err != nil {
+		log.Println("开通Stripe订阅失败", stripeSubscriptionId, err.Error())
+		return
+	}
+	log.Printf("Stripe订阅已开通：%s, 用户 %d, 套餐 %s", stripeSubscriptionId, userId, plan.Name)
+}
+
+// stripeSubscriptionUpgradePaid 升级差价账单支付成功后在本地完成升级，已在本地生效的升级直接忽略
+func stripeSubscriptionUpgradePaid(invoice *stripe.Invoice) {
+	local, err := model.GetSubscriptionByStripeId(invoice.Subscription.ID)
+	if err != nil {
+		return
+	}
+	if invoice.Lines == nil {
+		return
+	}
+	// 差价账单包含退还旧价格的负数行和收取新价格的正数行
+	for _, line := range invoice.Lines.Data {
+		if !line.Proration || line.Amount <= 0 || line.Price == nil {
+			continue
+		}
+		plan, err := model.GetSubscriptionPlanByStripePriceId(line.Price.ID)
+		if err != nil || plan.Id == local.PlanId {
+			return
+		}
+		if _, err := model.ChangeSubscriptionPlan(local.Id, plan); err != nil {
+			log.Println("Stripe订阅升级失败", invoice.Subscription.ID, err.Error())
+		}
+		return
+	}
+}
+
+func getStripeSubscriptionLine(invoice *stripe.Invoice) *stripe.InvoiceLineItem {
+	if invoice.Lines == nil {
+		return nil
+	}
+	for _, line := range invoice.Lines.Data {
+		if line.Type == stripe.InvoiceLineItemTypeSubscription && !line.Proration {
+			return line
+		}
+	}
+	return nil
+}
+
+// stripeSubscriptionUpdated 同步 Stripe 订阅的状态、取消设置和价格变更
+func stripeSubscriptionUpdated(event stripe.Event) {
+	var remote stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &remote); err != nil {
+		log.Println("解析Stripe订阅失败", err.Error())
+		return
+	}
+	local, err := model.GetSubscriptionByStripeId(remote.ID)
+	if err != nil {
+		// 订阅尚未通过 invoice.paid 开通
+		return
+	}
+	if local.Status == model.SubscriptionStatusCanceled {
+		return
+	}
+	switch remote.Status {
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		if err := model.LapseSubscription(local.Id, "Stripe 订阅已取消"); err != nil {
+			log.Println("Stripe订阅失效处理失败", remote.ID, err.Error())
+		}
+		return
+	case stripe.SubscriptionStatusPastDue, stripe.SubscriptionStatusUnpaid:
+		_ = model.SetSubscriptionStatus(local.Id, model.SubscriptionStatusPastDue)
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+		_ = model.SetSubscriptionStatus(local.Id, model.SubscriptionStatusActive)
+	}
+	if remote.CancelAtPeriodEnd != local.CancelAtPeriodEnd {
+		_ = model.SetSubscriptionCancelAtPeriodEnd(local.Id, remote.CancelAtPeriodEnd)
+	}
+	// 在 Stripe 后台直接降级时同步套餐，通过本系统变更的套餐已在本地生效
+	// 升级需要等差价账单支付成功，由 invoice.paid 处理
+	if remote.PendingUpdate != nil || remote.Items == nil || len(remote.Items.Data) == 0 || remote.Items.Data[0].Price == nil {
+		return
+	}
+	plan, err := model.GetSubscriptionPlanByStripePriceId(remote.Items.Data[0].Price.ID)
+	if err != nil || plan.Id == local.PlanId || plan.Id == local.PendingPlanId {
+		return
+	}
+	currentPlan, err := model.GetSubscriptionPlanById(local.PlanId)
+	if err == nil && model.IsSubscriptionUpgrade(currentPlan, plan) {
+		return
+	}
+	if _, err := model.ChangeSubscriptionPlan(local.Id, plan); err != nil {
+		log.Println("同步Stripe订阅套餐失败", remote.ID, err.Error())
+	}
+}
+
+func stripeSubscriptionDeleted(event stripe.Event) {
+	stripeSubscriptionId := event.GetObjectValue("id")
+	local, err := model.GetSubscriptionByStripeId(stripeSubscriptionId)
+	if err != nil {
+		return
+	}
+	if err := model.LapseSubscription(local.Id, "Stripe 订阅已取消"); err != nil {
+		log.Println("Stripe订阅失效处理失败", stripeSubscriptionId, err.Error())
+	}
+}
+
+// isStripeResourceMissing 判断 Stripe 返回的是否为对象不存在，例如订阅已在 Stripe 删除
+func isStripeResourceMissing(err error) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing
+}
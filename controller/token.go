@@ -118,6 +118,15 @@ func GetTokenUsage(c *gin.Context) {
 		expiredAt = 0
 	}
 
+	budgets, err := model.GetTokenBudgetUsage(token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    true,
 		"message": "ok",
@@ -131,6 +140,7 @@ func GetTokenUsage(c *gin.Context) {
 			"model_limits":         token.GetModelLimitsMap(),
 			"model_limits_enabled": token.ModelLimitsEnabled,
 			"expires_at":           expiredAt,
+			"budgets":              budgets,
 		},
 	})
 }
@@ -149,6 +159,24 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if token.DailyQuotaLimit < 0 || token.WeeklyQuotaLimit < 0 || token.MonthlyQuotaLimit < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "令牌预算不能为负数",
+		})
+		return
+	}
+	// 组织令牌消耗组织额度池，创建者必须是可以使用组织额度的成员
+	if token.OrganizationId != 0 {
+		member, err := model.GetOrganizationMember(token.OrganizationId, c.GetInt("id"))
+		if err != nil || !member.CanUseQuota() {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权创建该组织的令牌",
+			})
+			return
+		}
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -172,6 +200,10 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		DailyQuotaLimit:    token.DailyQuotaLimit,
+		WeeklyQuotaLimit:   token.WeeklyQuotaLimit,
+		MonthlyQuotaLimit:  token.MonthlyQuotaLimit,
+		OrganizationId:     token.OrganizationId,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -216,6 +248,13 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if token.DailyQuotaLimit < 0 || token.WeeklyQuotaLimit < 0 || token.MonthlyQuotaLimit < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "令牌预算不能为负数",
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		common.ApiError(c, err)
@@ -250,6 +289,9 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.DailyQuotaLimit = token.DailyQuotaLimit
+		cleanToken.WeeklyQuotaLimit = token.WeeklyQuotaLimit
+		cleanToken.MonthlyQuotaLimit = token.MonthlyQuotaLimit
 	}
 	err = cleanToken.Update()
 	if err != nil {
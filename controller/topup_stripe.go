@@ -150,6 +150,12 @@ func StripeWebhook(c *gin.Context) {
 		sessionCompleted(event)
 	case stripe.EventTypeCheckoutSessionExpired:
 		sessionExpired(event)
+	case stripe.EventTypeInvoicePaid:
+		stripeInvoicePaid(event)
+	case stripe.EventTypeCustomerSubscriptionCreated, stripe.EventTypeCustomerSubscriptionUpdated:
+		stripeSubscriptionUpdated(event)
+	case stripe.EventTypeCustomerSubscriptionDeleted:
+		stripeSubscriptionDeleted(event)
 	default:
 		log.Printf("不支持的Stripe Webhook事件类型: %s\n", event.Type)
 	}
@@ -166,6 +172,11 @@ func sessionCompleted(event stripe.Event) {
 		return
 	}
 
+	if event.GetObjectValue("mode") == string(stripe.CheckoutSessionModeSubscription) {
+		stripeSubscriptionCheckoutCompleted(event)
+		return
+	}
+
 	err := model.Recharge(referenceId, customerId)
 	if err != nil {
 		log.Println(err.Error(), referenceId)
@@ -210,12 +221,19 @@ func sessionExpired(event stripe.Event) {
 	log.Println("充值订单已过期", referenceId)
 }
 
-func genStripeLink(referenceId string, customerId string, email string, amount int64) (string, error) {
+// initStripeKey 校验并设置 Stripe API 密钥，充值和订阅创建 Checkout 前调用
+func initStripeKey() error {
 	if !strings.HasPrefix(setting.StripeApiSecret, "sk_") && !strings.HasPrefix(setting.StripeApiSecret, "rk_") {
-		return "", fmt.Errorf("无效的Stripe API密钥")
+		return fmt.Errorf("无效的Stripe API密钥")
 	}
-
 	stripe.Key = setting.StripeApiSecret
+	return nil
+}
+
+func genStripeLink(referenceId string, customerId string, email string, amount int64) (string, error) {
+	if err := initStripeKey(); err != nil {
+		return "", err
+	}
 
 	params := &stripe.CheckoutSessionParams{
 		ClientReferenceID: stripe.String(referenceId),
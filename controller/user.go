@@ -304,6 +304,31 @@ func SearchUsers(c *gin.Context) {
 	return
 }
 
+// canManageUser 判断当前用户能否查看或管理目标用户：管理员只能管理权限等级低于自己的用户，
+// 通过命名角色获得用户权限的普通用户只能管理其他未分配命名角色的普通用户
+func canManageUser(c *gin.Context, target *model.User) bool {
+	myRole := c.GetInt("role")
+	if myRole == common.RoleRootUser {
+		return true
+	}
+	if myRole >= common.RoleAdminUser {
+		return myRole > target.Role
+	}
+	return target.Role == common.RoleCommonUser && target.RoleId == 0 && target.Id != c.GetInt("id")
+}
+
+// canGrantUserRole 判断当前用户能否将其他用户设置为指定的权限等级
+func canGrantUserRole(c *gin.Context, role int) bool {
+	myRole := c.GetInt("role")
+	if myRole == common.RoleRootUser {
+		return true
+	}
+	if myRole >= common.RoleAdminUser {
+		return role < myRole
+	}
+	return role == common.RoleCommonUser
+}
+
 func GetUser(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -315,8 +340,7 @@ func GetUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
+	if !canManageUser(c, user) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权获取同级或更高等级用户的信息",
@@ -439,7 +463,12 @@ func GetSelf(c *gin.Context) {
 	user.Remark = ""
 
 	// 计算用户权限信息
-	permissions := calculateUserPermissions(userRole)
+	adminPermissions, err := model.GetUserPermissions(user.Id, userRole)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	permissions := calculateUserPermissions(userRole, adminPermissions)
 
 	// 获取用户设置并提取sidebar_modules
 	userSetting := user.GetSetting()
@@ -469,6 +498,7 @@ func GetSelf(c *gin.Context) {
 		"linux_do_id":       user.LinuxDOId,
 		"setting":           user.Setting,
 		"stripe_customer":   user.StripeCustomer,
+		"role_id":           user.RoleId,
 		"sidebar_modules":   userSetting.SidebarModules, // 正确提取sidebar_modules字段
 		"permissions":       permissions,                // 新增权限字段
 	}
@@ -481,22 +511,27 @@ func GetSelf(c *gin.Context) {
 	return
 }
 
-// 计算用户权限的辅助函数
-func calculateUserPermissions(userRole int) map[string]interface{} {
+// 计算用户权限的辅助函数，adminPermissions 为用户拥有的后台管理权限
+func calculateUserPermissions(userRole int, adminPermissions []string) map[string]interface{} {
 	permissions := map[string]interface{}{}
+	permissions["admin_permissions"] = adminPermissions
 
-	// 根据用户角色计算权限
+	// 根据用户角色和后台管理权限计算边栏权限
 	if userRole == common.RoleRootUser {
 		// 超级管理员不需要边栏设置功能
 		permissions["sidebar_settings"] = false
 		permissions["sidebar_modules"] = map[string]interface{}{}
-	} else if userRole == common.RoleAdminUser {
-		// 管理员可以设置边栏，但不包含系统设置功能
+	} else if admin := model.GetAdminSidebarModules(adminPermissions); admin != nil {
+		// 拥有后台权限的用户可以设置边栏，只能访问有权限的管理员模块
+		restricted := map[string]interface{}{}
+		for module, allowed := range admin {
+			if allowed == false {
+				restricted[module] = false
+			}
+		}
 		permissions["sidebar_settings"] = true
 		permissions["sidebar_modules"] = map[string]interface{}{
-			"admin": map[string]interface{}{
-				"setting": false, // 管理员不能访问系统设置
-			},
+			"admin": restricted,
 		}
 	} else {
 		// 普通用户只能设置个人功能，不包含管理员区域
@@ -623,15 +658,14 @@ func UpdateUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= originUser.Role && myRole != common.RoleRootUser {
+	if !canManageUser(c, originUser) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权更新同权限等级或更高权限等级的用户信息",
 		})
 		return
 	}
-	if myRole <= updatedUser.Role && myRole != common.RoleRootUser {
+	if !canGrantUserRole(c, updatedUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权将其他用户权限等级提升到大于等于自己的权限等级",
@@ -646,6 +680,13 @@ func UpdateUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if user, err := model.GetUserById(originUser.Id, false); err == nil {
+		if updatePassword {
+			// 密码未读取，只记录为已修改
+			user.Password = "changed"
+		}
+		service.RecordAudit(c, "user.update", "user", originUser.Id, originUser, user)
+	}
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", logger.LogQuota(originUser.Quota), logger.LogQuota(updatedUser.Quota)))
 	}
@@ -786,8 +827,7 @@ func DeleteUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= originUser.Role {
+	if !canManageUser(c, originUser) || originUser.Role == common.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权删除同权限等级或更高权限等级的用户",
@@ -849,8 +889,7 @@ func CreateUser(c *gin.Context) {
 	if user.DisplayName == "" {
 		user.DisplayName = user.Username
 	}
-	myRole := c.GetInt("role")
-	if user.Role >= myRole {
+	if user.Role == common.RoleRootUser || !canGrantUserRole(c, user.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无法创建权限大于等于自己的用户",
@@ -906,13 +945,14 @@ func ManageUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
+	if !canManageUser(c, &user) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权更新同权限等级或更高权限等级的用户信息",
 		})
 		return
 	}
+	before := service.AuditSnapshot(user)
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -978,6 +1018,11 @@ func ManageUser(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	if req.Action == "delete" {
+		service.RecordAudit(c, "user.delete", "user", user.Id, before, nil)
+	} else {
+		service.RecordAudit(c, "user."+req.Action, "user", user.Id, before, user)
+	}
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -7,6 +7,7 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	ToolCallEmulation      bool   `json:"tool_call_emulation,omitempty"` // 以提示词模拟工具调用，用于不支持原生函数调用的模型
 }
 
 type VertexKeyType string
@@ -31,6 +32,16 @@ type ChannelOtherSettings struct {
 	DisableStore          bool          `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
 	AllowSafetyIdentifier bool          `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
 	AwsKeyType            AwsKeyType    `json:"aws_key_type,omitempty"`
+	CostRatio             float64       `json:"cost_ratio,omitempty"`              // 渠道成本倍率，用于最低成本选路，未设置时视为 1
+	MaxConcurrency        int           `json:"max_concurrency,omitempty"`         // 渠道最大并发请求数，0 表示不限制
+	MaxConcurrencyPerKey  int           `json:"max_concurrency_per_key,omitempty"` // 多 key 渠道中每个 key 的最大并发请求数，0 表示不限制
+}
+
+func (s *ChannelOtherSettings) GetCostRatio() float64 {
+	if s == nil || s.CostRatio <= 0 {
+		return 1
+	}
+	return s.CostRatio
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
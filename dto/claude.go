@@ -28,12 +28,29 @@ type ClaudeMediaMessage struct {
 	Signature    string               `json:"signature,omitempty"`
 	Delta        string               `json:"delta,omitempty"`
 	CacheControl json.RawMessage      `json:"cache_control,omitempty"`
+	// redacted_thinking
+	Data string `json:"data,omitempty"`
+	// citations
+	Citations []ClaudeCitation `json:"citations,omitempty"`
+	Citation  *ClaudeCitation  `json:"citation,omitempty"`
 	// tool_calls
 	Id        string `json:"id,omitempty"`
 	Name      string `json:"name,omitempty"`
 	Input     any    `json:"input,omitempty"`
 	Content   any    `json:"content,omitempty"`
 	ToolUseId string `json:"tool_use_id,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+// ClaudeCitation 文本块引用，覆盖 web_search_result_location 与文档类引用的常用字段
+type ClaudeCitation struct {
+	Type           string `json:"type"`
+	CitedText      string `json:"cited_text,omitempty"`
+	Url            string `json:"url,omitempty"`
+	Title          string `json:"title,omitempty"`
+	EncryptedIndex string `json:"encrypted_index,omitempty"`
+	DocumentIndex  *int   `json:"document_index,omitempty"`
+	DocumentTitle  string `json:"document_title,omitempty"`
 }
 
 func (c *ClaudeMediaMessage) SetText(s string) {
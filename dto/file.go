@@ -0,0 +1,47 @@
+package dto
+
+const (
+	FilePurposeAssistants = "assistants"
+	FilePurposeBatch      = "batch"
+	FilePurposeBatchOut   = "batch_output"
+	FilePurposeFineTune   = "fine-tune"
+	FilePurposeVision     = "vision"
+	FilePurposeUserData   = "user_data"
+	FilePurposeEvals      = "evals"
+)
+
+// OpenAIFile OpenAI Files API 返回的文件对象
+type OpenAIFile struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	Bytes         int64  `json:"bytes"`
+	CreatedAt     int64  `json:"created_at"`
+	ExpiresAt     int64  `json:"expires_at,omitempty"`
+	Filename      string `json:"filename"`
+	Purpose       string `json:"purpose"`
+	Status        string `json:"status,omitempty"`
+	StatusDetails string `json:"status_details,omitempty"`
+}
+
+type OpenAIFileList struct {
+	Object  string       `json:"object"`
+	Data    []OpenAIFile `json:"data"`
+	FirstID string       `json:"first_id,omitempty"`
+	LastID  string       `json:"last_id,omitempty"`
+	HasMore bool         `json:"has_more"`
+}
+
+type OpenAIFileDeleted struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+// IsValidFilePurpose 校验上传文件的 purpose 是否为 OpenAI 支持的取值
+func IsValidFilePurpose(purpose string) bool {
+	switch purpose {
+	case FilePurposeAssistants, FilePurposeBatch, FilePurposeFineTune, FilePurposeVision, FilePurposeUserData, FilePurposeEvals:
+		return true
+	}
+	return false
+}
@@ -105,7 +105,7 @@ func (r *GeminiChatRequest) SetModelName(modelName string) {
 
 func (r *GeminiChatRequest) GetTools() []GeminiChatTool {
 	var tools []GeminiChatTool
-	if strings.HasSuffix(string(r.Tools), "[") {
+	if strings.HasPrefix(string(r.Tools), "[") {
 		// is array
 		if err := common.Unmarshal(r.Tools, &tools); err != nil {
 			logger.LogError(nil, "error_unmarshalling_tools: "+err.Error())
@@ -323,10 +323,42 @@ type GeminiChatGenerationConfig struct {
 type MediaResolution string
 
 type GeminiChatCandidate struct {
-	Content       GeminiChatContent        `json:"content"`
-	FinishReason  *string                  `json:"finishReason"`
-	Index         int64                    `json:"index"`
-	SafetyRatings []GeminiChatSafetyRating `json:"safetyRatings"`
+	Content           GeminiChatContent        `json:"content"`
+	FinishReason      *string                  `json:"finishReason"`
+	Index             int64                    `json:"index"`
+	SafetyRatings     []GeminiChatSafetyRating `json:"safetyRatings"`
+	GroundingMetadata *GeminiGroundingMetadata `json:"groundingMetadata,omitempty"`
+}
+
+type GeminiGroundingMetadata struct {
+	WebSearchQueries  []string                 `json:"webSearchQueries,omitempty"`
+	GroundingChunks   []GeminiGroundingChunk   `json:"groundingChunks,omitempty"`
+	GroundingSupports []GeminiGroundingSupport `json:"groundingSupports,omitempty"`
+	SearchEntryPoint  json.RawMessage          `json:"searchEntryPoint,omitempty"`
+}
+
+type GeminiGroundingChunk struct {
+	Web              *GeminiGroundingSource `json:"web,omitempty"`
+	RetrievedContext *GeminiGroundingSource `json:"retrievedContext,omitempty"`
+}
+
+type GeminiGroundingSource struct {
+	Uri   string `json:"uri,omitempty"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+type GeminiGroundingSupport struct {
+	Segment               *GeminiGroundingSegment `json:"segment,omitempty"`
+	GroundingChunkIndices []int                   `json:"groundingChunkIndices,omitempty"`
+	ConfidenceScores      []float64               `json:"confidenceScores,omitempty"`
+}
+
+type GeminiGroundingSegment struct {
+	PartIndex  int    `json:"partIndex,omitempty"`
+	StartIndex int    `json:"startIndex,omitempty"`
+	EndIndex   int    `json:"endIndex,omitempty"`
+	Text       string `json:"text,omitempty"`
 }
 
 type GeminiChatSafetyRating struct {
@@ -351,6 +383,8 @@ type GeminiUsageMetadata struct {
 	TotalTokenCount      int                         `json:"totalTokenCount"`
 	ThoughtsTokenCount   int                         `json:"thoughtsTokenCount"`
 	PromptTokensDetails  []GeminiPromptTokensDetails `json:"promptTokensDetails"`
+	// 命中上下文缓存的输入 token 数，包含在 PromptTokenCount 中
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
 }
 
 type GeminiPromptTokensDetails struct {
@@ -386,6 +420,20 @@ type GeminiImagePrediction struct {
 	SafetyAttributes   any    `json:"safetyAttributes,omitempty"`
 }
 
+// GeminiCountTokensRequest countTokens 请求，contents 与 generateContentRequest 二选一
+type GeminiCountTokensRequest struct {
+	Contents               []GeminiChatContent `json:"contents,omitempty"`
+	GenerateContentRequest *GeminiChatRequest  `json:"generateContentRequest,omitempty"`
+}
+
+// ToChatRequest 转换为 GeminiChatRequest 以复用 token 计数逻辑
+func (r *GeminiCountTokensRequest) ToChatRequest() *GeminiChatRequest {
+	if r.GenerateContentRequest != nil {
+		return r.GenerateContentRequest
+	}
+	return &GeminiChatRequest{Contents: r.Contents}
+}
+
 // Embedding related structs
 type GeminiEmbeddingRequest struct {
 	Model                string            `json:"model,omitempty"`
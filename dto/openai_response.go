@@ -331,6 +331,7 @@ func (o *OpenAIResponsesResponse) GetSize() string {
 
 type IncompleteDetails struct {
 	Reasoning string `json:"reasoning"`
+	Reason    string `json:"reason,omitempty"`
 }
 
 type ResponsesOutput struct {
@@ -339,8 +340,14 @@ type ResponsesOutput struct {
 	Status  string                   `json:"status"`
 	Role    string                   `json:"role"`
 	Content []ResponsesOutputContent `json:"content"`
-	Quality string                   `json:"quality"`
-	Size    string                   `json:"size"`
+	Quality string                   `json:"quality,omitempty"`
+	Size    string                   `json:"size,omitempty"`
+	// function_call
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// reasoning
+	Summary []ResponsesOutputContent `json:"summary,omitempty"`
 }
 
 type ResponsesOutputContent struct {
@@ -363,12 +370,38 @@ const (
 	ResponsesOutputTypeItemDone  = "response.output_item.done"
 )
 
+const (
+	ResponsesEventCreated                    = "response.created"
+	ResponsesEventInProgress                 = "response.in_progress"
+	ResponsesEventCompleted                  = "response.completed"
+	ResponsesEventIncomplete                 = "response.incomplete"
+	ResponsesEventContentPartAdded           = "response.content_part.added"
+	ResponsesEventContentPartDone            = "response.content_part.done"
+	ResponsesEventOutputTextDelta            = "response.output_text.delta"
+	ResponsesEventOutputTextDone             = "response.output_text.done"
+	ResponsesEventFunctionCallArgumentsDelta = "response.function_call_arguments.delta"
+	ResponsesEventFunctionCallArgumentsDone  = "response.function_call_arguments.done"
+	ResponsesEventReasoningSummaryPartAdded  = "response.reasoning_summary_part.added"
+	ResponsesEventReasoningSummaryPartDone   = "response.reasoning_summary_part.done"
+	ResponsesEventReasoningSummaryTextDelta  = "response.reasoning_summary_text.delta"
+	ResponsesEventReasoningSummaryTextDone   = "response.reasoning_summary_text.done"
+)
+
 // ResponsesStreamResponse 用于处理 /v1/responses 流式响应
 type ResponsesStreamResponse struct {
 	Type     string                   `json:"type"`
 	Response *OpenAIResponsesResponse `json:"response,omitempty"`
 	Delta    string                   `json:"delta,omitempty"`
 	Item     *ResponsesOutput         `json:"item,omitempty"`
+
+	SequenceNumber int                     `json:"sequence_number"`
+	OutputIndex    *int                    `json:"output_index,omitempty"`
+	ItemId         string                  `json:"item_id,omitempty"`
+	ContentIndex   *int                    `json:"content_index,omitempty"`
+	SummaryIndex   *int                    `json:"summary_index,omitempty"`
+	Part           *ResponsesOutputContent `json:"part,omitempty"`
+	Text           string                  `json:"text,omitempty"`
+	Arguments      string                  `json:"arguments,omitempty"`
 }
 
 // GetOpenAIError 从动态错误类型中提取OpenAIError结构
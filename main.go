@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/controller"
 	"github.com/QuantumNous/new-api/logger"
@@ -134,6 +136,18 @@ func main() {
 			controller.UpdateTaskBulk()
 		})
 	}
+	// 启动后台协程：清理已过期的上传文件
+	if common.IsMasterNode {
+		go controller.AutomaticallyCleanupExpiredFiles()
+		// 启动后台协程：按天归档超过保留天数的使用日志
+		go controller.AutomaticallyArchiveLogs()
+		// 启动后台协程：为到期的订阅续期发放额度，或处理失效的订阅
+		go controller.AutomaticallyRenewSubscriptions()
+		// 启动后台协程：每月初为上个月生成账单
+		go controller.AutomaticallyGenerateBillingStatements()
+	}
+	// 启动后台协程：清理过期的请求/响应体采集记录，本地文件需要各节点各自清理
+	go controller.AutomaticallyCleanupBodyCaptures()
 	// 如果启用了批量更新模式，初始化批量更新器
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
@@ -152,6 +166,18 @@ func main() {
 		common.SysLog("pprof enabled")
 	}
 
+	// 如果启用了链路追踪，初始化 OTLP 导出
+	if constant.TracingEnabled {
+		shutdownTracing, err := tracing.Init(context.Background())
+		if err != nil {
+			common.FatalLog("failed to initialize tracing: " + err.Error())
+		}
+		defer func() {
+			_ = shutdownTracing(context.Background())
+		}()
+		common.SysLog("tracing enabled")
+	}
+
 	// 初始化 Gin HTTP 服务器
 	server := gin.New()
 	// 添加自定义 panic 恢复中间件，捕获运行时错误并返回友好的错误信息
@@ -168,6 +194,8 @@ func main() {
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	// 添加请求 ID 中间件，为每个请求生成唯一标识
 	server.Use(middleware.RequestId())
+	// 添加链路追踪中间件，为每个请求创建根 span
+	server.Use(middleware.Tracing())
 	// 设置请求日志记录器
 	middleware.SetUpLogger(server)
 	// 初始化 Session 存储，使用 Cookie 方式
@@ -3,6 +3,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 )
 
 // validUserInfo 验证用户信息是否有效
@@ -44,13 +46,14 @@ func validUserInfo(username string, role int) bool {
 //
 //	c: Gin 上下文对象
 //	minRole: 最小需要的角色等级
+//	permissions: 需要的后台管理权限，满足其中任意一个即可，为空时只检查角色等级
 //
 // 说明:
 //  1. 优先检查 Session 中的用户信息
 //  2. 如果 Session 不存在，检查 Authorization Header 中的 Access Token
 //  3. 验证用户状态和权限
 //  4. 将用户信息写入上下文
-func authHelper(c *gin.Context, minRole int) {
+func authHelper(c *gin.Context, minRole int, permissions ...string) {
 	// 从 Session 中获取用户信息
 	session := sessions.Default(c)
 	username := session.Get("username")
@@ -152,6 +155,21 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
+	// 检查用户是否拥有所需的后台管理权限，角色等级保持不变，由权限集合决定能否访问
+	if len(permissions) > 0 {
+		userPermissions, err := model.GetUserPermissions(id.(int), role.(int))
+		if err != nil || !lo.ContainsBy(permissions, func(permission string) bool {
+			return lo.Contains(userPermissions, permission)
+		}) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，权限不足",
+			})
+			c.Abort()
+			return
+		}
+		common.SetContextKey(c, constant.ContextKeyUserPermissions, userPermissions)
+	}
 	// 将用户信息设置到上下文中，供后续业务使用
 	c.Set("username", username)
 	c.Set("role", role)
@@ -210,6 +228,16 @@ func AdminAuth() func(c *gin.Context) {
 	}
 }
 
+// PermissionAuth 要求用户拥有指定的后台管理权限之一，权限来自角色等级的预设和分配的命名角色
+// 返回值:
+//
+//	中间件函数
+func PermissionAuth(permissions ...string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authHelper(c, common.RoleCommonUser, permissions...)
+	}
+}
+
 // RootAuth 要求用户必须是超级管理员权限
 // 返回值:
 //
@@ -393,6 +421,8 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	// 设置 Token 的分组和跨组重试配置
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenBudgetLimited, token.HasBudgetLimits())
+	common.SetContextKey(c, constant.ContextKeyTokenOrganizationId, token.OrganizationId)
 	// 如果指定了渠道 ID（仅管理员可用）
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
@@ -404,3 +434,19 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	return nil
 }
+
+// MetricsAuth 校验 /metrics 的 Bearer Token，未配置 METRICS_TOKEN 时不校验
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if constant.MetricsToken == "" {
+			c.Next()
+			return
+		}
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(constant.MetricsToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
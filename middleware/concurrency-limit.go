@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit 令牌和用户并发请求数限制中间件，超过限制时排队等待，等待超时返回 429
+func ConcurrencyLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		setting := operation_setting.GetConcurrencyLimitSetting()
+		if !setting.Enabled {
+			c.Next()
+			return
+		}
+
+		type slot struct {
+			key     string
+			limit   int
+			message string
+		}
+		tokenId := c.GetInt("token_id")
+		userId := c.GetInt("id")
+		slots := []slot{
+			{fmt.Sprintf("token:%d", tokenId), setting.GetTokenLimit(tokenId), "令牌并发请求数已达上限：%d"},
+			{fmt.Sprintf("user:%d", userId), setting.GetUserLimit(userId), "用户并发请求数已达上限：%d"},
+		}
+
+		l := limiter.GetConcurrencyLimiter()
+		member := c.GetString(common.RequestIdKey)
+		if member == "" {
+			member = common.GetUUID()
+		}
+		lease := time.Duration(setting.GetLeaseSeconds()) * time.Second
+		wait := time.Duration(setting.QueueTimeoutSeconds) * time.Second
+
+		acquired := make([]string, 0, len(slots))
+		defer func() {
+			for _, key := range acquired {
+				if err := l.Release(context.Background(), key, member); err != nil {
+					common.SysError("failed to release concurrency: " + err.Error())
+				}
+			}
+		}()
+		for _, s := range slots {
+			if s.limit <= 0 {
+				continue
+			}
+			ok, err := limiter.AcquireWithWait(c.Request.Context(), l, s.key, member, s.limit, lease, wait)
+			if err != nil {
+				// 客户端已断开时不再继续处理
+				if c.Request.Context().Err() != nil {
+					c.Abort()
+					return
+				}
+				// 限流存储异常时放行，避免影响正常请求
+				common.SysError("failed to acquire concurrency: " + err.Error())
+				continue
+			}
+			if !ok {
+				abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf(s.message, s.limit), string(types.ErrorCodeRateLimitExceeded))
+				return
+			}
+			acquired = append(acquired, s.key)
+		}
+
+		// 请求处理期间（包括流式响应）定期续期，避免名额被提前回收
+		stopKeepAlive := limiter.KeepAlive(l, acquired, member, lease)
+		defer stopKeepAlive()
+		c.Next()
+	}
+}
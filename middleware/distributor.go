@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
@@ -28,7 +29,12 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// 选择渠道失败中止请求时由 defer 结束 span，成功时在进入后续处理前结束
+		_, span := tracing.Start(c.Request.Context(), "middleware.Distribute")
+		defer span.End()
 		var channel *model.Channel
+		// 文件绑定的密钥索引，上游文件只能由上传时所用的密钥访问
+		var pinnedKey *int
 		channelId, ok := common.GetContextKey(c, constant.ContextKeyTokenSpecificChannelId)
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		if err != nil {
@@ -53,24 +59,9 @@ func Distribute() func(c *gin.Context) {
 		} else {
 			// Select a channel for the user
 			// check token model mapping
-			modelLimitEnable := common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled)
-			if modelLimitEnable {
-				s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit)
-				if !ok {
-					// token model limit is empty, all models are not allowed
-					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问任何模型")
-					return
-				}
-				var tokenModelLimit map[string]bool
-				tokenModelLimit, ok = s.(map[string]bool)
-				if !ok {
-					tokenModelLimit = map[string]bool{}
-				}
-				matchName := ratio_setting.FormatMatchingModelName(modelRequest.Model) // match gpts & thinking-*
-				if _, ok := tokenModelLimit[matchName]; !ok {
-					abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+modelRequest.Model)
-					return
-				}
+			if err := CheckTokenModelLimit(c, modelRequest.Model); err != nil {
+				abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+				return
 			}
 
 			if shouldSelectChannel {
@@ -97,38 +88,109 @@ func Distribute() func(c *gin.Context) {
 						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
 					}
 				}
-				channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
-					Ctx:        c,
-					ModelName:  modelRequest.Model,
-					TokenGroup: usingGroup,
-					Retry:      common.GetPointer(0),
-				})
+				// 请求引用了绑定渠道的文件时，必须发往上传该文件的渠道
+				pinnedChannel, pinnedKeyIndex, err := getPinnedFileChannel(c, usingGroup, modelRequest.Model)
 				if err != nil {
-					showGroup := usingGroup
-					if usingGroup == "auto" {
-						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
-					}
-					message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
-					// 如果错误，但是渠道不为空，说明是数据库一致性问题
-					//if channel != nil {
-					//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
-					//	message = "数据库一致性已被破坏，请联系管理员"
-					//}
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message, string(types.ErrorCodeModelNotFound))
+					abortWithOpenAiMessage(c, http.StatusBadRequest, err.Error())
 					return
 				}
-				if channel == nil {
-					abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", usingGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
-					return
+				if pinnedChannel != nil {
+					channel = pinnedChannel
+					pinnedKey = &pinnedKeyIndex
+				} else {
+					channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
+						Ctx:        c,
+						ModelName:  modelRequest.Model,
+						TokenGroup: usingGroup,
+						Retry:      common.GetPointer(0),
+					})
+					if err != nil {
+						showGroup := usingGroup
+						if usingGroup == "auto" {
+							showGroup = fmt.Sprintf("auto(%s)", selectGroup)
+						}
+						message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
+						// 如果错误，但是渠道不为空，说明是数据库一致性问题
+						//if channel != nil {
+						//	common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
+						//	message = "数据库一致性已被破坏，请联系管理员"
+						//}
+						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, message, string(types.ErrorCodeModelNotFound))
+						return
+					}
+					if channel == nil {
+						abortWithOpenAiMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("分组 %s 下模型 %s 无可用渠道（distributor）", usingGroup, modelRequest.Model), string(types.ErrorCodeModelNotFound))
+						return
+					}
 				}
 			}
 		}
 		common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		if pinnedKey != nil {
+			key, newAPIError := channel.GetEnabledKeyByIndex(*pinnedKey)
+			if newAPIError != nil {
+				abortWithOpenAiMessage(c, http.StatusBadRequest, fmt.Sprintf("文件绑定的渠道 #%d 的密钥不可用: %s", channel.Id, newAPIError.Error()))
+				return
+			}
+			common.SetContextKey(c, constant.ContextKeyChannelKey, key)
+			common.SetContextKey(c, constant.ContextKeyChannelMultiKeyIndex, *pinnedKey)
+		}
+		span.SetAttributes(tracing.AttrChannelId.Int(common.GetContextKeyInt(c, constant.ContextKeyChannelId)))
+		span.End()
 		c.Next()
 	}
 }
 
+// CheckTokenModelLimit 检查令牌是否有权访问该模型
+func CheckTokenModelLimit(c *gin.Context, modelName string) error {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return nil
+	}
+	s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		// token model limit is empty, all models are not allowed
+		return errors.New("该令牌无权访问任何模型")
+	}
+	tokenModelLimit, ok := s.(map[string]bool)
+	if !ok {
+		tokenModelLimit = map[string]bool{}
+	}
+	matchName := ratio_setting.FormatMatchingModelName(modelName) // match gpts & thinking-*
+	if _, ok := tokenModelLimit[matchName]; !ok {
+		return errors.New("该令牌无权访问模型 " + modelName)
+	}
+	return nil
+}
+
+// getPinnedFileChannel 获取请求所引用文件绑定的渠道及密钥索引，未引用文件时返回 nil
+// 文件绑定的渠道等同于指定渠道，不参与重试，但仍需在令牌分组下启用了请求的模型
+func getPinnedFileChannel(c *gin.Context, usingGroup string, modelName string) (*model.Channel, int, error) {
+	if !strings.Contains(c.Request.Header.Get("Content-Type"), "application/json") {
+		return nil, 0, nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, 0, nil
+	}
+	channelId, keyIndex, err := service.GetPinnedFileChannelId(c.GetInt("id"), requestBody)
+	if err != nil || channelId == 0 {
+		return nil, 0, err
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		return nil, 0, fmt.Errorf("文件绑定的渠道 #%d 不存在", channelId)
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return nil, 0, fmt.Errorf("文件绑定的渠道 #%d 已被禁用", channelId)
+	}
+	if _, ok := service.GetChannelGroupForModel(c, usingGroup, modelName, channelId); !ok {
+		return nil, 0, fmt.Errorf("文件绑定的渠道 #%d 在分组 %s 下未启用模型 %s", channelId, usingGroup, modelName)
+	}
+	c.Set("specific_channel_id", strconv.Itoa(channelId))
+	return channel, keyIndex, nil
+}
+
 // getModelFromRequest 从请求中读取模型信息
 // 根据 Content-Type 自动处理：
 // - application/json
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+func TestGetChannelFromDatabase(t *testing.T) {
+	highPriority, lowPriority := int64(10), int64(0)
+	high := &Channel{Name: "db_select_high", Key: "k", Status: common.ChannelStatusEnabled, Group: "db_select", Models: "m", Priority: &highPriority}
+	low := &Channel{Name: "db_select_low", Key: "k", Status: common.ChannelStatusEnabled, Group: "db_select", Models: "m", Priority: &lowPriority}
+	for _, channel := range []*Channel{high, low} {
+		if err := channel.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	channel, err := GetChannel("db_select", "m", 0)
+	if err != nil || channel == nil || channel.Id != high.Id {
+		t.Fatalf("expected high priority channel, got %+v %v", channel, err)
+	}
+	channel, err = GetChannel("db_select", "m", 1)
+	if err != nil || channel == nil || channel.Id != low.Id {
+		t.Fatalf("expected low priority channel on retry, got %+v %v", channel, err)
+	}
+
+	// 熔断中的渠道被跳过
+	setting := operation_setting.GetCircuitBreakerSetting()
+	original := *setting
+	defer func() { *setting = original }()
+	setting.Enabled = true
+	setting.FailureThreshold = 1
+	RecordChannelCircuit(high.Id, 0, false, false)
+	channel, err = GetChannel("db_select", "m", 0)
+	if err != nil || channel == nil || channel.Id != low.Id {
+		t.Fatalf("expected open circuit channel to be skipped, got %+v %v", channel, err)
+	}
+
+	channel, err = GetChannel("db_select", "missing", 0)
+	if err != nil || channel != nil {
+		t.Fatalf("expected no channel, got %+v %v", channel, err)
+	}
+}
+
+func TestGetRandomSatisfiedChannelFromCache(t *testing.T) {
+	memoryCacheEnabled := common.MemoryCacheEnabled
+	defer func() {
+		common.MemoryCacheEnabled = memoryCacheEnabled
+		InitChannelCache()
+	}()
+	common.MemoryCacheEnabled = true
+
+	highPriority, lowPriority := int64(10), int64(0)
+	high := &Channel{Name: "cache_select_high", Key: "k", Status: common.ChannelStatusEnabled, Group: "cache_select", Models: "m", Priority: &highPriority}
+	low := &Channel{Name: "cache_select_low", Key: "k", Status: common.ChannelStatusEnabled, Group: "cache_select", Models: "m", Priority: &lowPriority}
+	for _, channel := range []*Channel{high, low} {
+		if err := channel.Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	InitChannelCache()
+
+	channel, err := GetRandomSatisfiedChannel("cache_select", "m", 0)
+	if err != nil || channel == nil || channel.Id != high.Id {
+		t.Fatalf("expected high priority channel, got %+v %v", channel, err)
+	}
+	channel, err = GetRandomSatisfiedChannel("cache_select", "m", 1)
+	if err != nil || channel == nil || channel.Id != low.Id {
+		t.Fatalf("expected low priority channel on retry, got %+v %v", channel, err)
+	}
+
+	// 熔断过滤在释放缓存读锁后进行，熔断中的渠道被跳过
+	setting := operation_setting.GetCircuitBreakerSetting()
+	original := *setting
+	defer func() { *setting = original }()
+	setting.Enabled = true
+	setting.FailureThreshold = 1
+	RecordChannelCircuit(high.Id, 0, false, false)
+	channel, err = GetRandomSatisfiedChannel("cache_select", "m", 0)
+	if err != nil || channel == nil || channel.Id != low.Id {
+		t.Fatalf("expected open circuit channel to be skipped, got %+v %v", channel, err)
+	}
+
+	channel, err = GetRandomSatisfiedChannel("cache_select", "missing", 0)
+	if err != nil || channel != nil {
+		t.Fatalf("expected no channel, got %+v %v", channel, err)
+	}
+}
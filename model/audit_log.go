@@ -0,0 +1,295 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// AuditLog 管理操作审计日志，按序号组成哈希链，删除或修改任意一条记录都会导致校验失败
+type AuditLog struct {
+	Id         int    `json:"id"`
+	Seq        int64  `json:"seq" gorm:"bigint;uniqueIndex"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	Username   string `json:"username" gorm:"type:varchar(64);default:''"`
+	Ip         string `json:"ip" gorm:"type:varchar(64);default:''"`
+	Action     string `json:"action" gorm:"type:varchar(64);index"`
+	TargetType string `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target,priority:1"`
+	TargetId   string `json:"target_id" gorm:"type:varchar(128);index:idx_audit_target,priority:2"`
+	Diff       string `json:"diff" gorm:"type:text"` // 变更前后的字段差异 JSON，敏感字段已脱敏
+	PrevHash   string `json:"prev_hash" gorm:"type:char(64)"`
+	Hash       string `json:"hash" gorm:"type:char(64)"`
+}
+
+type AuditLogFilter struct {
+	UserId         int
+	Action         string
+	TargetType     string
+	TargetId       string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+// AuditLogVerifyResult 哈希链校验结果，Valid 为 false 时 BrokenSeq 为第一条校验失败的记录序号
+type AuditLogVerifyResult struct {
+	Valid     bool   `json:"valid"`
+	Checked   int64  `json:"checked"`
+	FirstSeq  int64  `json:"first_seq"`
+	LastSeq   int64  `json:"last_seq"`
+	LastHash  string `json:"last_hash"` // 链尾哈希，校验时还会与数据库之外保存的链尾锚点比对
+	BrokenSeq int64  `json:"broken_seq,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// 同一进程内串行写入，保证哈希链按序号连续；多实例部署时由序号的唯一索引兜底并重试
+var auditLogLock sync.Mutex
+
+const auditLogInsertRetries = 5
+
+func (log *AuditLog) BeforeUpdate(tx *gorm.DB) error {
+	return errors.New("审计日志不可修改")
+}
+
+func (log *AuditLog) BeforeDelete(tx *gorm.DB) error {
+	return errors.New("审计日志不可删除")
+}
+
+// ComputeHash 使用 CRYPTO_SECRET 计算记录内容与上一条记录哈希的 HMAC，只能访问数据库的人无法伪造或重建哈希链
+// 未配置 CRYPTO_SECRET 或 SESSION_SECRET 时密钥在每次启动时随机生成，重启后此前的记录将无法通过校验
+func (log *AuditLog) ComputeHash() string {
+	content := fmt.Sprintf("%d|%d|%d|%s|%s|%s|%s|%s|%s|%s",
+		log.Seq, log.CreatedAt, log.UserId, log.Username, log.Ip, log.Action,
+		log.TargetType, log.TargetId, log.Diff, log.PrevHash)
+	return common.GenerateHMAC(content)
+}
+
+// auditLogAnchor 链尾锚点，保存在数据库之外，用于发现末尾记录被删除
+type auditLogAnchor struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+const auditLogAnchorRedisKey = "audit_log:anchor"
+
+// 启用 Redis 时锚点保存在 Redis 中，否则保存在 AUDIT_LOG_ANCHOR_FILE 指定的文件中，都未配置时仅在进程内有效
+var (
+	auditLogAnchorFile   = os.Getenv("AUDIT_LOG_ANCHOR_FILE")
+	auditLogMemoryAnchor *auditLogAnchor
+)
+
+func loadAuditLogAnchor() (*auditLogAnchor, error) {
+	var data string
+	switch {
+	case common.RedisEnabled:
+		value, err := common.RedisGet(auditLogAnchorRedisKey)
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = value
+	case auditLogAnchorFile != "":
+		content, err := os.ReadFile(auditLogAnchorFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = string(content)
+	default:
+		return auditLogMemoryAnchor, nil
+	}
+	anchor := &auditLogAnchor{}
+	if err := common.UnmarshalJsonStr(data, anchor); err != nil {
+		return nil, err
+	}
+	return anchor, nil
+}
+
+func saveAuditLogAnchor(anchor *auditLogAnchor) error {
+	switch {
+	case common.RedisEnabled:
+		return common.RedisSet(auditLogAnchorRedisKey, common.GetJsonString(anchor), 0)
+	case auditLogAnchorFile != "":
+		// 先写入临时文件再重命名，避免写入中断导致锚点损坏
+		tmp := filepath.Join(filepath.Dir(auditLogAnchorFile), "."+filepath.Base(auditLogAnchorFile)+".tmp")
+		if err := os.WriteFile(tmp, []byte(common.GetJsonString(anchor)), 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, auditLogAnchorFile)
+	default:
+		auditLogMemoryAnchor = anchor
+		return nil
+	}
+}
+
+// matches 判断锚点与数据库中的链尾是否一致，锚点落后于链尾（其他节点写入）视为一致
+func (anchor *auditLogAnchor) matches(last *AuditLog) bool {
+	if anchor == nil {
+		return true
+	}
+	if anchor.Seq > last.Seq {
+		return false
+	}
+	return anchor.Seq != last.Seq || anchor.Hash == last.Hash
+}
+
+// CreateAuditLog 追加一条审计日志，序号和哈希链在写入时计算
+func CreateAuditLog(log *AuditLog) error {
+	auditLogLock.Lock()
+	defer auditLogLock.Unlock()
+
+	log.Id = 0
+	log.CreatedAt = common.GetTimestamp()
+	var err error
+	for i := 0; i < auditLogInsertRetries; i++ {
+		var last AuditLog
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			err := tx.Order("seq desc").Limit(1).Find(&last).Error
+			if err != nil {
+				return err
+			}
+			log.Seq = last.Seq + 1
+			log.PrevHash = last.Hash
+			log.Hash = log.ComputeHash()
+			return tx.Create(log).Error
+		})
+		if err == nil {
+			updateAuditLogAnchor(&last, log)
+			return nil
+		}
+		log.Id = 0
+	}
+	return err
+}
+
+// updateAuditLogAnchor 将锚点移动到新写入的记录；锚点与写入前的链尾不一致时说明末尾记录已被删除，
+// 保留原锚点，使校验能够发现
+func updateAuditLogAnchor(last *AuditLog, log *AuditLog) {
+	anchor, err := loadAuditLogAnchor()
+	if err != nil {
+		common.SysError("failed to load audit log anchor: " + err.Error())
+		return
+	}
+	if !anchor.matches(last) {
+		common.SysError(fmt.Sprintf("audit log anchor seq %d does not match the chain tail seq %d, records may have been deleted", anchor.Seq, last.Seq))
+		return
+	}
+	if err := saveAuditLogAnchor(&auditLogAnchor{Seq: log.Seq, Hash: log.Hash}); err != nil {
+		common.SysError("failed to save audit log anchor: " + err.Error())
+	}
+}
+
+func (filter *AuditLogFilter) apply(tx *gorm.DB) *gorm.DB {
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		tx = tx.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		tx = tx.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	return tx
+}
+
+func GetAuditLogs(filter *AuditLogFilter, startIdx int, num int) (logs []*AuditLog, total int64, err error) {
+	tx := filter.apply(DB.Model(&AuditLog{}))
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("seq desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, total, err
+}
+
+// FindAuditLogsInBatches 按序号从小到大分批读取符合条件的审计日志
+func FindAuditLogsInBatches(filter *AuditLogFilter, batchSize int, fn func(logs []*AuditLog) error) error {
+	var logs []*AuditLog
+	return filter.apply(DB.Model(&AuditLog{})).Order("seq asc").FindInBatches(&logs, batchSize, func(tx *gorm.DB, batch int) error {
+		return fn(logs)
+	}).Error
+}
+
+// VerifyAuditLogs 从第一条记录开始校验哈希链：序号必须连续、每条记录的哈希与内容一致且与上一条记录的哈希相连
+// 第一条记录的序号不为 1 说明最早的记录被删除
+func VerifyAuditLogs() (*AuditLogVerifyResult, error) {
+	result := &AuditLogVerifyResult{Valid: true}
+	var prev *AuditLog
+	broken := func(log *AuditLog, reason string) {
+		result.Valid = false
+		result.BrokenSeq = log.Seq
+		result.Reason = reason
+	}
+	err := FindAuditLogsInBatches(&AuditLogFilter{}, 1000, func(logs []*AuditLog) error {
+		for _, log := range logs {
+			if !result.Valid {
+				return nil
+			}
+			result.Checked++
+			result.LastSeq = log.Seq
+			result.LastHash = log.Hash
+			if prev == nil {
+				result.FirstSeq = log.Seq
+				if log.Seq != 1 || log.PrevHash != "" {
+					broken(log, "第一条记录之前的记录已被删除")
+					return nil
+				}
+			} else if log.Seq != prev.Seq+1 {
+				broken(log, fmt.Sprintf("序号 %d 至 %d 之间的记录已被删除", prev.Seq, log.Seq))
+				return nil
+			} else if log.PrevHash != prev.Hash {
+				broken(log, "与上一条记录的哈希不一致")
+				return nil
+			}
+			if log.Hash != log.ComputeHash() {
+				broken(log, "记录内容已被修改")
+				return nil
+			}
+			current := *log
+			prev = &current
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !result.Valid {
+		return result, nil
+	}
+	// 与数据库之外的链尾锚点比对，发现末尾记录被删除
+	anchor, err := loadAuditLogAnchor()
+	if err != nil {
+		return nil, err
+	}
+	if anchor != nil {
+		var anchored AuditLog
+		if err := DB.Where("seq = ?", anchor.Seq).Limit(1).Find(&anchored).Error; err != nil {
+			return nil, err
+		}
+		if anchored.Id == 0 || anchored.Hash != anchor.Hash {
+			result.Valid = false
+			result.BrokenSeq = anchor.Seq
+			result.Reason = fmt.Sprintf("序号 %d 的链尾记录已被删除或替换", anchor.Seq)
+		}
+	}
+	return result, nil
+}
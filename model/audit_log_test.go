@@ -0,0 +1,41 @@
+package model
+
+import "testing"
+
+func TestVerifyAuditLogs(t *testing.T) {
+	DB.Exec("DELETE FROM audit_logs")
+	auditLogMemoryAnchor = nil
+	for _, action := range []string{"user.create", "user.update", "user.delete"} {
+		if err := CreateAuditLog(&AuditLog{UserId: 1, Action: action, TargetType: "user", TargetId: "2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := VerifyAuditLogs()
+	if err != nil || !result.Valid || result.Checked != 3 {
+		t.Fatalf("expected valid chain of 3 records, got %+v %v", result, err)
+	}
+
+	// 修改内容后哈希不一致
+	DB.Exec("UPDATE audit_logs SET target_id = ? WHERE seq = ?", "3", 2)
+	result, err = VerifyAuditLogs()
+	if err != nil || result.Valid || result.BrokenSeq != 2 {
+		t.Fatalf("expected modified record to be detected, got %+v %v", result, err)
+	}
+	DB.Exec("UPDATE audit_logs SET target_id = ? WHERE seq = ?", "2", 2)
+
+	// 删除末尾记录后与链尾锚点不一致
+	DB.Exec("DELETE FROM audit_logs WHERE seq = ?", 3)
+	result, err = VerifyAuditLogs()
+	if err != nil || result.Valid || result.BrokenSeq != 3 {
+		t.Fatalf("expected tail truncation to be detected, got %+v %v", result, err)
+	}
+
+	// 删除后继续写入，锚点不会被新记录覆盖
+	if err := CreateAuditLog(&AuditLog{UserId: 1, Action: "user.create", TargetType: "user", TargetId: "4"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = VerifyAuditLogs()
+	if err != nil || result.Valid || result.BrokenSeq != 3 {
+		t.Fatalf("expected truncation to stay detectable, got %+v %v", result, err)
+	}
+}
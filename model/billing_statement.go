@@ -0,0 +1,278 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 账单所属主体
+const (
+	BillingStatementOwnerUser         = "user"
+	BillingStatementOwnerOrganization = "organization"
+)
+
+// 消费明细的数据来源
+const (
+	BillingConsumeSourceLogs      = "logs"       // 按使用日志汇总，可按模型和令牌拆分
+	BillingConsumeSourceQuotaData = "quota_data" // 未开启消费日志时按数据看板汇总，只能按模型拆分
+)
+
+// BillingStatement 月度账单，生成后不可修改
+// 期末余额 = 期初余额 + 充值 + 兑换码 + 退款 - 消费 + 其他调整，其他调整包括管理员修改额度、订阅发放与收回、组织转账等
+type BillingStatement struct {
+	Id              int     `json:"id"`
+	OwnerType       string  `json:"owner_type" gorm:"type:varchar(16);uniqueIndex:idx_billing_statement_owner_period,priority:1"`
+	OwnerId         int     `json:"owner_id" gorm:"uniqueIndex:idx_billing_statement_owner_period,priority:2"`
+	OwnerName       string  `json:"owner_name" gorm:"type:varchar(128);default:''"`
+	Period          string  `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_billing_statement_owner_period,priority:3"` // 账单月份，如 2026-09
+	PeriodStart     int64   `json:"period_start" gorm:"bigint"`
+	PeriodEnd       int64   `json:"period_end" gorm:"bigint"`
+	OpeningBalance  int     `json:"opening_balance"`
+	TopUpQuota      int     `json:"topup_quota"`
+	TopUpMoney      float64 `json:"topup_money"`
+	RedemptionQuota int     `json:"redemption_quota"`
+	RefundQuota     int     `json:"refund_quota"`
+	ConsumedQuota   int     `json:"consumed_quota"`
+	AdjustmentQuota int     `json:"adjustment_quota"`
+	ClosingBalance  int     `json:"closing_balance"`
+	Detail          string  `json:"detail,omitempty" gorm:"type:text"` // BillingStatementDetail 的 JSON
+	Hash            string  `json:"hash" gorm:"type:varchar(64)"`      // 账单内容的 SHA-256，用于校验账单未被篡改
+	CreatedTime     int64   `json:"created_time" gorm:"bigint"`
+}
+
+// BillingStatementDetail 账单明细
+type BillingStatementDetail struct {
+	TopUps         []*BillingTopUpItem      `json:"topups"`
+	Redemptions    []*BillingRedemptionItem `json:"redemptions"`
+	Refunds        []*BillingRefundItem     `json:"refunds"`
+	ConsumeSource  string                   `json:"consume_source"`
+	ConsumeByModel []*BillingConsumeItem    `json:"consume_by_model"`
+	ConsumeByToken []*BillingConsumeItem    `json:"consume_by_token"`
+}
+
+type BillingTopUpItem struct {
+	TradeNo       string  `json:"trade_no"`
+	PaymentMethod string  `json:"payment_method"`
+	Money         float64 `json:"money"`
+	Quota         int     `json:"quota"`
+	CompleteTime  int64   `json:"complete_time"`
+}
+
+type BillingRedemptionItem struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	Quota        int    `json:"quota"`
+	RedeemedTime int64  `json:"redeemed_time"`
+}
+
+type BillingRefundItem struct {
+	Content   string `json:"content"`
+	Quota     int    `json:"quota"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type BillingConsumeItem struct {
+	ModelName        string `json:"model_name,omitempty"`
+	TokenName        string `json:"token_name,omitempty"`
+	Count            int    `json:"count"`
+	Quota            int    `json:"quota"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+func (statement *BillingStatement) BeforeUpdate(tx *gorm.DB) error {
+	return errors.New("账单生成后不可修改")
+}
+
+func (statement *BillingStatement) BeforeDelete(tx *gorm.DB) error {
+	return errors.New("账单生成后不可删除")
+}
+
+// ComputeHash 计算账单汇总与明细的摘要
+func (statement *BillingStatement) ComputeHash() string {
+	content := fmt.Sprintf("%s|%d|%s|%d|%d|%d|%d|%.6f|%d|%d|%d|%d|%d|%s",
+		statement.OwnerType, statement.OwnerId, statement.Period, statement.PeriodStart, statement.PeriodEnd,
+		statement.OpeningBalance, statement.TopUpQuota, statement.TopUpMoney, statement.RedemptionQuota,
+		statement.RefundQuota, statement.ConsumedQuota, statement.AdjustmentQuota, statement.ClosingBalance,
+		statement.Detail)
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify 校验账单内容与生成时的摘要一致
+func (statement *BillingStatement) Verify() bool {
+	return statement.Hash == statement.ComputeHash()
+}
+
+// CreateBillingStatement 保存账单，同一主体同一月份的账单已存在时返回已有账单
+func CreateBillingStatement(statement *BillingStatement) (*BillingStatement, error) {
+	existing, err := GetBillingStatement(statement.OwnerType, statement.OwnerId, statement.Period)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	statement.CreatedTime = common.GetTimestamp()
+	statement.Hash = statement.ComputeHash()
+	if err := DB.Create(statement).Error; err != nil {
+		// 并发生成时唯一索引冲突，返回先保存的账单
+		if existing, getErr := GetBillingStatement(statement.OwnerType, statement.OwnerId, statement.Period); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return statement, nil
+}
+
+func GetBillingStatement(ownerType string, ownerId int, period string) (*BillingStatement, error) {
+	var statement BillingStatement
+	err := DB.Where("owner_type = ? AND owner_id = ? AND period = ?", ownerType, ownerId, period).First(&statement).Error
+	return &statement, err
+}
+
+// GetBillingStatements 分页查询账单列表，不包含明细
+func GetBillingStatements(ownerType string, ownerId int, startIdx int, num int) (statements []*BillingStatement, total int64, err error) {
+	query := DB.Model(&BillingStatement{}).Where("owner_type = ? AND owner_id = ?", ownerType, ownerId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Omit("detail").Order("period desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
+
+// GetPreviousBillingStatement 查询指定月份之前最近的一期账单，用于衔接期初余额
+func GetPreviousBillingStatement(ownerType string, ownerId int, periodStart int64) (*BillingStatement, error) {
+	var statement BillingStatement
+	err := DB.Omit("detail").Where("owner_type = ? AND owner_id = ? AND period_end <= ?", ownerType, ownerId, periodStart).
+		Order("period_end desc").First(&statement).Error
+	return &statement, err
+}
+
+// GetBillingTopUps 查询时间范围内成功的充值订单
+func GetBillingTopUps(userId int, startTime int64, endTime int64) ([]*BillingTopUpItem, error) {
+	var topUps []*TopUp
+	err := DB.Where("user_id = ? AND status = ?", userId, common.TopUpStatusSuccess).
+		Where("(complete_time >= ? AND complete_time < ?) OR (complete_time = 0 AND create_time >= ? AND create_time < ?)",
+			startTime, endTime, startTime, endTime).
+		Order("id asc").Find(&topUps).Error
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*BillingTopUpItem, 0, len(topUps))
+	for _, topUp := range topUps {
+		items = append(items, &BillingTopUpItem{
+			TradeNo:       topUp.TradeNo,
+			PaymentMethod: topUp.PaymentMethod,
+			Money:         topUp.Money,
+			Quota:         topUp.GetQuota(),
+			CompleteTime:  topUp.GetCompletedTime(),
+		})
+	}
+	return items, nil
+}
+
+// GetBillingRedemptions 查询时间范围内用户使用的兑换码，已删除的兑换码同样计入
+func GetBillingRedemptions(userId int, startTime int64, endTime int64) ([]*BillingRedemptionItem, error) {
+	var redemptions []*Redemption
+	err := DB.Unscoped().Where("used_user_id = ? AND redeemed_time >= ? AND redeemed_time < ?", userId, startTime, endTime).
+		Order("redeemed_time asc").Find(&redemptions).Error
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*BillingRedemptionItem, 0, len(redemptions))
+	for _, redemption := range redemptions {
+		items = append(items, &BillingRedemptionItem{
+			Id:           redemption.Id,
+			Name:         redemption.Name,
+			Quota:        redemption.Quota,
+			RedeemedTime: redemption.RedeemedTime,
+		})
+	}
+	return items, nil
+}
+
+// GetBillingRefunds 查询时间范围内的退款，用户账单只包含退回个人额度的退款
+func GetBillingRefunds(ownerType string, ownerId int, startTime int64, endTime int64) ([]*BillingRefundItem, error) {
+	query := LOG_DB.Model(&Log{}).Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeRefund, startTime, endTime)
+	query = billingOwnerLogQuery(query, ownerType, ownerId)
+	var items []*BillingRefundItem
+	err := query.Select("content, quota, created_at").Order("created_at asc").Scan(&items).Error
+	return items, err
+}
+
+// billingOwnerLogQuery 按账单主体筛选日志，用户账单不包含组织令牌产生的记录
+func billingOwnerLogQuery(query *gorm.DB, ownerType string, ownerId int) *gorm.DB {
+	if ownerType == BillingStatementOwnerOrganization {
+		return query.Where("organization_id = ?", ownerId)
+	}
+	return query.Where("user_id = ? AND organization_id = 0", ownerId)
+}
+
+// GetBillingConsumeItems 按模型和令牌汇总时间范围内的消费日志
+func GetBillingConsumeItems(ownerType string, ownerId int, startTime int64, endTime int64) ([]*BillingConsumeItem, error) {
+	query := LOG_DB.Model(&Log{}).Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, startTime, endTime)
+	query = billingOwnerLogQuery(query, ownerType, ownerId)
+	var items []*BillingConsumeItem
+	err := query.Select("model_name, token_name, count(*) as count, sum(quota) as quota, " +
+		"sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+		Group("model_name, token_name").Scan(&items).Error
+	return items, err
+}
+
+// GetBillingQuotaDataItems 按模型汇总时间范围内的数据看板记录，数据看板按小时聚合且不区分令牌
+func GetBillingQuotaDataItems(userId int, startTime int64, endTime int64) ([]*BillingConsumeItem, error) {
+	var items []*BillingConsumeItem
+	err := DB.Model(&QuotaData{}).Where("user_id = ? AND created_at >= ? AND created_at < ?", userId, startTime, endTime).
+		Select("model_name, sum(count) as count, sum(quota) as quota, sum(token_used) as prompt_tokens").
+		Group("model_name").Scan(&items).Error
+	return items, err
+}
+
+// GetBillingOrganizationIds 查询账单月份结束前创建且未删除的组织
+func GetBillingOrganizationIds(endTime int64) ([]int, error) {
+	var ids []int
+	err := DB.Model(&Organization{}).Where("created_time < ?", endTime).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// GetBillingActiveUserIds 查询时间范围内有消费、充值、兑换或退款记录的用户
+func GetBillingActiveUserIds(startTime int64, endTime int64) ([]int, error) {
+	seen := make(map[int]struct{})
+	var ids []int
+	collect := func(query *gorm.DB, column string) error {
+		var found []int
+		if err := query.Distinct(column).Pluck(column, &found).Error; err != nil {
+			return err
+		}
+		for _, id := range found {
+			if _, ok := seen[id]; ok || id == 0 {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+		return nil
+	}
+	if err := collect(LOG_DB.Model(&Log{}).Where("type IN ? AND created_at >= ? AND created_at < ?",
+		[]int{LogTypeConsume, LogTypeRefund}, startTime, endTime), "user_id"); err != nil {
+		return nil, err
+	}
+	if err := collect(DB.Model(&TopUp{}).Where("status = ? AND ((complete_time >= ? AND complete_time < ?) OR (complete_time = 0 AND create_time >= ? AND create_time < ?))",
+		common.TopUpStatusSuccess, startTime, endTime, startTime, endTime), "user_id"); err != nil {
+		return nil, err
+	}
+	if err := collect(DB.Unscoped().Model(&Redemption{}).Where("redeemed_time >= ? AND redeemed_time < ?", startTime, endTime), "used_user_id"); err != nil {
+		return nil, err
+	}
+	if err := collect(DB.Model(&QuotaData{}).Where("created_at >= ? AND created_at < ?", startTime, endTime), "user_id"); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,39 @@
+package model
+
+import "testing"
+
+func TestGetBillingConsumeItemsByOwner(t *testing.T) {
+	logs := []*Log{
+		{UserId: 501, CreatedAt: 100, Type: LogTypeConsume, ModelName: "m", TokenName: "personal", Quota: 10},
+		{UserId: 501, CreatedAt: 100, Type: LogTypeConsume, ModelName: "m", TokenName: "org", Quota: 20, OrganizationId: 7},
+		{UserId: 502, CreatedAt: 100, Type: LogTypeConsume, ModelName: "m", TokenName: "org", Quota: 30, OrganizationId: 7},
+		{UserId: 501, CreatedAt: 100, Type: LogTypeRefund, Quota: 5, OrganizationId: 7},
+	}
+	if err := LOG_DB.Create(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	sum := func(items []*BillingConsumeItem) int {
+		total := 0
+		for _, item := range items {
+			total += item.Quota
+		}
+		return total
+	}
+	items, err := GetBillingConsumeItems(BillingStatementOwnerUser, 501, 0, 200)
+	if err != nil || sum(items) != 10 {
+		t.Fatalf("expected user consumption 10, got %d %v", sum(items), err)
+	}
+	items, err = GetBillingConsumeItems(BillingStatementOwnerOrganization, 7, 0, 200)
+	if err != nil || sum(items) != 50 {
+		t.Fatalf("expected organization consumption 50, got %d %v", sum(items), err)
+	}
+	refunds, err := GetBillingRefunds(BillingStatementOwnerUser, 501, 0, 200)
+	if err != nil || len(refunds) != 0 {
+		t.Fatalf("expected no personal refunds, got %d %v", len(refunds), err)
+	}
+	refunds, err = GetBillingRefunds(BillingStatementOwnerOrganization, 7, 0, 200)
+	if err != nil || len(refunds) != 1 {
+		t.Fatalf("expected one organization refund, got %d %v", len(refunds), err)
+	}
+}
@@ -114,6 +114,22 @@ func (channel *Channel) GetKeys() []string {
 	return keys
 }
 
+// GetEnabledKeyByIndex 获取指定索引的密钥，用于必须使用同一个密钥访问的上游资源（如已上传的文件）
+// 非多密钥模式直接返回原始密钥，多密钥模式下索引越界或该密钥已被禁用时返回错误
+func (channel *Channel) GetEnabledKeyByIndex(index int) (string, *types.NewAPIError) {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.Key, nil
+	}
+	keys := channel.GetKeys()
+	if index < 0 || index >= len(keys) {
+		return "", types.NewError(fmt.Errorf("key #%d does not exist", index), types.ErrorCodeChannelNoAvailableKey, types.ErrOptionWithSkipRetry())
+	}
+	if status, ok := channel.ChannelInfo.MultiKeyStatusList[index]; ok && status != common.ChannelStatusEnabled {
+		return "", types.NewError(fmt.Errorf("key #%d is disabled", index), types.ErrorCodeChannelNoAvailableKey, types.ErrOptionWithSkipRetry())
+	}
+	return keys[index], nil
+}
+
 // GetNextEnabledKey 获取下一个启用的密钥
 // 返回值:
 //
@@ -165,6 +181,9 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	if len(enabledIdx) == 0 {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
+	// 跳过熔断中和并发已满的密钥
+	enabledIdx = filterCircuitAllowedKeys(channel.Id, enabledIdx)
+	enabledIdx = filterConcurrencyAvailableKeys(channel, enabledIdx)
 
 	// 根据多密钥模式选择密钥
 	switch channel.ChannelInfo.MultiKeyMode {
@@ -199,7 +218,7 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		// 循环查找启用的密钥
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if lo.Contains(enabledIdx, idx) {
 				// 更新轮询索引为下一个位置
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
 				return keys[idx], idx, nil
@@ -398,12 +417,14 @@ func BatchInsertChannels(channels []Channel) error {
 		}
 	}()
 
-	for _, chunk := range lo.Chunk(channels, 50) {
+	for i, chunk := range lo.Chunk(channels, 50) {
 		if err := tx.Create(&chunk).Error; err != nil {
 			tx.Rollback()
 			return err
 		}
-		for _, channel_ := range chunk {
+		for j, channel_ := range chunk {
+			// 回写新渠道的 ID，供调用方记录审计日志
+			channels[i*50+j].Id = channel_.Id
 			if err := channel_.AddAbilities(tx); err != nil {
 				tx.Rollback()
 				return err
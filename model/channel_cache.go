@@ -3,14 +3,16 @@ package model
 import (
 	"errors"
 	"fmt"
-	"math/rand"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 )
 
@@ -22,6 +24,10 @@ func InitChannelCache() {
 	if !common.MemoryCacheEnabled {
 		return
 	}
+	syncStart := time.Now()
+	defer func() {
+		metrics.RecordChannelCacheSync(time.Since(syncStart))
+	}()
 	newChannelId2channel := make(map[int]*Channel)
 	var channels []*Channel
 	DB.Find(&channels)
@@ -93,42 +99,56 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
-	// if memory cache is disabled, get channel directly from database
-	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
-	}
-
+// getCachedCandidateChannels 在读锁内复制分组下该模型的候选渠道，熔断和并发过滤需要访问 Redis，不能在锁内进行
+func getCachedCandidateChannels(group string, model string) ([]int, map[int]*Channel, error) {
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
 
 	// First, try to find channels with the exact model name.
-	channels := group2model2channels[group][model]
+	channelIds := group2model2channels[group][model]
 
 	// If no channels found, try to find channels with the normalized model name.
-	if len(channels) == 0 {
+	if len(channelIds) == 0 {
 		normalizedModel := ratio_setting.FormatMatchingModelName(model)
-		channels = group2model2channels[group][normalizedModel]
+		channelIds = group2model2channels[group][normalizedModel]
+	}
+
+	candidates := make(map[int]*Channel, len(channelIds))
+	for _, channelId := range channelIds {
+		channel, ok := channelsIDM[channelId]
+		if !ok {
+			return nil, nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+		}
+		candidates[channelId] = channel
+	}
+	return slices.Clone(channelIds), candidates, nil
+}
+
+func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+	// if memory cache is disabled, get channel directly from database
+	if !common.MemoryCacheEnabled {
+		return GetChannel(group, model, retry)
 	}
 
+	channels, candidates, err := getCachedCandidateChannels(group, model)
+	if err != nil {
+		return nil, err
+	}
 	if len(channels) == 0 {
 		return nil, nil
 	}
 
+	// 跳过熔断中和并发已满的渠道
+	channels = filterCircuitAllowedChannels(channels)
+	channels = filterConcurrencyAvailableChannels(channels, candidates)
+
 	if len(channels) == 1 {
-		if channel, ok := channelsIDM[channels[0]]; ok {
-			return channel, nil
-		}
-		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
+		return candidates[channels[0]], nil
 	}
 
 	uniquePriorities := make(map[int]bool)
 	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			uniquePriorities[int(channel.GetPriority())] = true
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
-		}
+		uniquePriorities[int(candidates[channelId].GetPriority())] = true
 	}
 	var sortedUniquePriorities []int
 	for priority := range uniquePriorities {
@@ -142,16 +162,10 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	targetPriority := int64(sortedUniquePriorities[retry])
 
 	// get the priority for the given retry number
-	var sumWeight = 0
 	var targetChannels []*Channel
 	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			if channel.GetPriority() == targetPriority {
-				sumWeight += channel.GetWeight()
-				targetChannels = append(targetChannels, channel)
-			}
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+		if channel := candidates[channelId]; channel.GetPriority() == targetPriority {
+			targetChannels = append(targetChannels, channel)
 		}
 	}
 
@@ -159,35 +173,30 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
 	}
 
-	// smoothing factor and adjustment
-	smoothingFactor := 1
-	smoothingAdjustment := 0
+	return selectChannelByStrategy(operation_setting.GetChannelSelectStrategy(group, model), targetChannels)
+}
 
-	if sumWeight == 0 {
-		// when all channels have weight 0, set sumWeight to the number of channels and set smoothing adjustment to 100
-		// each channel's effective weight = 100
-		sumWeight = len(targetChannels) * 100
-		smoothingAdjustment = 100
-	} else if sumWeight/len(targetChannels) < 10 {
-		// when the average weight is less than 10, set smoothing factor to 100
-		smoothingFactor = 100
+// IsChannelEnabledForGroupModel 判断渠道是否在指定分组下启用了该模型，用于校验指定使用的渠道（如文件绑定的渠道）
+func IsChannelEnabledForGroupModel(group string, modelName string, channelId int) bool {
+	modelNames := []string{modelName}
+	if normalizedModel := ratio_setting.FormatMatchingModelName(modelName); normalizedModel != modelName {
+		modelNames = append(modelNames, normalizedModel)
 	}
-
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := sumWeight * smoothingFactor
-
-	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
-
-	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight()*smoothingFactor + smoothingAdjustment
-		if randomWeight < 0 {
-			return channel, nil
+	if !common.MemoryCacheEnabled {
+		var count int64
+		err := DB.Model(&Ability{}).
+			Where(commonGroupCol+" = ? and model in ? and channel_id = ? and enabled = ?", group, modelNames, channelId, true).
+			Count(&count).Error
+		return err == nil && count > 0
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	for _, name := range modelNames {
+		if slices.Contains(group2model2channels[group][name], channelId) {
+			return true
 		}
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return false
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
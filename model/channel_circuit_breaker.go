@@ -0,0 +1,137 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/breaker"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+func channelCircuitKey(channelId int) string {
+	return fmt.Sprintf("channel:%d", channelId)
+}
+
+func channelKeyCircuitKey(channelId int, keyIndex int) string {
+	return fmt.Sprintf("channel:%d:key:%d", channelId, keyIndex)
+}
+
+// filterCircuitAllowedChannels 过滤处于熔断状态的渠道，全部熔断时返回原列表，避免请求无渠道可用
+func filterCircuitAllowedChannels(channelIds []int) []int {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || len(channelIds) == 0 {
+		return channelIds
+	}
+	keys := make([]string, len(channelIds))
+	for i, channelId := range channelIds {
+		keys[i] = channelCircuitKey(channelId)
+	}
+	allowed := breaker.Get().Allow(context.Background(), keys, setting.BreakerConfig())
+	result := make([]int, 0, len(channelIds))
+	for i, channelId := range channelIds {
+		if allowed[i] {
+			result = append(result, channelId)
+		}
+	}
+	if len(result) == 0 {
+		return channelIds
+	}
+	return result
+}
+
+// filterCircuitAllowedKeys 过滤多 key 渠道中处于熔断状态的 key，全部熔断时返回原列表
+func filterCircuitAllowedKeys(channelId int, keyIndexes []int) []int {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || len(keyIndexes) == 0 {
+		return keyIndexes
+	}
+	keys := make([]string, len(keyIndexes))
+	for i, keyIndex := range keyIndexes {
+		keys[i] = channelKeyCircuitKey(channelId, keyIndex)
+	}
+	allowed := breaker.Get().Allow(context.Background(), keys, setting.BreakerConfig())
+	result := make([]int, 0, len(keyIndexes))
+	for i, keyIndex := range keyIndexes {
+		if allowed[i] {
+			result = append(result, keyIndex)
+		}
+	}
+	if len(result) == 0 {
+		return keyIndexes
+	}
+	return result
+}
+
+// AcquireChannelCircuit 请求发往渠道前调用，冷却结束的熔断器会进入半开状态并占用探测名额，
+// 半开状态的探测名额已满时返回 breaker.ErrProbesExhausted，调用方应换用其他渠道。
+// 熔断器存储不可用时只记录错误，不阻断请求
+func AcquireChannelCircuit(channelId int, keyIndex int, isMultiKey bool) error {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || channelId == 0 {
+		return nil
+	}
+	cfg := setting.BreakerConfig()
+	channelKey := channelCircuitKey(channelId)
+	if err := breaker.Get().Acquire(context.Background(), channelKey, cfg); err != nil {
+		if errors.Is(err, breaker.ErrProbesExhausted) {
+			return err
+		}
+		common.SysError(err.Error())
+	}
+	if isMultiKey {
+		if err := breaker.Get().Acquire(context.Background(), channelKeyCircuitKey(channelId, keyIndex), cfg); err != nil {
+			if errors.Is(err, breaker.ErrProbesExhausted) {
+				// 归还已占用的渠道级探测名额
+				if releaseErr := breaker.Get().Release(context.Background(), channelKey, cfg); releaseErr != nil {
+					common.SysError(releaseErr.Error())
+				}
+				return err
+			}
+			common.SysError(err.Error())
+		}
+	}
+	return nil
+}
+
+// RecordChannelCircuit 记录渠道请求结果
+func RecordChannelCircuit(channelId int, keyIndex int, isMultiKey bool, success bool) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || channelId == 0 {
+		return
+	}
+	cfg := setting.BreakerConfig()
+	recordCircuit(channelCircuitKey(channelId), success, cfg)
+	if isMultiKey {
+		recordCircuit(channelKeyCircuitKey(channelId, keyIndex), success, cfg)
+	}
+}
+
+// ReleaseChannelCircuit 请求被主动取消（如对冲竞速中落败）时调用，释放探测名额，不计入成功或失败
+func ReleaseChannelCircuit(channelId int, keyIndex int, isMultiKey bool) {
+	setting := operation_setting.GetCircuitBreakerSetting()
+	if !setting.Enabled || channelId == 0 {
+		return
+	}
+	cfg := setting.BreakerConfig()
+	if err := breaker.Get().Release(context.Background(), channelCircuitKey(channelId), cfg); err != nil {
+		common.SysError(err.Error())
+	}
+	if isMultiKey {
+		if err := breaker.Get().Release(context.Background(), channelKeyCircuitKey(channelId, keyIndex), cfg); err != nil {
+			common.SysError(err.Error())
+		}
+	}
+}
+
+func recordCircuit(key string, success bool, cfg breaker.Config) {
+	from, to, err := breaker.Get().Record(context.Background(), key, success, cfg)
+	if err != nil {
+		common.SysError(err.Error())
+		return
+	}
+	if from != to {
+		common.SysLog(fmt.Sprintf("circuit breaker %s: %s -> %s", key, from, to))
+	}
+}
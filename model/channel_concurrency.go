@@ -0,0 +1,136 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// ErrChannelConcurrencySaturated 渠道或 key 的并发请求数已达上限，且排队等待超时
+var ErrChannelConcurrencySaturated = errors.New("渠道并发请求数已达上限")
+
+func channelConcurrencyKey(channelId int) string {
+	return fmt.Sprintf("channel:%d", channelId)
+}
+
+func channelKeyConcurrencyKey(channelId int, keyIndex int) string {
+	return fmt.Sprintf("channel:%d:key:%d", channelId, keyIndex)
+}
+
+// filterSaturated 过滤已达到并发上限的项，全部饱和时返回原列表，由请求排队等待
+func filterSaturated(items []int, keys []string, limits []int) []int {
+	checkKeys := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if limits[i] > 0 {
+			checkKeys = append(checkKeys, key)
+		}
+	}
+	if len(checkKeys) == 0 {
+		return items
+	}
+	counts, err := limiter.GetConcurrencyLimiter().Count(context.Background(), checkKeys)
+	if err != nil {
+		common.SysError("failed to count concurrency: " + err.Error())
+		return items
+	}
+	result := make([]int, 0, len(items))
+	j := 0
+	for i, item := range items {
+		if limits[i] > 0 {
+			saturated := counts[j] >= limits[i]
+			j++
+			if saturated {
+				continue
+			}
+		}
+		result = append(result, item)
+	}
+	if len(result) == 0 {
+		return items
+	}
+	return result
+}
+
+// filterConcurrencyAvailableChannels 跳过已达到最大并发数的渠道，channels 为渠道 ID 到渠道的映射，
+// 该函数会访问 Redis，调用方不能持有 channelSyncLock
+func filterConcurrencyAvailableChannels(channelIds []int, channels map[int]*Channel) []int {
+	keys := make([]string, len(channelIds))
+	limits := make([]int, len(channelIds))
+	for i, channelId := range channelIds {
+		keys[i] = channelConcurrencyKey(channelId)
+		if channel, ok := channels[channelId]; ok {
+			limits[i] = channel.GetOtherSettings().MaxConcurrency
+		}
+	}
+	return filterSaturated(channelIds, keys, limits)
+}
+
+// filterConcurrencyAvailableKeys 跳过多 key 渠道中已达到最大并发数的 key
+func filterConcurrencyAvailableKeys(channel *Channel, keyIndexes []int) []int {
+	limit := channel.GetOtherSettings().MaxConcurrencyPerKey
+	if limit <= 0 {
+		return keyIndexes
+	}
+	keys := make([]string, len(keyIndexes))
+	limits := make([]int, len(keyIndexes))
+	for i, keyIndex := range keyIndexes {
+		keys[i] = channelKeyConcurrencyKey(channel.Id, keyIndex)
+		limits[i] = limit
+	}
+	return filterSaturated(keyIndexes, keys, limits)
+}
+
+// AcquireChannelConcurrency 占用渠道及 key 的并发名额，达到上限时最多排队等待 QueueTimeoutSeconds，
+// 成功时返回释放名额的函数
+func AcquireChannelConcurrency(ctx context.Context, channel *Channel, keyIndex int, isMultiKey bool, member string) (func(), error) {
+	settings := channel.GetOtherSettings()
+	setting := operation_setting.GetConcurrencyLimitSetting()
+	lease := time.Duration(setting.GetLeaseSeconds()) * time.Second
+	wait := time.Duration(setting.QueueTimeoutSeconds) * time.Second
+
+	type slot struct {
+		key   string
+		limit int
+	}
+	slots := make([]slot, 0, 2)
+	if settings.MaxConcurrency > 0 {
+		slots = append(slots, slot{channelConcurrencyKey(channel.Id), settings.MaxConcurrency})
+	}
+	if isMultiKey && settings.MaxConcurrencyPerKey > 0 {
+		slots = append(slots, slot{channelKeyConcurrencyKey(channel.Id, keyIndex), settings.MaxConcurrencyPerKey})
+	}
+
+	l := limiter.GetConcurrencyLimiter()
+	release := func(acquired []slot) {
+		for _, s := range acquired {
+			if err := l.Release(context.Background(), s.key, member); err != nil {
+				common.SysError("failed to release concurrency: " + err.Error())
+			}
+		}
+	}
+	for i, s := range slots {
+		ok, err := limiter.AcquireWithWait(ctx, l, s.key, member, s.limit, lease, wait)
+		if err == nil && !ok {
+			err = fmt.Errorf("%w：渠道 #%d 上限 %d", ErrChannelConcurrencySaturated, channel.Id, s.limit)
+		}
+		if err != nil {
+			release(slots[:i])
+			return nil, err
+		}
+	}
+	// 请求处理期间（包括流式响应）定期续期，避免名额被提前回收
+	keys := make([]string, len(slots))
+	for i, s := range slots {
+		keys[i] = s.key
+	}
+	stopKeepAlive := limiter.KeepAlive(l, keys, member, lease)
+	return func() {
+		stopKeepAlive()
+		release(slots)
+	}, nil
+}
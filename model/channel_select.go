@@ -0,0 +1,152 @@
+package model
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// selectChannelByStrategy 在同一优先级的渠道中按策略选择一个渠道
+func selectChannelByStrategy(strategy string, channels []*Channel) (*Channel, error) {
+	if len(channels) == 1 {
+		return channels[0], nil
+	}
+	switch strategy {
+	case operation_setting.ChannelSelectStrategyLatency:
+		return latencyWeightedChannel(channels)
+	case operation_setting.ChannelSelectStrategyLeastBusy:
+		return leastBusyChannel(channels)
+	case operation_setting.ChannelSelectStrategyLowestCost:
+		return lowestCostChannel(channels)
+	default:
+		return weightedRandomChannel(channels)
+	}
+}
+
+// channelEffectiveWeights 计算渠道的有效权重，权重过小或全为 0 时做平滑处理
+func channelEffectiveWeights(channels []*Channel) []int {
+	sumWeight := 0
+	for _, channel := range channels {
+		sumWeight += channel.GetWeight()
+	}
+
+	// smoothing factor and adjustment
+	smoothingFactor := 1
+	smoothingAdjustment := 0
+
+	if sumWeight == 0 {
+		// when all channels have weight 0, set smoothing adjustment to 100
+		// each channel's effective weight = 100
+		smoothingAdjustment = 100
+	} else if sumWeight/len(channels) < 10 {
+		// when the average weight is less than 10, set smoothing factor to 100
+		smoothingFactor = 100
+	}
+
+	weights := make([]int, len(channels))
+	for i, channel := range channels {
+		weights[i] = channel.GetWeight()*smoothingFactor + smoothingAdjustment
+	}
+	return weights
+}
+
+// weightedRandomChannel 按权重随机选择渠道
+func weightedRandomChannel(channels []*Channel) (*Channel, error) {
+	weights := channelEffectiveWeights(channels)
+	totalWeight := 0
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	if totalWeight <= 0 {
+		return channels[rand.Intn(len(channels))], nil
+	}
+
+	// Generate a random value in the range [0, totalWeight)
+	randomWeight := rand.Intn(totalWeight)
+
+	// Find a channel based on its weight
+	for i, channel := range channels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return channel, nil
+		}
+	}
+	// return null if no channel is not found
+	return nil, errors.New("channel not found")
+}
+
+// latencyWeightedChannel 在权重基础上按延迟反比调整，延迟为最快渠道两倍的渠道只分到一半的流量
+// 没有延迟数据的渠道按最快渠道处理，保证新渠道也能获得流量
+func latencyWeightedChannel(channels []*Channel) (*Channel, error) {
+	latencies := make([]float64, len(channels))
+	minLatency := 0.0
+	for i, channel := range channels {
+		latencies[i] = GetChannelLatency(channel)
+		if latencies[i] > 0 && (minLatency == 0 || latencies[i] < minLatency) {
+			minLatency = latencies[i]
+		}
+	}
+	if minLatency == 0 {
+		return weightedRandomChannel(channels)
+	}
+
+	weights := channelEffectiveWeights(channels)
+	scores := make([]float64, len(channels))
+	totalScore := 0.0
+	for i := range channels {
+		latency := latencies[i]
+		if latency <= 0 {
+			latency = minLatency
+		}
+		scores[i] = float64(weights[i]) * minLatency / latency
+		totalScore += scores[i]
+	}
+	if totalScore <= 0 {
+		return weightedRandomChannel(channels)
+	}
+
+	randomScore := rand.Float64() * totalScore
+	for i, channel := range channels {
+		randomScore -= scores[i]
+		if randomScore < 0 {
+			return channel, nil
+		}
+	}
+	return channels[len(channels)-1], nil
+}
+
+// leastBusyChannel 选择当前进行中请求最少的渠道，数量相同时按权重随机
+func leastBusyChannel(channels []*Channel) (*Channel, error) {
+	var candidates []*Channel
+	minInFlight := int64(-1)
+	for _, channel := range channels {
+		inFlight := GetChannelInFlight(channel.Id)
+		if minInFlight == -1 || inFlight < minInFlight {
+			minInFlight = inFlight
+			candidates = candidates[:0]
+		}
+		if inFlight == minInFlight {
+			candidates = append(candidates, channel)
+		}
+	}
+	return weightedRandomChannel(candidates)
+}
+
+// lowestCostChannel 选择成本倍率最低的渠道，成本相同时按权重随机
+func lowestCostChannel(channels []*Channel) (*Channel, error) {
+	var candidates []*Channel
+	minCost := -1.0
+	for _, channel := range channels {
+		otherSettings := channel.GetOtherSettings()
+		cost := otherSettings.GetCostRatio()
+		if minCost < 0 || cost < minCost {
+			minCost = cost
+			candidates = candidates[:0]
+		}
+		if cost == minCost {
+			candidates = append(candidates, channel)
+		}
+	}
+	return weightedRandomChannel(candidates)
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const (
+	channelLatencySampleSize = 200 // 每个渠道保留的最近延迟样本数
+	channelLatencyMinSamples = 10  // 计算百分位数所需的最少样本数
+)
+
+// channelRuntimeStat 渠道运行时统计，仅保存在当前节点内存中
+type channelRuntimeStat struct {
+	mutex          sync.Mutex
+	latencyEWMA    float64 // 毫秒，0 表示尚无实时数据
+	latencySamples []int64 // 最近的延迟样本（毫秒），写满后循环覆盖
+	sampleIndex    int
+	inFlight       atomic.Int64
+}
+
+var channelRuntimeStats sync.Map // channelId -> *channelRuntimeStat
+
+func getChannelRuntimeStat(channelId int) *channelRuntimeStat {
+	if stat, ok := channelRuntimeStats.Load(channelId); ok {
+		return stat.(*channelRuntimeStat)
+	}
+	stat, _ := channelRuntimeStats.LoadOrStore(channelId, &channelRuntimeStat{})
+	return stat.(*channelRuntimeStat)
+}
+
+// RecordChannelLatency 记录一次请求的延迟（毫秒），流式请求为首字延迟
+func RecordChannelLatency(channelId int, latencyMs int64) {
+	if channelId == 0 || latencyMs <= 0 {
+		return
+	}
+	alpha := operation_setting.GetChannelSelectSetting().LatencyEWMAAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	stat := getChannelRuntimeStat(channelId)
+	stat.mutex.Lock()
+	defer stat.mutex.Unlock()
+	if len(stat.latencySamples) < channelLatencySampleSize {
+		stat.latencySamples = append(stat.latencySamples, latencyMs)
+	} else {
+		stat.latencySamples[stat.sampleIndex] = latencyMs
+		stat.sampleIndex = (stat.sampleIndex + 1) % channelLatencySampleSize
+	}
+	if stat.latencyEWMA == 0 {
+		stat.latencyEWMA = float64(latencyMs)
+		return
+	}
+	stat.latencyEWMA = alpha*float64(latencyMs) + (1-alpha)*stat.latencyEWMA
+}
+
+// GetChannelLatency 获取渠道的延迟估计（毫秒），没有实时数据时使用渠道测试记录的响应时间
+func GetChannelLatency(channel *Channel) float64 {
+	stat := getChannelRuntimeStat(channel.Id)
+	stat.mutex.Lock()
+	latency := stat.latencyEWMA
+	stat.mutex.Unlock()
+	if latency > 0 {
+		return latency
+	}
+	return float64(channel.ResponseTime)
+}
+
+// GetChannelLatencyPercentile 获取渠道最近延迟的百分位数（毫秒），样本不足时返回 0
+func GetChannelLatencyPercentile(channelId int, percentile float64) int64 {
+	stat := getChannelRuntimeStat(channelId)
+	stat.mutex.Lock()
+	samples := slices.Clone(stat.latencySamples)
+	stat.mutex.Unlock()
+	if len(samples) < channelLatencyMinSamples {
+		return 0
+	}
+	slices.Sort(samples)
+	index := int(math.Ceil(percentile/100*float64(len(samples)))) - 1
+	index = min(max(index, 0), len(samples)-1)
+	return samples[index]
+}
+
+// IncreaseChannelInFlight 渠道开始处理请求
+func IncreaseChannelInFlight(channelId int) {
+	getChannelRuntimeStat(channelId).inFlight.Add(1)
+}
+
+// DecreaseChannelInFlight 渠道请求处理结束
+func DecreaseChannelInFlight(channelId int) {
+	getChannelRuntimeStat(channelId).inFlight.Add(-1)
+}
+
+// GetChannelInFlight 获取渠道当前进行中的请求数
+func GetChannelInFlight(channelId int) int64 {
+	return getChannelRuntimeStat(channelId).inFlight.Load()
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+// File 通过 /v1/files 上传的文件元数据，内容保存在对象存储中
+type File struct {
+	Id              int    `json:"id"`
+	FileId          string `json:"file_id" gorm:"type:varchar(128);uniqueIndex"`
+	UserId          int    `json:"user_id" gorm:"index"`
+	TokenId         int    `json:"token_id" gorm:"index"`
+	ChannelId       int    `json:"channel_id" gorm:"index"`                   // 上传时所用的渠道，0 表示仅保存在本地
+	ChannelKeyIndex int    `json:"channel_key_index"`                         // 上传时所用的多密钥索引，后续访问该文件必须使用同一个密钥
+	UpstreamFileId  string `json:"upstream_file_id" gorm:"type:varchar(128)"` // 上游返回的文件 ID
+	Filename        string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose         string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes           int64  `json:"bytes"`
+	ContentType     string `json:"content_type" gorm:"type:varchar(128)"`
+	StorageType     string `json:"storage_type" gorm:"type:varchar(16)"`
+	StorageKey      string `json:"-" gorm:"type:varchar(512)"`
+	Status          string `json:"status" gorm:"type:varchar(16)"`
+	CreatedAt       int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt       int64  `json:"expires_at" gorm:"bigint"`
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+func (file *File) Update() error {
+	return DB.Save(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Delete(file).Error
+}
+
+func (file *File) ToOpenAIFile() dto.OpenAIFile {
+	return dto.OpenAIFile{
+		ID:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		ExpiresAt: file.ExpiresAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+}
+
+// GetUserFileByFileId 获取用户拥有的指定文件
+func GetUserFileByFileId(userId int, fileId string) (*File, error) {
+	if fileId == "" {
+		return nil, errors.New("file_id 为空")
+	}
+	var file File
+	err := DB.Where("user_id = ? and file_id = ?", userId, fileId).First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetUserFilesByFileIds 批量获取用户拥有的文件，不存在的 ID 会被忽略
+func GetUserFilesByFileIds(userId int, fileIds []string) ([]*File, error) {
+	var files []*File
+	if len(fileIds) == 0 {
+		return files, nil
+	}
+	err := DB.Where("user_id = ? and file_id in (?)", userId, fileIds).Find(&files).Error
+	return files, err
+}
+
+// GetUserFiles 按创建时间分页列出用户文件，after 为游标（上一页最后一个 file_id）
+func GetUserFiles(userId int, purpose string, after string, limit int, ascending bool) ([]*File, error) {
+	var files []*File
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	order := "id desc"
+	if ascending {
+		order = "id asc"
+	}
+	if after != "" {
+		var cursor File
+		if err := DB.Select("id").Where("user_id = ? and file_id = ?", userId, after).First(&cursor).Error; err == nil {
+			if ascending {
+				query = query.Where("id > ?", cursor.Id)
+			} else {
+				query = query.Where("id < ?", cursor.Id)
+			}
+		}
+	}
+	err := query.Order(order).Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// GetExpiredFiles 获取已过期的文件，用于清理任务
+func GetExpiredFiles(now int64, limit int) ([]*File, error) {
+	var files []*File
+	err := DB.Where("expires_at > 0 and expires_at < ?", now).Order("id").Limit(limit).Find(&files).Error
+	return files, err
+}
@@ -8,7 +8,10 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	commonRelay "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -36,6 +39,7 @@ type Log struct {
 	TokenId          int    `json:"token_id" gorm:"default:0;index"`
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
+	OrganizationId   int    `json:"organization_id" gorm:"default:0;index"` // 消费或退款所属的组织，用于生成组织账单
 	Other            string `json:"other"`
 }
 
@@ -96,6 +100,24 @@ func RecordLog(userId int, logType int, content string) {
 	}
 }
 
+// RecordRefundLog 记录退还给用户或组织额度池的额度，组织退款记录组织 ID，用于生成账单
+func RecordRefundLog(userId int, organizationId int, content string, quota int) {
+	username, _ := GetUsernameById(userId, false)
+	log := &Log{
+		UserId:         userId,
+		Username:       username,
+		CreatedAt:      common.GetTimestamp(),
+		Type:           LogTypeRefund,
+		Content:        content,
+		Quota:          quota,
+		OrganizationId: organizationId,
+	}
+	err := LOG_DB.Create(log).Error
+	if err != nil {
+		common.SysLog("failed to record log: " + err.Error())
+	}
+}
+
 func RecordErrorLog(c *gin.Context, userId int, channelId int, modelName string, tokenName string, content string, tokenId int, useTimeSeconds int,
 	isStream bool, group string, other map[string]interface{}) {
 	logger.LogInfo(c, fmt.Sprintf("record error log: userId=%d, channelId=%d, modelName=%s, tokenName=%s, content=%s", userId, channelId, modelName, tokenName, content))
@@ -150,10 +172,16 @@ type RecordConsumeLogParams struct {
 	UseTimeSeconds   int                    `json:"use_time_seconds"`
 	IsStream         bool                   `json:"is_stream"`
 	Group            string                 `json:"group"`
+	OrganizationId   int                    `json:"organization_id"` // 为 0 时使用请求令牌所属的组织
 	Other            map[string]interface{} `json:"other"`
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	// 对冲竞速中落败的尝试不记录消费日志，由胜出的一方记录
+	if state, ok := common.GetContextKeyType[*commonRelay.HedgeState](c, constant.ContextKeyHedgeState); ok && state.IsLost() {
+		return
+	}
+	metrics.RecordQuotaSettled(params.ModelName, params.Group, params.Quota)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -167,6 +195,10 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			needRecordIp = true
 		}
 	}
+	organizationId := params.OrganizationId
+	if organizationId == 0 {
+		organizationId = common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId)
+	}
 	log := &Log{
 		UserId:           userId,
 		Username:         username,
@@ -189,7 +221,8 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		Other: otherStr,
+		OrganizationId: organizationId,
+		Other:          otherStr,
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
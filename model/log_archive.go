@@ -0,0 +1,89 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RestoredLog 从归档恢复到数据库的日志，这些日志已存在于归档中，再次超过保留天数时直接删除，不重复归档
+type RestoredLog struct {
+	LogId      int   `json:"log_id" gorm:"primaryKey;autoIncrement:false"`
+	RestoredAt int64 `json:"restored_at" gorm:"bigint"`
+}
+
+// GetOldestLogTimestamp 获取最早一条日志的时间戳，没有日志时返回 0
+func GetOldestLogTimestamp() (int64, error) {
+	var timestamp int64
+	err := LOG_DB.Model(&Log{}).Select("COALESCE(MIN(created_at), 0)").Scan(&timestamp).Error
+	return timestamp, err
+}
+
+// GetLogsForArchive 按 ID 顺序分批读取 [startTimestamp, endTimestamp) 区间内 ID 大于 afterId 的日志
+func GetLogsForArchive(startTimestamp int64, endTimestamp int64, afterId int, limit int) (logs []*Log, err error) {
+	err = LOG_DB.Where("created_at >= ? AND created_at < ? AND id > ?", startTimestamp, endTimestamp, afterId).
+		Order("id asc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
+// DeleteLogsByIds 分批删除已归档的日志
+func DeleteLogsByIds(ids []int) (int64, error) {
+	var total int64
+	for start := 0; start < len(ids); start += 1000 {
+		end := min(start+1000, len(ids))
+		result := LOG_DB.Where("id IN ?", ids[start:end]).Delete(&Log{})
+		if result.Error != nil {
+			return total, result.Error
+		}
+		total += result.RowsAffected
+	}
+	return total, nil
+}
+
+// RestoreLogs 将归档的日志按原 ID 写回数据库并标记为已恢复，已存在的记录跳过
+func RestoreLogs(logs []*Log) (int64, error) {
+	if len(logs) == 0 {
+		return 0, nil
+	}
+	now := common.GetTimestamp()
+	marks := make([]*RestoredLog, 0, len(logs))
+	for _, log := range logs {
+		marks = append(marks, &RestoredLog{LogId: log.Id, RestoredAt: now})
+	}
+	var restored int64
+	err := LOG_DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(logs, 500)
+		if result.Error != nil {
+			return result.Error
+		}
+		restored = result.RowsAffected
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(marks, 500).Error
+	})
+	return restored, err
+}
+
+// GetRestoredLogIds 返回 ids 中从归档恢复的日志 ID
+func GetRestoredLogIds(ids []int) ([]int, error) {
+	var restored []int
+	for start := 0; start < len(ids); start += 1000 {
+		end := min(start+1000, len(ids))
+		var found []int
+		if err := LOG_DB.Model(&RestoredLog{}).Where("log_id IN ?", ids[start:end]).Pluck("log_id", &found).Error; err != nil {
+			return nil, err
+		}
+		restored = append(restored, found...)
+	}
+	return restored, nil
+}
+
+// DeleteRestoredLogMarks 日志再次从数据库删除后清除恢复标记
+func DeleteRestoredLogMarks(ids []int) error {
+	for start := 0; start < len(ids); start += 1000 {
+		end := min(start+1000, len(ids))
+		if err := LOG_DB.Where("log_id IN ?", ids[start:end]).Delete(&RestoredLog{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
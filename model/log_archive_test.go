@@ -0,0 +1,25 @@
+package model
+
+import "testing"
+
+func TestRestoreLogsMarksRestored(t *testing.T) {
+	logs := []*Log{
+		{Id: 900001, UserId: 1, CreatedAt: 10, Type: LogTypeConsume},
+		{Id: 900002, UserId: 1, CreatedAt: 10, Type: LogTypeConsume},
+	}
+	restored, err := RestoreLogs(logs)
+	if err != nil || restored != 2 {
+		t.Fatalf("expected 2 restored logs, got %d %v", restored, err)
+	}
+	ids, err := GetRestoredLogIds([]int{900001, 900002, 900003})
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("expected restored marks for 2 logs, got %v %v", ids, err)
+	}
+	if err := DeleteRestoredLogMarks(ids); err != nil {
+		t.Fatal(err)
+	}
+	ids, err = GetRestoredLogIds([]int{900001, 900002})
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("expected marks to be deleted, got %v %v", ids, err)
+	}
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+)
+
+// LogCapture 开启采集时保存的上游请求体与最终响应体，保存在日志数据库中
+type LogCapture struct {
+	Id                int    `json:"id"`
+	RequestId         string `json:"request_id" gorm:"type:varchar(64);index"`
+	CreatedAt         int64  `json:"created_at" gorm:"bigint;index"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	TokenId           int    `json:"token_id" gorm:"default:0"`
+	Group             string `json:"group" gorm:"type:varchar(64);default:''"`
+	ModelName         string `json:"model_name" gorm:"default:''"`
+	ChannelId         int    `json:"channel_id" gorm:"default:0"`
+	StatusCode        int    `json:"status_code" gorm:"default:0"`
+	IsStream          bool   `json:"is_stream"`
+	RequestBody       string `json:"request_body"`
+	ResponseBody      string `json:"response_body"`
+	RequestTruncated  bool   `json:"request_truncated"`
+	ResponseTruncated bool   `json:"response_truncated"`
+}
+
+func (capture *LogCapture) Insert() error {
+	return LOG_DB.Create(capture).Error
+}
+
+// GetLogCapturesByRequestId 按请求 ID 查询采集记录，同一请求可能因重试产生多条
+func GetLogCapturesByRequestId(requestId string) (captures []*LogCapture, err error) {
+	err = LOG_DB.Where("request_id = ?", requestId).Order("id asc").Find(&captures).Error
+	return captures, err
+}
+
+// DeleteOldLogCaptures 分批删除指定时间之前的采集记录
+func DeleteOldLogCaptures(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
+	var total int64 = 0
+
+	for {
+		if nil != ctx.Err() {
+			return total, ctx.Err()
+		}
+
+		result := LOG_DB.Where("created_at < ?", targetTimestamp).Limit(limit).Delete(&LogCapture{})
+		if nil != result.Error {
+			return total, result.Error
+		}
+
+		total += result.RowsAffected
+
+		if result.RowsAffected < int64(limit) {
+			break
+		}
+	}
+
+	return total, nil
+}
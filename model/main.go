@@ -257,6 +257,8 @@ func migrateDB() error {
 		&Redemption{},
 		&Ability{},
 		&Log{},
+		&RestoredLog{},
+		&LogCapture{},
 		&Midjourney{},
 		&TopUp{},
 		&QuotaData{},
@@ -267,11 +269,20 @@ func migrateDB() error {
 		&Setup{},
 		&TwoFA{},
 		&TwoFABackupCode{},
+		&File{},
+		&TokenBudget{},
+		&Organization{},
+		&OrganizationMember{},
+		&SubscriptionPlan{},
+		&Subscription{},
+		&BillingStatement{},
+		&Role{},
+		&AuditLog{},
 	)
 	if err != nil {
 		return err
 	}
-	return nil
+	return initBuiltInRoles()
 }
 
 func migrateDBFast() error {
@@ -290,6 +301,8 @@ func migrateDBFast() error {
 		{&Redemption{}, "Redemption"},
 		{&Ability{}, "Ability"},
 		{&Log{}, "Log"},
+		{&RestoredLog{}, "RestoredLog"},
+		{&LogCapture{}, "LogCapture"},
 		{&Midjourney{}, "Midjourney"},
 		{&TopUp{}, "TopUp"},
 		{&QuotaData{}, "QuotaData"},
@@ -300,6 +313,15 @@ func migrateDBFast() error {
 		{&Setup{}, "Setup"},
 		{&TwoFA{}, "TwoFA"},
 		{&TwoFABackupCode{}, "TwoFABackupCode"},
+		{&File{}, "File"},
+		{&TokenBudget{}, "TokenBudget"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&Subscription{}, "Subscription"},
+		{&BillingStatement{}, "BillingStatement"},
+		{&Role{}, "Role"},
+		{&AuditLog{}, "AuditLog"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -324,13 +346,16 @@ func migrateDBFast() error {
 			return err
 		}
 	}
+	if err := initBuiltInRoles(); err != nil {
+		return err
+	}
 	common.SysLog("database migrated")
 	return nil
 }
 
 func migrateLOGDB() error {
 	var err error
-	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
+	if err = LOG_DB.AutoMigrate(&Log{}, &RestoredLog{}, &LogCapture{}); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestMain 使用内存 SQLite 数据库运行模型测试
+func TestMain(m *testing.M) {
+	common.RedisEnabled = false
+	common.UsingSQLite = true
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	if err != nil {
+		fmt.Println("failed to open test database:", err)
+		os.Exit(1)
+	}
+	DB = db
+	LOG_DB = db
+	initCol()
+	if err := migrateDB(); err != nil {
+		fmt.Println("failed to migrate test database:", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func createTestUser(t *testing.T, username string, role int) *User {
+	t.Helper()
+	user := &User{Username: username, Password: "12345678", Role: role, Group: "default", AffCode: username}
+	if err := DB.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
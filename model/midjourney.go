@@ -23,6 +23,8 @@ type Midjourney struct {
 	Quota       int    `json:"quota"`
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
+	// 提交任务时令牌所属的组织，失败退款时退回组织额度池
+	OrganizationId int `json:"organization_id" gorm:"default:0"`
 }
 
 // TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
@@ -0,0 +1,613 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 组织成员角色
+const (
+	OrganizationRoleOwner   = "owner"   // 所有者，唯一，拥有全部权限
+	OrganizationRoleAdmin   = "admin"   // 管理员，管理成员与组织令牌
+	OrganizationRoleMember  = "member"  // 普通成员，可以创建组织令牌
+	OrganizationRoleBilling = "billing" // 财务，查看用量并为额度池充值，不能使用组织额度
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+// 组织成员状态，被邀请的用户接受邀请后才成为成员
+const (
+	OrganizationMemberStatusActive  = 1
+	OrganizationMemberStatusInvited = 2
+)
+
+// ErrOrganizationQuotaExceeded 组织额度池不足或成员本月消耗已达上限
+var ErrOrganizationQuotaExceeded = errors.New("组织额度不足或成员本月消耗已达上限")
+
+// Organization 组织，成员通过组织令牌共享组织额度池
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"type:varchar(64);index"`
+	OwnerId     int            `json:"owner_id" gorm:"index"`
+	Status      int            `json:"status" gorm:"type:int;default:1"`
+	Quota       int            `json:"quota" gorm:"type:int;default:0"`
+	UsedQuota   int            `json:"used_quota" gorm:"type:int;default:0"`
+	CreatedTime int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	Role        string         `json:"role,omitempty" gorm:"-:all"`         // 当前用户在组织中的角色，仅用于返回
+	MemberCount int64          `json:"member_count,omitempty" gorm:"-:all"` // 成员数量，仅用于返回
+	OwnerName   string         `json:"owner_name,omitempty" gorm:"-:all"`   // 所有者用户名，仅用于返回
+}
+
+// OrganizationMember 组织成员，MonthlyQuotaLimit 限制成员每月可消耗的组织额度
+type OrganizationMember struct {
+	Id                int    `json:"id"`
+	OrganizationId    int    `json:"organization_id" gorm:"uniqueIndex:idx_organization_member"`
+	UserId            int    `json:"user_id" gorm:"uniqueIndex:idx_organization_member;index"`
+	Role              string `json:"role" gorm:"type:varchar(16)"`
+	Status            int    `json:"status" gorm:"type:int;default:1"`
+	InvitedBy         int    `json:"invited_by" gorm:"default:0"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit" gorm:"default:0"` // 每月消耗上限，0 表示不限制
+	MonthlyUsedQuota  int    `json:"monthly_used_quota" gorm:"default:0"`  // WindowStart 所在月份已消耗的额度
+	WindowStart       int64  `json:"window_start" gorm:"bigint;default:0"`
+	UsedQuota         int    `json:"used_quota" gorm:"default:0"` // 累计消耗的组织额度
+	CreatedTime       int64  `json:"created_time" gorm:"bigint"`
+	Username          string `json:"username,omitempty" gorm:"-:all"` // 仅用于返回
+}
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleMember, OrganizationRoleBilling:
+		return true
+	}
+	return false
+}
+
+// CanManageMembers 所有者和管理员可以管理成员与组织令牌
+func (member *OrganizationMember) CanManageMembers() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleAdmin
+}
+
+// CanManageBilling 所有者和财务可以查看用量并为额度池充值
+func (member *OrganizationMember) CanManageBilling() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleBilling
+}
+
+// CanUseQuota 财务角色不能使用组织额度
+func (member *OrganizationMember) CanUseQuota() bool {
+	return member.Role != OrganizationRoleBilling
+}
+
+func organizationMonthStart(now time.Time) int64 {
+	start, _ := GetTokenBudgetWindow(TokenBudgetPeriodMonthly, now)
+	return start.Unix()
+}
+
+// GetMonthlyUsedQuota 返回当前月份已消耗的额度
+func (member *OrganizationMember) GetMonthlyUsedQuota() int {
+	if member.WindowStart != organizationMonthStart(time.Now()) {
+		return 0
+	}
+	return member.MonthlyUsedQuota
+}
+
+// CreateOrganization 创建组织并将创建者设为所有者
+func CreateOrganization(name string, ownerId int) (*Organization, error) {
+	now := common.GetTimestamp()
+	organization := &Organization{
+		Name:        name,
+		OwnerId:     ownerId,
+		Status:      OrganizationStatusEnabled,
+		CreatedTime: now,
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrganizationId: organization.Id,
+			UserId:         ownerId,
+			Role:           OrganizationRoleOwner,
+			Status:         OrganizationMemberStatusActive,
+			CreatedTime:    now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var organization Organization
+	err := DB.First(&organization, "id = ?", id).Error
+	return &organization, err
+}
+
+// GetAllOrganizations 管理员分页查询所有组织
+func GetAllOrganizations(startIdx int, num int) (organizations []*Organization, total int64, err error) {
+	err = DB.Model(&Organization{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&organizations).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	fillOrganizationDetails(organizations)
+	return organizations, total, nil
+}
+
+// GetUserOrganizations 查询用户加入的所有组织及其角色
+func GetUserOrganizations(userId int) ([]*Organization, error) {
+	return getUserOrganizationsByStatus(userId, OrganizationMemberStatusActive)
+}
+
+// GetUserOrganizationInvitations 查询用户收到的、尚未接受的组织邀请，Role 为邀请的角色
+func GetUserOrganizationInvitations(userId int) ([]*Organization, error) {
+	return getUserOrganizationsByStatus(userId, OrganizationMemberStatusInvited)
+}
+
+func getUserOrganizationsByStatus(userId int, status int) ([]*Organization, error) {
+	var members []OrganizationMember
+	if err := DB.Where("user_id = ? AND status = ?", userId, status).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	roles := make(map[int]string, len(members))
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		roles[member.OrganizationId] = member.Role
+		ids = append(ids, member.OrganizationId)
+	}
+	organizations := make([]*Organization, 0, len(ids))
+	if len(ids) == 0 {
+		return organizations, nil
+	}
+	if err := DB.Where("id IN ?", ids).Order("id desc").Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	for _, organization := range organizations {
+		organization.Role = roles[organization.Id]
+	}
+	fillOrganizationDetails(organizations)
+	return organizations, nil
+}
+
+func fillOrganizationDetails(organizations []*Organization) {
+	for _, organization := range organizations {
+		DB.Model(&OrganizationMember{}).Where("organization_id = ? AND status = ?", organization.Id, OrganizationMemberStatusActive).Count(&organization.MemberCount)
+		organization.OwnerName, _ = GetUsernameById(organization.OwnerId, false)
+	}
+}
+
+// UpdateOrganization 更新组织名称和状态
+func (organization *Organization) Update() error {
+	if err := DB.Model(organization).Select("name", "status").Updates(organization).Error; err != nil {
+		return err
+	}
+	invalidateOrganizationCache(organization.Id)
+	return nil
+}
+
+// DeleteOrganization 删除组织，剩余额度退还给所有者，组织令牌全部禁用
+func DeleteOrganization(id int) error {
+	var tokens []Token
+	var organization Organization
+	var memberUserIds []int
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&organization, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if organization.Quota > 0 {
+			if err := tx.Model(&User{}).Where("id = ?", organization.OwnerId).
+				Update("quota", gorm.Expr("quota + ?", organization.Quota)).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("organization_id = ?", id).Find(&tokens).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Token{}).Where("organization_id = ?", id).
+			Update("status", common.TokenStatusDisabled).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&OrganizationMember{}).Where("organization_id = ?", id).Pluck("user_id", &memberUserIds).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&organization).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateOrganizationCache(id, memberUserIds...)
+	if organization.Quota > 0 {
+		gopool.Go(func() {
+			if err := cacheIncrUserQuota(organization.OwnerId, int64(organization.Quota)); err != nil {
+				common.SysLog("failed to increase user quota cache: " + err.Error())
+			}
+		})
+	}
+	invalidateTokenCache(tokens)
+	return nil
+}
+
+func invalidateTokenCache(tokens []Token) {
+	if !common.RedisEnabled || len(tokens) == 0 {
+		return
+	}
+	gopool.Go(func() {
+		for _, token := range tokens {
+			if err := cacheDeleteToken(token.Key); err != nil {
+				common.SysLog("failed to delete token cache: " + err.Error())
+			}
+		}
+	})
+}
+
+// GetOrganizationMember 查询已接受邀请的成员
+func GetOrganizationMember(organizationId int, userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.Where("organization_id = ? AND user_id = ? AND status = ?", organizationId, userId, OrganizationMemberStatusActive).First(&member).Error
+	return &member, err
+}
+
+// GetOrganizationInvitation 查询尚未接受的邀请
+func GetOrganizationInvitation(organizationId int, userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.Where("organization_id = ? AND user_id = ? AND status = ?", organizationId, userId, OrganizationMemberStatusInvited).First(&member).Error
+	return &member, err
+}
+
+func GetOrganizationMembers(organizationId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Where("organization_id = ?", organizationId).Order("id asc").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Username, _ = GetUsernameById(member.UserId, false)
+		member.MonthlyUsedQuota = member.GetMonthlyUsedQuota()
+	}
+	return members, nil
+}
+
+// InviteOrganizationMember 邀请用户加入组织，用户接受后才成为成员，不能直接邀请为所有者
+func InviteOrganizationMember(organizationId int, userId int, invitedBy int, role string, monthlyQuotaLimit int) (*OrganizationMember, error) {
+	if role == OrganizationRoleOwner || !IsValidOrganizationRole(role) {
+		return nil, fmt.Errorf("无效的成员角色: %s", role)
+	}
+	var existing OrganizationMember
+	err := DB.Where("organization_id = ? AND user_id = ?", organizationId, userId).First(&existing).Error
+	if err == nil {
+		if existing.Status == OrganizationMemberStatusInvited {
+			return nil, errors.New("已邀请该用户，等待对方接受")
+		}
+		return nil, errors.New("该用户已是组织成员")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	member := &OrganizationMember{
+		OrganizationId:    organizationId,
+		UserId:            userId,
+		Role:              role,
+		Status:            OrganizationMemberStatusInvited,
+		InvitedBy:         invitedBy,
+		MonthlyQuotaLimit: monthlyQuotaLimit,
+		CreatedTime:       common.GetTimestamp(),
+	}
+	if err := DB.Create(member).Error; err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// AcceptOrganizationInvitation 用户接受邀请成为组织成员
+func AcceptOrganizationInvitation(organizationId int, userId int) error {
+	result := DB.Model(&OrganizationMember{}).
+		Where("organization_id = ? AND user_id = ? AND status = ?", organizationId, userId, OrganizationMemberStatusInvited).
+		Updates(map[string]interface{}{
+			"status":       OrganizationMemberStatusActive,
+			"created_time": common.GetTimestamp(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请不存在或已处理")
+	}
+	invalidateOrganizationCache(organizationId, userId)
+	return nil
+}
+
+// DeclineOrganizationInvitation 用户拒绝邀请，或管理员撤回邀请
+func DeclineOrganizationInvitation(organizationId int, userId int) error {
+	result := DB.Where("organization_id = ? AND user_id = ? AND status = ?", organizationId, userId, OrganizationMemberStatusInvited).
+		Delete(&OrganizationMember{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请不存在或已处理")
+	}
+	return nil
+}
+
+// UpdateOrganizationMember 更新成员角色和每月消耗上限
+func (member *OrganizationMember) Update() error {
+	if err := DB.Model(member).Select("role", "monthly_quota_limit").Updates(member).Error; err != nil {
+		return err
+	}
+	invalidateOrganizationCache(member.OrganizationId, member.UserId)
+	return nil
+}
+
+// TransferOrganizationOwnership 将所有权转移给另一名成员，原所有者降为管理员
+func TransferOrganizationOwnership(organizationId int, fromUserId int, toUserId int) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ? AND status = ?", organizationId, toUserId, OrganizationMemberStatusActive).
+			Update("role", OrganizationRoleOwner)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该用户不是组织成员")
+		}
+		if err := tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ?", organizationId, fromUserId).
+			Update("role", OrganizationRoleAdmin).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Organization{}).Where("id = ?", organizationId).Update("owner_id", toUserId).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateOrganizationCache(organizationId, fromUserId, toUserId)
+	return nil
+}
+
+// RemoveOrganizationMember 移除成员，其创建的组织令牌转交给组织所有者，保证令牌继续可用
+func RemoveOrganizationMember(organizationId int, userId int) error {
+	var tokens []Token
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var organization Organization
+		if err := tx.First(&organization, "id = ?", organizationId).Error; err != nil {
+			return err
+		}
+		if organization.OwnerId == userId {
+			return errors.New("不能移除组织所有者，请先转移所有权")
+		}
+		result := tx.Where("organization_id = ? AND user_id = ?", organizationId, userId).Delete(&OrganizationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该用户不是组织成员")
+		}
+		if err := tx.Where("organization_id = ? AND user_id = ?", organizationId, userId).Find(&tokens).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Token{}).Where("organization_id = ? AND user_id = ?", organizationId, userId).
+			Update("user_id", organization.OwnerId).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateOrganizationCache(organizationId, userId)
+	invalidateTokenCache(tokens)
+	return nil
+}
+
+// GetOrganizationTokens 查询组织的所有令牌
+func GetOrganizationTokens(organizationId int, startIdx int, num int) (tokens []*Token, total int64, err error) {
+	query := DB.Model(&Token{}).Where("organization_id = ?", organizationId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&tokens).Error
+	return tokens, total, err
+}
+
+// DeleteOrganizationToken 删除组织令牌
+func DeleteOrganizationToken(organizationId int, tokenId int) error {
+	var token Token
+	if err := DB.Where("id = ? AND organization_id = ?", tokenId, organizationId).First(&token).Error; err != nil {
+		return err
+	}
+	return token.Delete()
+}
+
+// FundOrganization 将用户的个人额度转入组织额度池
+func FundOrganization(organizationId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, quota).
+			Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("个人额度不足")
+		}
+		return tx.Model(&Organization{}).Where("id = ?", organizationId).
+			Update("quota", gorm.Expr("quota + ?", quota)).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateOrganizationCache(organizationId)
+	gopool.Go(func() {
+		if err := cacheDecrUserQuota(userId, int64(quota)); err != nil {
+			common.SysLog("failed to decrease user quota cache: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// SetOrganizationQuota 管理员直接设置组织额度池
+func SetOrganizationQuota(organizationId int, quota int) error {
+	if err := DB.Model(&Organization{}).Where("id = ?", organizationId).Update("quota", quota).Error; err != nil {
+		return err
+	}
+	invalidateOrganizationCache(organizationId)
+	return nil
+}
+
+// GetBillingQuota 返回请求可用的额度：组织令牌为组织额度池与成员本月剩余上限中的较小值，否则为用户额度。
+// limited 表示成员设置了每月消耗上限，此时调用方不能信任额度跳过预扣费。
+// 组织和成员信息与用户额度一样优先从缓存读取
+func GetBillingQuota(userId int, organizationId int) (quota int, limited bool, err error) {
+	if organizationId == 0 {
+		quota, err = GetUserQuota(userId, false)
+		return quota, false, err
+	}
+	organization, err := getOrganizationCache(organizationId)
+	if err != nil {
+		return 0, false, errors.New("令牌所属的组织不存在")
+	}
+	if organization.Status != OrganizationStatusEnabled {
+		return 0, false, errors.New("令牌所属的组织已被禁用")
+	}
+	member, err := getOrganizationMemberCache(organizationId, userId)
+	if err != nil || member.Status != OrganizationMemberStatusActive {
+		return 0, false, errors.New("令牌所有者不是该组织的成员")
+	}
+	if !member.CanUseQuota() {
+		return 0, false, errors.New("财务角色不能使用组织额度")
+	}
+	quota = organization.Quota
+	if member.MonthlyQuotaLimit > 0 {
+		quota = min(quota, member.MonthlyQuotaLimit-member.GetMonthlyUsedQuota())
+	}
+	return quota, member.MonthlyQuotaLimit > 0, nil
+}
+
+// ConsumeBillingQuota 预扣费时扣除计费主体的额度。组织令牌在同一条更新语句中校验组织额度池和成员本月上限，
+// 并发请求不会超出上限，额度不足时返回 ErrOrganizationQuotaExceeded
+func ConsumeBillingQuota(userId int, organizationId int, quota int) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	if organizationId == 0 {
+		return DecreaseUserQuota(userId, quota)
+	}
+	return updateOrganizationQuota(userId, organizationId, -quota, true)
+}
+
+// DecreaseBillingQuota 结算时扣除计费主体的额度：组织令牌扣除组织额度池并累计成员用量，否则扣除用户额度。
+// 请求已经完成，结算不再拒绝扣费，超出上限的部分会使后续请求的预扣费失败
+func DecreaseBillingQuota(userId int, organizationId int, quota int) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	if organizationId == 0 {
+		return DecreaseUserQuota(userId, quota)
+	}
+	return updateOrganizationQuota(userId, organizationId, -quota, false)
+}
+
+// IncreaseBillingQuota 退还计费主体的额度
+func IncreaseBillingQuota(userId int, organizationId int, quota int) error {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	if organizationId == 0 {
+		return IncreaseUserQuota(userId, quota, false)
+	}
+	return updateOrganizationQuota(userId, organizationId, quota, false)
+}
+
+// updateOrganizationQuota delta 为负时扣除组织额度，同时累计成员的本月与累计用量；退还时用量不会低于 0。
+// enforce 为 true 时只有组织额度池和成员本月剩余上限都足够才会扣除
+func updateOrganizationQuota(userId int, organizationId int, delta int, enforce bool) error {
+	if delta == 0 {
+		return nil
+	}
+	windowStart := organizationMonthStart(time.Now())
+	used := -delta
+	var organization Organization
+	var member OrganizationMember
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		organizationQuery := tx.Model(&Organization{}).Where("id = ?", organizationId)
+		if enforce {
+			organizationQuery = organizationQuery.Where("quota >= ?", used)
+		}
+		result := organizationQuery.Updates(map[string]interface{}{
+			"quota":      gorm.Expr("quota + ?", delta),
+			"used_quota": gorm.Expr("CASE WHEN used_quota + ? < 0 THEN 0 ELSE used_quota + ? END", used, used),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if enforce && result.RowsAffected == 0 {
+			return ErrOrganizationQuotaExceeded
+		}
+		// 成员可能已被移除，此时只调整组织额度
+		// 先按旧的 window_start 累计用量，再单独切换统计窗口，不依赖 SET 子句的赋值顺序
+		memberQuery := tx.Model(&OrganizationMember{}).Where("organization_id = ? AND user_id = ?", organizationId, userId)
+		if enforce {
+			memberQuery = memberQuery.Where("monthly_quota_limit = 0 OR (CASE WHEN window_start = ? THEN monthly_used_quota ELSE 0 END) + ? <= monthly_quota_limit", windowStart, used)
+		}
+		result = memberQuery.Updates(map[string]interface{}{
+			"monthly_used_quota": gorm.Expr("CASE WHEN window_start = ? THEN (CASE WHEN monthly_used_quota + ? < 0 THEN 0 ELSE monthly_used_quota + ? END) ELSE ? END", windowStart, used, used, max(used, 0)),
+			"used_quota":         gorm.Expr("CASE WHEN used_quota + ? < 0 THEN 0 ELSE used_quota + ? END", used, used),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if enforce && result.RowsAffected == 0 {
+			return ErrOrganizationQuotaExceeded
+		}
+		if err := tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? AND user_id = ? AND window_start <> ?", organizationId, userId, windowStart).
+			Update("window_start", windowStart).Error; err != nil {
+			return err
+		}
+		// 读取更新后的数据刷新缓存
+		if !common.RedisEnabled {
+			return nil
+		}
+		if err := tx.First(&organization, "id = ?", organizationId).Error; err != nil {
+			return err
+		}
+		err := tx.Where("organization_id = ? AND user_id = ?", organizationId, userId).First(&member).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil || !common.RedisEnabled {
+		return err
+	}
+	gopool.Go(func() {
+		if err := updateOrganizationCache(&organization); err != nil {
+			common.SysLog("failed to update organization cache: " + err.Error())
+		}
+		if member.Id != 0 {
+			if err := updateOrganizationMemberCache(&member); err != nil {
+				common.SysLog("failed to update organization member cache: " + err.Error())
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// OrganizationBase 组织令牌计费时使用的组织信息缓存
+type OrganizationBase struct {
+	Id     int `json:"id"`
+	Status int `json:"status"`
+	Quota  int `json:"quota"`
+}
+
+// OrganizationMemberBase 组织令牌计费时使用的成员信息缓存
+type OrganizationMemberBase struct {
+	OrganizationId    int    `json:"organization_id"`
+	UserId            int    `json:"user_id"`
+	Role              string `json:"role"`
+	Status            int    `json:"status"`
+	MonthlyQuotaLimit int    `json:"monthly_quota_limit"`
+	MonthlyUsedQuota  int    `json:"monthly_used_quota"`
+	WindowStart       int64  `json:"window_start"`
+}
+
+func getOrganizationCacheKey(organizationId int) string {
+	return fmt.Sprintf("organization:%d", organizationId)
+}
+
+func getOrganizationMemberCacheKey(organizationId int, userId int) string {
+	return fmt.Sprintf("organization_member:%d:%d", organizationId, userId)
+}
+
+func (organization *Organization) ToBaseOrganization() *OrganizationBase {
+	return &OrganizationBase{
+		Id:     organization.Id,
+		Status: organization.Status,
+		Quota:  organization.Quota,
+	}
+}
+
+func (member *OrganizationMember) ToBaseMember() *OrganizationMemberBase {
+	return &OrganizationMemberBase{
+		OrganizationId:    member.OrganizationId,
+		UserId:            member.UserId,
+		Role:              member.Role,
+		Status:            member.Status,
+		MonthlyQuotaLimit: member.MonthlyQuotaLimit,
+		MonthlyUsedQuota:  member.MonthlyUsedQuota,
+		WindowStart:       member.WindowStart,
+	}
+}
+
+// toMember 转换为成员结构，便于复用角色和本月用量的判断
+func (member *OrganizationMemberBase) toMember() *OrganizationMember {
+	return &OrganizationMember{
+		OrganizationId:    member.OrganizationId,
+		UserId:            member.UserId,
+		Role:              member.Role,
+		Status:            member.Status,
+		MonthlyQuotaLimit: member.MonthlyQuotaLimit,
+		MonthlyUsedQuota:  member.MonthlyUsedQuota,
+		WindowStart:       member.WindowStart,
+	}
+}
+
+func updateOrganizationCache(organization *Organization) error {
+	if !common.RedisEnabled {
+		return nil
+	}
+	return common.RedisHSetObj(
+		getOrganizationCacheKey(organization.Id),
+		organization.ToBaseOrganization(),
+		time.Duration(common.RedisKeyCacheSeconds())*time.Second,
+	)
+}
+
+func updateOrganizationMemberCache(member *OrganizationMember) error {
+	if !common.RedisEnabled {
+		return nil
+	}
+	return common.RedisHSetObj(
+		getOrganizationMemberCacheKey(member.OrganizationId, member.UserId),
+		member.ToBaseMember(),
+		time.Duration(common.RedisKeyCacheSeconds())*time.Second,
+	)
+}
+
+// invalidateOrganizationCache 清除组织缓存，members 为需要一并清除的成员用户 ID
+func invalidateOrganizationCache(organizationId int, memberUserIds ...int) {
+	if !common.RedisEnabled {
+		return
+	}
+	gopool.Go(func() {
+		if err := common.RedisDelKey(getOrganizationCacheKey(organizationId)); err != nil {
+			common.SysLog("failed to delete organization cache: " + err.Error())
+		}
+		for _, userId := range memberUserIds {
+			if err := common.RedisDelKey(getOrganizationMemberCacheKey(organizationId, userId)); err != nil {
+				common.SysLog("failed to delete organization member cache: " + err.Error())
+			}
+		}
+	})
+}
+
+// getOrganizationCache 优先从 Redis 读取组织信息，未命中时查询数据库并异步写入缓存
+func getOrganizationCache(organizationId int) (*OrganizationBase, error) {
+	if common.RedisEnabled {
+		var cache OrganizationBase
+		if err := common.RedisHGetObj(getOrganizationCacheKey(organizationId), &cache); err == nil {
+			return &cache, nil
+		}
+	}
+	organization, err := GetOrganizationById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			if err := updateOrganizationCache(organization); err != nil {
+				common.SysLog("failed to update organization cache: " + err.Error())
+			}
+		})
+	}
+	return organization.ToBaseOrganization(), nil
+}
+
+// getOrganizationMemberCache 优先从 Redis 读取成员信息，未命中时查询数据库并异步写入缓存
+func getOrganizationMemberCache(organizationId int, userId int) (*OrganizationMember, error) {
+	if common.RedisEnabled {
+		var cache OrganizationMemberBase
+		if err := common.RedisHGetObj(getOrganizationMemberCacheKey(organizationId, userId), &cache); err == nil {
+			return cache.toMember(), nil
+		}
+	}
+	member, err := GetOrganizationMember(organizationId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			if err := updateOrganizationMemberCache(member); err != nil {
+				common.SysLog("failed to update organization member cache: " + err.Error())
+			}
+		})
+	}
+	return member, nil
+}
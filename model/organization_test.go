@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestOrganizationInvitationRequiresAcceptance(t *testing.T) {
+	owner := createTestUser(t, "org_invite_owner", common.RoleCommonUser)
+	invitee := createTestUser(t, "org_invite_member", common.RoleCommonUser)
+	organization, err := CreateOrganization("invite", owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOrganizationQuota(organization.Id, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InviteOrganizationMember(organization.Id, invitee.Id, owner.Id, OrganizationRoleMember, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InviteOrganizationMember(organization.Id, invitee.Id, owner.Id, OrganizationRoleMember, 0); err == nil {
+		t.Fatal("duplicate invitation should be rejected")
+	}
+	// 接受邀请前不是成员，不能使用组织额度
+	if _, err := GetOrganizationMember(organization.Id, invitee.Id); err == nil {
+		t.Fatal("invited user should not be a member before accepting")
+	}
+	if _, _, err := GetBillingQuota(invitee.Id, organization.Id); err == nil {
+		t.Fatal("invited user should not use organization quota")
+	}
+	if err := TransferOrganizationOwnership(organization.Id, owner.Id, invitee.Id); err == nil {
+		t.Fatal("ownership should not be transferred to an invited user")
+	}
+	if invitations, _ := GetUserOrganizationInvitations(invitee.Id); len(invitations) != 1 {
+		t.Fatalf("expected one invitation, got %d", len(invitations))
+	}
+
+	if err := AcceptOrganizationInvitation(organization.Id, invitee.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcceptOrganizationInvitation(organization.Id, invitee.Id); err == nil {
+		t.Fatal("accepted invitation should not be accepted twice")
+	}
+	if quota, limited, err := GetBillingQuota(invitee.Id, organization.Id); err != nil || quota != 1000 || limited {
+		t.Fatalf("member should use organization quota, got %d %v %v", quota, limited, err)
+	}
+	if organizations, _ := GetUserOrganizations(invitee.Id); len(organizations) != 1 || organizations[0].MemberCount != 2 {
+		t.Fatalf("accepted organization should be listed with two members, got %+v", organizations)
+	}
+
+	declined := createTestUser(t, "org_invite_declined", common.RoleCommonUser)
+	if _, err := InviteOrganizationMember(organization.Id, declined.Id, owner.Id, OrganizationRoleMember, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeclineOrganizationInvitation(organization.Id, declined.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcceptOrganizationInvitation(organization.Id, declined.Id); err == nil {
+		t.Fatal("declined invitation should not be accepted")
+	}
+}
+
+func TestConsumeBillingQuotaEnforcesMonthlyLimit(t *testing.T) {
+	owner := createTestUser(t, "org_limit_owner", common.RoleCommonUser)
+	user := createTestUser(t, "org_limit_member", common.RoleCommonUser)
+	organization, err := CreateOrganization("limit", owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOrganizationQuota(organization.Id, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InviteOrganizationMember(organization.Id, user.Id, owner.Id, OrganizationRoleMember, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcceptOrganizationInvitation(organization.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, limited, _ := GetBillingQuota(user.Id, organization.Id); !limited {
+		t.Fatal("member with monthly limit should be reported as limited")
+	}
+
+	if err := ConsumeBillingQuota(user.Id, organization.Id, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeBillingQuota(user.Id, organization.Id, 60); !errors.Is(err, ErrOrganizationQuotaExceeded) {
+		t.Fatalf("expected monthly limit exceeded, got %v", err)
+	}
+	// 超出上限时组织额度的扣除需要回滚
+	organization, _ = GetOrganizationById(organization.Id)
+	if organization.Quota != 940 {
+		t.Fatalf("organization quota should be rolled back, got %d", organization.Quota)
+	}
+	member, _ := GetOrganizationMember(organization.Id, user.Id)
+	if member.GetMonthlyUsedQuota() != 60 {
+		t.Fatalf("monthly used quota should be 60, got %d", member.GetMonthlyUsedQuota())
+	}
+
+	// 结算不再拒绝，退还后可以继续预扣
+	if err := DecreaseBillingQuota(user.Id, organization.Id, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeBillingQuota(user.Id, organization.Id, 1); !errors.Is(err, ErrOrganizationQuotaExceeded) {
+		t.Fatalf("expected monthly limit exceeded after settlement, got %v", err)
+	}
+	if err := IncreaseBillingQuota(user.Id, organization.Id, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeBillingQuota(user.Id, organization.Id, 40); err != nil {
+		t.Fatal(err)
+	}
+
+	// 进入新的月份后本月用量从 0 开始累计，累计用量不受影响
+	if err := DB.Model(&OrganizationMember{}).Where("organization_id = ? AND user_id = ?", organization.Id, user.Id).
+		Update("window_start", organizationMonthStart(time.Now().AddDate(0, -1, 0))).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeBillingQuota(user.Id, organization.Id, 70); err != nil {
+		t.Fatalf("monthly used quota should reset in a new month, got %v", err)
+	}
+	member, _ = GetOrganizationMember(organization.Id, user.Id)
+	if member.WindowStart != organizationMonthStart(time.Now()) || member.MonthlyUsedQuota != 70 || member.UsedQuota != 170 {
+		t.Fatalf("unexpected member usage after month rollover: %+v", member)
+	}
+
+	// 组织额度池不足时同样拒绝
+	if err := SetOrganizationQuota(organization.Id, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConsumeBillingQuota(owner.Id, organization.Id, 20); !errors.Is(err, ErrOrganizationQuotaExceeded) {
+		t.Fatalf("expected organization quota exceeded, got %v", err)
+	}
+}
+
+func TestDeleteOrganization(t *testing.T) {
+	owner := createTestUser(t, "org_delete_owner", common.RoleCommonUser)
+	organization, err := CreateOrganization("delete", owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteOrganization(organization.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetOrganizationById(organization.Id); err == nil {
+		t.Fatal("organization should be deleted")
+	}
+	var count int64
+	DB.Model(&OrganizationMember{}).Where("organization_id = ?", organization.Id).Count(&count)
+	if count != 0 {
+		t.Fatalf("members should be deleted, got %d", count)
+	}
+}
@@ -0,0 +1,307 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// Role 命名角色，为用户在原有角色等级之外额外授予一组后台管理权限
+type Role struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	DisplayName string `json:"display_name" gorm:"type:varchar(64);default:''"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Permissions string `json:"permissions" gorm:"type:text"` // 权限列表的 JSON 数组
+	BuiltIn     bool   `json:"built_in" gorm:"default:false"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UserCount   int64  `json:"user_count" gorm:"-:all"`
+}
+
+// 内置的命名角色，首次启动时创建，之后可以修改权限但不能删除
+var builtInRoles = []Role{
+	{
+		Name:        "channel_manager",
+		DisplayName: "渠道管理员",
+		Description: "管理渠道与渠道密钥，不能查看已保存的密钥",
+		Permissions: common.GetJsonString([]string{constant.PermissionChannelRead, constant.PermissionChannelWrite, constant.PermissionModelRead}),
+	},
+	{
+		Name:        "billing_operator",
+		DisplayName: "财务",
+		Description: "管理充值、兑换码、订阅与账单",
+		Permissions: common.GetJsonString([]string{constant.PermissionBillingRead, constant.PermissionBillingWrite, constant.PermissionUserRead}),
+	},
+	{
+		Name:        "support",
+		DisplayName: "客服",
+		Description: "只读查看用户与使用日志",
+		Permissions: common.GetJsonString([]string{constant.PermissionLogRead, constant.PermissionUserRead}),
+	},
+	{
+		Name:        "model_editor",
+		DisplayName: "模型与定价编辑",
+		Description: "管理模型、供应商与模型定价",
+		Permissions: common.GetJsonString([]string{constant.PermissionModelRead, constant.PermissionModelWrite, constant.PermissionPricingWrite}),
+	},
+}
+
+// adminPresetPermissions 管理员角色对应的权限预设，与原有 AdminAuth 可访问的范围一致
+var adminPresetPermissions = []string{
+	constant.PermissionChannelRead,
+	constant.PermissionChannelWrite,
+	constant.PermissionUserRead,
+	constant.PermissionUserWrite,
+	constant.PermissionBillingRead,
+	constant.PermissionBillingWrite,
+	constant.PermissionLogRead,
+	constant.PermissionLogWrite,
+	constant.PermissionModelRead,
+	constant.PermissionModelWrite,
+}
+
+// GetRolePresetPermissions 返回原有角色等级对应的权限预设：超级管理员拥有全部权限，普通用户没有后台权限
+func GetRolePresetPermissions(role int) []string {
+	switch {
+	case role >= common.RoleRootUser:
+		return append([]string{}, constant.AllPermissions...)
+	case role >= common.RoleAdminUser:
+		return append([]string{}, adminPresetPermissions...)
+	}
+	return []string{}
+}
+
+func (role *Role) GetPermissions() []string {
+	permissions := make([]string, 0)
+	if role.Permissions == "" {
+		return permissions
+	}
+	if err := common.UnmarshalJsonStr(role.Permissions, &permissions); err != nil {
+		common.SysLog(fmt.Sprintf("failed to unmarshal permissions of role %s: %s", role.Name, err.Error()))
+	}
+	return permissions
+}
+
+// SetPermissions 校验并保存权限列表，忽略重复项，只属于超级管理员的权限不能授予
+func (role *Role) SetPermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !lo.Contains(constant.AllPermissions, permission) {
+			return fmt.Errorf("未知的权限：%s", permission)
+		}
+		if lo.Contains(constant.RootOnlyPermissions, permission) {
+			return fmt.Errorf("权限 %s 只属于超级管理员，不能授予角色", permission)
+		}
+	}
+	role.Permissions = common.GetJsonString(lo.Uniq(permissions))
+	return nil
+}
+
+func initBuiltInRoles() error {
+	for _, builtIn := range builtInRoles {
+		role := builtIn
+		role.BuiltIn = true
+		role.CreatedTime = common.GetTimestamp()
+		if err := DB.Where("name = ?", role.Name).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetAllRoles() (roles []*Role, err error) {
+	if err = DB.Order("id asc").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		DB.Model(&User{}).Where("role_id = ?", role.Id).Count(&role.UserCount)
+	}
+	return roles, nil
+}
+
+func GetRoleById(id int) (*Role, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	var role Role
+	err := DB.First(&role, "id = ?", id).Error
+	return &role, err
+}
+
+func (role *Role) Insert() error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" {
+		return errors.New("角色名称不能为空")
+	}
+	role.Id = 0
+	role.BuiltIn = false
+	role.CreatedTime = common.GetTimestamp()
+	return DB.Create(role).Error
+}
+
+// Update 修改角色的显示名称、说明和权限，角色标识不可修改
+func (role *Role) Update() error {
+	var userIds []int
+	if err := DB.Model(&User{}).Where("role_id = ?", role.Id).Pluck("id", &userIds).Error; err != nil {
+		return err
+	}
+	err := DB.Model(&Role{}).Where("id = ?", role.Id).Updates(map[string]interface{}{
+		"display_name": role.DisplayName,
+		"description":  role.Description,
+		"permissions":  role.Permissions,
+	}).Error
+	if err != nil {
+		return err
+	}
+	clearUserPermissionCache()
+	updateUsersAdminSidebar(userIds)
+	return nil
+}
+
+// DeleteRoleById 删除自定义角色，同时收回已分配给用户的该角色
+func DeleteRoleById(id int) error {
+	role, err := GetRoleById(id)
+	if err != nil {
+		return err
+	}
+	if role.BuiltIn {
+		return errors.New("内置角色不能删除")
+	}
+	var userIds []int
+	if err := DB.Model(&User{}).Where("role_id = ?", id).Pluck("id", &userIds).Error; err != nil {
+		return err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("role_id = ?", id).Update("role_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Role{}, "id = ?", id).Error
+	})
+	if err != nil {
+		return err
+	}
+	clearUserPermissionCache()
+	updateUsersAdminSidebar(userIds)
+	return nil
+}
+
+// SetUserRoleId 为用户分配命名角色，roleId 为 0 表示取消分配
+func SetUserRoleId(userId int, roleId int) error {
+	if roleId != 0 {
+		if _, err := GetRoleById(roleId); err != nil {
+			return errors.New("角色不存在")
+		}
+	}
+	result := DB.Model(&User{}).Where("id = ?", userId).Update("role_id", roleId)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("用户不存在")
+	}
+	userPermissionCache.Delete(userId)
+	return updateUserAdminSidebar(userId)
+}
+
+func updateUsersAdminSidebar(userIds []int) {
+	for _, userId := range userIds {
+		if err := updateUserAdminSidebar(userId); err != nil {
+			common.SysLog(fmt.Sprintf("failed to update sidebar of user %d: %s", userId, err.Error()))
+		}
+	}
+}
+
+// updateUserAdminSidebar 角色变化后按新的权限重新生成用户边栏配置中的管理员区域，其他区域保持不变
+func updateUserAdminSidebar(userId int) error {
+	user, err := GetUserById(userId, false)
+	if err != nil {
+		return err
+	}
+	permissions, err := GetUserPermissions(user.Id, user.Role)
+	if err != nil {
+		return err
+	}
+	setting := user.GetSetting()
+	sidebar := map[string]interface{}{}
+	if setting.SidebarModules != "" {
+		if err := common.UnmarshalJsonStr(setting.SidebarModules, &sidebar); err != nil {
+			sidebar = map[string]interface{}{}
+		}
+	}
+	if admin := GetAdminSidebarModules(permissions); admin != nil {
+		sidebar["admin"] = admin
+	} else {
+		delete(sidebar, "admin")
+	}
+	setting.SidebarModules = common.GetJsonString(sidebar)
+	user.SetSetting(setting)
+	return user.Update(false)
+}
+
+type userPermissionCacheEntry struct {
+	permissions []string
+	expireAt    time.Time
+}
+
+// 用户权限的进程内缓存，避免每个后台请求都查询数据库
+// 本节点修改角色或分配时立即失效，其他节点最多在缓存时间后生效
+var userPermissionCache sync.Map
+
+const userPermissionCacheTTL = 10 * time.Second
+
+func clearUserPermissionCache() {
+	userPermissionCache.Range(func(key, value any) bool {
+		userPermissionCache.Delete(key)
+		return true
+	})
+}
+
+// GetUserPermissions 返回用户的后台管理权限：原有角色等级的权限预设加上命名角色的权限
+// 命名角色中只属于超级管理员的权限会被忽略
+func GetUserPermissions(userId int, userRole int) ([]string, error) {
+	permissions := GetRolePresetPermissions(userRole)
+	if userRole >= common.RoleRootUser {
+		return permissions, nil
+	}
+	if value, ok := userPermissionCache.Load(userId); ok {
+		entry := value.(*userPermissionCacheEntry)
+		if time.Now().Before(entry.expireAt) {
+			return lo.Uniq(append(permissions, entry.permissions...)), nil
+		}
+	}
+	rolePermissions, err := getUserRolePermissions(userId)
+	if err != nil {
+		return nil, err
+	}
+	userPermissionCache.Store(userId, &userPermissionCacheEntry{
+		permissions: rolePermissions,
+		expireAt:    time.Now().Add(userPermissionCacheTTL),
+	})
+	return lo.Uniq(append(permissions, rolePermissions...)), nil
+}
+
+// getUserRolePermissions 从数据库读取用户命名角色的权限
+func getUserRolePermissions(userId int) ([]string, error) {
+	var user User
+	if err := DB.Select("id", "role_id").First(&user, "id = ?", userId).Error; err != nil {
+		return nil, err
+	}
+	if user.RoleId == 0 {
+		return []string{}, nil
+	}
+	role, err := GetRoleById(user.RoleId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return lo.Without(role.GetPermissions(), constant.RootOnlyPermissions...), nil
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/samber/lo"
+)
+
+func TestRoleSetPermissionsRejectsRootOnly(t *testing.T) {
+	for _, permission := range constant.RootOnlyPermissions {
+		role := &Role{Name: "test"}
+		if err := role.SetPermissions([]string{constant.PermissionLogRead, permission}); err == nil {
+			t.Fatalf("expected %s to be rejected", permission)
+		}
+	}
+	role := &Role{Name: "test"}
+	if err := role.SetPermissions([]string{"unknown.permission"}); err == nil {
+		t.Fatal("expected unknown permission to be rejected")
+	}
+}
+
+func TestGetUserPermissions(t *testing.T) {
+	role := &Role{Name: "perm_test_role"}
+	if err := role.SetPermissions([]string{constant.PermissionLogRead}); err != nil {
+		t.Fatal(err)
+	}
+	if err := role.Insert(); err != nil {
+		t.Fatal(err)
+	}
+	// 直接写入数据库的越权权限不应生效
+	if err := DB.Model(&Role{}).Where("id = ?", role.Id).Update("permissions",
+		common.GetJsonString([]string{constant.PermissionLogRead, constant.PermissionRoleManage})).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := createTestUser(t, "perm_test_user", common.RoleCommonUser)
+	if err := SetUserRoleId(user.Id, role.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err := GetUserPermissions(user.Id, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lo.Contains(permissions, constant.PermissionLogRead) {
+		t.Fatalf("expected log.read, got %v", permissions)
+	}
+	if lo.Contains(permissions, constant.PermissionRoleManage) {
+		t.Fatalf("root-only permission granted through role: %v", permissions)
+	}
+
+	// 收回角色后缓存立即失效
+	if err := SetUserRoleId(user.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err = GetUserPermissions(user.Id, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 0 {
+		t.Fatalf("expected no permissions after revoking role, got %v", permissions)
+	}
+
+	// 修改角色权限后缓存立即失效
+	if err := SetUserRoleId(user.Id, role.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetUserPermissions(user.Id, user.Role); err != nil {
+		t.Fatal(err)
+	}
+	if err := role.SetPermissions([]string{constant.PermissionUserRead}); err != nil {
+		t.Fatal(err)
+	}
+	if err := role.Update(); err != nil {
+		t.Fatal(err)
+	}
+	permissions, err = GetUserPermissions(user.Id, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lo.Contains(permissions, constant.PermissionLogRead) || !lo.Contains(permissions, constant.PermissionUserRead) {
+		t.Fatalf("expected updated role permissions, got %v", permissions)
+	}
+}
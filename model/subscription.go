@@ -0,0 +1,427 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusPastDue  = "past_due" // Stripe 续费失败，宽限期内保留权益
+	SubscriptionStatusCanceled = "canceled" // 已失效，额度与分组均已收回
+)
+
+const (
+	SubscriptionPaymentStripe = "stripe"
+	SubscriptionPaymentAdmin  = "admin" // 管理员手动开通，到期自动续期直到取消
+)
+
+// SubscriptionPlan 订阅套餐，每个周期发放固定额度，订阅期间可以将用户升级到指定分组
+type SubscriptionPlan struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name" gorm:"type:varchar(64)"`
+	Description   string  `json:"description" gorm:"type:varchar(255)"`
+	Price         float64 `json:"price"`                                    // 每周期价格，用于展示和判断升级或降级
+	Quota         int     `json:"quota"`                                    // 每周期发放的额度
+	Group         string  `json:"group" gorm:"type:varchar(64);default:''"` // 订阅期间用户所在的分组，留空不修改
+	DurationDays  int     `json:"duration_days" gorm:"default:30"`          // 非 Stripe 订阅的周期天数，Stripe 订阅以 Stripe 的账单周期为准
+	StripePriceId string  `json:"stripe_price_id" gorm:"type:varchar(128);index"`
+	Enabled       bool    `json:"enabled" gorm:"default:true"`
+	Sort          int     `json:"sort" gorm:"default:0"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+}
+
+// Subscription 用户的订阅，同一用户同时只有一个有效订阅
+type Subscription struct {
+	Id                   int    `json:"id"`
+	UserId               int    `json:"user_id" gorm:"index"`
+	PlanId               int    `json:"plan_id"`
+	PendingPlanId        int    `json:"pending_plan_id" gorm:"default:0"` // 降级后在下个周期生效的套餐
+	Status               string `json:"status" gorm:"type:varchar(16);index"`
+	PaymentMethod        string `json:"payment_method" gorm:"type:varchar(16)"`
+	StripeSubscriptionId string `json:"stripe_subscription_id" gorm:"type:varchar(128);index"`
+	CurrentPeriodStart   int64  `json:"current_period_start" gorm:"bigint"`
+	CurrentPeriodEnd     int64  `json:"current_period_end" gorm:"bigint;index"`
+	CancelAtPeriodEnd    bool   `json:"cancel_at_period_end"`
+	GrantedQuota         int    `json:"granted_quota" gorm:"default:0"`                    // 本周期已发放的额度
+	UsedQuotaAtGrant     int    `json:"used_quota_at_grant" gorm:"default:0"`              // 发放时用户的累计已用额度，用于计算周期结束时未用完的额度
+	PreviousGroup        string `json:"previous_group" gorm:"type:varchar(64);default:''"` // 订阅前的分组，订阅失效时恢复
+	CreatedTime          int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime          int64  `json:"updated_time" gorm:"bigint"`
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	return DB.Model(plan).Select("name", "description", "price", "quota", "group", "duration_days",
+		"stripe_price_id", "enabled", "sort").Updates(plan).Error
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	var count int64
+	err := DB.Model(&Subscription{}).Where("(plan_id = ? OR pending_plan_id = ?) AND status <> ?", id, id, SubscriptionStatusCanceled).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有有效订阅，请先禁用套餐")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func GetSubscriptionPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	query := DB.Order("sort desc, id asc")
+	if enabledOnly {
+		query = query.Where("enabled = ?", true)
+	}
+	err = query.Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	var plan SubscriptionPlan
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func GetSubscriptionPlanByStripePriceId(priceId string) (*SubscriptionPlan, error) {
+	if priceId == "" {
+		return nil, errors.New("price id 为空！")
+	}
+	var plan SubscriptionPlan
+	err := DB.First(&plan, "stripe_price_id = ?", priceId).Error
+	return &plan, err
+}
+
+// GetActiveSubscription 查询用户当前有效的订阅，没有时返回 gorm.ErrRecordNotFound
+func GetActiveSubscription(userId int) (*Subscription, error) {
+	var subscription Subscription
+	err := DB.Where("user_id = ? AND status IN ?", userId, []string{SubscriptionStatusActive, SubscriptionStatusPastDue}).
+		Order("id desc").First(&subscription).Error
+	return &subscription, err
+}
+
+func GetSubscriptionById(id int) (*Subscription, error) {
+	var subscription Subscription
+	err := DB.First(&subscription, "id = ?", id).Error
+	return &subscription, err
+}
+
+func GetSubscriptionByStripeId(stripeSubscriptionId string) (*Subscription, error) {
+	if stripeSubscriptionId == "" {
+		return nil, errors.New("subscription id 为空！")
+	}
+	var subscription Subscription
+	err := DB.First(&subscription, "stripe_subscription_id = ?", stripeSubscriptionId).Error
+	return &subscription, err
+}
+
+// GetAllSubscriptions 管理员分页查询订阅，status 为空时查询全部
+func GetAllSubscriptions(status string, userId int, startIdx int, num int) (subscriptions []*Subscription, total int64, err error) {
+	query := DB.Model(&Subscription{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, total, err
+}
+
+// GetDueSubscriptions 查询周期已结束、需要续期或失效的订阅
+func GetDueSubscriptions(now int64, limit int) (subscriptions []*Subscription, err error) {
+	err = DB.Where("status IN ? AND current_period_end <= ?", []string{SubscriptionStatusActive, SubscriptionStatusPastDue}, now).
+		Order("current_period_end asc").Limit(limit).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func lockUser(tx *gorm.DB, userId int) (*User, error) {
+	var user User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userId).Error
+	return &user, err
+}
+
+// reclaimSubscriptionQuota 收回本周期未用完的套餐额度，优先认为用户先消耗套餐额度
+func reclaimSubscriptionQuota(tx *gorm.DB, subscription *Subscription, user *User) (int, error) {
+	if !operation_setting.GetSubscriptionSetting().ExpireUnusedQuota || subscription.GrantedQuota <= 0 {
+		return 0, nil
+	}
+	used := max(user.UsedQuota-subscription.UsedQuotaAtGrant, 0)
+	reclaim := min(max(subscription.GrantedQuota-used, 0), max(user.Quota, 0))
+	if reclaim == 0 {
+		return 0, nil
+	}
+	if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("quota", gorm.Expr("quota - ?", reclaim)).Error; err != nil {
+		return 0, err
+	}
+	user.Quota -= reclaim
+	return reclaim, nil
+}
+
+// grantSubscriptionQuota 发放新周期的额度并记录发放时的已用额度
+func grantSubscriptionQuota(tx *gorm.DB, subscription *Subscription, user *User, quota int) error {
+	if quota > 0 {
+		if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
+			return err
+		}
+		user.Quota += quota
+	}
+	subscription.GrantedQuota = quota
+	subscription.UsedQuotaAtGrant = user.UsedQuota
+	return nil
+}
+
+// applySubscriptionGroup 将用户切换到套餐分组，首次切换时记录原分组
+func applySubscriptionGroup(tx *gorm.DB, subscription *Subscription, user *User, plan *SubscriptionPlan) error {
+	if plan.Group == "" || user.Group == plan.Group {
+		return nil
+	}
+	if subscription.PreviousGroup == "" {
+		subscription.PreviousGroup = user.Group
+	}
+	if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("group", plan.Group).Error; err != nil {
+		return err
+	}
+	user.Group = plan.Group
+	return nil
+}
+
+// ActivateSubscription 开通订阅：发放首个周期的额度并升级分组
+func ActivateSubscription(userId int, plan *SubscriptionPlan, paymentMethod string, stripeSubscriptionId string, periodStart int64, periodEnd int64) (*Subscription, error) {
+	now := common.GetTimestamp()
+	subscription := &Subscription{
+		UserId:               userId,
+		PlanId:               plan.Id,
+		Status:               SubscriptionStatusActive,
+		PaymentMethod:        paymentMethod,
+		StripeSubscriptionId: stripeSubscriptionId,
+		CurrentPeriodStart:   periodStart,
+		CurrentPeriodEnd:     periodEnd,
+		CreatedTime:          now,
+		UpdatedTime:          now,
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userId)
+		if err != nil {
+			return err
+		}
+		var count int64
+		err = tx.Model(&Subscription{}).Where("user_id = ? AND status IN ?", userId, []string{SubscriptionStatusActive, SubscriptionStatusPastDue}).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("用户已有有效的订阅")
+		}
+		if err := grantSubscriptionQuota(tx, subscription, user, plan.Quota); err != nil {
+			return err
+		}
+		if err := applySubscriptionGroup(tx, subscription, user, plan); err != nil {
+			return err
+		}
+		return tx.Create(subscription).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = invalidateUserCache(userId)
+	RecordLog(userId, LogTypeTopup, fmt.Sprintf("开通订阅套餐 %s，发放额度 %s", plan.Name, logger.LogQuota(plan.Quota)))
+	return subscription, nil
+}
+
+// RenewSubscription 进入新的周期：收回上个周期未用完的额度，应用待生效的套餐并发放新周期的额度
+// periodStart 不晚于当前周期开始时间时视为重复通知，直接忽略
+func RenewSubscription(subscriptionId int, periodStart int64, periodEnd int64) error {
+	var plan *SubscriptionPlan
+	var reclaimed int
+	var subscription Subscription
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&subscription, "id = ?", subscriptionId).Error; err != nil {
+			return err
+		}
+		if subscription.Status == SubscriptionStatusCanceled {
+			return errors.New("订阅已失效")
+		}
+		if periodStart <= subscription.CurrentPeriodStart {
+			plan = nil
+			return nil
+		}
+		user, err := lockUser(tx, subscription.UserId)
+		if err != nil {
+			return err
+		}
+		if reclaimed, err = reclaimSubscriptionQuota(tx, &subscription, user); err != nil {
+			return err
+		}
+		if subscription.PendingPlanId != 0 {
+			subscription.PlanId = subscription.PendingPlanId
+			subscription.PendingPlanId = 0
+		}
+		plan = &SubscriptionPlan{}
+		if err := tx.First(plan, "id = ?", subscription.PlanId).Error; err != nil {
+			return err
+		}
+		if err := grantSubscriptionQuota(tx, &subscription, user, plan.Quota); err != nil {
+			return err
+		}
+		if err := applySubscriptionGroup(tx, &subscription, user, plan); err != nil {
+			return err
+		}
+		subscription.Status = SubscriptionStatusActive
+		subscription.CurrentPeriodStart = periodStart
+		subscription.CurrentPeriodEnd = periodEnd
+		subscription.UpdatedTime = common.GetTimestamp()
+		return tx.Save(&subscription).Error
+	})
+	if err != nil || plan == nil {
+		return err
+	}
+	_ = invalidateUserCache(subscription.UserId)
+	RecordLog(subscription.UserId, LogTypeTopup, fmt.Sprintf("订阅套餐 %s 续期，收回上期未用额度 %s，发放额度 %s",
+		plan.Name, logger.LogQuota(reclaimed), logger.LogQuota(plan.Quota)))
+	return nil
+}
+
+// LapseSubscription 订阅失效：收回未用完的额度，并在用户仍处于套餐分组时恢复原分组
+func LapseSubscription(subscriptionId int, reason string) error {
+	var reclaimed int
+	var subscription Subscription
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&subscription, "id = ?", subscriptionId).Error; err != nil {
+			return err
+		}
+		if subscription.Status == SubscriptionStatusCanceled {
+			return nil
+		}
+		user, err := lockUser(tx, subscription.UserId)
+		if err != nil {
+			return err
+		}
+		if reclaimed, err = reclaimSubscriptionQuota(tx, &subscription, user); err != nil {
+			return err
+		}
+		var plan SubscriptionPlan
+		if err := tx.First(&plan, "id = ?", subscription.PlanId).Error; err == nil {
+			if plan.Group != "" && user.Group == plan.Group && subscription.PreviousGroup != "" {
+				if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("group", subscription.PreviousGroup).Error; err != nil {
+					return err
+				}
+			}
+		}
+		subscription.Status = SubscriptionStatusCanceled
+		subscription.GrantedQuota = 0
+		subscription.PendingPlanId = 0
+		subscription.UpdatedTime = common.GetTimestamp()
+		return tx.Save(&subscription).Error
+	})
+	if err != nil {
+		return err
+	}
+	_ = invalidateUserCache(subscription.UserId)
+	RecordLog(subscription.UserId, LogTypeSystem, fmt.Sprintf("订阅已失效（%s），收回未用额度 %s", reason, logger.LogQuota(reclaimed)))
+	return nil
+}
+
+// proratedQuota 按当前周期剩余时间折算升级需要补发的额度
+func proratedQuota(oldQuota int, newQuota int, periodStart int64, periodEnd int64, now int64) int {
+	if newQuota <= oldQuota || periodEnd <= periodStart || now >= periodEnd {
+		return 0
+	}
+	remaining := decimal.NewFromInt(periodEnd - max(now, periodStart)).Div(decimal.NewFromInt(periodEnd - periodStart))
+	return int(decimal.NewFromInt(int64(newQuota - oldQuota)).Mul(remaining).Round(0).IntPart())
+}
+
+// IsSubscriptionUpgrade 价格更高的套餐视为升级
+func IsSubscriptionUpgrade(current *SubscriptionPlan, target *SubscriptionPlan) bool {
+	return target.Price > current.Price
+}
+
+// ChangeSubscriptionPlan 变更套餐：升级立即生效并按剩余时间补发额度差，降级在下个周期生效
+func ChangeSubscriptionPlan(subscriptionId int, targetPlan *SubscriptionPlan) (int, error) {
+	var extra int
+	var subscription Subscription
+	var upgrade bool
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&subscription, "id = ?", subscriptionId).Error; err != nil {
+			return err
+		}
+		if subscription.Status == SubscriptionStatusCanceled {
+			return errors.New("订阅已失效")
+		}
+		if subscription.PlanId == targetPlan.Id {
+			if subscription.PendingPlanId == 0 {
+				return errors.New("已订阅该套餐")
+			}
+			// 撤销尚未生效的降级
+			subscription.PendingPlanId = 0
+			return tx.Save(&subscription).Error
+		}
+		var currentPlan SubscriptionPlan
+		if err := tx.First(&currentPlan, "id = ?", subscription.PlanId).Error; err != nil {
+			return err
+		}
+		upgrade = IsSubscriptionUpgrade(&currentPlan, targetPlan)
+		if !upgrade {
+			subscription.PendingPlanId = targetPlan.Id
+			subscription.UpdatedTime = common.GetTimestamp()
+			return tx.Save(&subscription).Error
+		}
+		user, err := lockUser(tx, subscription.UserId)
+		if err != nil {
+			return err
+		}
+		extra = proratedQuota(currentPlan.Quota, targetPlan.Quota, subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd, common.GetTimestamp())
+		if extra > 0 {
+			if err := tx.Model(&User{}).Where("id = ?", user.Id).Update("quota", gorm.Expr("quota + ?", extra)).Error; err != nil {
+				return err
+			}
+			subscription.GrantedQuota += extra
+		}
+		if err := applySubscriptionGroup(tx, &subscription, user, targetPlan); err != nil {
+			return err
+		}
+		subscription.PlanId = targetPlan.Id
+		subscription.PendingPlanId = 0
+		subscription.UpdatedTime = common.GetTimestamp()
+		return tx.Save(&subscription).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if upgrade {
+		_ = invalidateUserCache(subscription.UserId)
+		RecordLog(subscription.UserId, LogTypeTopup, fmt.Sprintf("订阅升级为套餐 %s，按剩余周期补发额度 %s", targetPlan.Name, logger.LogQuota(extra)))
+	}
+	return extra, nil
+}
+
+// SetSubscriptionCancelAtPeriodEnd 设置是否在周期结束时取消订阅
+func SetSubscriptionCancelAtPeriodEnd(subscriptionId int, cancel bool) error {
+	return DB.Model(&Subscription{}).Where("id = ?", subscriptionId).Updates(map[string]interface{}{
+		"cancel_at_period_end": cancel,
+		"updated_time":         common.GetTimestamp(),
+	}).Error
+}
+
+// SetSubscriptionStatus 同步 Stripe 的订阅状态，只在有效状态之间切换
+func SetSubscriptionStatus(subscriptionId int, status string) error {
+	return DB.Model(&Subscription{}).Where("id = ? AND status <> ?", subscriptionId, SubscriptionStatusCanceled).Updates(map[string]interface{}{
+		"status":       status,
+		"updated_time": common.GetTimestamp(),
+	}).Error
+}
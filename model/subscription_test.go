@@ -0,0 +1,84 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func getSubscriptionTestQuota(t *testing.T, userId int) int {
+	t.Helper()
+	var user User
+	if err := DB.First(&user, "id = ?", userId).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.Quota
+}
+
+func TestRenewSubscriptionIgnoresDuplicatePeriod(t *testing.T) {
+	user := createTestUser(t, "sub_renew_user", common.RoleCommonUser)
+	plan := &SubscriptionPlan{Name: "basic", Price: 10, Quota: 1000, DurationDays: 30, Enabled: true}
+	if err := DB.Create(plan).Error; err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := ActivateSubscription(user.Id, plan, SubscriptionPaymentAdmin, "", 100, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ActivateSubscription(user.Id, plan, SubscriptionPaymentAdmin, "", 100, 200); err == nil {
+		t.Fatal("expected second activation to be rejected")
+	}
+	if err := RenewSubscription(subscription.Id, 200, 300); err != nil {
+		t.Fatal(err)
+	}
+	quota := getSubscriptionTestQuota(t, user.Id)
+	// 重复的续期通知不再发放额度
+	if err := RenewSubscription(subscription.Id, 200, 300); err != nil {
+		t.Fatal(err)
+	}
+	if got := getSubscriptionTestQuota(t, user.Id); got != quota {
+		t.Fatalf("duplicate renewal granted quota again: %d -> %d", quota, got)
+	}
+
+	if err := LapseSubscription(subscription.Id, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenewSubscription(subscription.Id, 300, 400); err == nil {
+		t.Fatal("expected renewal of a lapsed subscription to fail")
+	}
+}
+
+func TestChangeSubscriptionPlan(t *testing.T) {
+	user := createTestUser(t, "sub_change_user", common.RoleCommonUser)
+	basic := &SubscriptionPlan{Name: "change_basic", Price: 10, Quota: 1000, DurationDays: 30, Enabled: true}
+	pro := &SubscriptionPlan{Name: "change_pro", Price: 20, Quota: 3000, DurationDays: 30, Enabled: true}
+	if err := DB.Create(basic).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DB.Create(pro).Error; err != nil {
+		t.Fatal(err)
+	}
+	now := common.GetTimestamp()
+	subscription, err := ActivateSubscription(user.Id, basic, SubscriptionPaymentAdmin, "", now, now+1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, err := ChangeSubscriptionPlan(subscription.Id, pro)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extra <= 0 || extra > 2000 {
+		t.Fatalf("unexpected prorated quota %d", extra)
+	}
+	// 降级在下个周期生效
+	if _, err := ChangeSubscriptionPlan(subscription.Id, basic); err != nil {
+		t.Fatal(err)
+	}
+	current, err := GetSubscriptionById(subscription.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.PlanId != pro.Id || current.PendingPlanId != basic.Id {
+		t.Fatalf("unexpected plan state: plan %d pending %d", current.PlanId, current.PendingPlanId)
+	}
+}
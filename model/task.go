@@ -95,7 +95,12 @@ func (m Properties) Value() (driver.Value, error) {
 }
 
 type TaskPrivateData struct {
-	Key string `json:"key,omitempty"`
+	Key     string `json:"key,omitempty"`
+	TokenId int    `json:"token_id,omitempty"` // 批处理任务执行时使用的令牌
+	// 提交任务时令牌所属的组织，补扣费和退款时使用组织额度池
+	OrganizationId int `json:"organization_id,omitempty"`
+	// 上游批处理使用的多密钥索引，与输入文件上传时所用的密钥一致
+	ChannelKeyIndex int `json:"channel_key_index,omitempty"`
 }
 
 func (p *TaskPrivateData) Scan(val interface{}) error {
@@ -129,6 +134,9 @@ type SyncTaskQueryParams struct {
 func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.RelayInfo) *Task {
 	properties := Properties{}
 	privateData := TaskPrivateData{}
+	if relayInfo != nil {
+		privateData.OrganizationId = relayInfo.OrganizationId
+	}
 	if relayInfo != nil && relayInfo.ChannelMeta != nil {
 		if relayInfo.ChannelMeta.ChannelType == constant.ChannelTypeGemini {
 			privateData.Key = relayInfo.ChannelMeta.ApiKey
@@ -247,6 +255,20 @@ func GetAllUnFinishSyncTasks(limit int) []*Task {
 	return tasks
 }
 
+// GetUserBatchTasks 按创建顺序倒序分页列出用户的批处理任务，after 为游标（上一页最后一个 task_id）
+func GetUserBatchTasks(userId int, after string, limit int) ([]*Task, error) {
+	var tasks []*Task
+	query := DB.Where("user_id = ? and platform = ?", userId, constant.TaskPlatformBatch)
+	if after != "" {
+		var cursor Task
+		if err := DB.Select("id").Where("user_id = ? and task_id = ?", userId, after).First(&cursor).Error; err == nil {
+			query = query.Where("id < ?", cursor.ID)
+		}
+	}
+	err := query.Order("id desc").Limit(limit).Find(&tasks).Error
+	return tasks, err
+}
+
 func GetByOnlyTaskId(taskId string) (*Task, bool, error) {
 	if taskId == "" {
 		return nil, false, nil
@@ -306,6 +328,23 @@ func (Task *Task) Update() error {
 	return err
 }
 
+// ClaimTaskSettlement 原子地将未结束的任务进度标记为 100%，之后轮询不再处理该任务
+// 返回 false 表示任务已被之前的轮询或其他节点结算
+func ClaimTaskSettlement(id int64) (bool, error) {
+	result := DB.Model(&Task{}).Where("id = ? AND progress <> ?", id, "100%").Update("progress", "100%")
+	return result.RowsAffected > 0, result.Error
+}
+
+// ClaimTaskExecution 原子地领取需要在本地执行的任务：排队中的任务，或执行节点超过 leaseSeconds 未更新的任务
+// 执行期间需要定期更新 updated_at 续期，返回 false 表示任务正由其他节点执行
+func ClaimTaskExecution(id int64, leaseSeconds int64) (bool, error) {
+	now := time.Now().Unix()
+	result := DB.Model(&Task{}).
+		Where("id = ? AND progress <> ? AND (status = ? OR updated_at < ?)", id, "100%", TaskStatusQueued, now-leaseSeconds).
+		Updates(map[string]any{"status": TaskStatusInProgress, "updated_at": now})
+	return result.RowsAffected > 0, result.Error
+}
+
 func TaskBulkUpdate(TaskIds []string, params map[string]any) error {
 	if len(TaskIds) == 0 {
 		return nil
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaimTaskSettlement(t *testing.T) {
+	task := &Task{TaskID: "batch_settle_test", Status: TaskStatusInProgress, Progress: "50%"}
+	if err := DB.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := ClaimTaskSettlement(task.ID)
+	if err != nil || !claimed {
+		t.Fatalf("expected first claim to succeed, got %v %v", claimed, err)
+	}
+	claimed, err = ClaimTaskSettlement(task.ID)
+	if err != nil || claimed {
+		t.Fatalf("expected second claim to fail, got %v %v", claimed, err)
+	}
+}
+
+func TestClaimTaskExecution(t *testing.T) {
+	task := &Task{TaskID: "batch_lease_test", Status: TaskStatusQueued, Progress: "0%"}
+	if err := DB.Create(task).Error; err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := ClaimTaskExecution(task.ID, 60)
+	if err != nil || !claimed {
+		t.Fatalf("expected queued task to be claimed, got %v %v", claimed, err)
+	}
+	// 租约未过期时其他节点不能领取
+	claimed, err = ClaimTaskExecution(task.ID, 60)
+	if err != nil || claimed {
+		t.Fatalf("expected running task not to be claimed, got %v %v", claimed, err)
+	}
+	if err := DB.Model(&Task{}).Where("id = ?", task.ID).Update("updated_at", time.Now().Unix()-120).Error; err != nil {
+		t.Fatal(err)
+	}
+	claimed, err = ClaimTaskExecution(task.ID, 60)
+	if err != nil || !claimed {
+		t.Fatalf("expected expired lease to be claimed, got %v %v", claimed, err)
+	}
+}
@@ -28,6 +28,10 @@ type Token struct {
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
 	CrossGroupRetry    bool           `json:"cross_group_retry" gorm:"default:false"` // 跨分组重试，仅auto分组有效
+	DailyQuotaLimit    int            `json:"daily_quota_limit" gorm:"default:0"`     // 每日预算，0 表示不限制
+	WeeklyQuotaLimit   int            `json:"weekly_quota_limit" gorm:"default:0"`    // 每周预算，0 表示不限制
+	MonthlyQuotaLimit  int            `json:"monthly_quota_limit" gorm:"default:0"`   // 每月预算，0 表示不限制
+	OrganizationId     int            `json:"organization_id" gorm:"index;default:0"` // 所属组织，非 0 时消耗组织额度池
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -186,7 +190,8 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry",
+		"daily_quota_limit", "weekly_quota_limit", "monthly_quota_limit").Updates(token).Error
 	return err
 }
 
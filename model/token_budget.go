@@ -0,0 +1,171 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TokenBudgetPeriodDaily   = "daily"
+	TokenBudgetPeriodWeekly  = "weekly"
+	TokenBudgetPeriodMonthly = "monthly"
+)
+
+// TokenBudget 记录令牌在当前预算周期内已消耗的额度，周期切换时自动清零
+type TokenBudget struct {
+	Id          int    `json:"id"`
+	TokenId     int    `json:"token_id" gorm:"uniqueIndex:idx_token_budget_period"`
+	Period      string `json:"period" gorm:"type:varchar(16);uniqueIndex:idx_token_budget_period"`
+	WindowStart int64  `json:"window_start" gorm:"bigint"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+}
+
+type TokenBudgetLimit struct {
+	Period string
+	Limit  int
+}
+
+type TokenBudgetUsage struct {
+	Period    string `json:"period"`
+	Limit     int    `json:"limit"`
+	Used      int    `json:"used"`
+	Remaining int    `json:"remaining"`
+	ResetAt   int64  `json:"reset_at"`
+}
+
+// GetTokenBudgetWindow 返回 now 所在预算周期的起止时间，按服务器本地时区计算，周从周一开始
+func GetTokenBudgetWindow(period string, now time.Time) (time.Time, time.Time) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case TokenBudgetPeriodWeekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case TokenBudgetPeriodMonthly:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// GetBudgetLimits 返回令牌已配置的预算周期及额度上限
+func (token *Token) GetBudgetLimits() []TokenBudgetLimit {
+	limits := make([]TokenBudgetLimit, 0, 3)
+	if token.DailyQuotaLimit > 0 {
+		limits = append(limits, TokenBudgetLimit{Period: TokenBudgetPeriodDaily, Limit: token.DailyQuotaLimit})
+	}
+	if token.WeeklyQuotaLimit > 0 {
+		limits = append(limits, TokenBudgetLimit{Period: TokenBudgetPeriodWeekly, Limit: token.WeeklyQuotaLimit})
+	}
+	if token.MonthlyQuotaLimit > 0 {
+		limits = append(limits, TokenBudgetLimit{Period: TokenBudgetPeriodMonthly, Limit: token.MonthlyQuotaLimit})
+	}
+	return limits
+}
+
+func (token *Token) HasBudgetLimits() bool {
+	return token.DailyQuotaLimit > 0 || token.WeeklyQuotaLimit > 0 || token.MonthlyQuotaLimit > 0
+}
+
+// GetTokenBudgetUsage 查询令牌各预算周期的使用情况
+func GetTokenBudgetUsage(token *Token) ([]TokenBudgetUsage, error) {
+	limits := token.GetBudgetLimits()
+	if len(limits) == 0 {
+		return []TokenBudgetUsage{}, nil
+	}
+	var budgets []TokenBudget
+	if err := DB.Where("token_id = ?", token.Id).Find(&budgets).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	usages := make([]TokenBudgetUsage, 0, len(limits))
+	for _, limit := range limits {
+		start, end := GetTokenBudgetWindow(limit.Period, now)
+		used := 0
+		for _, budget := range budgets {
+			if budget.Period == limit.Period && budget.WindowStart == start.Unix() {
+				used = budget.UsedQuota
+			}
+		}
+		usages = append(usages, TokenBudgetUsage{
+			Period:    limit.Period,
+			Limit:     limit.Limit,
+			Used:      used,
+			Remaining: max(limit.Limit-used, 0),
+			ResetAt:   end.Unix(),
+		})
+	}
+	return usages, nil
+}
+
+// ConsumeTokenBudget 在所有预算周期内扣除额度，任一周期额度不足时全部回滚并返回该周期
+// quota 为 0 时仅检查预算是否已用尽
+func ConsumeTokenBudget(token *Token, quota int) (string, error) {
+	limits := token.GetBudgetLimits()
+	if len(limits) == 0 {
+		return "", nil
+	}
+	now := time.Now()
+	exceeded := ""
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, limit := range limits {
+			start, _ := GetTokenBudgetWindow(limit.Period, now)
+			windowStart := start.Unix()
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&TokenBudget{
+				TokenId:     token.Id,
+				Period:      limit.Period,
+				WindowStart: windowStart,
+			}).Error
+			if err != nil {
+				return err
+			}
+			// used_quota 需要在 window_start 之前赋值，MySQL 会按顺序使用已更新的值
+			result := tx.Model(&TokenBudget{}).
+				Where("token_id = ? AND period = ?", token.Id, limit.Period).
+				Where("(CASE WHEN window_start = ? THEN used_quota ELSE 0 END) + ? <= ?", windowStart, max(quota, 1), limit.Limit).
+				Updates(map[string]interface{}{
+					"used_quota":   gorm.Expr("CASE WHEN window_start = ? THEN used_quota + ? ELSE ? END", windowStart, quota, quota),
+					"window_start": windowStart,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				exceeded = limit.Period
+				return gorm.ErrInvalidData
+			}
+		}
+		return nil
+	})
+	if exceeded != "" {
+		return exceeded, nil
+	}
+	return "", err
+}
+
+// AdjustTokenBudget 结算时按实际消耗调整当前周期的已用额度，delta 为负时退还，不会低于 0
+// 预扣费发生在上一个周期时，退还部分直接忽略
+func AdjustTokenBudget(tokenId int, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	now := time.Now()
+	var budgets []TokenBudget
+	if err := DB.Where("token_id = ?", tokenId).Find(&budgets).Error; err != nil {
+		return err
+	}
+	for _, budget := range budgets {
+		start, _ := GetTokenBudgetWindow(budget.Period, now)
+		windowStart := start.Unix()
+		err := DB.Model(&TokenBudget{}).Where("id = ?", budget.Id).Updates(map[string]interface{}{
+			"used_quota":   gorm.Expr("CASE WHEN window_start = ? THEN (CASE WHEN used_quota + ? < 0 THEN 0 ELSE used_quota + ? END) ELSE ? END", windowStart, delta, delta, max(delta, 0)),
+			"window_start": windowStart,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
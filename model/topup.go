@@ -55,6 +55,28 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	return topUp
 }
 
+// GetQuota 返回充值订单到账的额度，与各支付方式回调中的计算方式一致
+func (topUp *TopUp) GetQuota() int {
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	switch topUp.PaymentMethod {
+	case "stripe":
+		return int(decimal.NewFromFloat(topUp.Money).Mul(dQuotaPerUnit).IntPart())
+	case "creem":
+		// Creem 直接使用 Amount 作为充值额度
+		return int(topUp.Amount)
+	default:
+		return int(decimal.NewFromInt(topUp.Amount).Mul(dQuotaPerUnit).IntPart())
+	}
+}
+
+// GetCompletedTime 返回订单完成时间，易支付回调未记录完成时间时使用创建时间
+func (topUp *TopUp) GetCompletedTime() int64 {
+	if topUp.CompleteTime != 0 {
+		return topUp.CompleteTime
+	}
+	return topUp.CreateTime
+}
+
 func Recharge(referenceId string, customerId string) (err error) {
 	if referenceId == "" {
 		return errors.New("未提供支付单号")
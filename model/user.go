@@ -8,10 +8,12 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 
 	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
@@ -47,6 +49,7 @@ type User struct {
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
 	Remark           string         `json:"remark,omitempty" gorm:"type:varchar(255)" validate:"max=255"`
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
+	RoleId           int            `json:"role_id" gorm:"type:int;default:0;index"` // 命名角色，仅能通过角色接口修改
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -93,8 +96,8 @@ func (user *User) SetSetting(setting dto.UserSetting) {
 	user.Setting = string(settingBytes)
 }
 
-// 根据用户角色生成默认的边栏配置
-func generateDefaultSidebarConfigForRole(userRole int) string {
+// 根据用户的后台管理权限生成默认的边栏配置
+func generateDefaultSidebarConfigForRole(permissions []string) string {
 	defaultConfig := map[string]interface{}{}
 
 	// 聊天区域 - 所有用户都可以访问
@@ -121,29 +124,10 @@ func generateDefaultSidebarConfigForRole(userRole int) string {
 		"personal": true,
 	}
 
-	// 管理员区域 - 根据角色决定
-	if userRole == common.RoleAdminUser {
-		// 管理员可以访问管理员区域，但不能访问系统设置
-		defaultConfig["admin"] = map[string]interface{}{
-			"enabled":    true,
-			"channel":    true,
-			"models":     true,
-			"redemption": true,
-			"user":       true,
-			"setting":    false, // 管理员不能访问系统设置
-		}
-	} else if userRole == common.RoleRootUser {
-		// 超级管理员可以访问所有功能
-		defaultConfig["admin"] = map[string]interface{}{
-			"enabled":    true,
-			"channel":    true,
-			"models":     true,
-			"redemption": true,
-			"user":       true,
-			"setting":    true,
-		}
+	// 管理员区域 - 根据权限决定，没有任何后台权限时不包含admin区域
+	if admin := GetAdminSidebarModules(permissions); admin != nil {
+		defaultConfig["admin"] = admin
 	}
-	// 普通用户不包含admin区域
 
 	// 转换为JSON字符串
 	configBytes, err := json.Marshal(defaultConfig)
@@ -155,6 +139,24 @@ func generateDefaultSidebarConfigForRole(userRole int) string {
 	return string(configBytes)
 }
 
+// GetAdminSidebarModules 根据后台管理权限返回管理员区域的边栏配置，没有任何后台权限时返回 nil
+func GetAdminSidebarModules(permissions []string) map[string]interface{} {
+	if len(permissions) == 0 {
+		return nil
+	}
+	has := func(permission string) bool {
+		return lo.Contains(permissions, permission)
+	}
+	return map[string]interface{}{
+		"enabled":    true,
+		"channel":    has(constant.PermissionChannelRead),
+		"models":     has(constant.PermissionModelRead),
+		"redemption": has(constant.PermissionBillingWrite),
+		"user":       has(constant.PermissionUserRead),
+		"setting":    has(constant.PermissionSettingWrite) || has(constant.PermissionPricingWrite),
+	}
+}
+
 // CheckUserExistOrDeleted check if user exist or deleted, if not exist, return false, nil, if deleted or exist, return true, nil
 func CheckUserExistOrDeleted(username string, email string) (bool, error) {
 	var user User
@@ -309,6 +311,22 @@ func GetUserIdByAffCode(affCode string) (int, error) {
 	return user.Id, err
 }
 
+func GetUserIdByUsername(username string) (int, error) {
+	if username == "" {
+		return 0, errors.New("username 为空！")
+	}
+	var user User
+	err := DB.Select("id").First(&user, "username = ?", username).Error
+	return user.Id, err
+}
+
+func UpdateUserStripeCustomer(id int, customerId string) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Model(&User{}).Where("id = ?", id).Update("stripe_customer", customerId).Error
+}
+
 func DeleteUserById(id int) (err error) {
 	if id == 0 {
 		return errors.New("id 为空！")
@@ -402,7 +420,7 @@ func (user *User) Insert(inviterId int) error {
 	var createdUser User
 	if err := DB.Where("username = ?", user.Username).First(&createdUser).Error; err == nil {
 		// 生成基于角色的默认边栏配置
-		defaultSidebarConfig := generateDefaultSidebarConfigForRole(createdUser.Role)
+		defaultSidebarConfig := generateDefaultSidebarConfigForRole(GetRolePresetPermissions(createdUser.Role))
 		if defaultSidebarConfig != "" {
 			currentSetting := createdUser.GetSetting()
 			currentSetting.SidebarModules = defaultSidebarConfig
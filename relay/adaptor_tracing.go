@@ -0,0 +1,60 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common/tracing"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// GetAdaptor 根据 API 类型获取适配器，启用链路追踪时记录上游请求和响应处理的 span
+func GetAdaptor(apiType int) channel.Adaptor {
+	adaptor := getAdaptor(apiType)
+	if adaptor == nil || !constant.TracingEnabled {
+		return adaptor
+	}
+	return &tracedAdaptor{Adaptor: adaptor}
+}
+
+// tracedAdaptor 在 DoRequest 和 DoResponse 外层创建 span，其余方法直接使用原适配器，
+// 原适配器实现的可选接口通过 Unwrap 由 channel.AsAdaptor 获取
+type tracedAdaptor struct {
+	channel.Adaptor
+}
+
+func (a *tracedAdaptor) Unwrap() channel.Adaptor {
+	return a.Adaptor
+}
+
+func (a *tracedAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	_, span := tracing.Start(c.Request.Context(), "adaptor.DoRequest", tracing.ChannelAttributes(info.ChannelId, info.UpstreamModelName)...)
+	resp, err := a.Adaptor.DoRequest(c, info, requestBody)
+	if httpResp, ok := resp.(*http.Response); ok && httpResp != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(httpResp.StatusCode))
+	}
+	tracing.End(span, err)
+	return resp, err
+}
+
+func (a *tracedAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (any, *types.NewAPIError) {
+	_, span := tracing.Start(c.Request.Context(), "adaptor.DoResponse", tracing.ChannelAttributes(info.ChannelId, info.UpstreamModelName)...)
+	usage, newAPIError := a.Adaptor.DoResponse(c, resp, info)
+	// 流式响应记录首字时间，便于区分上游排队和生成耗时
+	if info.IsStream && info.HasSendResponse() {
+		span.AddEvent("first_response", trace.WithTimestamp(info.FirstResponseTime))
+	}
+	if newAPIError != nil {
+		tracing.End(span, newAPIError)
+	} else {
+		tracing.End(span, nil)
+	}
+	return usage, newAPIError
+}
@@ -0,0 +1,104 @@
+package relay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common/tracing"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type videoConverter interface {
+	ConvertToVideo() string
+}
+
+// stubAdaptor 只实现测试用到的方法，并实现一个可选接口
+type stubAdaptor struct {
+	channel.Adaptor
+	doResponseErr *types.NewAPIError
+}
+
+func (a *stubAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	return &http.Response{StatusCode: http.StatusTooManyRequests}, nil
+}
+
+func (a *stubAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (any, *types.NewAPIError) {
+	info.SetFirstResponseTime()
+	return nil, a.doResponseErr
+}
+
+func (a *stubAdaptor) ConvertToVideo() string {
+	return "video"
+}
+
+func TestTracedAdaptorForwardsOptionalInterfaces(t *testing.T) {
+	var adaptor channel.Adaptor = &tracedAdaptor{Adaptor: &stubAdaptor{}}
+	if _, ok := adaptor.(videoConverter); ok {
+		t.Fatal("tracedAdaptor itself should not implement the optional interface")
+	}
+	converter, ok := channel.AsAdaptor[videoConverter](adaptor)
+	if !ok || converter.ConvertToVideo() != "video" {
+		t.Fatal("optional interface of wrapped adaptor should be reachable")
+	}
+	if _, ok := channel.AsAdaptor[io.Closer](adaptor); ok {
+		t.Fatal("unimplemented interface should not be reported")
+	}
+}
+
+func TestTracedAdaptorRecordsSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := relaycommon.GenRelayInfoOpenAI(c, nil)
+	info.StartTime = time.Now().Add(-time.Second)
+	info.IsStream = true
+	info.ChannelMeta = &relaycommon.ChannelMeta{ChannelId: 7, UpstreamModelName: "gpt-4o"}
+
+	adaptor := &tracedAdaptor{Adaptor: &stubAdaptor{doResponseErr: types.NewError(io.ErrUnexpectedEOF, types.ErrorCodeBadResponse)}}
+	resp, err := adaptor.DoRequest(c, info, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, newAPIError := adaptor.DoResponse(c, resp.(*http.Response), info); newAPIError == nil {
+		t.Fatal("expected DoResponse error to be returned")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	requestSpan, responseSpan := spans[0], spans[1]
+	if requestSpan.Name() != "adaptor.DoRequest" || responseSpan.Name() != "adaptor.DoResponse" {
+		t.Fatalf("unexpected span names: %s %s", requestSpan.Name(), responseSpan.Name())
+	}
+	attrs := map[string]any{}
+	for _, attr := range requestSpan.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.AsInterface()
+	}
+	if attrs[string(tracing.AttrChannelId)] != int64(7) || attrs["http.response.status_code"] != int64(http.StatusTooManyRequests) {
+		t.Fatalf("unexpected DoRequest attributes: %v", attrs)
+	}
+	if responseSpan.Status().Code != codes.Error {
+		t.Fatalf("DoResponse error should mark span as error, got %v", responseSpan.Status())
+	}
+	if events := responseSpan.Events(); len(events) == 0 || events[0].Name != "first_response" {
+		t.Fatalf("expected first_response event, got %v", events)
+	}
+}
@@ -31,6 +31,28 @@ type Adaptor interface {
 	ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) (any, error)
 }
 
+// AdaptorWrapper 由包装其他适配器的适配器实现（如链路追踪），返回被包装的适配器
+type AdaptorWrapper interface {
+	Unwrap() Adaptor
+}
+
+// AsAdaptor 依次检查适配器及其包装的适配器，返回第一个实现了可选接口 T 的适配器，
+// 对适配器做可选接口断言时应使用此函数，避免被包装后断言失败
+func AsAdaptor[T any](adaptor Adaptor) (T, bool) {
+	for adaptor != nil {
+		if t, ok := adaptor.(T); ok {
+			return t, true
+		}
+		wrapper, ok := adaptor.(AdaptorWrapper)
+		if !ok {
+			break
+		}
+		adaptor = wrapper.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
 type TaskAdaptor interface {
 	Init(info *relaycommon.RelayInfo)
 
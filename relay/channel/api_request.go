@@ -11,6 +11,7 @@ import (
 	"time"
 
 	common2 "github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
@@ -265,25 +266,35 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	}
 
 	var stopPinger context.CancelFunc
+	// 使用defer确保在任何情况下都能停止ping goroutine
+	defer func() {
+		if stopPinger != nil {
+			stopPinger()
+			if common2.DebugEnabled {
+				println("SSE ping goroutine stopped by defer")
+			}
+		}
+	}()
+	generalSettings := operation_setting.GetGeneralSetting()
+	pingEnabled := info.IsStream && generalSettings.PingIntervalEnabled && !info.DisablePing
+	pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
 	if info.IsStream {
 		helper.SetEventStreamHeaders(c)
 		// 处理流式请求的 ping 保活
-		generalSettings := operation_setting.GetGeneralSetting()
-		if generalSettings.PingIntervalEnabled && !info.DisablePing {
-			pingInterval := time.Duration(generalSettings.PingIntervalSeconds) * time.Second
+		// 开启流式故障转移时，首个内容到达前不向客户端写出任何数据，以便透明切换渠道
+		if pingEnabled && !operation_setting.GetStreamFailoverSetting().Enabled {
 			stopPinger = startPingKeepAlive(c, pingInterval)
-			// 使用defer确保在任何情况下都能停止ping goroutine
-			defer func() {
-				if stopPinger != nil {
-					stopPinger()
-					if common2.DebugEnabled {
-						println("SSE ping goroutine stopped by defer")
-					}
-				}
-			}()
 		}
 	}
 
+	if info.Hedge != nil {
+		// 对冲请求中落败的一方需要立即断开上游连接
+		req = req.WithContext(c.Request.Context())
+	}
+	if info.BodyCapture != nil && req.Body != nil && req.Body != http.NoBody {
+		// 开启请求体采集时在发送的同时记录转换后发往上游的请求体
+		req.Body = info.BodyCapture.TeeRequestBody(req.Body)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.LogError(c, "do request failed: "+err.Error())
@@ -292,6 +303,17 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	metrics.RecordUpstreamResponse(info.ChannelId, resp.StatusCode)
+	if isStreamFailoverResponse(resp) {
+		// 首个内容到达前上游失败时由调用方切换渠道重试
+		if newAPIError := awaitStreamFirstContent(c, resp, info); newAPIError != nil {
+			return nil, newAPIError
+		}
+		// 首个内容已到达，不再需要切换渠道，恢复 ping 保活直到流处理接管
+		if pingEnabled && stopPinger == nil {
+			stopPinger = startPingKeepAlive(c, pingInterval)
+		}
+	}
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
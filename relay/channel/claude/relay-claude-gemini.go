@@ -0,0 +1,574 @@
+package claude
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gemini 客户端访问 Claude 渠道时直接在两种格式间转换，不经过 OpenAI 格式中转，
+// 以保留 thinking 签名、多轮工具调用和引用等信息。
+
+const (
+	// 下发给 Gemini 客户端的 thinking 签名以 thoughtSignature 形式携带，加前缀以便回传时识别
+	claudeSignaturePrefix = "claude:"
+	// redacted_thinking 没有可读内容，整块数据放在 thoughtSignature 中回传
+	claudeRedactedSignaturePrefix = "claude-redacted:"
+)
+
+func RequestGemini2ClaudeMessage(c *gin.Context, geminiRequest *dto.GeminiChatRequest, info *relaycommon.RelayInfo) (*dto.ClaudeRequest, error) {
+	generationConfig := geminiRequest.GenerationConfig
+	claudeRequest := dto.ClaudeRequest{
+		Model:         info.UpstreamModelName,
+		MaxTokens:     generationConfig.MaxOutputTokens,
+		StopSequences: generationConfig.StopSequences,
+		Temperature:   generationConfig.Temperature,
+		TopP:          generationConfig.TopP,
+		TopK:          int(generationConfig.TopK),
+		Stream:        info.IsStream,
+	}
+	if claudeRequest.MaxTokens == 0 {
+		claudeRequest.MaxTokens = uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(claudeRequest.Model))
+	}
+
+	if thinkingConfig := generationConfig.ThinkingConfig; thinkingConfig != nil {
+		budget := -1
+		if thinkingConfig.ThinkingBudget != nil {
+			budget = *thinkingConfig.ThinkingBudget
+		}
+		if budget > 0 || (budget < 0 && (thinkingConfig.IncludeThoughts || thinkingConfig.ThinkingLevel != "")) {
+			if budget < 0 || budget >= int(claudeRequest.MaxTokens) {
+				budget = int(float64(claudeRequest.MaxTokens) * model_setting.GetClaudeSettings().ThinkingAdapterBudgetTokensPercentage)
+			}
+			// BudgetTokens 必须不小于 1024 且小于 max_tokens
+			if budget < 1024 {
+				budget = 1024
+			}
+			if claudeRequest.MaxTokens <= uint(budget) {
+				claudeRequest.MaxTokens = uint(budget) + 256
+			}
+			claudeRequest.Thinking = &dto.Thinking{
+				Type:         "enabled",
+				BudgetTokens: common.GetPointer(budget),
+			}
+			// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#important-considerations-when-using-extended-thinking
+			claudeRequest.TopP = 0
+			claudeRequest.TopK = 0
+			claudeRequest.Temperature = common.GetPointer[float64](1.0)
+		}
+	}
+
+	if geminiRequest.SystemInstructions != nil {
+		systems := make([]dto.ClaudeMediaMessage, 0, len(geminiRequest.SystemInstructions.Parts))
+		for _, part := range geminiRequest.SystemInstructions.Parts {
+			if part.Text == "" {
+				continue
+			}
+			system := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+			system.SetText(part.Text)
+			systems = append(systems, system)
+		}
+		if len(systems) > 0 {
+			claudeRequest.System = systems
+		}
+	}
+
+	hasFunctions := false
+	for _, tool := range geminiRequest.GetTools() {
+		if tool.GoogleSearch != nil || tool.GoogleSearchRetrieval != nil {
+			claudeRequest.AddTool(&dto.ClaudeWebSearchTool{
+				Type: "web_search_20250305",
+				Name: "web_search",
+			})
+		}
+		if tool.FunctionDeclarations == nil {
+			continue
+		}
+		declarations, err := common.Any2Type[[]map[string]any](tool.FunctionDeclarations)
+		if err != nil {
+			return nil, fmt.Errorf("invalid function declarations: %w", err)
+		}
+		for _, declaration := range declarations {
+			claudeTool := dto.Tool{
+				InputSchema: map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{},
+				},
+			}
+			claudeTool.Name, _ = declaration["name"].(string)
+			claudeTool.Description, _ = declaration["description"].(string)
+			params, ok := declaration["parameters"].(map[string]any)
+			if !ok {
+				params, ok = declaration["parametersJsonSchema"].(map[string]any)
+			}
+			if ok && len(params) > 0 {
+				claudeTool.InputSchema = normalizeGeminiSchema(params).(map[string]any)
+			}
+			claudeRequest.AddTool(&claudeTool)
+			hasFunctions = true
+		}
+	}
+	if hasFunctions && geminiRequest.ToolConfig != nil && geminiRequest.ToolConfig.FunctionCallingConfig != nil {
+		config := geminiRequest.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(string(config.Mode)) {
+		case "AUTO":
+			claudeRequest.ToolChoice = &dto.ClaudeToolChoice{Type: "auto"}
+		case "ANY", "VALIDATED":
+			if len(config.AllowedFunctionNames) == 1 {
+				claudeRequest.ToolChoice = &dto.ClaudeToolChoice{Type: "tool", Name: config.AllowedFunctionNames[0]}
+			} else {
+				claudeRequest.ToolChoice = &dto.ClaudeToolChoice{Type: "any"}
+			}
+		case "NONE":
+			claudeRequest.ToolChoice = &dto.ClaudeToolChoice{Type: "none"}
+		}
+	}
+
+	// Gemini 的函数调用没有 id，按消息与 part 的位置生成稳定的 id，
+	// 同名函数的响应按调用顺序依次匹配
+	pendingToolUseIds := make(map[string][]string)
+	messages := make([]dto.ClaudeMessage, 0, len(geminiRequest.Contents))
+	var lastAssistantBlocks []dto.ClaudeMediaMessage
+	for i, content := range geminiRequest.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		blocks := make([]dto.ClaudeMediaMessage, 0, len(content.Parts))
+		for j, part := range content.Parts {
+			switch {
+			case part.Thought:
+				if role != "assistant" {
+					continue
+				}
+				signature := ""
+				if len(part.ThoughtSignature) > 0 {
+					_ = common.Unmarshal(part.ThoughtSignature, &signature)
+				}
+				if data, ok := strings.CutPrefix(signature, claudeRedactedSignaturePrefix); ok {
+					blocks = append(blocks, dto.ClaudeMediaMessage{Type: "redacted_thinking", Data: data})
+					continue
+				}
+				// 流式输出时思考文本与签名分散在多个 part 中，合并回一个 thinking 块
+				if n := len(blocks); n > 0 && blocks[n-1].Type == "thinking" && blocks[n-1].Signature == "" {
+					*blocks[n-1].Thinking += part.Text
+				} else {
+					blocks = append(blocks, dto.ClaudeMediaMessage{Type: "thinking", Thinking: common.GetPointer(part.Text)})
+				}
+				if signature, ok := strings.CutPrefix(signature, claudeSignaturePrefix); ok {
+					blocks[len(blocks)-1].Signature = signature
+				}
+			case part.FunctionCall != nil:
+				id := fmt.Sprintf("toolu_%d_%d", i, j)
+				name := part.FunctionCall.FunctionName
+				pendingToolUseIds[name] = append(pendingToolUseIds[name], id)
+				input := part.FunctionCall.Arguments
+				if input == nil {
+					input = map[string]any{}
+				}
+				blocks = append(blocks, dto.ClaudeMediaMessage{
+					Type:  "tool_use",
+					Id:    id,
+					Name:  name,
+					Input: input,
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				id := fmt.Sprintf("toolu_%d_%d", i, j)
+				if ids := pendingToolUseIds[name]; len(ids) > 0 {
+					id = ids[0]
+					pendingToolUseIds[name] = ids[1:]
+				}
+				result := dto.ClaudeMediaMessage{
+					Type:      "tool_result",
+					ToolUseId: id,
+				}
+				response := part.FunctionResponse.Response
+				if errValue, ok := response["error"]; ok && len(response) == 1 {
+					result.IsError = true
+					result.Content = geminiFunctionResponseText(errValue)
+				} else if value, ok := response["content"]; ok && len(response) == 1 {
+					result.Content = geminiFunctionResponseText(value)
+				} else {
+					result.Content = geminiFunctionResponseText(response)
+				}
+				blocks = append(blocks, result)
+			case part.InlineData != nil || part.FileData != nil:
+				block, err := geminiMedia2ClaudeBlock(&part)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, *block)
+			case part.Text != "":
+				block := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+				block.SetText(part.Text)
+				blocks = append(blocks, block)
+			}
+		}
+		// 没有 Claude 签名的思考内容（如来自 Gemini 渠道）无法通过校验，直接丢弃
+		filtered := blocks[:0]
+		for _, block := range blocks {
+			if block.Type == "thinking" && block.Signature == "" {
+				continue
+			}
+			filtered = append(filtered, block)
+		}
+		blocks = filtered
+		if len(blocks) == 0 {
+			continue
+		}
+		if role == "assistant" {
+			lastAssistantBlocks = blocks
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			merged, _ := messages[n-1].ParseContent()
+			messages[n-1].SetContent(append(merged, blocks...))
+		} else {
+			messages = append(messages, dto.ClaudeMessage{Role: role, Content: blocks})
+		}
+	}
+	claudeRequest.Messages = messages
+
+	// 开启思考时，最后一轮助手工具调用必须以带签名的 thinking 块开头，历史来自其它渠道时只能关闭思考
+	if claudeRequest.Thinking != nil && len(lastAssistantBlocks) > 0 {
+		hasToolUse := false
+		for _, block := range lastAssistantBlocks {
+			if block.Type == "tool_use" {
+				hasToolUse = true
+				break
+			}
+		}
+		firstType := lastAssistantBlocks[0].Type
+		if hasToolUse && firstType != "thinking" && firstType != "redacted_thinking" {
+			claudeRequest.Thinking = nil
+		}
+	}
+
+	return &claudeRequest, nil
+}
+
+// normalizeGeminiSchema Gemini 的 OpenAPI 风格 schema 使用大写类型名，转换为 JSON Schema 的小写形式
+func normalizeGeminiSchema(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(v))
+		for key, value := range v {
+			if typeName, ok := value.(string); ok && key == "type" {
+				normalized[key] = strings.ToLower(typeName)
+				continue
+			}
+			normalized[key] = normalizeGeminiSchema(value)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeGeminiSchema(item)
+		}
+		return normalized
+	default:
+		return schema
+	}
+}
+
+func geminiFunctionResponseText(value any) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	data, err := common.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func geminiMedia2ClaudeBlock(part *dto.GeminiPart) (*dto.ClaudeMediaMessage, error) {
+	mimeType := ""
+	source := &dto.ClaudeMessageSource{}
+	if part.InlineData != nil {
+		mimeType = part.InlineData.MimeType
+		source.Type = "base64"
+		source.MediaType = mimeType
+		source.Data = part.InlineData.Data
+	} else {
+		mimeType = part.FileData.MimeType
+		source.Type = "url"
+		source.Url = part.FileData.FileUri
+	}
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return &dto.ClaudeMediaMessage{Type: "image", Source: source}, nil
+	case mimeType == "application/pdf":
+		return &dto.ClaudeMediaMessage{Type: "document", Source: source}, nil
+	}
+	return nil, fmt.Errorf("mime type is not supported by Claude: '%s'", mimeType)
+}
+
+func stopReasonClaude2Gemini(reason string) string {
+	switch reason {
+	case "max_tokens":
+		return "MAX_TOKENS"
+	case "refusal":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func claudeThinking2GeminiPart(block *dto.ClaudeMediaMessage) dto.GeminiPart {
+	part := dto.GeminiPart{
+		Thought: true,
+	}
+	signature := ""
+	if block.Type == "redacted_thinking" {
+		signature = claudeRedactedSignaturePrefix + block.Data
+	} else {
+		if block.Thinking != nil {
+			part.Text = *block.Thinking
+		}
+		if block.Signature != "" {
+			signature = claudeSignaturePrefix + block.Signature
+		}
+	}
+	if signature != "" {
+		part.ThoughtSignature, _ = common.Marshal(signature)
+	}
+	return part
+}
+
+func claudeUsage2GeminiUsage(usage *dto.Usage) dto.GeminiUsageMetadata {
+	promptTokens := usage.PromptTokens + usage.PromptTokensDetails.CachedTokens + usage.PromptTokensDetails.CachedCreationTokens
+	return dto.GeminiUsageMetadata{
+		PromptTokenCount:        promptTokens,
+		CandidatesTokenCount:    usage.CompletionTokens,
+		TotalTokenCount:         promptTokens + usage.CompletionTokens,
+		CachedContentTokenCount: usage.PromptTokensDetails.CachedTokens,
+	}
+}
+
+// geminiGroundingBuilder 将 Claude 文本块上的引用汇总为 Gemini 的 groundingMetadata，
+// segment 为被引用文本块在整段输出中的字节区间
+type geminiGroundingBuilder struct {
+	chunks     []dto.GeminiGroundingChunk
+	chunkIndex map[string]int
+	supports   []dto.GeminiGroundingSupport
+}
+
+func (b *geminiGroundingBuilder) add(citations []dto.ClaudeCitation, start int, text string) {
+	if len(citations) == 0 {
+		return
+	}
+	if b.chunkIndex == nil {
+		b.chunkIndex = make(map[string]int)
+	}
+	support := dto.GeminiGroundingSupport{
+		Segment: &dto.GeminiGroundingSegment{
+			StartIndex: start,
+			EndIndex:   start + len(text),
+			Text:       text,
+		},
+	}
+	for _, citation := range citations {
+		key := citation.Url
+		if key == "" {
+			key = citation.DocumentTitle + "\x00" + citation.CitedText
+		}
+		index, ok := b.chunkIndex[key]
+		if !ok {
+			chunk := dto.GeminiGroundingChunk{}
+			if citation.Url != "" {
+				chunk.Web = &dto.GeminiGroundingSource{Uri: citation.Url, Title: citation.Title}
+			} else {
+				title := citation.DocumentTitle
+				if title == "" {
+					title = citation.Title
+				}
+				chunk.RetrievedContext = &dto.GeminiGroundingSource{Title: title, Text: citation.CitedText}
+			}
+			index = len(b.chunks)
+			b.chunks = append(b.chunks, chunk)
+			b.chunkIndex[key] = index
+		}
+		support.GroundingChunkIndices = append(support.GroundingChunkIndices, index)
+	}
+	b.supports = append(b.supports, support)
+}
+
+func (b *geminiGroundingBuilder) build() *dto.GeminiGroundingMetadata {
+	if len(b.supports) == 0 {
+		return nil
+	}
+	return &dto.GeminiGroundingMetadata{
+		GroundingChunks:   b.chunks,
+		GroundingSupports: b.supports,
+	}
+}
+
+func ResponseClaude2Gemini(claudeResponse *dto.ClaudeResponse, usage *dto.Usage) *dto.GeminiChatResponse {
+	candidate := dto.GeminiChatCandidate{
+		Content: dto.GeminiChatContent{
+			Role:  "model",
+			Parts: make([]dto.GeminiPart, 0, len(claudeResponse.Content)),
+		},
+		SafetyRatings: []dto.GeminiChatSafetyRating{},
+	}
+	grounding := &geminiGroundingBuilder{}
+	textOffset := 0
+	for i := range claudeResponse.Content {
+		block := &claudeResponse.Content[i]
+		switch block.Type {
+		case dto.ContentTypeText:
+			text := block.GetText()
+			grounding.add(block.Citations, textOffset, text)
+			textOffset += len(text)
+			candidate.Content.Parts = append(candidate.Content.Parts, dto.GeminiPart{Text: text})
+		case "thinking", "redacted_thinking":
+			candidate.Content.Parts = append(candidate.Content.Parts, claudeThinking2GeminiPart(block))
+		case "tool_use":
+			candidate.Content.Parts = append(candidate.Content.Parts, dto.GeminiPart{
+				FunctionCall: &dto.FunctionCall{
+					FunctionName: block.Name,
+					Arguments:    block.Input,
+				},
+			})
+		}
+	}
+	finishReason := stopReasonClaude2Gemini(claudeResponse.StopReason)
+	candidate.FinishReason = &finishReason
+	candidate.GroundingMetadata = grounding.build()
+	return &dto.GeminiChatResponse{
+		Candidates:    []dto.GeminiChatCandidate{candidate},
+		UsageMetadata: claudeUsage2GeminiUsage(usage),
+	}
+}
+
+type claudeGeminiStreamBlock struct {
+	blockType   string
+	name        string
+	partialJson strings.Builder
+	text        strings.Builder
+	textStart   int
+	citations   []dto.ClaudeCitation
+}
+
+// ClaudeGeminiStreamState 记录 Claude 流式事件转换为 Gemini 流式响应时跨事件的状态
+type ClaudeGeminiStreamState struct {
+	blocks     map[int]*claudeGeminiStreamBlock
+	textOffset int
+	grounding  geminiGroundingBuilder
+}
+
+func NewClaudeGeminiStreamState() *ClaudeGeminiStreamState {
+	return &ClaudeGeminiStreamState{
+		blocks: make(map[int]*claudeGeminiStreamBlock),
+	}
+}
+
+func geminiStreamChunk(parts ...dto.GeminiPart) *dto.GeminiChatResponse {
+	return &dto.GeminiChatResponse{
+		Candidates: []dto.GeminiChatCandidate{
+			{
+				Content: dto.GeminiChatContent{
+					Role:  "model",
+					Parts: parts,
+				},
+				SafetyRatings: []dto.GeminiChatSafetyRating{},
+			},
+		},
+	}
+}
+
+// StreamResponseClaude2Gemini 将单个 Claude 流式事件转换为 Gemini 流式响应，无需输出时返回 nil。
+// 工具调用参数在 content_block_stop 时整体输出，引用在 message_delta 时随 groundingMetadata 输出
+func StreamResponseClaude2Gemini(claudeResponse *dto.ClaudeResponse, state *ClaudeGeminiStreamState, usage *dto.Usage) *dto.GeminiChatResponse {
+	index := claudeResponse.GetIndex()
+	switch claudeResponse.Type {
+	case "content_block_start":
+		if claudeResponse.ContentBlock == nil {
+			return nil
+		}
+		block := &claudeGeminiStreamBlock{
+			blockType: claudeResponse.ContentBlock.Type,
+			name:      claudeResponse.ContentBlock.Name,
+			textStart: state.textOffset,
+		}
+		state.blocks[index] = block
+		switch block.blockType {
+		case dto.ContentTypeText:
+			if text := claudeResponse.ContentBlock.GetText(); text != "" {
+				block.text.WriteString(text)
+				state.textOffset += len(text)
+				return geminiStreamChunk(dto.GeminiPart{Text: text})
+			}
+		case "redacted_thinking":
+			return geminiStreamChunk(claudeThinking2GeminiPart(claudeResponse.ContentBlock))
+		}
+	case "content_block_delta":
+		block := state.blocks[index]
+		delta := claudeResponse.Delta
+		if block == nil || delta == nil {
+			return nil
+		}
+		switch delta.Type {
+		case "text_delta":
+			text := delta.GetText()
+			block.text.WriteString(text)
+			state.textOffset += len(text)
+			return geminiStreamChunk(dto.GeminiPart{Text: text})
+		case "thinking_delta":
+			return geminiStreamChunk(claudeThinking2GeminiPart(&dto.ClaudeMediaMessage{Type: "thinking", Thinking: delta.Thinking}))
+		case "signature_delta":
+			return geminiStreamChunk(claudeThinking2GeminiPart(&dto.ClaudeMediaMessage{Type: "thinking", Thinking: common.GetPointer(""), Signature: delta.Signature}))
+		case "input_json_delta":
+			if delta.PartialJson != nil {
+				block.partialJson.WriteString(*delta.PartialJson)
+			}
+		case "citations_delta":
+			if delta.Citation != nil {
+				block.citations = append(block.citations, *delta.Citation)
+			}
+		}
+	case "content_block_stop":
+		block := state.blocks[index]
+		if block == nil {
+			return nil
+		}
+		delete(state.blocks, index)
+		switch block.blockType {
+		case dto.ContentTypeText:
+			state.grounding.add(block.citations, block.textStart, block.text.String())
+		case "tool_use":
+			args := map[string]any{}
+			if block.partialJson.Len() > 0 {
+				if err := common.UnmarshalJsonStr(block.partialJson.String(), &args); err != nil {
+					args = map[string]any{"arguments": block.partialJson.String()}
+				}
+			}
+			return geminiStreamChunk(dto.GeminiPart{
+				FunctionCall: &dto.FunctionCall{
+					FunctionName: block.name,
+					Arguments:    args,
+				},
+			})
+		}
+	case "message_delta":
+		chunk := geminiStreamChunk()
+		chunk.Candidates[0].Content.Parts = make([]dto.GeminiPart, 0)
+		stopReason := ""
+		if claudeResponse.Delta != nil && claudeResponse.Delta.StopReason != nil {
+			stopReason = *claudeResponse.Delta.StopReason
+		}
+		finishReason := stopReasonClaude2Gemini(stopReason)
+		chunk.Candidates[0].FinishReason = &finishReason
+		chunk.Candidates[0].GroundingMetadata = state.grounding.build()
+		chunk.UsageMetadata = claudeUsage2GeminiUsage(usage)
+		return chunk
+	}
+	return nil
+}
@@ -584,6 +584,8 @@ type ClaudeResponseInfo struct {
 	ResponseText strings.Builder
 	Usage        *dto.Usage
 	Done         bool
+	// Gemini 格式流式输出时跨事件的转换状态
+	geminiStreamState *ClaudeGeminiStreamState
 }
 
 func FormatClaudeResponseInfo(requestMode int, claudeResponse *dto.ClaudeResponse, oaiResponse *dto.ChatCompletionsStreamResponse, claudeInfo *ClaudeResponseInfo) bool {
@@ -662,6 +664,24 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 			return nil
 		}
 
+		err = helper.ObjectData(c, response)
+		if err != nil {
+			logger.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+	} else if info.RelayFormat == types.RelayFormatGemini {
+		if !FormatClaudeResponseInfo(requestMode, &claudeResponse, nil, claudeInfo) {
+			return nil
+		}
+		if claudeResponse.Type == "message_start" {
+			info.UpstreamModelName = claudeResponse.Message.Model
+		}
+		if claudeInfo.geminiStreamState == nil {
+			claudeInfo.geminiStreamState = NewClaudeGeminiStreamState()
+		}
+		response := StreamResponseClaude2Gemini(&claudeResponse, claudeInfo.geminiStreamState, claudeInfo.Usage)
+		if response == nil {
+			return nil
+		}
 		err = helper.ObjectData(c, response)
 		if err != nil {
 			logger.LogError(c, "send_stream_response_failed: "+err.Error())
@@ -755,6 +775,12 @@ func HandleClaudeResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		}
 	case types.RelayFormatClaude:
 		responseData = data
+	case types.RelayFormatGemini:
+		geminiResponse := ResponseClaude2Gemini(&claudeResponse, claudeInfo.Usage)
+		responseData, err = common.Marshal(geminiResponse)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeBadResponseBody)
+		}
 	}
 
 	if claudeResponse.Usage.ServerToolUse != nil && claudeResponse.Usage.ServerToolUse.WebSearchRequests > 0 {
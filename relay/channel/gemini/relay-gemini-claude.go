@@ -0,0 +1,814 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Claude 客户端访问 Gemini 渠道时直接在两种格式间转换，不经过 OpenAI 格式中转，
+// 以保留 thoughtSignature、多轮工具调用和 grounding 引用等信息。
+
+// geminiSignaturePrefix 标记下发给 Claude 客户端的 Gemini thoughtSignature，
+// 客户端在后续轮次回传 thinking 块时据此识别并还原到对应的 part 上
+const geminiSignaturePrefix = "gemini:"
+
+func CovertClaude2Gemini(c *gin.Context, claudeRequest *dto.ClaudeRequest, info *relaycommon.RelayInfo) (*dto.GeminiChatRequest, error) {
+	geminiRequest := dto.GeminiChatRequest{
+		Contents: make([]dto.GeminiChatContent, 0, len(claudeRequest.Messages)),
+		GenerationConfig: dto.GeminiChatGenerationConfig{
+			Temperature:     claudeRequest.Temperature,
+			TopP:            claudeRequest.TopP,
+			TopK:            float64(claudeRequest.TopK),
+			MaxOutputTokens: claudeRequest.MaxTokens,
+			StopSequences:   claudeRequest.StopSequences,
+		},
+	}
+
+	if model_setting.IsGeminiModelSupportImagine(info.UpstreamModelName) {
+		geminiRequest.GenerationConfig.ResponseModalities = []string{
+			"TEXT",
+			"IMAGE",
+		}
+	}
+
+	if claudeRequest.Thinking != nil && claudeRequest.Thinking.Type == "enabled" {
+		geminiRequest.GenerationConfig.ThinkingConfig = &dto.GeminiThinkingConfig{
+			IncludeThoughts: true,
+		}
+		if budget := claudeRequest.Thinking.GetBudgetTokens(); budget > 0 {
+			geminiRequest.GenerationConfig.ThinkingConfig.ThinkingBudget = common.GetPointer(clampThinkingBudget(info.UpstreamModelName, budget))
+		}
+	} else {
+		ThinkingAdaptor(&geminiRequest, info)
+	}
+
+	safetySettings := make([]dto.GeminiChatSafetySettings, 0, len(SafetySettingList))
+	for _, category := range SafetySettingList {
+		safetySettings = append(safetySettings, dto.GeminiChatSafetySettings{
+			Category:  category,
+			Threshold: model_setting.GetGeminiSafetySetting(category),
+		})
+	}
+	geminiRequest.SafetySettings = safetySettings
+
+	if claudeRequest.Tools != nil {
+		tools, err := common.Any2Type[[]map[string]any](claudeRequest.Tools)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tools: %w", err)
+		}
+		functions := make([]dto.FunctionRequest, 0, len(tools))
+		googleSearch := false
+		for _, tool := range tools {
+			toolType, _ := tool["type"].(string)
+			if strings.HasPrefix(toolType, "web_search") {
+				googleSearch = true
+				continue
+			}
+			// bash、text_editor 等 Anthropic 内置工具没有对应的 Gemini 工具
+			if toolType != "" && toolType != "custom" {
+				continue
+			}
+			function := dto.FunctionRequest{}
+			function.Name, _ = tool["name"].(string)
+			function.Description, _ = tool["description"].(string)
+			if schema, ok := tool["input_schema"].(map[string]any); ok {
+				function.Parameters = schema
+				if props, hasProps := schema["properties"].(map[string]any); hasProps && len(props) == 0 {
+					function.Parameters = nil
+				}
+			}
+			function.Parameters = cleanFunctionParameters(function.Parameters)
+			functions = append(functions, function)
+		}
+		geminiTools := make([]dto.GeminiChatTool, 0, 2)
+		if googleSearch {
+			geminiTools = append(geminiTools, dto.GeminiChatTool{
+				GoogleSearch: make(map[string]string),
+			})
+		}
+		if len(functions) > 0 {
+			geminiTools = append(geminiTools, dto.GeminiChatTool{
+				FunctionDeclarations: functions,
+			})
+			geminiRequest.ToolConfig = claudeToolChoice2Gemini(claudeRequest.ToolChoice)
+		}
+		if len(geminiTools) > 0 {
+			geminiRequest.SetTools(geminiTools)
+		}
+	}
+
+	if claudeRequest.System != nil {
+		var systemParts []dto.GeminiPart
+		if claudeRequest.IsStringSystem() {
+			if system := claudeRequest.GetStringSystem(); system != "" {
+				systemParts = append(systemParts, dto.GeminiPart{Text: system})
+			}
+		} else {
+			for _, system := range claudeRequest.ParseSystem() {
+				if system.Type == dto.ContentTypeText && system.GetText() != "" {
+					systemParts = append(systemParts, dto.GeminiPart{Text: system.GetText()})
+				}
+			}
+		}
+		if len(systemParts) > 0 {
+			geminiRequest.SystemInstructions = &dto.GeminiChatContent{
+				Parts: systemParts,
+			}
+		}
+	}
+
+	attachThoughtSignature := (info.ChannelType == constant.ChannelTypeGemini ||
+		info.ChannelType == constant.ChannelTypeVertexAi) &&
+		model_setting.GetGeminiSettings().FunctionCallThoughtSignatureEnabled
+
+	toolNames := make(map[string]string)
+	for _, message := range claudeRequest.Messages {
+		content := dto.GeminiChatContent{
+			Role: "user",
+		}
+		if message.Role == "assistant" {
+			content.Role = "model"
+		}
+
+		if message.IsStringContent() {
+			if text := message.GetStringContent(); text != "" {
+				content.Parts = append(content.Parts, dto.GeminiPart{Text: text})
+			}
+		} else {
+			blocks, err := message.ParseContent()
+			if err != nil {
+				return nil, err
+			}
+			// thinking 块携带的 Gemini 签名需要还原到紧随其后的 part 上
+			var pendingSignature json.RawMessage
+			appendPart := func(part dto.GeminiPart) {
+				if len(pendingSignature) > 0 {
+					part.ThoughtSignature = pendingSignature
+					pendingSignature = nil
+				}
+				content.Parts = append(content.Parts, part)
+			}
+			for _, block := range blocks {
+				switch block.Type {
+				case dto.ContentTypeText:
+					if block.GetText() != "" {
+						appendPart(dto.GeminiPart{Text: block.GetText()})
+					}
+				case "thinking":
+					// 思考摘要无需回传，只还原由 Gemini 签发的签名，Anthropic 签名对 Gemini 无效
+					if signature, ok := strings.CutPrefix(block.Signature, geminiSignaturePrefix); ok && signature != "" {
+						pendingSignature = json.RawMessage(strconv.Quote(signature))
+					}
+				case "image", "document":
+					part, err := claudeSource2GeminiPart(c, block.Source)
+					if err != nil {
+						return nil, err
+					}
+					if part != nil {
+						appendPart(*part)
+					}
+				case "tool_use":
+					toolNames[block.Id] = block.Name
+					args := block.Input
+					if args == nil {
+						args = map[string]any{}
+					}
+					appendPart(dto.GeminiPart{
+						FunctionCall: &dto.FunctionCall{
+							FunctionName: block.Name,
+							Arguments:    args,
+						},
+					})
+				case "tool_result":
+					name, ok := toolNames[block.ToolUseId]
+					if !ok {
+						name = claudeRequest.SearchToolNameByToolCallId(block.ToolUseId)
+					}
+					response, mediaParts, err := claudeToolResult2Gemini(c, &block)
+					if err != nil {
+						return nil, err
+					}
+					appendPart(dto.GeminiPart{
+						FunctionResponse: &dto.GeminiFunctionResponse{
+							Name:     name,
+							Response: response,
+						},
+					})
+					for _, part := range mediaParts {
+						appendPart(part)
+					}
+				}
+			}
+			// 签名之后没有其它内容时挂到最后一个 part 上
+			if len(pendingSignature) > 0 && len(content.Parts) > 0 && len(content.Parts[len(content.Parts)-1].ThoughtSignature) == 0 {
+				content.Parts[len(content.Parts)-1].ThoughtSignature = pendingSignature
+			}
+		}
+
+		if len(content.Parts) == 0 {
+			continue
+		}
+		if attachThoughtSignature && content.Role == "model" {
+			attachThoughtSignatureBypass(content.Parts)
+		}
+		// Gemini 要求 user 与 model 交替出现，合并相邻的同角色消息
+		if n := len(geminiRequest.Contents); n > 0 && geminiRequest.Contents[n-1].Role == content.Role {
+			geminiRequest.Contents[n-1].Parts = append(geminiRequest.Contents[n-1].Parts, content.Parts...)
+		} else {
+			geminiRequest.Contents = append(geminiRequest.Contents, content)
+		}
+	}
+
+	return &geminiRequest, nil
+}
+
+// attachThoughtSignatureBypass 没有可还原的签名时（如历史消息来自其它渠道），
+// 与 OpenAI 转换一致，为首个函数调用或文本 part 附加跳过校验的签名
+func attachThoughtSignatureBypass(parts []dto.GeminiPart) {
+	for _, part := range parts {
+		if len(part.ThoughtSignature) > 0 {
+			return
+		}
+	}
+	for i := range parts {
+		if hasFunctionCallContent(parts[i].FunctionCall) {
+			parts[i].ThoughtSignature = json.RawMessage(strconv.Quote(thoughtSignatureBypassValue))
+			return
+		}
+	}
+	for i := range parts {
+		if parts[i].Text != "" {
+			parts[i].ThoughtSignature = json.RawMessage(strconv.Quote(thoughtSignatureBypassValue))
+			return
+		}
+	}
+}
+
+func claudeToolChoice2Gemini(toolChoice any) *dto.ToolConfig {
+	if toolChoice == nil {
+		return nil
+	}
+	choice, err := common.Any2Type[dto.ClaudeToolChoice](toolChoice)
+	if err != nil {
+		return nil
+	}
+	config := &dto.FunctionCallingConfig{}
+	switch choice.Type {
+	case "auto":
+		config.Mode = "AUTO"
+	case "any":
+		config.Mode = "ANY"
+	case "tool":
+		config.Mode = "ANY"
+		config.AllowedFunctionNames = []string{choice.Name}
+	case "none":
+		config.Mode = "NONE"
+	default:
+		return nil
+	}
+	return &dto.ToolConfig{
+		FunctionCallingConfig: config,
+	}
+}
+
+func claudeSource2GeminiPart(c *gin.Context, source *dto.ClaudeMessageSource) (*dto.GeminiPart, error) {
+	if source == nil {
+		return nil, nil
+	}
+	switch source.Type {
+	case "base64":
+		return &dto.GeminiPart{
+			InlineData: &dto.GeminiInlineData{
+				MimeType: source.MediaType,
+				Data:     common.Interface2String(source.Data),
+			},
+		}, nil
+	case "url":
+		fileData, err := service.GetFileBase64FromUrl(c, source.Url, "formatting file for Gemini")
+		if err != nil {
+			return nil, fmt.Errorf("get file base64 from url '%s' failed: %w", source.Url, err)
+		}
+		if _, ok := geminiSupportedMimeTypes[strings.ToLower(fileData.MimeType)]; !ok {
+			return nil, fmt.Errorf("mime type is not supported by Gemini: '%s', url: '%s', supported types are: %v", fileData.MimeType, source.Url, getSupportedMimeTypesList())
+		}
+		return &dto.GeminiPart{
+			InlineData: &dto.GeminiInlineData{
+				MimeType: fileData.MimeType,
+				Data:     fileData.Base64Data,
+			},
+		}, nil
+	case "text":
+		if text := common.Interface2String(source.Data); text != "" {
+			return &dto.GeminiPart{Text: text}, nil
+		}
+	}
+	return nil, nil
+}
+
+// claudeToolResult2Gemini 将 tool_result 转为 functionResponse.response，
+// 结果中的图片和文档作为独立 part 跟在 functionResponse 之后
+func claudeToolResult2Gemini(c *gin.Context, block *dto.ClaudeMediaMessage) (map[string]any, []dto.GeminiPart, error) {
+	var text string
+	var mediaParts []dto.GeminiPart
+	if block.IsStringContent() {
+		text = block.GetStringContent()
+	} else {
+		var texts []string
+		for _, item := range block.ParseMediaContent() {
+			switch item.Type {
+			case dto.ContentTypeText:
+				texts = append(texts, item.GetText())
+			case "image", "document":
+				part, err := claudeSource2GeminiPart(c, item.Source)
+				if err != nil {
+					return nil, nil, err
+				}
+				if part != nil {
+					mediaParts = append(mediaParts, *part)
+				}
+			}
+		}
+		text = strings.Join(texts, "\n")
+	}
+
+	var value any
+	if err := common.UnmarshalJsonStr(text, &value); err != nil {
+		value = text
+	}
+	if block.IsError {
+		return map[string]any{"error": value}, mediaParts, nil
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		return v, mediaParts, nil
+	case []any:
+		return map[string]any{"result": v}, mediaParts, nil
+	default:
+		return map[string]any{"content": text}, mediaParts, nil
+	}
+}
+
+func decodeThoughtSignature(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var signature string
+	if err := common.Unmarshal(raw, &signature); err != nil {
+		return ""
+	}
+	return signature
+}
+
+// geminiPartClaudeText 返回非思考、非函数调用 part 在 Claude 文本块中的表示
+func geminiPartClaudeText(part *dto.GeminiPart) string {
+	if part.InlineData != nil {
+		if strings.HasPrefix(part.InlineData.MimeType, "image") {
+			return "![image](data:" + part.InlineData.MimeType + ";base64," + part.InlineData.Data + ")"
+		}
+		return fmt.Sprintf("[media](data:%s;base64,%s)", part.InlineData.MimeType, part.InlineData.Data)
+	}
+	if part.ExecutableCode != nil {
+		return "```" + part.ExecutableCode.Language + "\n" + part.ExecutableCode.Code + "\n```\n"
+	}
+	if part.CodeExecutionResult != nil {
+		return "```output\n" + part.CodeExecutionResult.Output + "\n```\n"
+	}
+	return part.Text
+}
+
+func geminiFunctionCallInput(call *dto.FunctionCall) any {
+	if args, ok := call.Arguments.(map[string]interface{}); ok {
+		return unescapeMapOrSlice(args)
+	}
+	if call.Arguments == nil {
+		return map[string]any{}
+	}
+	return call.Arguments
+}
+
+func newClaudeToolUseId() string {
+	return fmt.Sprintf("toolu_%s", common.GetUUID())
+}
+
+func stopReasonGemini2Claude(reason string) string {
+	switch reason {
+	case "STOP":
+		return "end_turn"
+	case "MAX_TOKENS":
+		return "max_tokens"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// geminiGrounding2ClaudeCitations 将 grounding 元数据转为 web_search_result_location 引用
+func geminiGrounding2ClaudeCitations(metadata *dto.GeminiGroundingMetadata) []dto.ClaudeCitation {
+	if metadata == nil {
+		return nil
+	}
+	toCitation := func(chunk dto.GeminiGroundingChunk, citedText string) *dto.ClaudeCitation {
+		source := chunk.Web
+		if source == nil {
+			source = chunk.RetrievedContext
+		}
+		if source == nil {
+			return nil
+		}
+		if citedText == "" {
+			citedText = source.Text
+		}
+		return &dto.ClaudeCitation{
+			Type:      "web_search_result_location",
+			Url:       source.Uri,
+			Title:     source.Title,
+			CitedText: citedText,
+		}
+	}
+	var citations []dto.ClaudeCitation
+	if len(metadata.GroundingSupports) == 0 {
+		for _, chunk := range metadata.GroundingChunks {
+			if citation := toCitation(chunk, ""); citation != nil {
+				citations = append(citations, *citation)
+			}
+		}
+		return citations
+	}
+	for _, support := range metadata.GroundingSupports {
+		citedText := ""
+		if support.Segment != nil {
+			citedText = support.Segment.Text
+		}
+		for _, index := range support.GroundingChunkIndices {
+			if index < 0 || index >= len(metadata.GroundingChunks) {
+				continue
+			}
+			if citation := toCitation(metadata.GroundingChunks[index], citedText); citation != nil {
+				citations = append(citations, *citation)
+			}
+		}
+	}
+	return citations
+}
+
+// geminiUsage2ClaudeUsage Gemini 的 promptTokenCount 包含缓存命中部分，Claude 的 input_tokens 不包含
+func geminiUsage2ClaudeUsage(usage *dto.Usage, cachedTokens int) *dto.ClaudeUsage {
+	return &dto.ClaudeUsage{
+		InputTokens:          usage.PromptTokens - cachedTokens,
+		CacheReadInputTokens: cachedTokens,
+		OutputTokens:         usage.CompletionTokens,
+	}
+}
+
+func responseGeminiChat2Claude(c *gin.Context, response *dto.GeminiChatResponse, info *relaycommon.RelayInfo) *dto.ClaudeResponse {
+	claudeResponse := &dto.ClaudeResponse{
+		Id:         helper.GetResponseID(c),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      info.UpstreamModelName,
+		Content:    make([]dto.ClaudeMediaMessage, 0),
+		StopReason: "end_turn",
+	}
+	if len(response.Candidates) == 0 {
+		return claudeResponse
+	}
+	candidate := response.Candidates[0]
+	if candidate.FinishReason != nil {
+		claudeResponse.StopReason = stopReasonGemini2Claude(*candidate.FinishReason)
+	}
+
+	contents := claudeResponse.Content
+	lastBlock := func(blockType string) *dto.ClaudeMediaMessage {
+		if n := len(contents); n > 0 && contents[n-1].Type == blockType {
+			return &contents[n-1]
+		}
+		return nil
+	}
+	hasToolUse := false
+	for _, part := range candidate.Content.Parts {
+		signature := decodeThoughtSignature(part.ThoughtSignature)
+		if signature != "" {
+			signature = geminiSignaturePrefix + signature
+		}
+		if part.Thought {
+			if last := lastBlock("thinking"); last != nil && last.Signature == "" {
+				*last.Thinking += part.Text
+				last.Signature = signature
+			} else {
+				contents = append(contents, dto.ClaudeMediaMessage{
+					Type:      "thinking",
+					Thinking:  common.GetPointer(part.Text),
+					Signature: signature,
+				})
+			}
+			continue
+		}
+		// 非思考 part 上的签名放到它前面的 thinking 块中，回传时还原到该 part
+		if signature != "" {
+			if last := lastBlock("thinking"); last != nil && last.Signature == "" {
+				last.Signature = signature
+			} else {
+				contents = append(contents, dto.ClaudeMediaMessage{
+					Type:      "thinking",
+					Thinking:  common.GetPointer(""),
+					Signature: signature,
+				})
+			}
+		}
+		if part.FunctionCall != nil {
+			hasToolUse = true
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    newClaudeToolUseId(),
+				Name:  part.FunctionCall.FunctionName,
+				Input: geminiFunctionCallInput(part.FunctionCall),
+			})
+			continue
+		}
+		text := geminiPartClaudeText(&part)
+		if text == "" {
+			continue
+		}
+		if last := lastBlock(dto.ContentTypeText); last != nil {
+			last.SetText(last.GetText() + text)
+		} else {
+			block := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+			block.SetText(text)
+			contents = append(contents, block)
+		}
+	}
+	if citations := geminiGrounding2ClaudeCitations(candidate.GroundingMetadata); len(citations) > 0 {
+		for i := len(contents) - 1; i >= 0; i-- {
+			if contents[i].Type == dto.ContentTypeText {
+				contents[i].Citations = citations
+				break
+			}
+		}
+	}
+	if hasToolUse {
+		claudeResponse.StopReason = "tool_use"
+	}
+	claudeResponse.Content = contents
+	return claudeResponse
+}
+
+// claudeStreamBlocks 维护转换过程中当前打开的 Claude 内容块
+type claudeStreamBlocks struct {
+	convertInfo *relaycommon.ClaudeConvertInfo
+	responses   []*dto.ClaudeResponse
+}
+
+func (b *claudeStreamBlocks) current() string {
+	return b.convertInfo.LastMessagesType
+}
+
+func (b *claudeStreamBlocks) start(blockType string, block *dto.ClaudeMediaMessage) {
+	b.stop()
+	resp := &dto.ClaudeResponse{
+		Type:         "content_block_start",
+		ContentBlock: block,
+	}
+	resp.SetIndex(b.convertInfo.Index)
+	b.responses = append(b.responses, resp)
+	b.convertInfo.LastMessagesType = blockType
+}
+
+func (b *claudeStreamBlocks) delta(delta *dto.ClaudeMediaMessage) {
+	resp := &dto.ClaudeResponse{
+		Type:  "content_block_delta",
+		Delta: delta,
+	}
+	resp.SetIndex(b.convertInfo.Index)
+	b.responses = append(b.responses, resp)
+}
+
+func (b *claudeStreamBlocks) stop() {
+	if b.convertInfo.LastMessagesType == relaycommon.LastMessageTypeNone {
+		return
+	}
+	resp := &dto.ClaudeResponse{
+		Type: "content_block_stop",
+	}
+	resp.SetIndex(b.convertInfo.Index)
+	b.responses = append(b.responses, resp)
+	b.convertInfo.Index++
+	b.convertInfo.LastMessagesType = relaycommon.LastMessageTypeNone
+}
+
+func (b *claudeStreamBlocks) signature(signature string) {
+	if b.current() != relaycommon.LastMessageTypeThinking {
+		b.start(relaycommon.LastMessageTypeThinking, &dto.ClaudeMediaMessage{
+			Type:     "thinking",
+			Thinking: common.GetPointer(""),
+		})
+	}
+	b.delta(&dto.ClaudeMediaMessage{
+		Type:      "signature_delta",
+		Signature: signature,
+	})
+	b.stop()
+}
+
+func claudeMessageStart(id string, info *relaycommon.RelayInfo) *dto.ClaudeResponse {
+	msg := &dto.ClaudeMediaMessage{
+		Id:    id,
+		Model: info.UpstreamModelName,
+		Type:  "message",
+		Role:  "assistant",
+		Usage: &dto.ClaudeUsage{
+			InputTokens: info.GetEstimatePromptTokens(),
+		},
+	}
+	msg.SetContent(make([]any, 0))
+	return &dto.ClaudeResponse{
+		Type:    "message_start",
+		Message: msg,
+	}
+}
+
+func streamResponseGeminiChat2Claude(id string, geminiResponse *dto.GeminiChatResponse, info *relaycommon.RelayInfo) []*dto.ClaudeResponse {
+	blocks := &claudeStreamBlocks{convertInfo: info.ClaudeConvertInfo}
+	if info.SendResponseCount == 1 {
+		blocks.responses = append(blocks.responses, claudeMessageStart(id, info))
+	}
+	if geminiResponse.UsageMetadata.CachedContentTokenCount > 0 {
+		info.ClaudeConvertInfo.Usage = &dto.Usage{}
+		info.ClaudeConvertInfo.Usage.PromptTokensDetails.CachedTokens = geminiResponse.UsageMetadata.CachedContentTokenCount
+	}
+	if len(geminiResponse.Candidates) == 0 {
+		return blocks.responses
+	}
+	candidate := geminiResponse.Candidates[0]
+	for _, part := range candidate.Content.Parts {
+		signature := decodeThoughtSignature(part.ThoughtSignature)
+		if part.Thought {
+			if blocks.current() != relaycommon.LastMessageTypeThinking {
+				blocks.start(relaycommon.LastMessageTypeThinking, &dto.ClaudeMediaMessage{
+					Type:     "thinking",
+					Thinking: common.GetPointer(""),
+				})
+			}
+			if part.Text != "" {
+				blocks.delta(&dto.ClaudeMediaMessage{
+					Type:     "thinking_delta",
+					Thinking: common.GetPointer(part.Text),
+				})
+			}
+			if signature != "" {
+				blocks.signature(geminiSignaturePrefix + signature)
+			}
+			continue
+		}
+		if signature != "" {
+			blocks.signature(geminiSignaturePrefix + signature)
+		}
+		if part.FunctionCall != nil {
+			blocks.start(relaycommon.LastMessageTypeTools, &dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    newClaudeToolUseId(),
+				Name:  part.FunctionCall.FunctionName,
+				Input: map[string]any{},
+			})
+			args, _ := common.Marshal(geminiFunctionCallInput(part.FunctionCall))
+			blocks.delta(&dto.ClaudeMediaMessage{
+				Type:        "input_json_delta",
+				PartialJson: common.GetPointer(string(args)),
+			})
+			blocks.stop()
+			info.ClaudeConvertInfo.FinishReason = "tool_use"
+			continue
+		}
+		text := geminiPartClaudeText(&part)
+		if text == "" {
+			continue
+		}
+		if blocks.current() != relaycommon.LastMessageTypeText {
+			blocks.start(relaycommon.LastMessageTypeText, &dto.ClaudeMediaMessage{
+				Type: dto.ContentTypeText,
+				Text: common.GetPointer(""),
+			})
+		}
+		blocks.delta(&dto.ClaudeMediaMessage{
+			Type: "text_delta",
+			Text: common.GetPointer(text),
+		})
+	}
+	if citations := geminiGrounding2ClaudeCitations(candidate.GroundingMetadata); len(citations) > 0 {
+		if blocks.current() != relaycommon.LastMessageTypeText {
+			blocks.start(relaycommon.LastMessageTypeText, &dto.ClaudeMediaMessage{
+				Type: dto.ContentTypeText,
+				Text: common.GetPointer(""),
+			})
+		}
+		for i := range citations {
+			blocks.delta(&dto.ClaudeMediaMessage{
+				Type:     "citations_delta",
+				Citation: &citations[i],
+			})
+		}
+	}
+	if candidate.FinishReason != nil && info.ClaudeConvertInfo.FinishReason != "tool_use" {
+		info.ClaudeConvertInfo.FinishReason = stopReasonGemini2Claude(*candidate.FinishReason)
+	}
+	return blocks.responses
+}
+
+func finishGeminiClaudeStream(id string, info *relaycommon.RelayInfo, usage *dto.Usage) []*dto.ClaudeResponse {
+	blocks := &claudeStreamBlocks{convertInfo: info.ClaudeConvertInfo}
+	if info.SendResponseCount == 0 {
+		blocks.responses = append(blocks.responses, claudeMessageStart(id, info))
+	}
+	blocks.stop()
+	stopReason := info.ClaudeConvertInfo.FinishReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	cachedTokens := 0
+	if info.ClaudeConvertInfo.Usage != nil {
+		cachedTokens = info.ClaudeConvertInfo.Usage.PromptTokensDetails.CachedTokens
+	}
+	blocks.responses = append(blocks.responses, &dto.ClaudeResponse{
+		Type:  "message_delta",
+		Usage: geminiUsage2ClaudeUsage(usage, cachedTokens),
+		Delta: &dto.ClaudeMediaMessage{
+			StopReason: common.GetPointer(stopReason),
+		},
+	}, &dto.ClaudeResponse{
+		Type: "message_stop",
+	})
+	info.ClaudeConvertInfo.Done = true
+	return blocks.responses
+}
+
+func geminiClaudeStreamHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	id := helper.GetResponseID(c)
+	usage, err := geminiStreamHandler(c, info, resp, func(data string, geminiResponse *dto.GeminiChatResponse) bool {
+		info.SendResponseCount++
+		for _, claudeResponse := range streamResponseGeminiChat2Claude(id, geminiResponse, info) {
+			_ = helper.ClaudeData(c, *claudeResponse)
+		}
+		return true
+	})
+	if err != nil {
+		return usage, err
+	}
+	for _, claudeResponse := range finishGeminiClaudeStream(id, info, usage) {
+		_ = helper.ClaudeData(c, *claudeResponse)
+	}
+	return usage, nil
+}
+
+func geminiClaudeHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Response) (*dto.Usage, *types.NewAPIError) {
+	defer service.CloseResponseBodyGracefully(resp)
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+	if common.DebugEnabled {
+		println(string(responseBody))
+	}
+	var geminiResponse dto.GeminiChatResponse
+	if err = common.Unmarshal(responseBody, &geminiResponse); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+
+	usage := dto.Usage{
+		PromptTokens: geminiResponse.UsageMetadata.PromptTokenCount,
+		TotalTokens:  geminiResponse.UsageMetadata.TotalTokenCount,
+	}
+	usage.CompletionTokens = usage.TotalTokens - usage.PromptTokens
+	usage.CompletionTokenDetails.ReasoningTokens = geminiResponse.UsageMetadata.ThoughtsTokenCount
+	for _, detail := range geminiResponse.UsageMetadata.PromptTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.PromptTokensDetails.AudioTokens = detail.TokenCount
+		} else if detail.Modality == "TEXT" {
+			usage.PromptTokensDetails.TextTokens = detail.TokenCount
+		}
+	}
+
+	claudeResponse := responseGeminiChat2Claude(c, &geminiResponse, info)
+	claudeResponse.Usage = geminiUsage2ClaudeUsage(&usage, geminiResponse.UsageMetadata.CachedContentTokenCount)
+	responseBody, err = common.Marshal(claudeResponse)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeBadResponseBody)
+	}
+	service.IOCopyBytesGracefully(c, resp, responseBody)
+	return &usage, nil
+}
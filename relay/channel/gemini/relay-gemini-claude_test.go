@@ -0,0 +1,173 @@
+package gemini
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel/claude"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func newClaudeGeminiTestContext() (*gin.Context, *relaycommon.RelayInfo) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gemini-2.0-flash"}}
+	return c, info
+}
+
+// claudeGeminiRoundTrip 将 Claude 请求转换为 Gemini 请求再转换回来，返回转换结果的 JSON
+func claudeGeminiRoundTrip(t *testing.T, request string) (string, string) {
+	t.Helper()
+	c, info := newClaudeGeminiTestContext()
+	var claudeRequest dto.ClaudeRequest
+	if err := common.UnmarshalJsonStr(request, &claudeRequest); err != nil {
+		t.Fatal(err)
+	}
+	geminiRequest, err := CovertClaude2Gemini(c, &claudeRequest, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := claude.RequestGemini2ClaudeMessage(c, geminiRequest, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	geminiData, _ := common.Marshal(geminiRequest)
+	claudeData, _ := common.Marshal(converted)
+	return string(geminiData), string(claudeData)
+}
+
+func TestClaudeGeminiRequestRoundTrip(t *testing.T) {
+	geminiJson, claudeJson := claudeGeminiRoundTrip(t, `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "You are a weather assistant."}],
+		"tools": [{
+			"name": "get_weather",
+			"description": "Get the weather of a city",
+			"input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+		}],
+		"tool_choice": {"type": "tool", "name": "get_weather"},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is the weather in this photo?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "toolu_01", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_01", "content": "sunny"}
+			]}
+		]
+	}`)
+
+	// Gemini 请求
+	if system := gjson.Get(geminiJson, "systemInstruction.parts.0.text").String(); system != "You are a weather assistant." {
+		t.Fatalf("system prompt should be converted, got %s", geminiJson)
+	}
+	if gjson.Get(geminiJson, "tools.0.functionDeclarations.0.name").String() != "get_weather" ||
+		gjson.Get(geminiJson, "toolConfig.functionCallingConfig.allowedFunctionNames.0").String() != "get_weather" {
+		t.Fatalf("tools should be converted, got %s", geminiJson)
+	}
+	if gjson.Get(geminiJson, "contents.0.parts.1.inlineData.mimeType").String() != "image/png" ||
+		gjson.Get(geminiJson, "contents.0.parts.1.inlineData.data").String() != "iVBORw0KGgo=" {
+		t.Fatalf("image should be converted to inline data, got %s", geminiJson)
+	}
+	if gjson.Get(geminiJson, "contents.2.parts.0.functionResponse.name").String() != "get_weather" {
+		t.Fatalf("tool result should be matched to the function name, got %s", geminiJson)
+	}
+
+	// 转换回 Claude 请求
+	if gjson.Get(claudeJson, "system.#").Int() != 1 || gjson.Get(claudeJson, "system.0.text").String() != "You are a weather assistant." {
+		t.Fatalf("system prompt should survive the round trip, got %s", claudeJson)
+	}
+	if gjson.Get(claudeJson, "tools.0.name").String() != "get_weather" ||
+		gjson.Get(claudeJson, "tools.0.description").String() != "Get the weather of a city" ||
+		gjson.Get(claudeJson, "tools.0.input_schema.properties.city.type").String() != "string" ||
+		gjson.Get(claudeJson, "tools.0.input_schema.required.0").String() != "city" {
+		t.Fatalf("tools should survive the round trip, got %s", claudeJson)
+	}
+	if gjson.Get(claudeJson, "tool_choice.type").String() != "tool" || gjson.Get(claudeJson, "tool_choice.name").String() != "get_weather" {
+		t.Fatalf("tool choice should survive the round trip, got %s", claudeJson)
+	}
+	if gjson.Get(claudeJson, "messages.#").Int() != 3 {
+		t.Fatalf("expected three messages, got %s", claudeJson)
+	}
+	image := gjson.Get(claudeJson, "messages.0.content.1")
+	if image.Get("type").String() != "image" || image.Get("source.type").String() != "base64" ||
+		image.Get("source.media_type").String() != "image/png" || image.Get("source.data").String() != "iVBORw0KGgo=" {
+		t.Fatalf("image should survive the round trip, got %s", image.Raw)
+	}
+	toolUse := gjson.Get(claudeJson, "messages.1.content.1")
+	if toolUse.Get("type").String() != "tool_use" || toolUse.Get("name").String() != "get_weather" ||
+		toolUse.Get("input.city").String() != "Paris" || toolUse.Get("id").String() == "" {
+		t.Fatalf("tool use should survive the round trip, got %s", toolUse.Raw)
+	}
+	toolResult := gjson.Get(claudeJson, "messages.2.content.0")
+	if toolResult.Get("type").String() != "tool_result" || toolResult.Get("tool_use_id").String() != toolUse.Get("id").String() ||
+		toolResult.Get("content").String() != "sunny" {
+		t.Fatalf("tool result should reference the tool use, got %s", toolResult.Raw)
+	}
+}
+
+func TestClaudeGeminiStringSystemRoundTrip(t *testing.T) {
+	geminiJson, claudeJson := claudeGeminiRoundTrip(t, `{
+		"model": "claude-sonnet-4",
+		"max_tokens": 1024,
+		"system": "Answer briefly.",
+		"messages": [{"role": "user", "content": "hello"}]
+	}`)
+	if gjson.Get(geminiJson, "systemInstruction.parts.0.text").String() != "Answer briefly." {
+		t.Fatalf("string system prompt should be converted, got %s", geminiJson)
+	}
+	if gjson.Get(claudeJson, "system.0.text").String() != "Answer briefly." ||
+		gjson.Get(claudeJson, "messages.0.content.0.text").String() != "hello" {
+		t.Fatalf("string system prompt should survive the round trip, got %s", claudeJson)
+	}
+	if gjson.Get(claudeJson, "tools").Exists() {
+		t.Fatalf("no tools should be added, got %s", claudeJson)
+	}
+}
+
+func TestGeminiClaudeResponseRoundTrip(t *testing.T) {
+	c, info := newClaudeGeminiTestContext()
+	var response dto.GeminiChatResponse
+	err := common.UnmarshalJsonStr(`{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"text": "Checking the weather."},
+				{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}
+			]},
+			"finishReason": "STOP"
+		}]
+	}`, &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claudeResponse := responseGeminiChat2Claude(c, &response, info)
+	if claudeResponse.StopReason != "tool_use" || len(claudeResponse.Content) != 2 {
+		t.Fatalf("function call should be converted to tool use, got %+v", claudeResponse)
+	}
+	if claudeResponse.Content[0].GetText() != "Checking the weather." || claudeResponse.Content[1].Name != "get_weather" {
+		t.Fatalf("unexpected content, got %+v", claudeResponse.Content)
+	}
+
+	converted := claude.ResponseClaude2Gemini(claudeResponse, &dto.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})
+	data, _ := common.Marshal(converted)
+	geminiJson := string(data)
+	if gjson.Get(geminiJson, "candidates.0.content.parts.0.text").String() != "Checking the weather." ||
+		gjson.Get(geminiJson, "candidates.0.content.parts.1.functionCall.name").String() != "get_weather" ||
+		gjson.Get(geminiJson, "candidates.0.content.parts.1.functionCall.args.city").String() != "Paris" ||
+		gjson.Get(geminiJson, "candidates.0.finishReason").String() != "STOP" {
+		t.Fatalf("response should survive the round trip, got %s", geminiJson)
+	}
+}
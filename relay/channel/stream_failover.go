@@ -0,0 +1,177 @@
+package channel
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+type streamDataKind int
+
+const (
+	streamDataPending streamDataKind = iota // 尚未产生内容的前置事件，如 message_start、ping
+	streamDataContent                       // 内容增量或结束事件，之后的数据可以转发给客户端
+	streamDataError                         // 上游错误事件
+)
+
+var errStreamEndedBeforeContent = errors.New("upstream stream ended before any content")
+
+// streamPreambleTypes 各格式中不携带内容的前置事件类型
+var streamPreambleTypes = map[string]bool{
+	"message_start":               true,
+	"content_block_start":         true,
+	"ping":                        true,
+	"response.created":            true,
+	"response.queued":             true,
+	"response.in_progress":        true,
+	"response.output_item.added":  true,
+	"response.content_part.added": true,
+}
+
+// streamBody 将已暂存的数据拼接到剩余的上游响应体之前
+type streamBody struct {
+	io.Reader
+	io.Closer
+}
+
+// isStreamFailoverResponse 判断是否需要在转发前等待首个内容
+func isStreamFailoverResponse(resp *http.Response) bool {
+	if !operation_setting.GetStreamFailoverSetting().Enabled {
+		return false
+	}
+	return resp.StatusCode == http.StatusOK && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// awaitStreamFirstContent 在转发给客户端之前读取上游流，直到出现首个内容增量。
+// 首个内容之前上游出错、超时或返回错误事件时返回可重试的错误，由调用方切换渠道；
+// 已读取的数据会重新拼接到响应体前，后续的流处理不受影响
+func awaitStreamFirstContent(c *gin.Context, resp *http.Response, info *common.RelayInfo) *types.NewAPIError {
+	setting := operation_setting.GetStreamFailoverSetting()
+	timeout := setting.GetFirstTokenTimeout()
+	reader := bufio.NewReader(resp.Body)
+	buffered := &bytes.Buffer{}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- readUntilFirstContent(reader, buffered, setting.GetMaxBufferBytes())
+	}()
+
+	var err error
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-result:
+	case <-timer.C:
+		// 关闭响应体使读取协程退出
+		_ = resp.Body.Close()
+		<-result
+		logger.LogWarn(c, fmt.Sprintf("channel #%d no content within %s, failover", info.ChannelId, timeout))
+		return types.NewOpenAIError(fmt.Errorf("upstream sent no content within %s", timeout), types.ErrorCodeFirstTokenTimeout, http.StatusBadGateway)
+	case <-c.Request.Context().Done():
+		_ = resp.Body.Close()
+		<-result
+		return types.NewError(c.Request.Context().Err(), types.ErrorCodeDoRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	if err != nil {
+		_ = resp.Body.Close()
+		logger.LogWarn(c, fmt.Sprintf("channel #%d stream failed before first content, failover: %s", info.ChannelId, err.Error()))
+		code := types.ErrorCodeBadResponse
+		if errors.Is(err, errStreamEndedBeforeContent) {
+			code = types.ErrorCodeEmptyResponse
+		}
+		return types.NewOpenAIError(err, code, http.StatusBadGateway)
+	}
+	resp.Body = &streamBody{Reader: io.MultiReader(buffered, reader), Closer: resp.Body}
+	return nil
+}
+
+// readUntilFirstContent 逐行读取 SSE 数据并暂存，遇到内容增量、超过暂存上限时返回 nil
+func readUntilFirstContent(reader *bufio.Reader, buffered *bytes.Buffer, maxBytes int) error {
+	for {
+		line, err := reader.ReadBytes('\n')
+		buffered.Write(line)
+		if data, ok := strings.CutPrefix(strings.TrimSpace(string(line)), "data:"); ok {
+			data = strings.TrimSpace(data)
+			if strings.HasPrefix(data, "[DONE]") {
+				return errStreamEndedBeforeContent
+			}
+			switch classifyStreamData(data) {
+			case streamDataContent:
+				return nil
+			case streamDataError:
+				return fmt.Errorf("upstream error event: %s", data)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return errStreamEndedBeforeContent
+			}
+			return err
+		}
+		if buffered.Len() >= maxBytes {
+			return nil
+		}
+	}
+}
+
+// classifyStreamData 判断一条 SSE 数据是前置事件、内容还是错误，无法识别的格式视为内容以免误判
+func classifyStreamData(data string) streamDataKind {
+	if data == "" {
+		return streamDataPending
+	}
+	if !gjson.Valid(data) {
+		return streamDataContent
+	}
+	result := gjson.Parse(data)
+	if errorResult := result.Get("error"); errorResult.Exists() && errorResult.Type != gjson.Null {
+		return streamDataError
+	}
+	eventType := result.Get("type").String()
+	switch {
+	case eventType == "error" || eventType == "response.failed":
+		return streamDataError
+	case streamPreambleTypes[eventType]:
+		return streamDataPending
+	}
+	// OpenAI Chat Completions 格式
+	if choices := result.Get("choices"); choices.Exists() {
+		for _, choice := range choices.Array() {
+			// 角色和推理增量同样视为上游已开始输出
+			if choice.Get("delta.content").String() != "" ||
+				choice.Get("delta.role").String() != "" ||
+				choice.Get("delta.reasoning_content").String() != "" ||
+				choice.Get("delta.reasoning").String() != "" ||
+				choice.Get("delta.reasoning_details").Exists() ||
+				choice.Get("delta.thinking").String() != "" ||
+				choice.Get("delta.tool_calls").Exists() ||
+				choice.Get("text").String() != "" ||
+				choice.Get("finish_reason").String() != "" {
+				return streamDataContent
+			}
+		}
+		return streamDataPending
+	}
+	// Gemini 格式
+	if candidates := result.Get("candidates"); candidates.Exists() {
+		for _, candidate := range candidates.Array() {
+			if len(candidate.Get("content.parts").Array()) > 0 || candidate.Get("finishReason").String() != "" {
+				return streamDataContent
+			}
+		}
+		return streamDataPending
+	}
+	return streamDataContent
+}
@@ -0,0 +1,95 @@
+package channel
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClassifyStreamData(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		want streamDataKind
+	}{
+		{"empty", ``, streamDataPending},
+		{"role delta", `{"choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}`, streamDataContent},
+		{"reasoning content delta", `{"choices":[{"index":0,"delta":{"reasoning_content":"thinking"}}]}`, streamDataContent},
+		{"reasoning details delta", `{"choices":[{"index":0,"delta":{"reasoning_details":[{"type":"reasoning.text","text":"hm"}]}}]}`, streamDataContent},
+		{"empty delta", `{"choices":[{"index":0,"delta":{}}]}`, streamDataPending},
+		{"claude message start", `{"type":"message_start","message":{"role":"assistant"}}`, streamDataPending},
+		{"claude thinking delta", `{"type":"content_block_delta","delta":{"type":"thinking_delta","thinking":"hm"}}`, streamDataContent},
+		{"responses reasoning delta", `{"type":"response.reasoning_summary_text.delta","delta":"hm"}`, streamDataContent},
+		{"gemini empty candidate", `{"candidates":[{"content":{"parts":[]}}]}`, streamDataPending},
+		{"error", `{"error":{"message":"overloaded"}}`, streamDataError},
+		{"responses failed", `{"type":"response.failed"}`, streamDataError},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyStreamData(tc.data); got != tc.want {
+				t.Fatalf("classifyStreamData(%s) = %d, want %d", tc.data, got, tc.want)
+			}
+		})
+	}
+}
+
+func newFailoverTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c, recorder
+}
+
+func newFailoverTestResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestAwaitStreamFirstContent(t *testing.T) {
+	info := &common.RelayInfo{ChannelMeta: &common.ChannelMeta{ChannelId: 1}}
+
+	t.Run("role delta keeps stream", func(t *testing.T) {
+		body := "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\"}}]}\n\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n"
+		c, recorder := newFailoverTestContext()
+		resp := newFailoverTestResponse(body)
+		if newAPIError := awaitStreamFirstContent(c, resp, info); newAPIError != nil {
+			t.Fatal(newAPIError)
+		}
+		replayed, _ := io.ReadAll(resp.Body)
+		if string(replayed) != body {
+			t.Fatalf("buffered data should be replayed, got %q", replayed)
+		}
+		if recorder.Body.Len() != 0 {
+			t.Fatalf("nothing should be written to the client while waiting, got %q", recorder.Body.String())
+		}
+	})
+
+	t.Run("error before content", func(t *testing.T) {
+		c, _ := newFailoverTestContext()
+		resp := newFailoverTestResponse("event: ping\ndata: {\"type\":\"ping\"}\n\ndata: {\"error\":{\"message\":\"overloaded\"}}\n\n")
+		newAPIError := awaitStreamFirstContent(c, resp, info)
+		if newAPIError == nil || newAPIError.GetErrorCode() != types.ErrorCodeBadResponse || newAPIError.StatusCode != http.StatusBadGateway {
+			t.Fatalf("expected retryable bad response, got %v", newAPIError)
+		}
+	})
+
+	t.Run("ended before content", func(t *testing.T) {
+		c, _ := newFailoverTestContext()
+		resp := newFailoverTestResponse("data: {\"choices\":[{\"index\":0,\"delta\":{}}]}\n\ndata: [DONE]\n\n")
+		newAPIError := awaitStreamFirstContent(c, resp, info)
+		if newAPIError == nil || newAPIError.GetErrorCode() != types.ErrorCodeEmptyResponse {
+			t.Fatalf("expected empty response error, got %v", newAPIError)
+		}
+	})
+}
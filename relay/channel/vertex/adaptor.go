@@ -51,11 +51,32 @@ type Adaptor struct {
 }
 
 func (a *Adaptor) ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeminiChatRequest) (any, error) {
+	if a.RequestMode == RequestModeClaude {
+		claudeReq, err := claude.RequestGemini2ClaudeMessage(c, request, info)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
+			c.Set("request_model", v)
+		} else {
+			c.Set("request_model", claudeReq.Model)
+		}
+		return copyRequest(claudeReq, anthropicVersion), nil
+	}
 	geminiAdaptor := gemini.Adaptor{}
 	return geminiAdaptor.ConvertGeminiRequest(c, info, request)
 }
 
 func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	if a.RequestMode == RequestModeGemini {
+		geminiAdaptor := gemini.Adaptor{}
+		geminiRequest, err := geminiAdaptor.ConvertClaudeRequest(c, info, request)
+		if err != nil {
+			return nil, err
+		}
+		c.Set("request_model", request.Model)
+		return geminiRequest, nil
+	}
 	if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
 		c.Set("request_model", v)
 	} else {
@@ -34,6 +34,14 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		return types.NewError(fmt.Errorf("failed to copy request to ClaudeRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
 	}
 
+	cacheKey := service.GetResponseCacheKey(c, info, request)
+	if cacheKey != nil {
+		if usage := replayResponseCache(c, info, cacheKey); usage != nil {
+			service.PostClaudeConsumeQuota(c, info, usage)
+			return nil
+		}
+	}
+
 	err = helper.ModelMappedHelper(c, info, request)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
@@ -99,6 +107,7 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 	}
 
 	var requestBody io.Reader
+	var toolCallEmulation *service.ToolCallEmulation
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
@@ -106,6 +115,9 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
+		// 模型不支持原生函数调用时，以提示词模拟工具调用
+		toolCallEmulation = service.EmulateClaudeToolCalls(info, request)
+
 		convertedRequest, err := adaptor.ConvertClaudeRequest(c, info, request)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
@@ -153,7 +165,14 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		}
 	}
 
+	finishToolCallEmulation := service.StartToolCallEmulation(c, toolCallEmulation, info.RelayFormat)
+	if finishToolCallEmulation != nil {
+		defer finishToolCallEmulation()
+	}
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	if finishToolCallEmulation != nil {
+		finishToolCallEmulation()
+	}
 	//log.Printf("usage: %v", usage)
 	if newAPIError != nil {
 		// reset status code 重置状态码
@@ -161,6 +180,10 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		return newAPIError
 	}
 
+	if cacheKey != nil {
+		service.SaveCapturedResponse(c, info, cacheKey, usage.(*dto.Usage))
+	}
+
 	service.PostClaudeConsumeQuota(c, info, usage.(*dto.Usage))
 	return nil
 }
@@ -1,10 +1,17 @@
 package common
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -15,6 +22,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/jinzhu/copier"
 )
 
 type ThinkingContentInfo struct {
@@ -38,6 +46,132 @@ type ClaudeConvertInfo struct {
 	Done             bool
 }
 
+// HedgeState 对冲请求中单次尝试的状态
+type HedgeState struct {
+	fired atomic.Bool // 是否已向第二个渠道发起对冲请求
+	lost  atomic.Bool // 是否在竞速中落败，落败的尝试不计费
+}
+
+func (s *HedgeState) SetFired() {
+	s.fired.Store(true)
+}
+
+func (s *HedgeState) IsFired() bool {
+	return s.fired.Load()
+}
+
+func (s *HedgeState) SetLost() {
+	s.lost.Store(true)
+}
+
+func (s *HedgeState) IsLost() bool {
+	return s.lost.Load()
+}
+
+// BodyCapture 开启请求/响应体采集时暂存的上游请求体和返回给客户端的响应体，
+// 对冲请求与主请求共用同一份，超过上限的部分会被丢弃
+type BodyCapture struct {
+	mutex             sync.Mutex
+	maxRequestBytes   int
+	maxResponseBytes  int
+	requestBody       []byte
+	requestTruncated  bool
+	response          bytes.Buffer
+	responseTruncated bool
+}
+
+func NewBodyCapture(maxRequestBytes int, maxResponseBytes int) *BodyCapture {
+	return &BodyCapture{
+		maxRequestBytes:  maxRequestBytes,
+		maxResponseBytes: maxResponseBytes,
+	}
+}
+
+// setRequestBody 记录发往上游的请求体，重试时以最后一次为准
+func (b *BodyCapture) setRequestBody(body []byte, truncated bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.requestTruncated = truncated
+	if len(body) > b.maxRequestBytes {
+		body = body[:b.maxRequestBytes]
+	}
+	b.requestBody = bytes.Clone(body)
+}
+
+// TeeRequestBody 包装发往上游的请求体，在发送的同时最多记录 maxRequestBytes 字节，避免将大请求体完整读入内存；
+// 请求体关闭时才写入采集结果，对冲请求并发发送时不会互相覆盖出混合的内容
+func (b *BodyCapture) TeeRequestBody(body io.ReadCloser) io.ReadCloser {
+	return &captureRequestBody{ReadCloser: body, capture: b}
+}
+
+type captureRequestBody struct {
+	io.ReadCloser
+	capture   *BodyCapture
+	buf       bytes.Buffer
+	truncated bool
+	closed    bool
+}
+
+func (r *captureRequestBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.truncated {
+		data := p[:n]
+		if remaining := r.capture.maxRequestBytes - r.buf.Len(); len(data) > remaining {
+			data = data[:remaining]
+			r.truncated = true
+		}
+		r.buf.Write(data)
+	}
+	return n, err
+}
+
+func (r *captureRequestBody) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.capture.setRequestBody(r.buf.Bytes(), r.truncated)
+	}
+	return r.ReadCloser.Close()
+}
+
+func (b *BodyCapture) AppendResponse(data []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.responseTruncated {
+		return
+	}
+	if remaining := b.maxResponseBytes - b.response.Len(); len(data) > remaining {
+		data = data[:remaining]
+		b.responseTruncated = true
+	}
+	b.response.Write(data)
+}
+
+func (b *BodyCapture) RequestBody() ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.requestBody, b.requestTruncated
+}
+
+func (b *BodyCapture) ResponseBody() ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return bytes.Clone(b.response.Bytes()), b.responseTruncated
+}
+
+// ResponsesConvertInfo 将 Chat Completions 流式响应转换为 Responses 事件时的状态
+type ResponsesConvertInfo struct {
+	ResponseId     string
+	CreatedAt      int
+	Model          string
+	SequenceNumber int
+	Started        bool
+	Output         []dto.ResponsesOutput
+	MessageIndex   int         // 当前输出文本的 output 下标，-1 表示没有
+	ReasoningIndex int         // 当前输出思考内容的 output 下标，-1 表示没有
+	ToolIndexes    map[int]int // tool_calls 下标到 output 下标的映射
+	FinishReason   string
+}
+
 type RerankerInfo struct {
 	Documents       []any
 	ReturnDocuments bool
@@ -73,6 +207,19 @@ type ChannelMeta struct {
 	SupportStreamOptions bool // 是否支持流式选项
 }
 
+// TPMBucket TPM 限流的令牌桶
+type TPMBucket struct {
+	Key string
+	TPM int
+}
+
+// TPMLimitInfo 请求涉及的 TPM 令牌桶，以及预先按估算 token 数扣减的输入额度
+type TPMLimitInfo struct {
+	InputBuckets        []TPMBucket
+	OutputBuckets       []TPMBucket
+	ReservedInputTokens int
+}
+
 type TokenCountMeta struct {
 	//promptTokens int
 	estimatePromptTokens int
@@ -90,30 +237,42 @@ type RelayInfo struct {
 	FirstResponseTime time.Time
 	isFirstResponse   bool
 	//SendLastReasoningResponse bool
-	IsStream               bool
-	IsGeminiBatchEmbedding bool
-	IsPlayground           bool
-	UsePrice               bool
-	RelayMode              int
-	OriginModelName        string
-	RequestURLPath         string
-	ShouldIncludeUsage     bool
-	DisablePing            bool // 是否禁止向下游发送自定义 Ping
-	ClientWs               *websocket.Conn
-	TargetWs               *websocket.Conn
-	InputAudioFormat       string
-	OutputAudioFormat      string
-	RealtimeTools          []dto.RealTimeTool
-	IsFirstRequest         bool
-	AudioUsage             bool
-	ReasoningEffort        string
-	UserSetting            dto.UserSetting
-	UserEmail              string
-	UserQuota              int
-	RelayFormat            types.RelayFormat
-	SendResponseCount      int
-	FinalPreConsumedQuota  int  // 最终预消耗的配额
-	IsClaudeBetaQuery      bool // /v1/messages?beta=true
+	IsStream                bool
+	IsGeminiBatchEmbedding  bool
+	IsPlayground            bool
+	UsePrice                bool
+	RelayMode               int
+	OriginModelName         string
+	RequestURLPath          string
+	ShouldIncludeUsage      bool
+	DisablePing             bool // 是否禁止向下游发送自定义 Ping
+	ClientWs                *websocket.Conn
+	TargetWs                *websocket.Conn
+	InputAudioFormat        string
+	OutputAudioFormat       string
+	RealtimeTools           []dto.RealTimeTool
+	IsFirstRequest          bool
+	AudioUsage              bool
+	ReasoningEffort         string
+	UserSetting             dto.UserSetting
+	UserEmail               string
+	UserQuota               int
+	RelayFormat             types.RelayFormat
+	SendResponseCount       int
+	FinalPreConsumedQuota   int           // 最终预消耗的配额
+	IsClaudeBetaQuery       bool          // /v1/messages?beta=true
+	IsBatch                 bool          // 是否为批处理任务中的请求
+	BatchRatio              float64       // 批处理折扣倍率，仅 IsBatch 时有效
+	ResponseCacheHit        bool          // 是否命中响应缓存
+	ResponseCacheRatio      float64       // 响应缓存命中计费倍率，仅 ResponseCacheHit 时有效
+	ResponseCacheSimilarity float64       // 语义缓存命中时的相似度，精确匹配命中时为 0
+	TPMLimit                *TPMLimitInfo // 本次请求占用的 TPM 限额，未启用时为 nil
+	TokenBudgetLimited      bool          // 令牌是否配置了每日/每周/每月预算
+	OrganizationId          int           // 令牌所属的组织，非 0 时消耗组织额度池
+	Hedge                   *HedgeState   // 开启对冲请求时本次尝试的状态，未开启时为 nil
+	BodyCapture             *BodyCapture  // 开启请求/响应体采集时的暂存数据，未开启时为 nil
+	// Responses 请求经由 Chat Completions 转发时的转换状态，原生支持 Responses 的渠道为 nil
+	ResponsesConvertInfo *ResponsesConvertInfo
 
 	PriceData types.PriceData
 
@@ -409,6 +568,10 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 		TokenGroup:     tokenGroup,
 
+		IsBatch:            common.GetContextKeyBool(c, constant.ContextKeyBatchRequest),
+		TokenBudgetLimited: common.GetContextKeyBool(c, constant.ContextKeyTokenBudgetLimited),
+		OrganizationId:     common.GetContextKeyInt(c, constant.ContextKeyTokenOrganizationId),
+
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
 		RequestURLPath:  c.Request.URL.String(),
@@ -498,6 +661,76 @@ func (info *RelayInfo) SetFirstResponseTime() {
 	}
 }
 
+// GetResponseCacheRatio 返回响应缓存命中的计费倍率，未命中时为 1
+func (info *RelayInfo) GetResponseCacheRatio() float64 {
+	if !info.ResponseCacheHit {
+		return 1
+	}
+	return info.ResponseCacheRatio
+}
+
+// IsHedgeLost 判断本次尝试是否在对冲竞速中落败
+func (info *RelayInfo) IsHedgeLost() bool {
+	return info.Hedge != nil && info.Hedge.IsLost()
+}
+
+// CloneForHedge 深拷贝请求元信息供对冲请求使用，两次尝试并发执行，除请求体采集外不共享任何可变状态
+// 渠道信息在选定渠道后重新初始化
+func (info *RelayInfo) CloneForHedge() *RelayInfo {
+	clone := *info
+	clone.ChannelMeta = nil
+	clone.ResponsesConvertInfo = nil
+	clone.Request = cloneRequest(info.Request)
+	clone.PriceData.OtherRatios = maps.Clone(info.PriceData.OtherRatios)
+	clone.RealtimeTools = slices.Clone(info.RealtimeTools)
+	if info.TPMLimit != nil {
+		tpmLimit := *info.TPMLimit
+		tpmLimit.InputBuckets = slices.Clone(info.TPMLimit.InputBuckets)
+		tpmLimit.OutputBuckets = slices.Clone(info.TPMLimit.OutputBuckets)
+		clone.TPMLimit = &tpmLimit
+	}
+	if info.ClaudeConvertInfo != nil {
+		claudeConvertInfo := *info.ClaudeConvertInfo
+		clone.ClaudeConvertInfo = &claudeConvertInfo
+	}
+	if info.RerankerInfo != nil {
+		rerankerInfo := *info.RerankerInfo
+		rerankerInfo.Documents = slices.Clone(info.RerankerInfo.Documents)
+		clone.RerankerInfo = &rerankerInfo
+	}
+	if info.TaskRelayInfo != nil {
+		taskRelayInfo := *info.TaskRelayInfo
+		clone.TaskRelayInfo = &taskRelayInfo
+	}
+	if info.ResponsesUsageInfo != nil {
+		builtInTools := make(map[string]*BuildInToolInfo, len(info.ResponsesUsageInfo.BuiltInTools))
+		for name, tool := range info.ResponsesUsageInfo.BuiltInTools {
+			toolInfo := *tool
+			builtInTools[name] = &toolInfo
+		}
+		clone.ResponsesUsageInfo = &ResponsesUsageInfo{BuiltInTools: builtInTools}
+	}
+	clone.Hedge = &HedgeState{}
+	return &clone
+}
+
+// cloneRequest 深拷贝解析后的请求，选定渠道时会修改其中的模型名称
+func cloneRequest(request dto.Request) dto.Request {
+	value := reflect.ValueOf(request)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return request
+	}
+	dst := reflect.New(value.Elem().Type())
+	if err := copier.CopyWithOption(dst.Interface(), request, copier.Option{DeepCopy: true}); err != nil {
+		common.SysError("failed to clone request for hedge: " + err.Error())
+		return request
+	}
+	if cloned, ok := dst.Interface().(dto.Request); ok {
+		return cloned
+	}
+	return request
+}
+
 func (info *RelayInfo) HasSendResponse() bool {
 	return info.FirstResponseTime.After(info.StartTime)
 }
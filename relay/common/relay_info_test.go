@@ -0,0 +1,76 @@
+package common
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestCloneForHedgeDoesNotShareState(t *testing.T) {
+	request := &dto.GeneralOpenAIRequest{
+		Model:    "gpt-4o",
+		Messages: []dto.Message{{Role: "user"}},
+	}
+	info := &RelayInfo{
+		Request:  request,
+		TPMLimit: &TPMLimitInfo{InputBuckets: []TPMBucket{{}}},
+		Hedge:    &HedgeState{},
+	}
+	info.PriceData.OtherRatios = map[string]float64{"cache": 1}
+
+	clone := info.CloneForHedge()
+
+	cloned, ok := clone.Request.(*dto.GeneralOpenAIRequest)
+	if !ok || cloned == request {
+		t.Fatalf("request should be copied, got %T", clone.Request)
+	}
+	cloned.Model = "claude-3"
+	cloned.Messages[0].Role = "assistant"
+	if request.Model != "gpt-4o" || request.Messages[0].Role != "user" {
+		t.Fatalf("modifying cloned request changed the original: %+v", request)
+	}
+
+	clone.PriceData.OtherRatios["cache"] = 2
+	if info.PriceData.OtherRatios["cache"] != 1 {
+		t.Fatal("other ratios should not be shared")
+	}
+
+	if clone.TPMLimit == info.TPMLimit || &clone.TPMLimit.InputBuckets[0] == &info.TPMLimit.InputBuckets[0] {
+		t.Fatal("tpm limit should not be shared")
+	}
+
+	clone.Hedge.SetLost()
+	if info.IsHedgeLost() {
+		t.Fatal("hedge state should not be shared")
+	}
+}
+
+func TestBodyCaptureTeeRequestBody(t *testing.T) {
+	capture := NewBodyCapture(8, 8)
+	body := capture.TeeRequestBody(io.NopCloser(strings.NewReader("0123456789abcdef")))
+	sent, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sent) != "0123456789abcdef" {
+		t.Fatalf("upstream should receive the full body, got %q", sent)
+	}
+	if captured, _ := capture.RequestBody(); len(captured) != 0 {
+		t.Fatalf("body should be recorded only after close, got %q", captured)
+	}
+	_ = body.Close()
+	captured, truncated := capture.RequestBody()
+	if string(captured) != "01234567" || !truncated {
+		t.Fatalf("expected bounded capture, got %q truncated=%v", captured, truncated)
+	}
+
+	// 重试时以最后一次发送的请求体为准
+	retry := capture.TeeRequestBody(io.NopCloser(strings.NewReader("retry")))
+	_, _ = io.ReadAll(retry)
+	_ = retry.Close()
+	if captured, truncated := capture.RequestBody(); string(captured) != "retry" || truncated {
+		t.Fatalf("expected retry body, got %q truncated=%v", captured, truncated)
+	}
+}
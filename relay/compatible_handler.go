@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
@@ -42,6 +43,14 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		c.Set("chat_completion_web_search_context_size", request.WebSearchOptions.SearchContextSize)
 	}
 
+	cacheKey := service.GetResponseCacheKey(c, info, request)
+	if cacheKey != nil {
+		if usage := replayResponseCache(c, info, cacheKey); usage != nil {
+			postConsumeQuota(c, info, usage, "")
+			return nil
+		}
+	}
+
 	err = helper.ModelMappedHelper(c, info, request)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
@@ -73,6 +82,7 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	}
 	adaptor.Init(info)
 	var requestBody io.Reader
+	var toolCallEmulation *service.ToolCallEmulation
 
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled {
 		body, err := common.GetRequestBody(c)
@@ -84,6 +94,9 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
+		// 模型不支持原生函数调用时，以提示词模拟工具调用
+		toolCallEmulation = service.EmulateOpenAIToolCalls(info, request)
+
 		convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, request)
 		if err != nil {
 			return types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
@@ -174,13 +187,24 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 		}
 	}
 
+	finishToolCallEmulation := service.StartToolCallEmulation(c, toolCallEmulation, info.RelayFormat)
+	if finishToolCallEmulation != nil {
+		defer finishToolCallEmulation()
+	}
 	usage, newApiErr := adaptor.DoResponse(c, httpResp, info)
+	if finishToolCallEmulation != nil {
+		finishToolCallEmulation()
+	}
 	if newApiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
 		return newApiErr
 	}
 
+	if cacheKey != nil {
+		service.SaveCapturedResponse(c, info, cacheKey, usage.(*dto.Usage))
+	}
+
 	if usage.(*dto.Usage).CompletionTokenDetails.AudioTokens > 0 || usage.(*dto.Usage).PromptTokensDetails.AudioTokens > 0 {
 		service.PostAudioConsumeQuota(c, info, usage.(*dto.Usage), "")
 	} else {
@@ -190,6 +214,12 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 }
 
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	if !service.ShouldSettle(ctx, relayInfo) {
+		return
+	}
+	_, span := tracing.Start(ctx.Request.Context(), "quota.settle", tracing.ChannelAttributes(relayInfo.ChannelId, relayInfo.UpstreamModelName)...)
+	defer span.End()
+
 	if usage == nil {
 		usage = &dto.Usage{
 			PromptTokens:     relayInfo.GetEstimatePromptTokens(),
@@ -198,6 +228,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		}
 		extraContent += "（可能是请求出错）"
 	}
+	service.ReconcileTPMLimit(relayInfo, usage.PromptTokens, usage.CompletionTokens)
+	service.AdjustModeratedCompletionUsage(ctx, relayInfo, usage)
+
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -349,6 +382,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(audioInputQuota)
 	// 添加 image generation call 计费
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(dImageGenerationCallQuota)
+	// 命中响应缓存时单独叠加缓存倍率，分组倍率保持不变
+	responseCacheRatio := relayInfo.GetResponseCacheRatio()
+	quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(responseCacheRatio))
 
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
@@ -364,7 +400,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		logger.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, relayInfo.FinalPreConsumedQuota))
 	} else {
-		if !ratio.IsZero() && quota == 0 {
+		if !ratio.IsZero() && responseCacheRatio != 0 && quota == 0 {
 			quota = 1
 		}
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
@@ -0,0 +1,132 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// CountTokensHelper 处理 Claude /v1/messages/count_tokens 与 Gemini :countTokens 请求，
+// 渠道原生支持时转发上游，否则本地估算；该请求不计费
+func CountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types.NewAPIError) {
+	info.InitChannelMeta(c)
+
+	var provider service.Provider
+	switch info.Request.(type) {
+	case *dto.ClaudeRequest:
+		provider = service.Claude
+	case *dto.GeminiChatRequest:
+		provider = service.Gemini
+	default:
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type for count tokens: %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	err := helper.ModelMappedHelper(c, info, info.Request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+
+	if upstreamURL := countTokensUpstreamURL(info); upstreamURL != "" {
+		done, err := relayCountTokensUpstream(c, info, upstreamURL)
+		if done {
+			return nil
+		}
+		// 上游不可用时回退到本地估算，避免阻断客户端的上下文管理
+		logger.LogWarn(c, fmt.Sprintf("count tokens upstream failed, fallback to local estimate: %v", err))
+	}
+
+	tokens := service.CountRequestTokenLocally(provider, info.UpstreamModelName, info.Request.GetTokenCountMeta())
+	if provider == service.Gemini {
+		c.JSON(http.StatusOK, gin.H{"totalTokens": tokens})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"input_tokens": tokens})
+	}
+	return nil
+}
+
+// countTokensUpstreamURL 返回渠道原生 count tokens 接口地址，渠道不支持时返回空字符串
+func countTokensUpstreamURL(info *relaycommon.RelayInfo) string {
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		if info.ApiType != constant.APITypeAnthropic {
+			return ""
+		}
+		url := fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+		if info.IsClaudeBetaQuery {
+			url += "?beta=true"
+		}
+		return url
+	case types.RelayFormatGemini:
+		if info.ApiType != constant.APITypeGemini {
+			return ""
+		}
+		adaptor := GetAdaptor(info.ApiType)
+		adaptor.Init(info)
+		url, err := adaptor.GetRequestURL(info)
+		if err != nil || !strings.HasSuffix(url, ":generateContent") {
+			return ""
+		}
+		return strings.TrimSuffix(url, ":generateContent") + ":countTokens"
+	}
+	return ""
+}
+
+// relayCountTokensUpstream 将原始请求体转发到上游，成功时直接写出上游响应
+func relayCountTokensUpstream(c *gin.Context, info *relaycommon.RelayInfo, upstreamURL string) (bool, error) {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return false, err
+	}
+	switch info.RelayFormat {
+	case types.RelayFormatClaude:
+		body, err = sjson.SetBytes(body, "model", info.UpstreamModelName)
+	case types.RelayFormatGemini:
+		if gjson.GetBytes(body, "generateContentRequest.model").Exists() {
+			body, err = sjson.SetBytes(body, "generateContentRequest.model", "models/"+info.UpstreamModelName)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, upstreamURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	adaptor := GetAdaptor(info.ApiType)
+	if err := adaptor.SetupRequestHeader(c, &req.Header, info); err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := channel.DoRequest(c, req, info)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("status code %d: %s", resp.StatusCode, string(responseBody))
+	}
+	c.Data(http.StatusOK, "application/json", responseBody)
+	return true, nil
+}
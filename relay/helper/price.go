@@ -42,6 +42,12 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
 	}
 
+	// batch discount，批处理请求在分组倍率基础上叠加批处理倍率
+	if relayInfo.IsBatch {
+		relayInfo.BatchRatio = ratio_setting.GetBatchRatio(relayInfo.OriginModelName)
+		groupRatioInfo.GroupRatio *= relayInfo.BatchRatio
+	}
+
 	return groupRatioInfo
 }
 
@@ -51,7 +51,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// GetAdaptor 根据 API 类型返回对应的适配器
+// getAdaptor 根据 API 类型返回对应的适配器
 // 参数:
 //
 //	apiType: API 类型常量，如 APITypeOpenAI、APITypeAnthropic 等
@@ -63,7 +63,7 @@ import (
 // 说明:
 //
 //	每个适配器负责将通用请求转换为特定 AI 服务商的请求格式
-func GetAdaptor(apiType int) channel.Adaptor {
+func getAdaptor(apiType int) channel.Adaptor {
 	switch apiType {
 	case constant.APITypeAli:
 		return &ali.Adaptor{}
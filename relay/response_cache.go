@@ -0,0 +1,128 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// replayResponseCache 查找响应缓存，命中时回放缓存的响应并返回缓存的用量，未命中时开始记录本次响应
+func replayResponseCache(c *gin.Context, info *relaycommon.RelayInfo, cacheKey *service.ResponseCacheKey) *dto.Usage {
+	entry := service.GetResponseCache(cacheKey.Key)
+	similarity := 0.0
+	if entry == nil {
+		entry, similarity = service.GetSemanticResponseCache(c, cacheKey)
+	}
+	if entry == nil {
+		service.CaptureResponseForCache(c)
+		return nil
+	}
+	ratio := operation_setting.GetResponseCacheSetting().HitRatio
+	info.ResponseCacheHit = true
+	info.ResponseCacheRatio = ratio
+	info.ResponseCacheSimilarity = similarity
+	info.IsStream = entry.Stream
+
+	// 回放的响应使用本次请求的 id 和创建时间，避免多次请求返回相同的 id
+	rewriter := newCachedResponseRewriter(c)
+	if entry.Stream {
+		// 按事件拆分后逐个写出，模拟上游的流式响应
+		helper.SetEventStreamHeaders(c)
+		for _, event := range bytes.Split(entry.Body, []byte("\n\n")) {
+			if len(bytes.TrimSpace(event)) == 0 {
+				continue
+			}
+			if _, err := c.Writer.Write(append(rewriter.rewriteEvent(event), '\n', '\n')); err != nil {
+				break
+			}
+			info.SetFirstResponseTime()
+			if err := helper.FlushWriter(c); err != nil {
+				break
+			}
+		}
+	} else {
+		c.Data(http.StatusOK, entry.ContentType, rewriter.rewrite(entry.Body))
+	}
+	if similarity > 0 {
+		logger.LogInfo(c, fmt.Sprintf("response cache semantic hit, similarity %.4f, created at %d", similarity, entry.CreatedAt))
+	} else {
+		logger.LogInfo(c, fmt.Sprintf("response cache hit, created at %d", entry.CreatedAt))
+	}
+	usage := entry.Usage
+	return &usage
+}
+
+// cachedResponseRewriter 将缓存响应中的 id、created 替换为本次请求的值
+type cachedResponseRewriter struct {
+	requestId string
+	created   int64
+	ids       map[string]string // 原 id -> 新 id，同一个流中的事件保持一致
+}
+
+func newCachedResponseRewriter(c *gin.Context) *cachedResponseRewriter {
+	requestId := c.GetString(common.RequestIdKey)
+	if requestId == "" {
+		requestId = common.GetUUID()
+	}
+	return &cachedResponseRewriter{
+		requestId: requestId,
+		created:   time.Now().Unix(),
+		ids:       make(map[string]string),
+	}
+}
+
+// newId 保留原 id 的前缀（如 chatcmpl-、msg_），其余部分替换为请求 ID
+func (r *cachedResponseRewriter) newId(id string) string {
+	if newId, ok := r.ids[id]; ok {
+		return newId
+	}
+	newId := id[:strings.IndexAny(id, "-_")+1] + r.requestId
+	r.ids[id] = newId
+	return newId
+}
+
+// rewrite 替换 JSON 响应顶层的 id、created 以及 Claude message_start 中的 message.id
+func (r *cachedResponseRewriter) rewrite(data []byte) []byte {
+	if !gjson.ValidBytes(data) {
+		return data
+	}
+	for _, path := range []string{"id", "message.id", "response.id"} {
+		if id := gjson.GetBytes(data, path); id.Type == gjson.String && id.String() != "" {
+			data, _ = sjson.SetBytes(data, path, r.newId(id.String()))
+		}
+	}
+	for _, path := range []string{"created", "created_at", "response.created_at"} {
+		if created := gjson.GetBytes(data, path); created.Type == gjson.Number {
+			data, _ = sjson.SetBytes(data, path, r.created)
+		}
+	}
+	return data
+}
+
+// rewriteEvent 逐行处理 SSE 事件，替换 data 行中的 JSON
+func (r *cachedResponseRewriter) rewriteEvent(event []byte) []byte {
+	lines := bytes.Split(event, []byte("\n"))
+	for i, line := range lines {
+		payload, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		payload = bytes.TrimSpace(payload)
+		lines[i] = append([]byte("data: "), r.rewrite(payload)...)
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
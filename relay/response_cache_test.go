@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestReplayResponseCacheRewritesIds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() { common.RedisEnabled = redisEnabled })
+	streamBody := "data: {\"id\":\"chatcmpl-cached\",\"object\":\"chat.completion.chunk\",\"created\":1700000000,\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\n" +
+		"data: {\"id\":\"chatcmpl-cached\",\"object\":\"chat.completion.chunk\",\"created\":1700000000,\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	claudeBody := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_cached\",\"role\":\"assistant\"}}\n\n"
+
+	cases := []struct {
+		name  string
+		entry *service.ResponseCacheEntry
+		check func(t *testing.T, body string)
+	}{
+		{
+			name:  "openai stream",
+			entry: &service.ResponseCacheEntry{Stream: true, Body: []byte(streamBody)},
+			check: func(t *testing.T, body string) {
+				events := strings.Split(strings.TrimSpace(body), "\n\n")
+				if len(events) != 3 || events[2] != "data: [DONE]" {
+					t.Fatalf("unexpected events: %q", body)
+				}
+				for _, event := range events[:2] {
+					data := strings.TrimPrefix(event, "data: ")
+					if id := gjson.Get(data, "id").String(); id != "chatcmpl-req-1" {
+						t.Fatalf("id should be rewritten, got %s", id)
+					}
+					if created := gjson.Get(data, "created").Int(); created < time.Now().Unix()-60 {
+						t.Fatalf("created should be rewritten, got %d", created)
+					}
+				}
+			},
+		},
+		{
+			name:  "claude stream",
+			entry: &service.ResponseCacheEntry{Stream: true, Body: []byte(claudeBody)},
+			check: func(t *testing.T, body string) {
+				if !strings.HasPrefix(body, "event: message_start\ndata: ") || !strings.Contains(body, `"id":"msg_req-1"`) {
+					t.Fatalf("message id should be rewritten, got %q", body)
+				}
+			},
+		},
+		{
+			name:  "json",
+			entry: &service.ResponseCacheEntry{ContentType: "application/json", Body: []byte(`{"id":"chatcmpl-cached","created":1700000000,"choices":[]}`)},
+			check: func(t *testing.T, body string) {
+				if gjson.Get(body, "id").String() != "chatcmpl-req-1" || gjson.Get(body, "created").Int() == 1700000000 {
+					t.Fatalf("id and created should be rewritten, got %s", body)
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			key := "response_cache:test:" + tc.name
+			tc.entry.Usage = dto.Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3}
+			if err := service.SetResponseCache(key, tc.entry, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			c.Set(common.RequestIdKey, "req-1")
+			info := &relaycommon.RelayInfo{StartTime: time.Now()}
+			info.PriceData.GroupRatioInfo.GroupRatio = 1.5
+
+			usage := replayResponseCache(c, info, &service.ResponseCacheKey{Key: key})
+			if usage == nil || usage.TotalTokens != 3 || !info.ResponseCacheHit {
+				t.Fatalf("expected cache hit with cached usage, got %+v", usage)
+			}
+			// 缓存倍率单独计费，不修改分组倍率
+			if info.PriceData.GroupRatioInfo.GroupRatio != 1.5 || info.GetResponseCacheRatio() != info.ResponseCacheRatio {
+				t.Fatalf("group ratio should be unchanged, got %v", info.PriceData.GroupRatioInfo.GroupRatio)
+			}
+			tc.check(t, recorder.Body.String())
+		})
+	}
+}
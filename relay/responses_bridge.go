@@ -0,0 +1,253 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// isResponsesNativeSupported 判断渠道是否原生支持 Responses API，其余渠道通过 Chat Completions 转发
+func isResponsesNativeSupported(apiType int) bool {
+	switch apiType {
+	case constant.APITypeOpenAI, constant.APITypeCloudflare:
+		return true
+	}
+	return false
+}
+
+// responsesBridgeWriter 将渠道输出的 Chat Completions 响应转换为 Responses 格式后写出
+type responsesBridgeWriter struct {
+	gin.ResponseWriter
+	info   *relaycommon.RelayInfo
+	buf    bytes.Buffer
+	status int
+}
+
+func (w *responsesBridgeWriter) WriteHeader(code int) {
+	// 非流式响应需要转换完成后才能确定 Content-Length，推迟写出响应头
+	if !w.info.IsStream {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responsesBridgeWriter) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	if !w.info.IsStream {
+		return len(data), nil
+	}
+	for {
+		index := bytes.Index(w.buf.Bytes(), []byte("\n\n"))
+		if index < 0 {
+			break
+		}
+		event := string(w.buf.Next(index + 2))
+		if err := w.writeStreamEvent(event); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *responsesBridgeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responsesBridgeWriter) writeStreamEvent(event string) error {
+	for _, line := range strings.Split(event, "\n") {
+		if strings.HasPrefix(line, ":") {
+			// 保留心跳
+			_, err := w.ResponseWriter.WriteString(line + "\n\n")
+			return err
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		if errorResult := gjson.Get(data, "error"); errorResult.Exists() {
+			return w.writeResponsesEvent("error", []byte(errorResult.Raw))
+		}
+		var streamResponse dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(data, &streamResponse); err != nil {
+			common.SysError("failed to unmarshal chat completions chunk: " + err.Error())
+			continue
+		}
+		for _, resp := range service.StreamResponseOpenAI2Responses(&streamResponse, w.info) {
+			if err := w.writeResponse(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *responsesBridgeWriter) writeResponse(resp dto.ResponsesStreamResponse) error {
+	data, err := common.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return w.writeResponsesEvent(resp.Type, data)
+}
+
+func (w *responsesBridgeWriter) writeResponsesEvent(eventType string, data []byte) error {
+	_, err := w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, data))
+	return err
+}
+
+// finish 写出流式响应的结束事件，或将缓存的非流式响应转换后写出
+func (w *responsesBridgeWriter) finish(usage *dto.Usage) error {
+	if w.info.IsStream {
+		// 最后一个事件可能没有以空行结尾，结束前写出缓存中剩余的数据
+		if w.buf.Len() > 0 {
+			if err := w.writeStreamEvent(w.buf.String()); err != nil {
+				return err
+			}
+			w.buf.Reset()
+		}
+		for _, resp := range service.StreamResponseOpenAI2ResponsesDone(w.info, usage) {
+			if err := w.writeResponse(resp); err != nil {
+				return err
+			}
+		}
+		w.ResponseWriter.Flush()
+		return nil
+	}
+	var openAIResponse dto.OpenAITextResponse
+	if err := common.Unmarshal(w.buf.Bytes(), &openAIResponse); err != nil {
+		return err
+	}
+	data, err := common.Marshal(service.ResponseOpenAI2Responses(&openAIResponse, w.info, usage))
+	if err != nil {
+		return err
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.ResponseWriter.WriteHeader(status)
+	_, err = w.ResponseWriter.Write(data)
+	return err
+}
+
+// responsesViaChatCompletions 将 Responses 请求转换为 Chat Completions 请求交给渠道处理，
+// 再将渠道输出的 Chat Completions 响应转换回 Responses 格式
+func responsesViaChatCompletions(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, responsesRequest *dto.OpenAIResponsesRequest) (*dto.Usage, *types.NewAPIError) {
+	request, err := service.ResponsesToOpenAIRequest(*responsesRequest, info)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeConvertRequestFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	// 按 Chat Completions 请求处理，结束后恢复，避免影响重试到原生支持 Responses 的渠道
+	relayMode, relayFormat, requestURLPath := info.RelayMode, info.RelayFormat, info.RequestURLPath
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RelayFormat = types.RelayFormatOpenAI
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ShouldIncludeUsage = false
+	info.ResponsesConvertInfo = &relaycommon.ResponsesConvertInfo{
+		ResponseId:     "resp_" + common.GetRandomString(24),
+		CreatedAt:      int(common.GetTimestamp()),
+		Model:          info.OriginModelName,
+		MessageIndex:   -1,
+		ReasoningIndex: -1,
+		ToolIndexes:    make(map[int]int),
+	}
+	defer func() {
+		info.RelayMode, info.RelayFormat, info.RequestURLPath = relayMode, relayFormat, requestURLPath
+	}()
+	if request.Stream && info.SupportStreamOptions {
+		request.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	// 模型不支持原生函数调用时，以提示词模拟工具调用，模型输出先转换为 Chat Completions 的 tool_calls
+	toolCallEmulation := service.EmulateOpenAIToolCalls(info, request)
+
+	adaptor.Init(info)
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, request)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+	}
+	jsonData, err = relaycommon.RemoveDisabledFields(jsonData, info.ChannelOtherSettings)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	if len(info.ParamOverride) > 0 {
+		jsonData, err = relaycommon.ApplyParamOverride(jsonData, info.ParamOverride, relaycommon.BuildParamOverrideContext(info))
+		if err != nil {
+			return nil, types.NewError(err, types.ErrorCodeChannelParamOverrideInvalid, types.ErrOptionWithSkipRetry())
+		}
+	}
+	logger.LogDebug(c, fmt.Sprintf("responses bridge request body: %s", string(jsonData)))
+
+	var requestBody io.Reader = bytes.NewBuffer(jsonData)
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		info.IsStream = info.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if httpResp.StatusCode != http.StatusOK {
+			newAPIError := service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+			return nil, newAPIError
+		}
+	}
+
+	writer := &responsesBridgeWriter{ResponseWriter: c.Writer, info: info}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+	finishToolCallEmulation := service.StartToolCallEmulation(c, toolCallEmulation, types.RelayFormatOpenAI)
+	if finishToolCallEmulation != nil {
+		defer finishToolCallEmulation()
+	}
+	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	if finishToolCallEmulation != nil {
+		finishToolCallEmulation()
+	}
+	if newAPIError != nil {
+		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
+		return nil, newAPIError
+	}
+	u, ok := usage.(*dto.Usage)
+	if !ok {
+		return nil, types.NewError(fmt.Errorf("unexpected usage type %T", usage), types.ErrorCodeBadResponse, types.ErrOptionWithSkipRetry())
+	}
+	// 对冲竞速中落败时输出会被丢弃，无需转换
+	if info.IsHedgeLost() {
+		return u, nil
+	}
+	if err := writer.finish(u); err != nil {
+		logger.LogError(c, "failed to write responses bridge response: "+err.Error())
+	}
+	return u, nil
+}
@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponsesBridgeWriterFlushesTrailingEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	info := &relaycommon.RelayInfo{
+		IsStream: true,
+		ResponsesConvertInfo: &relaycommon.ResponsesConvertInfo{
+			ResponseId:     "resp_test",
+			Model:          "gpt-4o",
+			MessageIndex:   -1,
+			ReasoningIndex: -1,
+			ToolIndexes:    make(map[int]int),
+		},
+	}
+	writer := &responsesBridgeWriter{ResponseWriter: c.Writer, info: info}
+
+	// 最后一个事件没有以空行结尾
+	chunk := `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"hello"}}]}`
+	if _, err := writer.WriteString(chunk); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(recorder.Body.String(), "hello") {
+		t.Fatal("incomplete event should be buffered")
+	}
+	if err := writer.finish(&dto.Usage{}); err != nil {
+		t.Fatal(err)
+	}
+	body := recorder.Body.String()
+	if !strings.Contains(body, `"delta":"hello"`) {
+		t.Fatalf("trailing event was not flushed: %s", body)
+	}
+	if !strings.Contains(body, "event: response.completed") {
+		t.Fatalf("missing completed event: %s", body)
+	}
+}
+
+func newToolCallEmulationBridge(t *testing.T, stream bool) (*gin.Context, *httptest.ResponseRecorder, *responsesBridgeWriter, func()) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	info := &relaycommon.RelayInfo{
+		IsStream:    stream,
+		ChannelMeta: &relaycommon.ChannelMeta{},
+		ResponsesConvertInfo: &relaycommon.ResponsesConvertInfo{
+			ResponseId:     "resp_test",
+			Model:          "gpt-4o",
+			MessageIndex:   -1,
+			ReasoningIndex: -1,
+			ToolIndexes:    make(map[int]int),
+		},
+	}
+	info.ChannelSetting.ToolCallEmulation = true
+	request := &dto.GeneralOpenAIRequest{
+		Messages: []dto.Message{{Role: "user", Content: "weather?"}},
+		Tools:    []dto.ToolCallRequest{{Type: "function", Function: dto.FunctionRequest{Name: "get_weather"}}},
+	}
+	emulation := service.EmulateOpenAIToolCalls(info, request)
+	writer := &responsesBridgeWriter{ResponseWriter: c.Writer, info: info}
+	c.Writer = writer
+	finish := service.StartToolCallEmulation(c, emulation, types.RelayFormatOpenAI)
+	if finish == nil {
+		t.Fatal("emulation should be started")
+	}
+	return c, recorder, writer, finish
+}
+
+func TestResponsesBridgeEmulatesToolCalls(t *testing.T) {
+	toolCall := `<tool_call>{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}</tool_call>`
+
+	t.Run("non-stream", func(t *testing.T) {
+		c, recorder, writer, finish := newToolCallEmulationBridge(t, false)
+		c.Header("Content-Type", "application/json")
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"` + toolCall + `"},"finish_reason":"stop"}]}`))
+		finish()
+		if err := writer.finish(&dto.Usage{}); err != nil {
+			t.Fatal(err)
+		}
+		body := recorder.Body.String()
+		output := gjson.Get(body, `output.#(type=="function_call")`)
+		if output.Get("name").String() != "get_weather" || gjson.Get(output.Get("arguments").String(), "city").String() != "Paris" {
+			t.Fatalf("function call should be converted, got %s", body)
+		}
+		if recorder.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+			t.Fatalf("content length should match converted body, got %s", recorder.Header().Get("Content-Length"))
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		c, recorder, writer, finish := newToolCallEmulationBridge(t, true)
+		c.Header("Content-Type", "text/event-stream")
+		_, _ = c.Writer.Write([]byte(`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"` + toolCall + `"}}]}` + "\n\n"))
+		_, _ = c.Writer.Write([]byte(`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\ndata: [DONE]\n\n"))
+		finish()
+		if err := writer.finish(&dto.Usage{}); err != nil {
+			t.Fatal(err)
+		}
+		body := recorder.Body.String()
+		if strings.Contains(body, "tool_call>") {
+			t.Fatalf("emulated tool call text should not reach the client: %s", body)
+		}
+		if !strings.Contains(body, `"type":"function_call"`) || !strings.Contains(body, "get_weather") {
+			t.Fatalf("function call events should be emitted: %s", body)
+		}
+	})
+}
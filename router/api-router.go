@@ -1,6 +1,7 @@
 package router
 
 import (
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/controller"
 	"github.com/QuantumNous/new-api/middleware"
 
@@ -18,7 +19,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/uptime/status", controller.GetUptimeKumaStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
-		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.GET("/status/test", middleware.PermissionAuth(constant.PermissionChannelRead), controller.TestStatus)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/user-agreement", controller.GetUserAgreement)
 		apiRouter.GET("/privacy-policy", controller.GetPrivacyPolicy)
@@ -86,6 +87,8 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/creem/pay", middleware.CriticalRateLimit(), controller.RequestCreemPay)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
+				selfRoute.GET("/billing/statements", controller.GetSelfBillingStatements)
+				selfRoute.GET("/billing/statements/:period", controller.GetSelfBillingStatement)
 
 				// 2FA routes
 				selfRoute.GET("/2fa/status", controller.Get2FAStatus)
@@ -96,66 +99,79 @@ func SetApiRouter(router *gin.Engine) {
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
 			{
-				adminRoute.GET("/", controller.GetAllUsers)
-				adminRoute.GET("/topup", controller.GetAllTopUps)
-				adminRoute.POST("/topup/complete", controller.AdminCompleteTopUp)
-				adminRoute.GET("/search", controller.SearchUsers)
-				adminRoute.GET("/:id", controller.GetUser)
-				adminRoute.POST("/", controller.CreateUser)
-				adminRoute.POST("/manage", controller.ManageUser)
-				adminRoute.PUT("/", controller.UpdateUser)
-				adminRoute.DELETE("/:id", controller.DeleteUser)
-				adminRoute.DELETE("/:id/reset_passkey", controller.AdminResetPasskey)
+				adminRoute.GET("/", middleware.PermissionAuth(constant.PermissionUserRead), controller.GetAllUsers)
+				adminRoute.GET("/topup", middleware.PermissionAuth(constant.PermissionBillingRead), controller.GetAllTopUps)
+				adminRoute.POST("/topup/complete", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.AdminCompleteTopUp)
+				adminRoute.GET("/search", middleware.PermissionAuth(constant.PermissionUserRead), controller.SearchUsers)
+				adminRoute.GET("/:id", middleware.PermissionAuth(constant.PermissionUserRead), controller.GetUser)
+				adminRoute.POST("/", middleware.PermissionAuth(constant.PermissionUserWrite), controller.CreateUser)
+				adminRoute.POST("/manage", middleware.PermissionAuth(constant.PermissionUserWrite), controller.ManageUser)
+				adminRoute.PUT("/", middleware.PermissionAuth(constant.PermissionUserWrite), controller.UpdateUser)
+				adminRoute.DELETE("/:id", middleware.PermissionAuth(constant.PermissionUserWrite), controller.DeleteUser)
+				adminRoute.DELETE("/:id/reset_passkey", middleware.PermissionAuth(constant.PermissionUserWrite), controller.AdminResetPasskey)
 
 				// Admin 2FA routes
-				adminRoute.GET("/2fa/stats", controller.Admin2FAStats)
-				adminRoute.DELETE("/:id/2fa", controller.AdminDisable2FA)
+				adminRoute.GET("/2fa/stats", middleware.PermissionAuth(constant.PermissionUserRead), controller.Admin2FAStats)
+				adminRoute.DELETE("/:id/2fa", middleware.PermissionAuth(constant.PermissionUserWrite), controller.AdminDisable2FA)
 			}
 		}
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.Use(middleware.PermissionAuth(constant.PermissionRoleManage))
+		{
+			roleRoute.GET("/", controller.GetAllRoles)
+			roleRoute.GET("/permissions", controller.GetAllPermissions)
+			roleRoute.POST("/", controller.CreateRole)
+			roleRoute.PUT("/", controller.UpdateRole)
+			roleRoute.DELETE("/:id", controller.DeleteRole)
+			roleRoute.POST("/assign", controller.AssignUserRole)
+		}
+		auditRoute := apiRouter.Group("/audit")
+		auditRoute.Use(middleware.PermissionAuth(constant.PermissionAuditRead))
+		{
+			auditRoute.GET("/", controller.GetAuditLogs)
+			auditRoute.GET("/export", controller.ExportAuditLogs)
+			auditRoute.GET("/verify", controller.VerifyAuditLogs)
+		}
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth())
 		{
-			optionRoute.GET("/", controller.GetOptions)
-			optionRoute.PUT("/", controller.UpdateOption)
-			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
-			optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
+			optionRoute.GET("/", middleware.PermissionAuth(constant.PermissionSettingWrite, constant.PermissionPricingWrite), controller.GetOptions)
+			optionRoute.PUT("/", middleware.PermissionAuth(constant.PermissionSettingWrite, constant.PermissionPricingWrite), controller.UpdateOption)
+			optionRoute.POST("/rest_model_ratio", middleware.PermissionAuth(constant.PermissionPricingWrite), controller.ResetModelRatio)
+			optionRoute.POST("/migrate_console_setting", middleware.PermissionAuth(constant.PermissionSettingWrite), controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
 		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
-		ratioSyncRoute.Use(middleware.RootAuth())
 		{
-			ratioSyncRoute.GET("/channels", controller.GetSyncableChannels)
-			ratioSyncRoute.POST("/fetch", controller.FetchUpstreamRatios)
+			ratioSyncRoute.GET("/channels", middleware.PermissionAuth(constant.PermissionPricingWrite), controller.GetSyncableChannels)
+			ratioSyncRoute.POST("/fetch", middleware.PermissionAuth(constant.PermissionPricingWrite), controller.FetchUpstreamRatios)
 		}
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
 		{
-			channelRoute.GET("/", controller.GetAllChannels)
-			channelRoute.GET("/search", controller.SearchChannels)
-			channelRoute.GET("/models", controller.ChannelListModels)
-			channelRoute.GET("/models_enabled", controller.EnabledListModels)
-			channelRoute.GET("/:id", controller.GetChannel)
-			channelRoute.POST("/:id/key", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
-			channelRoute.GET("/test", controller.TestAllChannels)
-			channelRoute.GET("/test/:id", controller.TestChannel)
-			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
-			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
-			channelRoute.POST("/", controller.AddChannel)
-			channelRoute.PUT("/", controller.UpdateChannel)
-			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
-			channelRoute.POST("/tag/disabled", controller.DisableTagChannels)
-			channelRoute.POST("/tag/enabled", controller.EnableTagChannels)
-			channelRoute.PUT("/tag", controller.EditTagChannels)
-			channelRoute.DELETE("/:id", controller.DeleteChannel)
-			channelRoute.POST("/batch", controller.DeleteChannelBatch)
-			channelRoute.POST("/fix", controller.FixChannelsAbilities)
-			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
-			channelRoute.POST("/fetch_models", controller.FetchModels)
-			channelRoute.POST("/batch/tag", controller.BatchSetChannelTag)
-			channelRoute.GET("/tag/models", controller.GetTagModels)
-			channelRoute.POST("/copy/:id", controller.CopyChannel)
-			channelRoute.POST("/multi_key/manage", controller.ManageMultiKeys)
+			channelRoute.GET("/", middleware.PermissionAuth(constant.PermissionChannelRead), controller.GetAllChannels)
+			channelRoute.GET("/search", middleware.PermissionAuth(constant.PermissionChannelRead), controller.SearchChannels)
+			channelRoute.GET("/models", middleware.PermissionAuth(constant.PermissionChannelRead), controller.ChannelListModels)
+			channelRoute.GET("/models_enabled", middleware.PermissionAuth(constant.PermissionChannelRead), controller.EnabledListModels)
+			channelRoute.GET("/:id", middleware.PermissionAuth(constant.PermissionChannelRead), controller.GetChannel)
+			channelRoute.POST("/:id/key", middleware.PermissionAuth(constant.PermissionChannelKey), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
+			channelRoute.GET("/test", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.TestAllChannels)
+			channelRoute.GET("/test/:id", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.TestChannel)
+			channelRoute.GET("/update_balance", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.UpdateAllChannelsBalance)
+			channelRoute.GET("/update_balance/:id", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.UpdateChannelBalance)
+			channelRoute.POST("/", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.AddChannel)
+			channelRoute.PUT("/", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.UpdateChannel)
+			channelRoute.DELETE("/disabled", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.DeleteDisabledChannel)
+			channelRoute.POST("/tag/disabled", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.DisableTagChannels)
+			channelRoute.POST("/tag/enabled", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.EnableTagChannels)
+			channelRoute.PUT("/tag", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.EditTagChannels)
+			channelRoute.DELETE("/:id", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.DeleteChannel)
+			channelRoute.POST("/batch", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.DeleteChannelBatch)
+			channelRoute.POST("/fix", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.FixChannelsAbilities)
+			channelRoute.GET("/fetch_models/:id", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.FetchUpstreamModels)
+			channelRoute.POST("/fetch_models", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.FetchModels)
+			channelRoute.POST("/batch/tag", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.BatchSetChannelTag)
+			channelRoute.GET("/tag/models", middleware.PermissionAuth(constant.PermissionChannelRead), controller.GetTagModels)
+			channelRoute.POST("/copy/:id", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.CopyChannel)
+			channelRoute.POST("/multi_key/manage", middleware.PermissionAuth(constant.PermissionChannelWrite), controller.ManageMultiKeys)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -168,6 +184,48 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
 		}
+		organizationRoute := apiRouter.Group("/organization")
+		{
+			organizationRoute.GET("/", middleware.PermissionAuth(constant.PermissionUserRead), controller.GetAllOrganizations)
+			organizationRoute.PUT("/", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.AdminUpdateOrganization)
+			organizationRoute.GET("/self", middleware.UserAuth(), controller.GetSelfOrganizations)
+			organizationRoute.GET("/self/invitation", middleware.UserAuth(), controller.GetSelfOrganizationInvitations)
+			organizationRoute.POST("/", middleware.UserAuth(), controller.CreateOrganization)
+			organizationRoute.GET("/:id", middleware.UserAuth(), controller.GetOrganization)
+			organizationRoute.PUT("/:id", middleware.UserAuth(), controller.UpdateOrganization)
+			organizationRoute.DELETE("/:id", middleware.UserAuth(), controller.DeleteOrganization)
+			organizationRoute.POST("/:id/accept", middleware.UserAuth(), controller.AcceptOrganizationInvitation)
+			organizationRoute.POST("/:id/decline", middleware.UserAuth(), controller.DeclineOrganizationInvitation)
+			organizationRoute.GET("/:id/member", middleware.UserAuth(), controller.GetOrganizationMembers)
+			organizationRoute.POST("/:id/member", middleware.UserAuth(), controller.AddOrganizationMember)
+			organizationRoute.PUT("/:id/member", middleware.UserAuth(), controller.UpdateOrganizationMember)
+			organizationRoute.DELETE("/:id/member/:user_id", middleware.UserAuth(), controller.RemoveOrganizationMember)
+			organizationRoute.POST("/:id/fund", middleware.UserAuth(), controller.FundOrganization)
+			organizationRoute.GET("/:id/token", middleware.UserAuth(), controller.GetOrganizationTokens)
+			organizationRoute.DELETE("/:id/token/:token_id", middleware.UserAuth(), controller.DeleteOrganizationToken)
+		}
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+			subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+			subscriptionRoute.POST("/self/change", middleware.UserAuth(), controller.ChangeSelfSubscription)
+			subscriptionRoute.POST("/self/cancel", middleware.UserAuth(), controller.CancelSelfSubscription)
+			subscriptionRoute.POST("/stripe/pay", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.RequestStripeSubscription)
+			subscriptionRoute.GET("/plan/all", middleware.PermissionAuth(constant.PermissionBillingRead), controller.GetAllSubscriptionPlans)
+			subscriptionRoute.POST("/plan", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.AddSubscriptionPlan)
+			subscriptionRoute.PUT("/plan", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.UpdateSubscriptionPlan)
+			subscriptionRoute.DELETE("/plan/:id", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.DeleteSubscriptionPlan)
+			subscriptionRoute.GET("/", middleware.PermissionAuth(constant.PermissionBillingRead), controller.GetAllSubscriptions)
+			subscriptionRoute.POST("/grant", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.GrantSubscription)
+			subscriptionRoute.POST("/:id/cancel", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.AdminCancelSubscription)
+		}
+
+		billingRoute := apiRouter.Group("/billing")
+		{
+			billingRoute.GET("/statements/:period", middleware.PermissionAuth(constant.PermissionBillingRead), controller.GetBillingStatement)
+			billingRoute.POST("/statements/generate", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.GenerateBillingStatements)
+		}
 
 		usageRoute := apiRouter.Group("/usage")
 		usageRoute.Use(middleware.CriticalRateLimit())
@@ -180,27 +238,32 @@ func SetApiRouter(router *gin.Engine) {
 		}
 
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
 		{
-			redemptionRoute.GET("/", controller.GetAllRedemptions)
-			redemptionRoute.GET("/search", controller.SearchRedemptions)
-			redemptionRoute.GET("/:id", controller.GetRedemption)
-			redemptionRoute.POST("/", controller.AddRedemption)
-			redemptionRoute.PUT("/", controller.UpdateRedemption)
-			redemptionRoute.DELETE("/invalid", controller.DeleteInvalidRedemption)
-			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
+			redemptionRoute.GET("/", middleware.PermissionAuth(constant.PermissionBillingRead), controller.GetAllRedemptions)
+			redemptionRoute.GET("/search", middleware.PermissionAuth(constant.PermissionBillingRead), controller.SearchRedemptions)
+			redemptionRoute.GET("/:id", middleware.PermissionAuth(constant.PermissionBillingRead), controller.GetRedemption)
+			redemptionRoute.POST("/", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.AddRedemption)
+			redemptionRoute.PUT("/", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.UpdateRedemption)
+			redemptionRoute.DELETE("/invalid", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.DeleteInvalidRedemption)
+			redemptionRoute.DELETE("/:id", middleware.PermissionAuth(constant.PermissionBillingWrite), controller.DeleteRedemption)
 		}
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/", middleware.PermissionAuth(constant.PermissionLogRead), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.PermissionAuth(constant.PermissionLogWrite), controller.DeleteHistoryLogs)
+		logRoute.GET("/stat", middleware.PermissionAuth(constant.PermissionLogRead), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/search", middleware.PermissionAuth(constant.PermissionLogRead), controller.SearchAllLogs)
+		logRoute.GET("/capture/:request_id", middleware.PermissionAuth(constant.PermissionLogRead), controller.GetLogCaptures)
+		logRoute.GET("/archive", middleware.PermissionAuth(constant.PermissionLogRead), controller.ListLogArchives)
+		logRoute.POST("/archive/run", middleware.PermissionAuth(constant.PermissionLogWrite), controller.RunLogArchive)
+		logRoute.POST("/archive/restore", middleware.PermissionAuth(constant.PermissionLogWrite), controller.RestoreLogArchives)
+		logRoute.GET("/archive/query", middleware.PermissionAuth(constant.PermissionLogRead), controller.QueryLogArchives)
+		logRoute.GET("/archive/export", middleware.PermissionAuth(constant.PermissionLogRead), controller.ExportLogArchives)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
 		dataRoute := apiRouter.Group("/data")
-		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
+		dataRoute.GET("/", middleware.PermissionAuth(constant.PermissionLogRead), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
 
 		logRoute.Use(middleware.CORS())
@@ -208,53 +271,49 @@ func SetApiRouter(router *gin.Engine) {
 			logRoute.GET("/token", controller.GetLogByKey)
 		}
 		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
 		{
-			groupRoute.GET("/", controller.GetGroups)
+			groupRoute.GET("/", middleware.PermissionAuth(constant.PermissionChannelRead, constant.PermissionUserRead, constant.PermissionModelRead, constant.PermissionBillingRead), controller.GetGroups)
 		}
 
 		prefillGroupRoute := apiRouter.Group("/prefill_group")
-		prefillGroupRoute.Use(middleware.AdminAuth())
 		{
-			prefillGroupRoute.GET("/", controller.GetPrefillGroups)
-			prefillGroupRoute.POST("/", controller.CreatePrefillGroup)
-			prefillGroupRoute.PUT("/", controller.UpdatePrefillGroup)
-			prefillGroupRoute.DELETE("/:id", controller.DeletePrefillGroup)
+			prefillGroupRoute.GET("/", middleware.PermissionAuth(constant.PermissionModelRead), controller.GetPrefillGroups)
+			prefillGroupRoute.POST("/", middleware.PermissionAuth(constant.PermissionModelWrite), controller.CreatePrefillGroup)
+			prefillGroupRoute.PUT("/", middleware.PermissionAuth(constant.PermissionModelWrite), controller.UpdatePrefillGroup)
+			prefillGroupRoute.DELETE("/:id", middleware.PermissionAuth(constant.PermissionModelWrite), controller.DeletePrefillGroup)
 		}
 
 		mjRoute := apiRouter.Group("/mj")
 		mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
-		mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+		mjRoute.GET("/", middleware.PermissionAuth(constant.PermissionLogRead), controller.GetAllMidjourney)
 
 		taskRoute := apiRouter.Group("/task")
 		{
 			taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
-			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
+			taskRoute.GET("/", middleware.PermissionAuth(constant.PermissionLogRead), controller.GetAllTask)
 		}
 
 		vendorRoute := apiRouter.Group("/vendors")
-		vendorRoute.Use(middleware.AdminAuth())
 		{
-			vendorRoute.GET("/", controller.GetAllVendors)
-			vendorRoute.GET("/search", controller.SearchVendors)
-			vendorRoute.GET("/:id", controller.GetVendorMeta)
-			vendorRoute.POST("/", controller.CreateVendorMeta)
-			vendorRoute.PUT("/", controller.UpdateVendorMeta)
-			vendorRoute.DELETE("/:id", controller.DeleteVendorMeta)
+			vendorRoute.GET("/", middleware.PermissionAuth(constant.PermissionModelRead), controller.GetAllVendors)
+			vendorRoute.GET("/search", middleware.PermissionAuth(constant.PermissionModelRead), controller.SearchVendors)
+			vendorRoute.GET("/:id", middleware.PermissionAuth(constant.PermissionModelRead), controller.GetVendorMeta)
+			vendorRoute.POST("/", middleware.PermissionAuth(constant.PermissionModelWrite), controller.CreateVendorMeta)
+			vendorRoute.PUT("/", middleware.PermissionAuth(constant.PermissionModelWrite), controller.UpdateVendorMeta)
+			vendorRoute.DELETE("/:id", middleware.PermissionAuth(constant.PermissionModelWrite), controller.DeleteVendorMeta)
 		}
 
 		modelsRoute := apiRouter.Group("/models")
-		modelsRoute.Use(middleware.AdminAuth())
 		{
-			modelsRoute.GET("/sync_upstream/preview", controller.SyncUpstreamPreview)
-			modelsRoute.POST("/sync_upstream", controller.SyncUpstreamModels)
-			modelsRoute.GET("/missing", controller.GetMissingModels)
-			modelsRoute.GET("/", controller.GetAllModelsMeta)
-			modelsRoute.GET("/search", controller.SearchModelsMeta)
-			modelsRoute.GET("/:id", controller.GetModelMeta)
-			modelsRoute.POST("/", controller.CreateModelMeta)
-			modelsRoute.PUT("/", controller.UpdateModelMeta)
-			modelsRoute.DELETE("/:id", controller.DeleteModelMeta)
+			modelsRoute.GET("/sync_upstream/preview", middleware.PermissionAuth(constant.PermissionModelRead), controller.SyncUpstreamPreview)
+			modelsRoute.POST("/sync_upstream", middleware.PermissionAuth(constant.PermissionModelWrite), controller.SyncUpstreamModels)
+			modelsRoute.GET("/missing", middleware.PermissionAuth(constant.PermissionModelRead), controller.GetMissingModels)
+			modelsRoute.GET("/", middleware.PermissionAuth(constant.PermissionModelRead), controller.GetAllModelsMeta)
+			modelsRoute.GET("/search", middleware.PermissionAuth(constant.PermissionModelRead), controller.SearchModelsMeta)
+			modelsRoute.GET("/:id", middleware.PermissionAuth(constant.PermissionModelRead), controller.GetModelMeta)
+			modelsRoute.POST("/", middleware.PermissionAuth(constant.PermissionModelWrite), controller.CreateModelMeta)
+			modelsRoute.PUT("/", middleware.PermissionAuth(constant.PermissionModelWrite), controller.UpdateModelMeta)
+			modelsRoute.DELETE("/:id", middleware.PermissionAuth(constant.PermissionModelWrite), controller.DeleteModelMeta)
 		}
 	}
 }
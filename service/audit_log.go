@@ -0,0 +1,194 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const auditMaskedValue = "******"
+
+// AuditFieldChange 审计日志中单个字段的变化
+type AuditFieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// isAuditSensitiveField 判断字段是否为密钥、令牌、密码等敏感字段，与配置接口隐藏敏感配置项的规则一致
+// 渠道的请求头覆盖通常包含鉴权信息，同样脱敏
+func isAuditSensitiveField(field string) bool {
+	lower := strings.ToLower(field)
+	return strings.HasSuffix(lower, "key") || strings.HasSuffix(lower, "token") ||
+		strings.HasSuffix(lower, "secret") || strings.Contains(lower, "password") ||
+		lower == "header_override"
+}
+
+func maskAuditValue(value any) any {
+	if value == nil || value == "" {
+		return value
+	}
+	return auditMaskedValue
+}
+
+// maskAuditNested 递归脱敏对象、数组以及 JSON 字符串中的敏感字段，如渠道设置中的密钥
+func maskAuditNested(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		masked := make(map[string]any, len(v))
+		for field, fieldValue := range v {
+			if isAuditSensitiveField(field) {
+				masked[field] = maskAuditValue(fieldValue)
+			} else {
+				masked[field] = maskAuditNested(fieldValue)
+			}
+		}
+		return masked
+	case []any:
+		masked := make([]any, len(v))
+		for i, item := range v {
+			masked[i] = maskAuditNested(item)
+		}
+		return masked
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+			return v
+		}
+		var parsed any
+		if common.UnmarshalJsonStr(trimmed, &parsed) != nil {
+			return v
+		}
+		masked := maskAuditNested(parsed)
+		if reflect.DeepEqual(masked, parsed) {
+			return v
+		}
+		return common.GetJsonString(masked)
+	default:
+		return value
+	}
+}
+
+// AuditSnapshot 将结构体或 map 转换为按 JSON 字段名索引的 map，其他类型放在 value 字段下
+// 会被原地修改的对象应在修改前先生成快照再传给 RecordAudit
+func AuditSnapshot(value any) map[string]any {
+	fields := map[string]any{}
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return fields
+	}
+	data, err := common.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	if err := common.Unmarshal(data, &fields); err != nil {
+		var raw any
+		_ = common.Unmarshal(data, &raw)
+		return map[string]any{"value": raw}
+	}
+	return fields
+}
+
+// BuildAuditDiff 对比变更前后的字段，只保留发生变化的字段，敏感字段只记录是否变化
+// before 为 nil 表示新建，after 为 nil 表示删除
+func BuildAuditDiff(before any, after any) map[string]*AuditFieldChange {
+	beforeFields := AuditSnapshot(before)
+	afterFields := AuditSnapshot(after)
+	diff := make(map[string]*AuditFieldChange)
+	for field, value := range beforeFields {
+		if afterValue, ok := afterFields[field]; !ok || !reflect.DeepEqual(value, afterValue) {
+			diff[field] = &AuditFieldChange{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			diff[field] = &AuditFieldChange{After: value}
+		}
+	}
+	for field, change := range diff {
+		if isAuditSensitiveField(field) {
+			change.Before = maskAuditValue(change.Before)
+			change.After = maskAuditValue(change.After)
+		} else {
+			change.Before = maskAuditNested(change.Before)
+			change.After = maskAuditNested(change.After)
+		}
+	}
+	return diff
+}
+
+// RecordAudit 记录一次管理操作，操作人和 IP 从请求上下文中获取
+// 变更前后没有差异时不记录；写入失败只记录系统日志，不影响已完成的操作
+func RecordAudit(c *gin.Context, action string, targetType string, targetId any, before any, after any) {
+	diff := BuildAuditDiff(before, after)
+	if len(diff) == 0 && before != nil && after != nil {
+		return
+	}
+	log := &model.AuditLog{
+		UserId:     c.GetInt("id"),
+		Username:   c.GetString("username"),
+		Ip:         c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   fmt.Sprintf("%v", targetId),
+		Diff:       common.GetJsonString(diff),
+	}
+	if err := model.CreateAuditLog(log); err != nil {
+		common.SysError(fmt.Sprintf("failed to record audit log %s %s %v: %s", action, targetType, targetId, err.Error()))
+	}
+}
+
+// RecordOptionAudit 记录配置项的修改，值为 JSON 对象的配置（如模型倍率）按对象中的键记录差异
+func RecordOptionAudit(c *gin.Context, key string, before string, after string) {
+	if !isAuditSensitiveField(key) {
+		var beforeObject, afterObject map[string]any
+		if common.UnmarshalJsonStr(before, &beforeObject) == nil && common.UnmarshalJsonStr(after, &afterObject) == nil &&
+			beforeObject != nil && afterObject != nil {
+			RecordAudit(c, "option.update", "option", key, beforeObject, afterObject)
+			return
+		}
+	}
+	RecordAudit(c, "option.update", "option", key, map[string]any{key: before}, map[string]any{key: after})
+}
+
+// ExportAuditLogs 以 CSV 导出审计日志，包含序号和哈希，持有 CRYPTO_SECRET 时导出文件可以脱离系统独立校验哈希链
+func ExportAuditLogs(filter *model.AuditLogFilter, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"seq", "created_at", "user_id", "username", "ip", "action", "target_type", "target_id", "diff", "prev_hash", "hash"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	err := model.FindAuditLogsInBatches(filter, 1000, func(logs []*model.AuditLog) error {
+		for _, log := range logs {
+			row := []string{
+				strconv.FormatInt(log.Seq, 10),
+				strconv.FormatInt(log.CreatedAt, 10),
+				strconv.Itoa(log.UserId),
+				log.Username,
+				log.Ip,
+				log.Action,
+				log.TargetType,
+				log.TargetId,
+				log.Diff,
+				log.PrevHash,
+				log.Hash,
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
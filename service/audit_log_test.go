@@ -0,0 +1,34 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestBuildAuditDiffMasksNestedFields(t *testing.T) {
+	before := map[string]any{
+		"name":     "channel",
+		"key":      "sk-old",
+		"settings": map[string]any{"api_key": "sk-a", "region": "us"},
+		"setting":  `{"vertex_key":"secret","proxy":"http://a"}`,
+	}
+	after := map[string]any{
+		"name":     "channel2",
+		"key":      "sk-new",
+		"settings": map[string]any{"api_key": "sk-b", "region": "eu"},
+		"setting":  `{"vertex_key":"secret2","proxy":"http://b"}`,
+	}
+	diff := common.GetJsonString(BuildAuditDiff(before, after))
+	for _, secret := range []string{"sk-old", "sk-new", "sk-a", "sk-b", "secret"} {
+		if strings.Contains(diff, secret) {
+			t.Fatalf("diff leaks %q: %s", secret, diff)
+		}
+	}
+	for _, value := range []string{"channel2", "eu", "http://b"} {
+		if !strings.Contains(diff, value) {
+			t.Fatalf("diff misses %q: %s", value, diff)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/tidwall/gjson"
+)
+
+// IsBatchSupportedChannel 判断渠道是否原生支持 OpenAI Batch API
+func IsBatchSupportedChannel(channelType int) bool {
+	return IsFileUploadSupportedChannel(channelType)
+}
+
+// GenerateBatchStorageKey 生成本地批处理中间结果在对象存储中的路径
+func GenerateBatchStorageKey(userId int, batchId string, name string) string {
+	return fmt.Sprintf("batches/%d/%s/%s", userId, batchId, name)
+}
+
+func parseUpstreamBatch(respBody []byte) (*dto.OpenAIBatch, error) {
+	var batch dto.OpenAIBatch
+	if err := common.Unmarshal(respBody, &batch); err != nil {
+		return nil, err
+	}
+	if batch.ID == "" {
+		return nil, fmt.Errorf("upstream returned empty batch id: %s", string(respBody))
+	}
+	return &batch, nil
+}
+
+// CreateUpstreamBatch 在渠道上创建批处理，keyIndex 需与输入文件上传时所用的密钥一致
+func CreateUpstreamBatch(ctx context.Context, channel *model.Channel, keyIndex int, request *dto.OpenAIBatchRequest) (*dto.OpenAIBatch, error) {
+	body, err := common.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := doFileChannelRequest(ctx, channel, keyIndex, http.MethodPost, "/v1/batches", bytes.NewReader(body), "application/json")
+	if err != nil {
+		return nil, err
+	}
+	return parseUpstreamBatch(respBody)
+}
+
+// RetrieveUpstreamBatch 查询上游批处理状态
+func RetrieveUpstreamBatch(ctx context.Context, channel *model.Channel, keyIndex int, upstreamBatchId string) (*dto.OpenAIBatch, error) {
+	respBody, err := doFileChannelRequest(ctx, channel, keyIndex, http.MethodGet, "/v1/batches/"+upstreamBatchId, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return parseUpstreamBatch(respBody)
+}
+
+// CancelUpstreamBatch 取消上游批处理
+func CancelUpstreamBatch(ctx context.Context, channel *model.Channel, keyIndex int, upstreamBatchId string) (*dto.OpenAIBatch, error) {
+	respBody, err := doFileChannelRequest(ctx, channel, keyIndex, http.MethodPost, "/v1/batches/"+upstreamBatchId+"/cancel", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return parseUpstreamBatch(respBody)
+}
+
+// ParseBatchInput 解析批处理输入文件，逐行校验格式，返回解析后的请求
+func ParseBatchInput(data []byte, endpoint string, maxRequests int) ([]dto.BatchRequestLine, error) {
+	lines := make([]dto.BatchRequestLine, 0)
+	seen := make(map[string]bool)
+	for i, raw := range bytes.Split(data, []byte("\n")) {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		var line dto.BatchRequestLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			return nil, fmt.Errorf("line %d: invalid json: %s", i+1, err.Error())
+		}
+		if line.CustomID == "" {
+			return nil, fmt.Errorf("line %d: custom_id is required", i+1)
+		}
+		if seen[line.CustomID] {
+			return nil, fmt.Errorf("line %d: duplicate custom_id %s", i+1, line.CustomID)
+		}
+		seen[line.CustomID] = true
+		if line.Method != "" && line.Method != http.MethodPost {
+			return nil, fmt.Errorf("line %d: method must be POST", i+1)
+		}
+		if line.URL != endpoint {
+			return nil, fmt.Errorf("line %d: url %s does not match batch endpoint %s", i+1, line.URL, endpoint)
+		}
+		if len(line.Body) == 0 || common.GetJsonType(line.Body) != "object" {
+			return nil, fmt.Errorf("line %d: body must be a json object", i+1)
+		}
+		if gjson.GetBytes(line.Body, "stream").Bool() {
+			return nil, fmt.Errorf("line %d: stream is not supported in batch requests", i+1)
+		}
+		lines = append(lines, line)
+		if maxRequests > 0 && len(lines) > maxRequests {
+			return nil, fmt.Errorf("batch exceeds the maximum of %d requests", maxRequests)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("input file contains no requests")
+	}
+	return lines, nil
+}
+
+// EncodeBatchLines 将批处理结果编码为 JSONL
+func EncodeBatchLines(lines []dto.BatchResponseLine) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		b, err := common.Marshal(line)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeBatchLines 解析 JSONL 格式的批处理结果，无法解析的行会被忽略
+func DecodeBatchLines(data []byte) []dto.BatchResponseLine {
+	lines := make([]dto.BatchResponseLine, 0)
+	for _, raw := range bytes.Split(data, []byte("\n")) {
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 {
+			continue
+		}
+		var line dto.BatchResponseLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// SaveBatchOutputFile 将批处理产生的结果保存为 batch_output 文件
+func SaveBatchOutputFile(ctx context.Context, userId int, tokenId int, filename string, data []byte) (*model.File, error) {
+	file := &model.File{
+		FileId:      "file-" + common.GetRandomString(24),
+		UserId:      userId,
+		TokenId:     tokenId,
+		Filename:    filename,
+		Purpose:     dto.FilePurposeBatchOut,
+		Bytes:       int64(len(data)),
+		ContentType: "application/jsonl",
+		Status:      model.FileStatusProcessed,
+		CreatedAt:   common.GetTimestamp(),
+	}
+	storageType, storageKey, err := SaveFileContent(ctx, userId, file.FileId, data, file.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	file.StorageType = storageType
+	file.StorageKey = storageKey
+	if err = file.Insert(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"gorm.io/gorm"
+)
+
+const billingStatementPeriodLayout = "2006-01"
+
+var billingStatementGenerating atomic.Bool
+
+// ParseBillingStatementPeriod 解析账单月份（YYYY-MM），返回服务器时区下该月的起止时间
+func ParseBillingStatementPeriod(period string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(billingStatementPeriodLayout, period, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("账单月份格式应为 YYYY-MM")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// PreviousBillingStatementPeriod 返回上一个自然月的账单月份
+func PreviousBillingStatementPeriod(now time.Time) string {
+	return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location()).Format(billingStatementPeriodLayout)
+}
+
+type billingTotals struct {
+	TopUpQuota      int
+	TopUpMoney      float64
+	RedemptionQuota int
+	RefundQuota     int
+	ConsumedQuota   int
+}
+
+// net 返回期间内可以从账务记录中确定的余额变化
+func (t *billingTotals) net() int {
+	return t.TopUpQuota + t.RedemptionQuota + t.RefundQuota - t.ConsumedQuota
+}
+
+func sumBillingDetail(detail *model.BillingStatementDetail) *billingTotals {
+	totals := &billingTotals{}
+	for _, item := range detail.TopUps {
+		totals.TopUpQuota += item.Quota
+		totals.TopUpMoney += item.Money
+	}
+	for _, item := range detail.Redemptions {
+		totals.RedemptionQuota += item.Quota
+	}
+	for _, item := range detail.Refunds {
+		totals.RefundQuota += item.Quota
+	}
+	for _, item := range detail.ConsumeByModel {
+		totals.ConsumedQuota += item.Quota
+	}
+	return totals
+}
+
+// groupBillingConsumeItems 将按模型和令牌汇总的消费合并为按模型或按令牌汇总，按消费额度从高到低排序
+func groupBillingConsumeItems(items []*model.BillingConsumeItem, byToken bool) []*model.BillingConsumeItem {
+	grouped := make(map[string]*model.BillingConsumeItem)
+	result := make([]*model.BillingConsumeItem, 0)
+	for _, item := range items {
+		name := item.ModelName
+		if byToken {
+			name = item.TokenName
+		}
+		group, ok := grouped[name]
+		if !ok {
+			group = &model.BillingConsumeItem{ModelName: item.ModelName}
+			if byToken {
+				group = &model.BillingConsumeItem{TokenName: item.TokenName}
+			}
+			grouped[name] = group
+			result = append(result, group)
+		}
+		group.Count += item.Count
+		group.Quota += item.Quota
+		group.PromptTokens += item.PromptTokens
+		group.CompletionTokens += item.CompletionTokens
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Quota > result[j].Quota
+	})
+	return result
+}
+
+// loadBillingDetail 汇总时间范围内的充值、兑换码、退款和消费
+func loadBillingDetail(ownerType string, ownerId int, startTime int64, endTime int64) (*model.BillingStatementDetail, error) {
+	detail := &model.BillingStatementDetail{
+		TopUps:         []*model.BillingTopUpItem{},
+		Redemptions:    []*model.BillingRedemptionItem{},
+		ConsumeSource:  model.BillingConsumeSourceLogs,
+		ConsumeByToken: []*model.BillingConsumeItem{},
+	}
+	var err error
+	if ownerType == model.BillingStatementOwnerUser {
+		if detail.TopUps, err = model.GetBillingTopUps(ownerId, startTime, endTime); err != nil {
+			return nil, err
+		}
+		if detail.Redemptions, err = model.GetBillingRedemptions(ownerId, startTime, endTime); err != nil {
+			return nil, err
+		}
+	}
+	if detail.Refunds, err = model.GetBillingRefunds(ownerType, ownerId, startTime, endTime); err != nil {
+		return nil, err
+	}
+	if detail.Refunds == nil {
+		detail.Refunds = []*model.BillingRefundItem{}
+	}
+	items, err := model.GetBillingConsumeItems(ownerType, ownerId, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 && ownerType == model.BillingStatementOwnerUser && !common.LogConsumeEnabled {
+		// 未开启消费日志时只能使用数据看板的按模型汇总
+		if items, err = model.GetBillingQuotaDataItems(ownerId, startTime, endTime); err != nil {
+			return nil, err
+		}
+		detail.ConsumeSource = model.BillingConsumeSourceQuotaData
+	}
+	detail.ConsumeByModel = groupBillingConsumeItems(items, false)
+	if detail.ConsumeSource == model.BillingConsumeSourceLogs {
+		detail.ConsumeByToken = groupBillingConsumeItems(items, true)
+	}
+	return detail, nil
+}
+
+func getBillingOwner(ownerType string, ownerId int) (name string, balance int, err error) {
+	switch ownerType {
+	case model.BillingStatementOwnerUser:
+		user, err := model.GetUserById(ownerId, false)
+		if err != nil {
+			return "", 0, err
+		}
+		return user.Username, user.Quota, nil
+	case model.BillingStatementOwnerOrganization:
+		organization, err := model.GetOrganizationById(ownerId)
+		if err != nil {
+			return "", 0, err
+		}
+		return organization.Name, organization.Quota, nil
+	}
+	return "", 0, fmt.Errorf("未知的账单主体类型：%s", ownerType)
+}
+
+// GetOrCreateBillingStatement 获取账单，不存在时根据日志、充值订单、兑换码和数据看板生成并保存
+// 期末余额由当前余额减去账单月份结束后的账务变化得到；期初余额衔接上一期账单的期末余额，
+// 无法从账务记录中解释的差额计入其他调整。没有上一期账单时期初余额按本期账务记录倒推
+func GetOrCreateBillingStatement(ownerType string, ownerId int, period string) (*model.BillingStatement, error) {
+	statement, err := model.GetBillingStatement(ownerType, ownerId, period)
+	if err == nil {
+		return statement, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	start, end, err := ParseBillingStatementPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if end.After(now) {
+		return nil, errors.New("账单月份尚未结束")
+	}
+	name, balance, err := getBillingOwner(ownerType, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	detail, err := loadBillingDetail(ownerType, ownerId, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	after, err := loadBillingDetail(ownerType, ownerId, end.Unix(), now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	totals := sumBillingDetail(detail)
+	closing := balance - sumBillingDetail(after).net()
+	opening := closing - totals.net()
+	adjustment := 0
+	if previous, err := model.GetPreviousBillingStatement(ownerType, ownerId, start.Unix()); err == nil {
+		opening = previous.ClosingBalance
+		adjustment = closing - opening - totals.net()
+	}
+	detailJson, err := common.Marshal(detail)
+	if err != nil {
+		return nil, err
+	}
+	return model.CreateBillingStatement(&model.BillingStatement{
+		OwnerType:       ownerType,
+		OwnerId:         ownerId,
+		OwnerName:       name,
+		Period:          period,
+		PeriodStart:     start.Unix(),
+		PeriodEnd:       end.Unix(),
+		OpeningBalance:  opening,
+		TopUpQuota:      totals.TopUpQuota,
+		TopUpMoney:      totals.TopUpMoney,
+		RedemptionQuota: totals.RedemptionQuota,
+		RefundQuota:     totals.RefundQuota,
+		ConsumedQuota:   totals.ConsumedQuota,
+		AdjustmentQuota: adjustment,
+		ClosingBalance:  closing,
+		Detail:          string(detailJson),
+	})
+}
+
+// GetBillingStatementDetail 解析账单明细
+func GetBillingStatementDetail(statement *model.BillingStatement) (*model.BillingStatementDetail, error) {
+	detail := &model.BillingStatementDetail{}
+	if statement.Detail == "" {
+		return detail, nil
+	}
+	err := common.UnmarshalJsonStr(statement.Detail, detail)
+	return detail, err
+}
+
+func IsBillingStatementGenerating() bool {
+	return billingStatementGenerating.Load()
+}
+
+// GenerateBillingStatements 为指定月份有账务往来的用户和所有组织生成账单，已生成的账单不会重复生成
+func GenerateBillingStatements(period string) (int, error) {
+	if !billingStatementGenerating.CompareAndSwap(false, true) {
+		return 0, errors.New("账单正在生成中")
+	}
+	defer billingStatementGenerating.Store(false)
+
+	start, end, err := ParseBillingStatementPeriod(period)
+	if err != nil {
+		return 0, err
+	}
+	userIds, err := model.GetBillingActiveUserIds(start.Unix(), end.Unix())
+	if err != nil {
+		return 0, err
+	}
+	organizationIds, err := model.GetBillingOrganizationIds(end.Unix())
+	if err != nil {
+		return 0, err
+	}
+	generated := 0
+	generate := func(ownerType string, ownerId int) {
+		if _, err := model.GetBillingStatement(ownerType, ownerId, period); err == nil {
+			return
+		}
+		if _, err := GetOrCreateBillingStatement(ownerType, ownerId, period); err != nil {
+			common.SysError(fmt.Sprintf("failed to generate billing statement %s for %s %d: %s", period, ownerType, ownerId, err.Error()))
+			return
+		}
+		generated++
+	}
+	for _, userId := range userIds {
+		generate(model.BillingStatementOwnerUser, userId)
+	}
+	for _, organizationId := range organizationIds {
+		generate(model.BillingStatementOwnerOrganization, organizationId)
+	}
+	return generated, nil
+}
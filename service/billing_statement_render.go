@@ -0,0 +1,197 @@
+package service
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+type billingStatementView struct {
+	*model.BillingStatement
+	Detail     *model.BillingStatementDetail
+	IssuerName string
+	IssuerInfo string
+	Verified   bool
+}
+
+var billingStatementTemplate = template.Must(template.New("statement").Funcs(template.FuncMap{
+	"quota": logger.FormatQuota,
+	"time":  formatBillingTime,
+	"date": func(timestamp int64) string {
+		return time.Unix(timestamp, 0).Format("2006-01-02")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>账单 {{.Period}} - {{.OwnerName}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; color: #222; max-width: 960px; margin: 32px auto; padding: 0 16px; }
+h1 { font-size: 22px; margin-bottom: 4px; }
+h2 { font-size: 16px; margin-top: 28px; border-bottom: 1px solid #ddd; padding-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; font-size: 13px; }
+th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #eee; }
+td.num, th.num { text-align: right; font-variant-numeric: tabular-nums; }
+.meta { color: #666; font-size: 13px; }
+.total td { font-weight: 600; }
+.hash { font-family: monospace; font-size: 11px; color: #888; word-break: break-all; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>账单 {{.Period}}</h1>
+<div class="meta">
+{{if .IssuerName}}<div>开具方：{{.IssuerName}}</div>{{end}}
+{{if .IssuerInfo}}<div>{{.IssuerInfo}}</div>{{end}}
+<div>{{if eq .OwnerType "organization"}}组织{{else}}用户{{end}}：{{.OwnerName}}（ID {{.OwnerId}}）</div>
+<div>账单周期：{{date .PeriodStart}} 至 {{date .PeriodEnd}}（不含）</div>
+<div>生成时间：{{time .CreatedTime}}</div>
+</div>
+
+<h2>汇总</h2>
+<table>
+<tr><td>期初余额</td><td class="num">{{quota .OpeningBalance}}</td></tr>
+<tr><td>充值（支付金额 {{printf "%.2f" .TopUpMoney}}）</td><td class="num">{{quota .TopUpQuota}}</td></tr>
+<tr><td>兑换码</td><td class="num">{{quota .RedemptionQuota}}</td></tr>
+<tr><td>退款</td><td class="num">{{quota .RefundQuota}}</td></tr>
+<tr><td>消费</td><td class="num">-{{quota .ConsumedQuota}}</td></tr>
+<tr><td>其他调整</td><td class="num">{{quota .AdjustmentQuota}}</td></tr>
+<tr class="total"><td>期末余额</td><td class="num">{{quota .ClosingBalance}}</td></tr>
+</table>
+
+{{if .Detail.TopUps}}
+<h2>充值</h2>
+<table>
+<tr><th>时间</th><th>订单号</th><th>支付方式</th><th class="num">支付金额</th><th class="num">到账额度</th></tr>
+{{range .Detail.TopUps}}<tr><td>{{time .CompleteTime}}</td><td>{{.TradeNo}}</td><td>{{.PaymentMethod}}</td><td class="num">{{printf "%.2f" .Money}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Detail.Redemptions}}
+<h2>兑换码</h2>
+<table>
+<tr><th>时间</th><th>兑换码</th><th class="num">额度</th></tr>
+{{range .Detail.Redemptions}}<tr><td>{{time .RedeemedTime}}</td><td>#{{.Id}} {{.Name}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Detail.Refunds}}
+<h2>退款</h2>
+<table>
+<tr><th>时间</th><th>说明</th><th class="num">额度</th></tr>
+{{range .Detail.Refunds}}<tr><td>{{time .CreatedAt}}</td><td>{{.Content}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>按模型消费{{if eq .Detail.ConsumeSource "quota_data"}}（按数据看板统计，tokens 为总数）{{end}}</h2>
+<table>
+<tr><th>模型</th><th class="num">请求次数</th><th class="num">输入 tokens</th><th class="num">输出 tokens</th><th class="num">消费额度</th></tr>
+{{range .Detail.ConsumeByModel}}<tr><td>{{.ModelName}}</td><td class="num">{{.Count}}</td><td class="num">{{.PromptTokens}}</td><td class="num">{{.CompletionTokens}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{else}}<tr><td colspan="5">无消费记录</td></tr>
+{{end}}</table>
+
+{{if .Detail.ConsumeByToken}}
+<h2>按令牌消费</h2>
+<table>
+<tr><th>令牌</th><th class="num">请求次数</th><th class="num">输入 tokens</th><th class="num">输出 tokens</th><th class="num">消费额度</th></tr>
+{{range .Detail.ConsumeByToken}}<tr><td>{{.TokenName}}</td><td class="num">{{.Count}}</td><td class="num">{{.PromptTokens}}</td><td class="num">{{.CompletionTokens}}</td><td class="num">{{quota .Quota}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<p class="hash">SHA-256：{{.Hash}}{{if not .Verified}}（校验失败，账单内容与生成时不一致）{{end}}</p>
+</body>
+</html>
+`))
+
+func formatBillingTime(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+}
+
+func newBillingStatementView(statement *model.BillingStatement) (*billingStatementView, error) {
+	detail, err := GetBillingStatementDetail(statement)
+	if err != nil {
+		return nil, err
+	}
+	setting := operation_setting.GetBillingStatementSetting()
+	return &billingStatementView{
+		BillingStatement: statement,
+		Detail:           detail,
+		IssuerName:       setting.IssuerName,
+		IssuerInfo:       setting.IssuerInfo,
+		Verified:         statement.Verify(),
+	}, nil
+}
+
+// RenderBillingStatementHTML 以可打印的 HTML 输出账单，可在浏览器中打印为 PDF
+func RenderBillingStatementHTML(statement *model.BillingStatement, w io.Writer) error {
+	view, err := newBillingStatementView(statement)
+	if err != nil {
+		return err
+	}
+	return billingStatementTemplate.Execute(w, view)
+}
+
+// RenderBillingStatementCSV 以 CSV 输出账单，各部分之间以空行分隔，额度为原始额度值
+func RenderBillingStatementCSV(statement *model.BillingStatement, w io.Writer) error {
+	view, err := newBillingStatementView(statement)
+	if err != nil {
+		return err
+	}
+	detail := view.Detail
+	rows := [][]string{
+		{"section", "period", "owner_type", "owner_id", "owner_name", "created_at", "hash"},
+		{"statement", statement.Period, statement.OwnerType, strconv.Itoa(statement.OwnerId), statement.OwnerName,
+			formatBillingTime(statement.CreatedTime), statement.Hash},
+		{},
+		{"section", "item", "quota"},
+		{"summary", "opening_balance", strconv.Itoa(statement.OpeningBalance)},
+		{"summary", "topup", strconv.Itoa(statement.TopUpQuota)},
+		{"summary", "topup_money", fmt.Sprintf("%.2f", statement.TopUpMoney)},
+		{"summary", "redemption", strconv.Itoa(statement.RedemptionQuota)},
+		{"summary", "refund", strconv.Itoa(statement.RefundQuota)},
+		{"summary", "consumed", strconv.Itoa(-statement.ConsumedQuota)},
+		{"summary", "adjustment", strconv.Itoa(statement.AdjustmentQuota)},
+		{"summary", "closing_balance", strconv.Itoa(statement.ClosingBalance)},
+		{},
+		{"section", "time", "trade_no", "payment_method", "money", "quota"},
+	}
+	for _, item := range detail.TopUps {
+		rows = append(rows, []string{"topup", formatBillingTime(item.CompleteTime), item.TradeNo, item.PaymentMethod,
+			fmt.Sprintf("%.2f", item.Money), strconv.Itoa(item.Quota)})
+	}
+	rows = append(rows, []string{}, []string{"section", "time", "redemption_id", "name", "quota"})
+	for _, item := range detail.Redemptions {
+		rows = append(rows, []string{"redemption", formatBillingTime(item.RedeemedTime), strconv.Itoa(item.Id), item.Name,
+			strconv.Itoa(item.Quota)})
+	}
+	rows = append(rows, []string{}, []string{"section", "time", "content", "quota"})
+	for _, item := range detail.Refunds {
+		rows = append(rows, []string{"refund", formatBillingTime(item.CreatedAt), item.Content, strconv.Itoa(item.Quota)})
+	}
+	consumeHeader := []string{"section", "name", "count", "prompt_tokens", "completion_tokens", "quota"}
+	rows = append(rows, []string{}, consumeHeader)
+	for _, item := range detail.ConsumeByModel {
+		rows = append(rows, []string{"consume_by_model", item.ModelName, strconv.Itoa(item.Count),
+			strconv.Itoa(item.PromptTokens), strconv.Itoa(item.CompletionTokens), strconv.Itoa(item.Quota)})
+	}
+	rows = append(rows, []string{}, consumeHeader)
+	for _, item := range detail.ConsumeByToken {
+		rows = append(rows, []string{"consume_by_token", item.TokenName, strconv.Itoa(item.Count),
+			strconv.Itoa(item.PromptTokens), strconv.Itoa(item.CompletionTokens), strconv.Itoa(item.Quota)})
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
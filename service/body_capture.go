@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const bodyCaptureRedacted = "[REDACTED]"
+
+// bodyCaptureRawFactor 采集时原始数据的上限倍数，流式响应按重组后的内容计算大小，脱敏前需要保留更多数据
+const bodyCaptureRawFactor = 4
+
+var (
+	// 无论配置如何都会脱敏的密钥格式
+	bodyCaptureSecretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`sk-[A-Za-z0-9_\-]{20,}`),
+		regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`),
+		regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/\-]+=*`),
+	}
+	bodyCaptureDataURLPattern = regexp.MustCompile(`data:[\w/+.\-]+;base64,[A-Za-z0-9+/=]+`)
+	bodyCapturePatternCache   sync.Map // 正则表达式 -> *regexp.Regexp，无效的表达式缓存为 nil
+)
+
+// bodyCaptureWriter 在写出响应的同时暂存响应体
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	capture *relaycommon.BodyCapture
+}
+
+func (w *bodyCaptureWriter) Write(data []byte) (int, error) {
+	w.capture.AppendResponse(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.capture.AppendResponse([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// StartBodyCapture 当前用户、令牌或分组开启了请求/响应体采集时包装响应写入器，未开启时返回 nil
+func StartBodyCapture(c *gin.Context) *relaycommon.BodyCapture {
+	setting := operation_setting.GetBodyCaptureSetting()
+	userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	if !setting.ShouldCapture(userId, tokenId, group) {
+		return nil
+	}
+	maxBytes := setting.GetMaxBodyBytes() * bodyCaptureRawFactor
+	capture := relaycommon.NewBodyCapture(maxBytes, maxBytes)
+	c.Writer = &bodyCaptureWriter{ResponseWriter: c.Writer, capture: capture}
+	return capture
+}
+
+// SaveBodyCapture 在请求结束后脱敏并异步保存采集到的请求体和响应体
+func SaveBodyCapture(c *gin.Context, capture *relaycommon.BodyCapture) {
+	setting := operation_setting.GetBodyCaptureSetting()
+	requestBody, requestTruncated := capture.RequestBody()
+	responseBody, responseTruncated := capture.ResponseBody()
+	record := &model.LogCapture{
+		RequestId:  c.GetString(common.RequestIdKey),
+		CreatedAt:  common.GetTimestamp(),
+		UserId:     common.GetContextKeyInt(c, constant.ContextKeyUserId),
+		TokenId:    common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		Group:      common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		ModelName:  common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		ChannelId:  common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		StatusCode: c.Writer.Status(),
+		IsStream:   strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream"),
+	}
+	gopool.Go(func() {
+		if record.IsStream {
+			responseBody = reassembleStreamResponse(responseBody)
+		}
+		redactor := newBodyRedactor(setting)
+		maxBytes := setting.GetMaxBodyBytes()
+		var truncated bool
+		record.RequestBody, truncated = truncateCapturedBody(redactor.redact(requestBody), maxBytes)
+		record.RequestTruncated = requestTruncated || truncated
+		record.ResponseBody, truncated = truncateCapturedBody(redactor.redact(responseBody), maxBytes)
+		record.ResponseTruncated = responseTruncated || truncated
+		if err := getBodyCaptureStore(setting).Save(record); err != nil {
+			common.SysError(fmt.Sprintf("failed to save body capture %s: %s", record.RequestId, err.Error()))
+		}
+	})
+}
+
+// bodyRedactor 按配置脱敏字段、个人信息和图片数据
+type bodyRedactor struct {
+	fields        map[string]bool
+	fieldsPattern *regexp.Regexp // 用于无法解析为 JSON 的内容，如被截断的请求体
+	patterns      []*regexp.Regexp
+	hashImages    bool
+}
+
+func newBodyRedactor(setting *operation_setting.BodyCaptureSetting) *bodyRedactor {
+	redactor := &bodyRedactor{
+		fields:     make(map[string]bool, len(setting.RedactFields)),
+		patterns:   append([]*regexp.Regexp{}, bodyCaptureSecretPatterns...),
+		hashImages: setting.HashImages,
+	}
+	quoted := make([]string, 0, len(setting.RedactFields))
+	for _, field := range setting.RedactFields {
+		redactor.fields[strings.ToLower(field)] = true
+		quoted = append(quoted, regexp.QuoteMeta(field))
+	}
+	if len(quoted) > 0 {
+		redactor.fieldsPattern = regexp.MustCompile(`(?i)"(` + strings.Join(quoted, "|") + `)"\s*:\s*"(?:[^"\\]|\\.)*"`)
+	}
+	for _, pattern := range setting.RedactPatterns {
+		if re := getBodyCapturePattern(pattern); re != nil {
+			redactor.patterns = append(redactor.patterns, re)
+		}
+	}
+	return redactor
+}
+
+func getBodyCapturePattern(pattern string) *regexp.Regexp {
+	if cached, ok := bodyCapturePatternCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid body capture redact pattern %q: %s", pattern, err.Error()))
+		re = nil
+	}
+	bodyCapturePatternCache.Store(pattern, re)
+	return re
+}
+
+func (r *bodyRedactor) redact(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !utf8.Valid(body) {
+		// 二进制内容（如音频、multipart 文件）只记录摘要
+		return hashCapturedPayload(string(body))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var data any
+	if err := decoder.Decode(&data); err == nil && !decoder.More() {
+		if out, err := common.Marshal(r.redactValue(data)); err == nil {
+			return string(out)
+		}
+	}
+	return r.redactText(string(body))
+}
+
+func (r *bodyRedactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if r.fields[strings.ToLower(key)] {
+				v[key] = bodyCaptureRedacted
+				continue
+			}
+			v[key] = r.redactValue(item)
+		}
+	case []any:
+		for i := range v {
+			v[i] = r.redactValue(v[i])
+		}
+	case string:
+		if r.hashImages && isBase64Payload(v) {
+			return hashCapturedPayload(v)
+		}
+		return r.redactString(v)
+	}
+	return value
+}
+
+func (r *bodyRedactor) redactText(text string) string {
+	if r.fieldsPattern != nil {
+		text = r.fieldsPattern.ReplaceAllString(text, `"$1":"`+bodyCaptureRedacted+`"`)
+	}
+	return r.redactString(text)
+}
+
+func (r *bodyRedactor) redactString(s string) string {
+	if r.hashImages {
+		s = bodyCaptureDataURLPattern.ReplaceAllStringFunc(s, hashCapturedPayload)
+	}
+	for _, pattern := range r.patterns {
+		s = pattern.ReplaceAllString(s, bodyCaptureRedacted)
+	}
+	return s
+}
+
+// isBase64Payload 判断字符串是否为较长的 base64 数据，如 inline_data、b64_json 中的图片和音频
+func isBase64Payload(s string) bool {
+	if len(s) < 1024 {
+		return false
+	}
+	for i := 0; i < 1024; i++ {
+		ch := s[i]
+		if !(ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '+' || ch == '/' || ch == '=') {
+			return false
+		}
+	}
+	return true
+}
+
+func hashCapturedPayload(s string) string {
+	return fmt.Sprintf("[sha256:%x, %d bytes]", sha256.Sum256([]byte(s)), len(s))
+}
+
+// truncateCapturedBody 按字节数截断，并保证结果是合法的 UTF-8
+func truncateCapturedBody(s string, maxBytes int) (string, bool) {
+	if len(s) <= maxBytes {
+		return s, false
+	}
+	return strings.ToValidUTF8(s[:maxBytes], ""), true
+}
+
+type capturedToolCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// capturedStream 重组后的流式响应
+type capturedStream struct {
+	Stream           bool                `json:"stream"`
+	Events           int                 `json:"events"`
+	Content          string              `json:"content"`
+	ReasoningContent string              `json:"reasoning_content,omitempty"`
+	ToolCalls        []*capturedToolCall `json:"tool_calls,omitempty"`
+	FinishReason     string              `json:"finish_reason,omitempty"`
+	Usage            json.RawMessage     `json:"usage,omitempty"`
+	LastEvent        json.RawMessage     `json:"last_event,omitempty"`
+}
+
+// reassembleStreamResponse 将 SSE 响应重组为完整的内容，支持 OpenAI、Claude、Gemini 和 Responses 格式
+func reassembleStreamResponse(raw []byte) []byte {
+	stream := &capturedStream{Stream: true}
+	var content, reasoning strings.Builder
+	openaiToolCalls := make(map[int64]*capturedToolCall)
+	for _, line := range strings.Split(string(raw), "\n") {
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		data = strings.TrimSpace(data)
+		if !ok || data == "" || data == "[DONE]" || !gjson.Valid(data) {
+			continue
+		}
+		stream.Events++
+		stream.LastEvent = json.RawMessage(data)
+		event := gjson.Parse(data)
+		for _, path := range []string{"usage", "usageMetadata", "response.usage"} {
+			if usage := event.Get(path); usage.IsObject() {
+				stream.Usage = json.RawMessage(usage.Raw)
+			}
+		}
+
+		switch event.Get("type").String() {
+		case "content_block_start":
+			if block := event.Get("content_block"); block.Get("type").String() == "tool_use" {
+				stream.ToolCalls = append(stream.ToolCalls, &capturedToolCall{Name: block.Get("name").String()})
+			}
+		case "content_block_delta":
+			delta := event.Get("delta")
+			content.WriteString(delta.Get("text").String())
+			reasoning.WriteString(delta.Get("thinking").String())
+			if partial := delta.Get("partial_json"); partial.Exists() && len(stream.ToolCalls) > 0 {
+				stream.ToolCalls[len(stream.ToolCalls)-1].Arguments += partial.String()
+			}
+		case "message_delta":
+			if reason := event.Get("delta.stop_reason").String(); reason != "" {
+				stream.FinishReason = reason
+			}
+		case "response.output_text.delta":
+			content.WriteString(event.Get("delta").String())
+		case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+			reasoning.WriteString(event.Get("delta").String())
+		case "response.output_item.done":
+			if item := event.Get("item"); item.Get("type").String() == "function_call" {
+				stream.ToolCalls = append(stream.ToolCalls, &capturedToolCall{
+					Name:      item.Get("name").String(),
+					Arguments: item.Get("arguments").String(),
+				})
+			}
+		case "response.completed", "response.incomplete":
+			stream.FinishReason = event.Get("response.status").String()
+		}
+
+		for _, choice := range event.Get("choices").Array() {
+			content.WriteString(choice.Get("delta.content").String())
+			content.WriteString(choice.Get("text").String())
+			reasoning.WriteString(choice.Get("delta.reasoning_content").String())
+			reasoning.WriteString(choice.Get("delta.reasoning").String())
+			for _, toolCall := range choice.Get("delta.tool_calls").Array() {
+				index := toolCall.Get("index").Int()
+				call, ok := openaiToolCalls[index]
+				if !ok {
+					call = &capturedToolCall{}
+					openaiToolCalls[index] = call
+					stream.ToolCalls = append(stream.ToolCalls, call)
+				}
+				call.Name += toolCall.Get("function.name").String()
+				call.Arguments += toolCall.Get("function.arguments").String()
+			}
+			if reason := choice.Get("finish_reason").String(); reason != "" {
+				stream.FinishReason = reason
+			}
+		}
+
+		for _, candidate := range event.Get("candidates").Array() {
+			for _, part := range candidate.Get("content.parts").Array() {
+				if part.Get("thought").Bool() {
+					reasoning.WriteString(part.Get("text").String())
+				} else {
+					content.WriteString(part.Get("text").String())
+				}
+				if call := part.Get("functionCall"); call.Exists() {
+					stream.ToolCalls = append(stream.ToolCalls, &capturedToolCall{
+						Name:      call.Get("name").String(),
+						Arguments: call.Get("args").Raw,
+					})
+				}
+			}
+			if reason := candidate.Get("finishReason").String(); reason != "" {
+				stream.FinishReason = reason
+			}
+		}
+	}
+	if stream.Events == 0 {
+		// 流式请求在开始输出前失败时返回的是普通错误响应
+		return raw
+	}
+	stream.Content = content.String()
+	stream.ReasoningContent = reasoning.String()
+	out, err := common.Marshal(stream)
+	if err != nil {
+		return raw
+	}
+	return out
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const (
+	bodyCaptureFilePrefix = "capture-"
+	bodyCaptureFileSuffix = ".jsonl"
+)
+
+// bodyCaptureStore 请求/响应体采集记录的存储后端
+type bodyCaptureStore interface {
+	Save(capture *model.LogCapture) error
+	Get(requestId string) ([]*model.LogCapture, error)
+	// Purge 删除指定时间戳之前的记录
+	Purge(ctx context.Context, before int64) (int64, error)
+}
+
+type dbBodyCaptureStore struct{}
+
+func (dbBodyCaptureStore) Save(capture *model.LogCapture) error {
+	return capture.Insert()
+}
+
+func (dbBodyCaptureStore) Get(requestId string) ([]*model.LogCapture, error) {
+	return model.GetLogCapturesByRequestId(requestId)
+}
+
+func (dbBodyCaptureStore) Purge(ctx context.Context, before int64) (int64, error) {
+	return model.DeleteOldLogCaptures(ctx, before, 1000)
+}
+
+// fileBodyCaptureStore 以 JSONL 格式保存到本地目录，按天和文件大小轮转，按文件修改时间清理
+type fileBodyCaptureStore struct {
+	mutex sync.Mutex
+	dir   string
+	file  *os.File
+	day   string
+	size  int64
+}
+
+var (
+	fileBodyCaptureStores      = make(map[string]*fileBodyCaptureStore)
+	fileBodyCaptureStoresMutex sync.Mutex
+)
+
+func getFileBodyCaptureStore(dir string) *fileBodyCaptureStore {
+	fileBodyCaptureStoresMutex.Lock()
+	defer fileBodyCaptureStoresMutex.Unlock()
+	store, ok := fileBodyCaptureStores[dir]
+	if !ok {
+		store = &fileBodyCaptureStore{dir: dir}
+		fileBodyCaptureStores[dir] = store
+	}
+	return store
+}
+
+func getBodyCaptureStore(setting *operation_setting.BodyCaptureSetting) bodyCaptureStore {
+	if setting.GetStorage() == operation_setting.BodyCaptureStorageFile {
+		return getFileBodyCaptureStore(setting.FileDir)
+	}
+	return dbBodyCaptureStore{}
+}
+
+func (s *fileBodyCaptureStore) Save(capture *model.LogCapture) error {
+	line, err := common.Marshal(capture)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	day := now.Format("20060102")
+	maxSize := operation_setting.GetBodyCaptureSetting().GetFileMaxSize()
+	if s.file == nil || s.day != day || s.size+int64(len(line)) > maxSize {
+		if err := s.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate 关闭当前文件并创建新文件，调用方需持有锁
+func (s *fileBodyCaptureStore) rotate(now time.Time) error {
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return err
+	}
+	name := bodyCaptureFilePrefix + now.Format("20060102-150405.000000") + bodyCaptureFileSuffix
+	file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.day = now.Format("20060102")
+	s.size = 0
+	return nil
+}
+
+// listFiles 按时间从新到旧返回采集文件
+func (s *fileBodyCaptureStore) listFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, bodyCaptureFilePrefix) && strings.HasSuffix(name, bodyCaptureFileSuffix) {
+			files = append(files, filepath.Join(s.dir, name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	return files, nil
+}
+
+func (s *fileBodyCaptureStore) Get(requestId string) ([]*model.LogCapture, error) {
+	files, err := s.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	needle := []byte(fmt.Sprintf(`"request_id":%q`, requestId))
+	var captures []*model.LogCapture
+	for _, name := range files {
+		found, err := scanBodyCaptureFile(name, needle, requestId)
+		if err != nil {
+			return nil, err
+		}
+		// 同一请求的记录写在相邻的时间内，找到后不再继续扫描更早的文件
+		if len(found) > 0 {
+			captures = append(found, captures...)
+			break
+		}
+	}
+	return captures, nil
+}
+
+func scanBodyCaptureFile(name string, needle []byte, requestId string) ([]*model.LogCapture, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var captures []*model.LogCapture
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if bytes.Contains(line, needle) {
+			var capture model.LogCapture
+			if common.Unmarshal(line, &capture) == nil && capture.RequestId == requestId {
+				captures = append(captures, &capture)
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return captures, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+func (s *fileBodyCaptureStore) Purge(ctx context.Context, before int64) (int64, error) {
+	files, err := s.listFiles()
+	if err != nil {
+		return 0, err
+	}
+	s.mutex.Lock()
+	var current string
+	if s.file != nil {
+		current = s.file.Name()
+	}
+	s.mutex.Unlock()
+
+	var total int64
+	for _, name := range files {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		if name == current {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil || info.ModTime().Unix() >= before {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return total, err
+		}
+		total++
+	}
+	return total, nil
+}
+
+// GetBodyCaptures 按请求 ID 查询采集记录，同时查询数据库和文件存储，以便切换存储方式后仍能查看历史记录
+func GetBodyCaptures(requestId string) ([]*model.LogCapture, error) {
+	captures, err := dbBodyCaptureStore{}.Get(requestId)
+	if err != nil {
+		return nil, err
+	}
+	setting := operation_setting.GetBodyCaptureSetting()
+	if setting.FileDir != "" {
+		fileCaptures, err := getFileBodyCaptureStore(setting.FileDir).Get(requestId)
+		if err != nil {
+			return nil, err
+		}
+		captures = append(captures, fileCaptures...)
+	}
+	return captures, nil
+}
+
+// CleanupExpiredBodyCaptures 删除超过保留天数的采集记录，数据库中的记录只由主节点清理，本地文件由各节点各自清理
+func CleanupExpiredBodyCaptures(ctx context.Context) {
+	setting := operation_setting.GetBodyCaptureSetting()
+	if setting.RetentionDays <= 0 {
+		return
+	}
+	before := time.Now().AddDate(0, 0, -setting.RetentionDays).Unix()
+	if common.IsMasterNode {
+		count, err := dbBodyCaptureStore{}.Purge(ctx, before)
+		if err != nil {
+			common.SysError("failed to purge body captures: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("purged %d expired body captures", count))
+		}
+	}
+	if setting.FileDir != "" {
+		count, err := getFileBodyCaptureStore(setting.FileDir).Purge(ctx, before)
+		if err != nil {
+			common.SysError("failed to purge body capture files: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("purged %d expired body capture files", count))
+		}
+	}
+}
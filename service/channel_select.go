@@ -160,3 +160,19 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 	}
 	return channel, selectGroup, nil
 }
+
+// GetChannelGroupForModel 判断指定渠道能否在令牌分组下提供该模型，返回渠道所在的分组
+// 用于不经过随机选择的渠道（如文件绑定的渠道），auto 分组会依次检查用户可用的分组
+func GetChannelGroupForModel(ctx *gin.Context, tokenGroup string, modelName string, channelId int) (string, bool) {
+	if tokenGroup != "auto" {
+		return tokenGroup, model.IsChannelEnabledForGroupModel(tokenGroup, modelName, channelId)
+	}
+	userGroup := common.GetContextKeyString(ctx, constant.ContextKeyUserGroup)
+	for _, autoGroup := range GetUserAutoGroup(userGroup) {
+		if model.IsChannelEnabledForGroupModel(autoGroup, modelName, channelId) {
+			common.SetContextKey(ctx, constant.ContextKeyAutoGroup, autoGroup)
+			return autoGroup, true
+		}
+	}
+	return tokenGroup, false
+}
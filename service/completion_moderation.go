@@ -0,0 +1,733 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const sensitiveWordMask = "**###**"
+
+type moderationMode int
+
+const (
+	moderationModeUnknown     moderationMode = iota
+	moderationModeStream                     // SSE 响应，逐个事件审核
+	moderationModeBuffer                     // JSON 响应，完整读取后审核
+	moderationModePassthrough                // 其他响应（音频、图片等）不审核
+)
+
+// sensitiveSpan 敏感词在文本中的 rune 区间 [start, end)
+type sensitiveSpan struct {
+	start int
+	end   int
+}
+
+// findSensitiveSpans 查找文本中的敏感词，重叠的区间会被合并
+func findSensitiveSpans(text []rune) []sensitiveSpan {
+	if len(text) == 0 || len(setting.SensitiveWords) == 0 {
+		return nil
+	}
+	m := getOrBuildAC(setting.SensitiveWords)
+	if m == nil {
+		return nil
+	}
+	lower := make([]rune, len(text))
+	for i, r := range text {
+		lower[i] = unicode.ToLower(r)
+	}
+	hits := m.MultiPatternSearch(lower, false)
+	if len(hits) == 0 {
+		return nil
+	}
+	spans := make([]sensitiveSpan, 0, len(hits))
+	for _, hit := range hits {
+		spans = append(spans, sensitiveSpan{start: hit.Pos, end: hit.Pos + len(hit.Word)})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := spans[:1]
+	for _, span := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if span.start <= last.end {
+			last.end = max(last.end, span.end)
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// maxSensitiveWordLength 敏感词的最大长度（rune），流式审核需要保留相应长度的文本以匹配跨事件的敏感词
+func maxSensitiveWordLength() int {
+	length := 0
+	for _, word := range setting.SensitiveWords {
+		length = max(length, utf8.RuneCountInString(strings.TrimSpace(word)))
+	}
+	return length
+}
+
+// completionModerationWriter 审核返回给客户端的模型输出，流式响应在各字段上保留一段滑动窗口，
+// 使跨 SSE 事件的敏感词也能被匹配
+type completionModerationWriter struct {
+	gin.ResponseWriter
+	c      *gin.Context
+	action string
+	mode   moderationMode
+
+	pending     []byte            // 尚未完整的 SSE 事件
+	windows     map[string][]rune // 各字段尚未输出的文本
+	claudeKinds map[int64]string  // Claude 各内容块的增量类型
+	lastOpenAI  string            // 最近一个 OpenAI 格式的事件，用于构造补发的事件
+	holdBack    int
+	stopped     bool // 已截断或中止，之后的输出全部丢弃
+
+	body       bytes.Buffer // 非流式响应的完整响应体
+	bufferDone bool
+	bufferBody []byte          // 审核后的非流式响应体
+	delivered  strings.Builder // 已返回给客户端的文本，截断或中止时按此计算输出 token
+	words      []string
+}
+
+// StartCompletionModeration 开启输出敏感词检测时包装响应写入器，返回结束时需要调用的函数，未开启时返回 nil
+func StartCompletionModeration(c *gin.Context) func() {
+	if !setting.ShouldCheckCompletionSensitive() || len(setting.SensitiveWords) == 0 {
+		return nil
+	}
+	w := &completionModerationWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		action:         setting.GetCompletionSensitiveAction(),
+		windows:        make(map[string][]rune),
+		claudeKinds:    make(map[int64]string),
+		holdBack:       max(maxSensitiveWordLength()-1, 0),
+	}
+	c.Writer = w
+	common.SetContextKey(c, constant.ContextKeyCompletionModeration, w)
+	return w.finish
+}
+
+// AdjustModeratedCompletionUsage 模型输出被敏感词截断或中止时，按实际返回给客户端的文本重新计算输出 token，
+// 上游已生成但没有返回给客户端的内容不计费
+func AdjustModeratedCompletionUsage(c *gin.Context, info *relaycommon.RelayInfo, usage *dto.Usage) {
+	value, ok := common.GetContextKey(c, constant.ContextKeyCompletionModeration)
+	if !ok || usage == nil {
+		return
+	}
+	w, ok := value.(*completionModerationWriter)
+	if !ok || w.action == setting.CompletionSensitiveActionMask {
+		return
+	}
+	if w.detectMode() == moderationModeBuffer {
+		// 非流式响应在写出前审核，结算时提前完成审核以确定是否被截断
+		w.moderateBuffer()
+	}
+	if !w.stopped {
+		return
+	}
+	completionTokens := CountTextToken(w.delivered.String(), info.UpstreamModelName)
+	if completionTokens >= usage.CompletionTokens {
+		return
+	}
+	logger.LogInfo(c, fmt.Sprintf("completion stopped by sensitive words, bill %d of %d completion tokens", completionTokens, usage.CompletionTokens))
+	usage.TotalTokens -= usage.CompletionTokens - completionTokens
+	usage.CompletionTokens = completionTokens
+	usage.CompletionTokenDetails.ReasoningTokens = min(usage.CompletionTokenDetails.ReasoningTokens, completionTokens)
+	usage.CompletionTokenDetails.TextTokens = min(usage.CompletionTokenDetails.TextTokens, completionTokens)
+}
+
+// detectModerationMode 根据响应的 Content-Type 判断审核方式
+func detectModerationMode(header http.Header) moderationMode {
+	contentType := header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		return moderationModeStream
+	case strings.Contains(contentType, "json"):
+		return moderationModeBuffer
+	}
+	return moderationModePassthrough
+}
+
+func (w *completionModerationWriter) detectMode() moderationMode {
+	if w.mode == moderationModeUnknown {
+		w.mode = detectModerationMode(w.Header())
+	}
+	return w.mode
+}
+
+func (w *completionModerationWriter) Write(data []byte) (int, error) {
+	switch w.detectMode() {
+	case moderationModeStream:
+		if w.stopped {
+			return len(data), nil
+		}
+		w.pending = append(w.pending, data...)
+		for {
+			end := bytes.Index(w.pending, []byte("\n\n"))
+			if end < 0 {
+				break
+			}
+			event := string(w.pending[:end+2])
+			w.pending = w.pending[end+2:]
+			if err := w.writeString(w.moderateStreamEvent(event)); err != nil {
+				return 0, err
+			}
+			if w.stopped {
+				w.pending = nil
+				break
+			}
+		}
+		return len(data), nil
+	case moderationModeBuffer:
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *completionModerationWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow 非流式响应在审核完成后才写出响应头
+func (w *completionModerationWriter) WriteHeaderNow() {
+	if w.detectMode() != moderationModeBuffer {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *completionModerationWriter) Flush() {
+	if w.detectMode() != moderationModeBuffer {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *completionModerationWriter) writeString(s string) error {
+	if s == "" {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write([]byte(s))
+	return err
+}
+
+// finish 写出剩余的输出并记录审核事件
+func (w *completionModerationWriter) finish() {
+	switch w.mode {
+	case moderationModeStream:
+		if !w.stopped {
+			_ = w.writeString(w.flushOpenAIWindows())
+			_ = w.writeString(string(w.pending))
+			w.ResponseWriter.Flush()
+		}
+	case moderationModeBuffer:
+		w.finishBuffer()
+	}
+	if len(w.words) > 0 {
+		words := strings.Join(RemoveDuplicate(w.words), ", ")
+		logger.LogWarn(w.c, fmt.Sprintf("completion sensitive words detected: %s, action: %s", words, w.action))
+		userId := common.GetContextKeyInt(w.c, constant.ContextKeyUserId)
+		content := fmt.Sprintf("模型输出命中敏感词：%s，处理方式：%s，请求 ID：%s", words, w.action, w.c.GetString(common.RequestIdKey))
+		gopool.Go(func() {
+			model.RecordLog(userId, model.LogTypeSystem, content)
+		})
+	}
+}
+
+// moderateText 审核完整文本，替换模式下返回替换后的文本，否则返回敏感词之前的文本
+func (w *completionModerationWriter) moderateText(text string) (string, bool) {
+	runes := []rune(text)
+	spans := findSensitiveSpans(runes)
+	if len(spans) == 0 {
+		return text, false
+	}
+	if w.action != setting.CompletionSensitiveActionMask {
+		w.recordWords(runes, spans[:1])
+		return string(runes[:spans[0].start]), true
+	}
+	w.recordWords(runes, spans)
+	return maskSensitiveSpans(runes, spans), false
+}
+
+// feed 将流式增量追加到字段的滑动窗口，返回可以输出的文本；
+// final 为 true 时输出全部剩余文本，返回 true 表示需要截断或中止
+func (w *completionModerationWriter) feed(key string, text string, final bool) (string, bool) {
+	window := append(w.windows[key], []rune(text)...)
+	spans := findSensitiveSpans(window)
+	if len(spans) > 0 && w.action != setting.CompletionSensitiveActionMask {
+		delete(w.windows, key)
+		w.recordWords(window, spans[:1])
+		if w.action == setting.CompletionSensitiveActionAbort {
+			return "", true
+		}
+		output := string(window[:spans[0].start])
+		w.delivered.WriteString(output)
+		return output, true
+	}
+	boundary := len(window)
+	if !final {
+		boundary = max(boundary-w.holdBack, 0)
+		// 已完整匹配且跨越边界的敏感词一并输出
+		for _, span := range spans {
+			if span.start < boundary && span.end > boundary {
+				boundary = span.end
+			}
+		}
+	}
+	released := make([]sensitiveSpan, 0, len(spans))
+	for _, span := range spans {
+		if span.end <= boundary {
+			released = append(released, span)
+		}
+	}
+	w.recordWords(window, released)
+	if boundary == len(window) {
+		delete(w.windows, key)
+	} else {
+		w.windows[key] = append([]rune(nil), window[boundary:]...)
+	}
+	output := maskSensitiveSpans(window[:boundary], released)
+	w.delivered.WriteString(output)
+	return output, false
+}
+
+// flushKey 输出字段窗口中的全部剩余文本
+func (w *completionModerationWriter) flushKey(key string) string {
+	if _, ok := w.windows[key]; !ok {
+		return ""
+	}
+	text, _ := w.feed(key, "", true)
+	return text
+}
+
+func (w *completionModerationWriter) recordWords(text []rune, spans []sensitiveSpan) {
+	for _, span := range spans {
+		w.words = append(w.words, string(text[span.start:span.end]))
+	}
+}
+
+func maskSensitiveSpans(text []rune, spans []sensitiveSpan) string {
+	if len(spans) == 0 {
+		return string(text)
+	}
+	var builder strings.Builder
+	last := 0
+	for _, span := range spans {
+		builder.WriteString(string(text[last:span.start]))
+		builder.WriteString(sensitiveWordMask)
+		last = span.end
+	}
+	builder.WriteString(string(text[last:]))
+	return builder.String()
+}
+
+func (w *completionModerationWriter) sensitiveError() *types.NewAPIError {
+	return types.NewErrorWithStatusCode(errors.New("completion contains sensitive words"), types.ErrorCodeSensitiveWordsDetected, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// formatSSEEvent 生成 SSE 事件，多行数据按规范拆分为多个 data 行
+func formatSSEEvent(eventType string, data string) string {
+	data = "data: " + strings.ReplaceAll(data, "\n", "\ndata: ")
+	if eventType != "" {
+		return fmt.Sprintf("event: %s\n%s\n\n", eventType, data)
+	}
+	return data + "\n\n"
+}
+
+// moderateStreamEvent 审核单个 SSE 事件，返回需要写出的内容
+func (w *completionModerationWriter) moderateStreamEvent(event string) string {
+	eventType, data, hasData := parseModerationSSEEvent(event)
+	if !hasData {
+		return event
+	}
+	if data == "[DONE]" {
+		return w.flushOpenAIWindows() + event
+	}
+	if !gjson.Valid(data) {
+		return event
+	}
+	result := gjson.Parse(data)
+	switch {
+	case result.Get("choices").Exists():
+		return w.moderateOpenAIEvent(eventType, data)
+	case result.Get("candidates").Exists():
+		return w.moderateGeminiEvent(eventType, data)
+	case strings.HasPrefix(result.Get("type").String(), "response."):
+		return w.moderateResponsesEvent(eventType, data)
+	case result.Get("type").Exists():
+		return w.moderateClaudeEvent(eventType, data)
+	}
+	return event
+}
+
+// parseModerationSSEEvent 解析 SSE 事件，按规范以换行连接多个 data 行，并只去掉冒号后的一个空格
+func parseModerationSSEEvent(event string) (eventType string, data string, hasData bool) {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(event, "\r\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if value, ok := strings.CutPrefix(line, "event:"); ok {
+			eventType = strings.TrimSpace(value)
+		} else if value, ok := strings.CutPrefix(line, "data:"); ok {
+			lines = append(lines, strings.TrimPrefix(value, " "))
+		} else if line == "data" {
+			lines = append(lines, "")
+		}
+	}
+	if len(lines) == 0 {
+		return eventType, "", false
+	}
+	return eventType, strings.TrimSpace(strings.Join(lines, "\n")), true
+}
+
+var openAIStreamTextFields = []string{"delta.content", "delta.reasoning_content", "delta.reasoning", "text"}
+
+func (w *completionModerationWriter) moderateOpenAIEvent(eventType string, data string) string {
+	w.lastOpenAI = data
+	for i, choice := range gjson.Get(data, "choices").Array() {
+		index := choice.Get("index").Int()
+		final := choice.Get("finish_reason").String() != ""
+		for _, field := range openAIStreamTextFields {
+			key := fmt.Sprintf("openai:%d:%s", index, field)
+			value := choice.Get(field)
+			if !value.Exists() && !(final && w.windows[key] != nil) {
+				continue
+			}
+			text, stop := w.feed(key, value.String(), final)
+			path := fmt.Sprintf("choices.%d.%s", i, field)
+			data, _ = sjson.Set(data, path, text)
+			if !stop {
+				continue
+			}
+			w.stopped = true
+			if w.action == setting.CompletionSensitiveActionAbort {
+				errorData, _ := common.Marshal(gin.H{"error": w.sensitiveError().ToOpenAIError()})
+				return formatSSEEvent("", string(errorData))
+			}
+			data, _ = sjson.Set(data, fmt.Sprintf("choices.%d.finish_reason", i), "content_filter")
+			return formatSSEEvent(eventType, data) + formatSSEEvent("", "[DONE]")
+		}
+	}
+	return formatSSEEvent(eventType, data)
+}
+
+// flushOpenAIWindows 上游没有返回结束原因时，在结束前补发窗口中剩余的文本
+func (w *completionModerationWriter) flushOpenAIWindows() string {
+	if w.lastOpenAI == "" {
+		return ""
+	}
+	var builder strings.Builder
+	for key := range w.windows {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) != 3 || parts[0] != "openai" {
+			continue
+		}
+		text := w.flushKey(key)
+		if text == "" {
+			continue
+		}
+		index, _ := strconv.Atoi(parts[1])
+		data, _ := sjson.Set(w.lastOpenAI, "choices", []gin.H{{"index": index, "delta": gin.H{}}})
+		data, _ = sjson.Set(data, "choices.0."+parts[2], text)
+		data, _ = sjson.Delete(data, "usage")
+		builder.WriteString(formatSSEEvent("", data))
+	}
+	return builder.String()
+}
+
+func (w *completionModerationWriter) moderateClaudeEvent(eventType string, data string) string {
+	result := gjson.Parse(data)
+	index := result.Get("index").Int()
+	key := fmt.Sprintf("claude:%d", index)
+	switch result.Get("type").String() {
+	case "content_block_start":
+		w.claudeKinds[index] = result.Get("content_block.type").String()
+	case "content_block_delta":
+		field := ""
+		switch result.Get("delta.type").String() {
+		case "text_delta":
+			field = "delta.text"
+		case "thinking_delta":
+			field = "delta.thinking"
+		default:
+			return formatSSEEvent(eventType, data)
+		}
+		text, stop := w.feed(key, result.Get(field).String(), false)
+		data, _ = sjson.Set(data, field, text)
+		if stop {
+			w.stopped = true
+			if w.action == setting.CompletionSensitiveActionAbort {
+				errorData, _ := common.Marshal(gin.H{"type": "error", "error": w.sensitiveError().ToClaudeError()})
+				return formatSSEEvent("error", string(errorData))
+			}
+			stopData, _ := common.Marshal(gin.H{"type": "content_block_stop", "index": index})
+			deltaData, _ := common.Marshal(gin.H{
+				"type":  "message_delta",
+				"delta": gin.H{"stop_reason": "refusal", "stop_sequence": nil},
+			})
+			return formatSSEEvent(eventType, data) +
+				formatSSEEvent("content_block_stop", string(stopData)) +
+				formatSSEEvent("message_delta", string(deltaData)) +
+				formatSSEEvent("message_stop", `{"type":"message_stop"}`)
+		}
+	case "content_block_stop":
+		// 内容块结束前补发窗口中剩余的文本
+		if text := w.flushKey(key); text != "" {
+			delta := gin.H{"type": "text_delta", "text": text}
+			if w.claudeKinds[index] == "thinking" {
+				delta = gin.H{"type": "thinking_delta", "thinking": text}
+			}
+			deltaData, _ := common.Marshal(gin.H{"type": "content_block_delta", "index": index, "delta": delta})
+			return formatSSEEvent("content_block_delta", string(deltaData)) + formatSSEEvent(eventType, data)
+		}
+	}
+	return formatSSEEvent(eventType, data)
+}
+
+func (w *completionModerationWriter) moderateGeminiEvent(eventType string, data string) string {
+	for i, candidate := range gjson.Get(data, "candidates").Array() {
+		index := candidate.Get("index").Int()
+		final := candidate.Get("finishReason").String() != ""
+		for j, part := range candidate.Get("content.parts").Array() {
+			if !part.Get("text").Exists() {
+				continue
+			}
+			key := fmt.Sprintf("gemini:%d:%t", index, part.Get("thought").Bool())
+			text, stop := w.feed(key, part.Get("text").String(), false)
+			data, _ = sjson.Set(data, fmt.Sprintf("candidates.%d.content.parts.%d.text", i, j), text)
+			if !stop {
+				continue
+			}
+			w.stopped = true
+			if w.action == setting.CompletionSensitiveActionAbort {
+				errorData, _ := common.Marshal(gin.H{"error": gin.H{
+					"code":    http.StatusBadRequest,
+					"message": w.sensitiveError().Error(),
+					"status":  "INVALID_ARGUMENT",
+				}})
+				return formatSSEEvent("", string(errorData))
+			}
+			parts := gjson.Get(data, fmt.Sprintf("candidates.%d.content.parts", i)).Array()
+			data, _ = sjson.SetRaw(data, fmt.Sprintf("candidates.%d.content.parts", i), "["+joinRaw(parts[:j+1])+"]")
+			data, _ = sjson.Set(data, fmt.Sprintf("candidates.%d.finishReason", i), "SAFETY")
+			return formatSSEEvent(eventType, data)
+		}
+		if !final {
+			continue
+		}
+		for _, thought := range []bool{false, true} {
+			text := w.flushKey(fmt.Sprintf("gemini:%d:%t", index, thought))
+			if text == "" {
+				continue
+			}
+			part := gin.H{"text": text}
+			if thought {
+				part["thought"] = true
+			}
+			data, _ = sjson.Set(data, fmt.Sprintf("candidates.%d.content.parts.-1", i), part)
+		}
+	}
+	return formatSSEEvent(eventType, data)
+}
+
+func joinRaw(results []gjson.Result) string {
+	raws := make([]string, 0, len(results))
+	for _, result := range results {
+		raws = append(raws, result.Raw)
+	}
+	return strings.Join(raws, ",")
+}
+
+func (w *completionModerationWriter) moderateResponsesEvent(eventType string, data string) string {
+	result := gjson.Parse(data)
+	key := fmt.Sprintf("responses:%d:%d", result.Get("output_index").Int(), result.Get("content_index").Int())
+	switch result.Get("type").String() {
+	case "response.output_text.delta":
+		text, stop := w.feed(key, result.Get("delta").String(), false)
+		data, _ = sjson.Set(data, "delta", text)
+		if stop {
+			w.stopped = true
+			if w.action == setting.CompletionSensitiveActionAbort {
+				errorData, _ := common.Marshal(gin.H{
+					"type":    "error",
+					"code":    types.ErrorCodeSensitiveWordsDetected,
+					"message": w.sensitiveError().Error(),
+				})
+				return formatSSEEvent("error", string(errorData))
+			}
+			incompleteData, _ := common.Marshal(gin.H{
+				"type": "response.incomplete",
+				"response": gin.H{
+					"status":             "incomplete",
+					"incomplete_details": gin.H{"reason": "content_filter"},
+				},
+			})
+			return formatSSEEvent(eventType, data) + formatSSEEvent("response.incomplete", string(incompleteData))
+		}
+	case "response.output_text.done":
+		var prefix string
+		if text := w.flushKey(key); text != "" {
+			deltaData, _ := sjson.Delete(data, "text")
+			deltaData, _ = sjson.Set(deltaData, "type", "response.output_text.delta")
+			deltaData, _ = sjson.Set(deltaData, "delta", text)
+			prefix = formatSSEEvent("response.output_text.delta", deltaData)
+		}
+		data = w.moderateResponsesText(data, "text")
+		return prefix + formatSSEEvent(eventType, data)
+	case "response.content_part.done":
+		data = w.moderateResponsesText(data, "part.text")
+	case "response.output_item.done":
+		data = w.moderateResponsesOutput(data, "item.content")
+	case "response.completed", "response.incomplete":
+		for i := range gjson.Get(data, "response.output").Array() {
+			data = w.moderateResponsesOutput(data, fmt.Sprintf("response.output.%d.content", i))
+		}
+	}
+	return formatSSEEvent(eventType, data)
+}
+
+// moderateResponsesText 对已通过增量审核的完整文本再做替换，增量已输出的内容与完整文本保持一致
+func (w *completionModerationWriter) moderateResponsesText(data string, path string) string {
+	value := gjson.Get(data, path)
+	if value.Type != gjson.String {
+		return data
+	}
+	words := w.words
+	text, _ := w.moderateText(value.String())
+	w.words = words
+	data, _ = sjson.Set(data, path, text)
+	return data
+}
+
+func (w *completionModerationWriter) moderateResponsesOutput(data string, path string) string {
+	for i := range gjson.Get(data, path).Array() {
+		data = w.moderateResponsesText(data, fmt.Sprintf("%s.%d.text", path, i))
+	}
+	return data
+}
+
+// moderateBuffer 审核完整的非流式响应，只执行一次
+func (w *completionModerationWriter) moderateBuffer() []byte {
+	if !w.bufferDone {
+		w.bufferDone = true
+		w.bufferBody = w.body.Bytes()
+		if w.Status() == http.StatusOK && gjson.ValidBytes(w.bufferBody) {
+			w.bufferBody = w.moderateJSONBody(w.bufferBody)
+		}
+	}
+	return w.bufferBody
+}
+
+// finishBuffer 审核完整的非流式响应后写出
+func (w *completionModerationWriter) finishBuffer() {
+	body := w.moderateBuffer()
+	if w.Header().Get("Content-Length") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// moderateJSONBody 审核非流式响应中的文本，中止时返回对应格式的错误响应
+func (w *completionModerationWriter) moderateJSONBody(body []byte) []byte {
+	data := string(body)
+	result := gjson.Parse(data)
+	type textField struct {
+		path     string
+		group    int    // 截断只影响同一个候选结果中之后的内容
+		stopPath string // 截断时需要设置的结束原因字段
+		stopWith string
+	}
+	var fields []textField
+	errorFormat := types.RelayFormatOpenAI
+	switch {
+	case result.Get("choices").Exists():
+		for i := range result.Get("choices").Array() {
+			for _, field := range []string{"message.reasoning_content", "message.content", "text"} {
+				fields = append(fields, textField{fmt.Sprintf("choices.%d.%s", i, field), i, fmt.Sprintf("choices.%d.finish_reason", i), "content_filter"})
+			}
+		}
+	case result.Get("candidates").Exists():
+		errorFormat = types.RelayFormatGemini
+		for i, candidate := range result.Get("candidates").Array() {
+			for j := range candidate.Get("content.parts").Array() {
+				fields = append(fields, textField{fmt.Sprintf("candidates.%d.content.parts.%d.text", i, j), i, fmt.Sprintf("candidates.%d.finishReason", i), "SAFETY"})
+			}
+		}
+	case result.Get("output").IsArray():
+		errorFormat = types.RelayFormatOpenAIResponses
+		for i, item := range result.Get("output").Array() {
+			for j := range item.Get("content").Array() {
+				fields = append(fields, textField{fmt.Sprintf("output.%d.content.%d.text", i, j), 0, "status", "incomplete"})
+			}
+		}
+	case result.Get("content").IsArray():
+		errorFormat = types.RelayFormatClaude
+		for i := range result.Get("content").Array() {
+			for _, field := range []string{"thinking", "text"} {
+				fields = append(fields, textField{fmt.Sprintf("content.%d.%s", i, field), 0, "stop_reason", "refusal"})
+			}
+		}
+	}
+
+	stoppedGroups := make(map[int]bool)
+	for _, field := range fields {
+		value := gjson.Get(data, field.path)
+		if value.Type != gjson.String {
+			continue
+		}
+		if stoppedGroups[field.group] {
+			// 截断后不再输出之后的内容
+			data, _ = sjson.Set(data, field.path, "")
+			continue
+		}
+		text, stop := w.moderateText(value.String())
+		data, _ = sjson.Set(data, field.path, text)
+		if !stop {
+			w.delivered.WriteString(text)
+			continue
+		}
+		w.stopped = true
+		if w.action == setting.CompletionSensitiveActionAbort {
+			w.delivered.Reset()
+			return w.abortJSONBody(errorFormat)
+		}
+		w.delivered.WriteString(text)
+		stoppedGroups[field.group] = true
+		data, _ = sjson.Set(data, field.stopPath, field.stopWith)
+		if errorFormat == types.RelayFormatOpenAIResponses {
+			data, _ = sjson.Set(data, "incomplete_details", gin.H{"reason": "content_filter"})
+		}
+	}
+	return []byte(data)
+}
+
+func (w *completionModerationWriter) abortJSONBody(format types.RelayFormat) []byte {
+	newAPIError := w.sensitiveError()
+	newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), w.c.GetString(common.RequestIdKey)))
+	var body []byte
+	switch format {
+	case types.RelayFormatClaude:
+		body, _ = common.Marshal(gin.H{"type": "error", "error": newAPIError.ToClaudeError()})
+	default:
+		body, _ = common.Marshal(gin.H{"error": newAPIError.ToOpenAIError()})
+	}
+	w.WriteHeader(newAPIError.StatusCode)
+	return body
+}
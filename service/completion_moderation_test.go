@@ -0,0 +1,113 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func setCompletionModeration(t *testing.T, action string, words ...string) {
+	t.Helper()
+	enabled, completionEnabled, originalAction, originalWords := setting.CheckSensitiveEnabled, setting.CheckSensitiveOnCompletionEnabled, setting.CompletionSensitiveAction, setting.SensitiveWords
+	t.Cleanup(func() {
+		setting.CheckSensitiveEnabled, setting.CheckSensitiveOnCompletionEnabled, setting.CompletionSensitiveAction, setting.SensitiveWords = enabled, completionEnabled, originalAction, originalWords
+	})
+	setting.CheckSensitiveEnabled = true
+	setting.CheckSensitiveOnCompletionEnabled = true
+	setting.CompletionSensitiveAction = action
+	setting.SensitiveWords = words
+}
+
+func newCompletionModerationContext() (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c, recorder
+}
+
+func TestCompletionModerationJoinsMultiLineData(t *testing.T) {
+	setCompletionModeration(t, setting.CompletionSensitiveActionMask, "badword")
+	c, recorder := newCompletionModerationContext()
+	finish := StartCompletionModeration(c)
+	c.Header("Content-Type", "text/event-stream")
+	// 一个事件的 JSON 分布在多个 data 行中
+	_, _ = c.Writer.Write([]byte("data: {\"choices\":[{\"index\":0,\ndata: \"delta\":{\"content\":\"hello badword\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"))
+	finish()
+
+	events := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n\n")
+	if len(events) != 2 || events[1] != "data: [DONE]" {
+		t.Fatalf("unexpected events: %q", recorder.Body.String())
+	}
+	_, data, _ := parseModerationSSEEvent(events[0])
+	if content := gjson.Get(data, "choices.0.delta.content").String(); content != "hello "+sensitiveWordMask {
+		t.Fatalf("multi-line event should be moderated, got %q from %q", content, events[0])
+	}
+	for _, line := range strings.Split(events[0], "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			t.Fatalf("every line of the event should be a data line, got %q", line)
+		}
+	}
+}
+
+func TestAdjustModeratedCompletionUsage(t *testing.T) {
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "claude-3-5-sonnet"}}
+
+	t.Run("stream truncated", func(t *testing.T) {
+		setCompletionModeration(t, setting.CompletionSensitiveActionTruncate, "badword")
+		c, recorder := newCompletionModerationContext()
+		finish := StartCompletionModeration(c)
+		c.Header("Content-Type", "text/event-stream")
+		_, _ = c.Writer.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hello badword and a long tail\"}}]}\n\n"))
+		usage := &dto.Usage{PromptTokens: 10, CompletionTokens: 500, TotalTokens: 510}
+		AdjustModeratedCompletionUsage(c, info, usage)
+		finish()
+		if !strings.Contains(recorder.Body.String(), "content_filter") {
+			t.Fatalf("stream should be truncated, got %q", recorder.Body.String())
+		}
+		if usage.CompletionTokens <= 0 || usage.CompletionTokens >= 500 || usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens {
+			t.Fatalf("only delivered output should be billed, got %+v", usage)
+		}
+	})
+
+	t.Run("json aborted", func(t *testing.T) {
+		setCompletionModeration(t, setting.CompletionSensitiveActionAbort, "badword")
+		c, recorder := newCompletionModerationContext()
+		finish := StartCompletionModeration(c)
+		c.Header("Content-Type", "application/json")
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"badword"},"finish_reason":"stop"}]}`))
+		usage := &dto.Usage{PromptTokens: 10, CompletionTokens: 500, TotalTokens: 510}
+		AdjustModeratedCompletionUsage(c, info, usage)
+		finish()
+		if recorder.Code != http.StatusBadRequest || !gjson.Get(recorder.Body.String(), "error").Exists() {
+			t.Fatalf("response should be aborted, got %d %s", recorder.Code, recorder.Body.String())
+		}
+		if usage.CompletionTokens != 0 || usage.TotalTokens != 10 {
+			t.Fatalf("aborted output should not be billed, got %+v", usage)
+		}
+	})
+
+	t.Run("not stopped", func(t *testing.T) {
+		setCompletionModeration(t, setting.CompletionSensitiveActionTruncate, "badword")
+		c, _ := newCompletionModerationContext()
+		finish := StartCompletionModeration(c)
+		c.Header("Content-Type", "application/json")
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}]}`))
+		usage := &dto.Usage{PromptTokens: 10, CompletionTokens: 500, TotalTokens: 510}
+		AdjustModeratedCompletionUsage(c, info, usage)
+		finish()
+		if usage.CompletionTokens != 500 {
+			t.Fatalf("usage should be unchanged, got %+v", usage)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/tidwall/gjson"
+)
+
+// fileReferencePaths 请求体中可能引用已上传文件的 JSON 路径
+var fileReferencePaths = []string{
+	"input_file_id",
+	"training_file",
+	"validation_file",
+	"file_id",
+	"file_ids",
+	"messages.#.content.#.file.file_id",
+	"input.#.content.#.file_id",
+	"tool_resources.code_interpreter.file_ids",
+}
+
+// IsFileUploadSupportedChannel 判断渠道是否原生支持 OpenAI Files API
+func IsFileUploadSupportedChannel(channelType int) bool {
+	switch channelType {
+	case constant.ChannelTypeOpenAI, constant.ChannelTypeCustom:
+		return true
+	}
+	return false
+}
+
+// GenerateFileStorageKey 生成文件在对象存储中的路径，按用户分目录
+func GenerateFileStorageKey(userId int, fileId string) string {
+	return fmt.Sprintf("files/%d/%s", userId, fileId)
+}
+
+// SaveFileContent 保存文件内容到对象存储，返回存储类型和存储路径
+func SaveFileContent(ctx context.Context, userId int, fileId string, data []byte, contentType string) (string, string, error) {
+	storage := GetFileStorage()
+	key := GenerateFileStorageKey(userId, fileId)
+	if err := storage.Put(ctx, key, data, contentType); err != nil {
+		return "", "", err
+	}
+	return storage.Type(), key, nil
+}
+
+// GetFileContent 读取已保存的文件内容
+func GetFileContent(ctx context.Context, file *model.File) ([]byte, error) {
+	if file == nil {
+		return nil, errors.New("file is nil")
+	}
+	reader, err := GetFileStorage().Get(ctx, file.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// DeleteFileContent 删除对象存储中的文件内容，并在文件绑定渠道时同步删除上游文件
+func DeleteFileContent(ctx context.Context, file *model.File) error {
+	if file.ChannelId != 0 && file.UpstreamFileId != "" {
+		channel, err := model.CacheGetChannel(file.ChannelId)
+		if err == nil {
+			if err := DeleteUpstreamFile(ctx, channel, file.ChannelKeyIndex, file.UpstreamFileId); err != nil {
+				logger.LogWarn(ctx, fmt.Sprintf("delete upstream file %s failed: %s", file.UpstreamFileId, err.Error()))
+			}
+		}
+	}
+	if file.StorageKey == "" {
+		return nil
+	}
+	return GetFileStorage().Delete(ctx, file.StorageKey)
+}
+
+// fileChannelAuth 返回渠道的请求地址和指定索引的密钥，上游文件只能由上传时所用的密钥访问
+func fileChannelAuth(channel *model.Channel, keyIndex int) (string, string, error) {
+	key, newAPIError := channel.GetEnabledKeyByIndex(keyIndex)
+	if newAPIError != nil {
+		return "", "", newAPIError
+	}
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+	return strings.TrimSuffix(baseURL, "/"), key, nil
+}
+
+func doFileChannelRequest(ctx context.Context, channel *model.Channel, keyIndex int, method string, path string, body io.Reader, contentType string) ([]byte, error) {
+	baseURL, key, err := fileChannelAuth(channel, keyIndex)
+	if err != nil {
+		return nil, err
+	}
+	client, err := GetHttpClientWithProxy(channel.GetSetting().Proxy)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("upstream status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
+
+// UploadFileToChannel 使用渠道指定索引的密钥将文件上传到 /v1/files，返回上游文件对象
+func UploadFileToChannel(ctx context.Context, channel *model.Channel, keyIndex int, filename string, purpose string, contentType string, data []byte) (*dto.OpenAIFile, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("purpose", purpose); err != nil {
+		return nil, err
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, strings.ReplaceAll(filename, `"`, "")))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	respBody, err := doFileChannelRequest(ctx, channel, keyIndex, http.MethodPost, "/v1/files", &body, writer.FormDataContentType())
+	if err != nil {
+		return nil, err
+	}
+	var upstreamFile dto.OpenAIFile
+	if err = common.Unmarshal(respBody, &upstreamFile); err != nil {
+		return nil, err
+	}
+	if upstreamFile.ID == "" {
+		return nil, fmt.Errorf("upstream returned empty file id: %s", string(respBody))
+	}
+	return &upstreamFile, nil
+}
+
+// DownloadUpstreamFile 下载上游文件内容，用于同步上游生成的文件（如批处理结果）
+func DownloadUpstreamFile(ctx context.Context, channel *model.Channel, keyIndex int, upstreamFileId string) ([]byte, error) {
+	return doFileChannelRequest(ctx, channel, keyIndex, http.MethodGet, "/v1/files/"+upstreamFileId+"/content", nil, "")
+}
+
+// DeleteUpstreamFile 删除上游文件
+func DeleteUpstreamFile(ctx context.Context, channel *model.Channel, keyIndex int, upstreamFileId string) error {
+	_, err := doFileChannelRequest(ctx, channel, keyIndex, http.MethodDelete, "/v1/files/"+upstreamFileId, nil, "")
+	return err
+}
+
+// ExtractReferencedFileIds 从 JSON 请求体中提取引用的文件 ID
+func ExtractReferencedFileIds(body []byte) []string {
+	if len(body) == 0 || !gjson.ValidBytes(body) {
+		return nil
+	}
+	seen := make(map[string]bool)
+	fileIds := make([]string, 0)
+	var collect func(result gjson.Result)
+	collect = func(result gjson.Result) {
+		if result.IsArray() {
+			result.ForEach(func(_, value gjson.Result) bool {
+				collect(value)
+				return true
+			})
+			return
+		}
+		if result.Type == gjson.String && result.String() != "" && !seen[result.String()] {
+			seen[result.String()] = true
+			fileIds = append(fileIds, result.String())
+		}
+	}
+	for _, path := range fileReferencePaths {
+		collect(gjson.GetBytes(body, path))
+	}
+	return fileIds
+}
+
+// GetPinnedFileChannelId 返回请求引用的文件所绑定的渠道及密钥索引，未引用或未绑定时渠道为 0
+func GetPinnedFileChannelId(userId int, body []byte) (int, int, error) {
+	fileIds := ExtractReferencedFileIds(body)
+	if len(fileIds) == 0 {
+		return 0, 0, nil
+	}
+	files, err := model.GetUserFilesByFileIds(userId, fileIds)
+	if err != nil {
+		return 0, 0, err
+	}
+	channelId, keyIndex := 0, 0
+	for _, file := range files {
+		if file.ChannelId == 0 {
+			continue
+		}
+		if channelId != 0 && (channelId != file.ChannelId || keyIndex != file.ChannelKeyIndex) {
+			return 0, 0, errors.New("引用的文件绑定在不同的渠道或密钥上，无法在同一请求中使用")
+		}
+		channelId, keyIndex = file.ChannelId, file.ChannelKeyIndex
+	}
+	return channelId, keyIndex, nil
+}
+
+// CleanupExpiredFiles 删除已过期的文件及其内容
+func CleanupExpiredFiles(ctx context.Context) {
+	files, err := model.GetExpiredFiles(time.Now().Unix(), 100)
+	if err != nil {
+		common.SysError("failed to get expired files: " + err.Error())
+		return
+	}
+	for _, file := range files {
+		if err := DeleteFileContent(ctx, file); err != nil {
+			common.SysError(fmt.Sprintf("failed to delete content of expired file %s: %s", file.FileId, err.Error()))
+			continue
+		}
+		if err := file.Delete(); err != nil {
+			common.SysError(fmt.Sprintf("failed to delete expired file %s: %s", file.FileId, err.Error()))
+		}
+	}
+}
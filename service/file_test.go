@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupFileTestDB(t *testing.T) {
+	t.Helper()
+	setupQuotaTestDB(t)
+	if err := model.DB.AutoMigrate(&model.File{}, &model.Channel{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createTestFile(t *testing.T, userId int, fileId string, channelId int, keyIndex int) {
+	t.Helper()
+	file := &model.File{FileId: fileId, UserId: userId, Filename: fileId + ".jsonl", Purpose: "batch", Status: model.FileStatusProcessed,
+		ChannelId: channelId, ChannelKeyIndex: keyIndex, UpstreamFileId: fileId}
+	if err := file.Insert(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetPinnedFileChannelId(t *testing.T) {
+	setupFileTestDB(t)
+	createTestFile(t, 1, "file-a1", 3, 1)
+	createTestFile(t, 1, "file-a2", 3, 1)
+	createTestFile(t, 1, "file-a3", 3, 0)
+	createTestFile(t, 1, "file-a4", 4, 1)
+	createTestFile(t, 1, "file-local", 0, 0)
+
+	channelId, keyIndex, err := GetPinnedFileChannelId(1, []byte(`{"model":"gpt-4o","input_file_id":"file-a1","file_ids":["file-a2","file-local"]}`))
+	if err != nil || channelId != 3 || keyIndex != 1 {
+		t.Fatalf("expected channel #3 key #1, got %d %d %v", channelId, keyIndex, err)
+	}
+	if _, _, err = GetPinnedFileChannelId(1, []byte(`{"file_ids":["file-a1","file-a4"]}`)); err == nil {
+		t.Fatal("files on different channels should not be used together")
+	}
+	if _, _, err = GetPinnedFileChannelId(1, []byte(`{"file_ids":["file-a1","file-a3"]}`)); err == nil {
+		t.Fatal("files on different keys of the same channel should not be used together")
+	}
+	// 其他用户引用该文件 ID 时不会被绑定到上传者的渠道
+	channelId, _, err = GetPinnedFileChannelId(2, []byte(`{"input_file_id":"file-a1"}`))
+	if err != nil || channelId != 0 {
+		t.Fatalf("files of other users should be ignored, got %d %v", channelId, err)
+	}
+}
+
+func TestFileChannelRequestUsesPinnedKey(t *testing.T) {
+	setupFileTestDB(t)
+	InitHttpClient()
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"id":"file-a1","object":"file","deleted":true}`))
+	}))
+	t.Cleanup(server.Close)
+	channel := &model.Channel{Key: "sk-0\nsk-1\nsk-2", Status: common.ChannelStatusEnabled, BaseURL: common.GetPointer(server.URL)}
+	channel.ChannelInfo.IsMultiKey = true
+	channel.ChannelInfo.MultiKeySize = 3
+	channel.ChannelInfo.MultiKeyStatusList = map[int]int{2: common.ChannelStatusManuallyDisabled}
+
+	if err := DeleteUpstreamFile(context.Background(), channel, 1, "file-a1"); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer sk-1" {
+		t.Fatalf("request should use the key the file was uploaded with, got %q", authorization)
+	}
+	if err := DeleteUpstreamFile(context.Background(), channel, 2, "file-a1"); err == nil {
+		t.Fatal("disabled key should not be used")
+	}
+	if err := DeleteUpstreamFile(context.Background(), channel, 3, "file-a1"); err == nil {
+		t.Fatal("missing key should not be used")
+	}
+}
+
+func TestGetChannelGroupForModel(t *testing.T) {
+	setupFileTestDB(t)
+	if err := model.DB.AutoMigrate(&model.Ability{}); err != nil {
+		t.Fatal(err)
+	}
+	abilities := []model.Ability{
+		{Group: "default", Model: "gpt-4o", ChannelId: 3, Enabled: true},
+		{Group: "vip", Model: "gpt-4o-mini", ChannelId: 3, Enabled: true},
+		{Group: "default", Model: "gpt-4o-mini", ChannelId: 3, Enabled: false},
+	}
+	if err := model.DB.Create(&abilities).Error; err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	if group, ok := GetChannelGroupForModel(c, "default", "gpt-4o", 3); !ok || group != "default" {
+		t.Fatalf("channel should serve gpt-4o in default, got %s %v", group, ok)
+	}
+	if _, ok := GetChannelGroupForModel(c, "default", "gpt-4o-mini", 3); ok {
+		t.Fatal("disabled ability should not match")
+	}
+	if _, ok := GetChannelGroupForModel(c, "vip", "gpt-4o", 3); ok {
+		t.Fatal("channel is not enabled for gpt-4o in vip")
+	}
+	if _, ok := GetChannelGroupForModel(c, "default", "gpt-4o", 4); ok {
+		t.Fatal("other channels should not match")
+	}
+}
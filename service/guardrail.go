@@ -0,0 +1,565 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// 护栏钩子的审核结果
+const (
+	GuardrailActionAllow   = "allow"
+	GuardrailActionBlock   = "block"
+	GuardrailActionRewrite = "rewrite"
+)
+
+// guardrailMaxStreamBytes 流式响应送审时最多保留的原始数据
+const guardrailMaxStreamBytes = 1 << 20
+
+// GuardrailVerdict 单个钩子的审核结果，记录在日志的 other 字段中
+type GuardrailVerdict struct {
+	Hook      string `json:"hook"`
+	Stage     string `json:"stage"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"` // 调用失败的原因，按 fail_open 放行或拦截
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// guardrailHookRequest default 格式发送给钩子的请求
+type guardrailHookRequest struct {
+	Stage     string          `json:"stage"`
+	RequestId string          `json:"request_id"`
+	UserId    int             `json:"user_id"`
+	TokenId   int             `json:"token_id"`
+	Group     string          `json:"group"`
+	Model     string          `json:"model"`
+	Text      string          `json:"text"`
+	Body      json.RawMessage `json:"body,omitempty"` // 请求阶段为原始请求体，响应阶段为响应体
+}
+
+// guardrailHookResponse default 格式钩子的响应，rewrite 时 body 为改写后的请求体
+type guardrailHookResponse struct {
+	Action string          `json:"action"`
+	Reason string          `json:"reason"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// guardrailState 单个请求的护栏审核状态
+type guardrailState struct {
+	mutex    sync.Mutex
+	verdicts []GuardrailVerdict
+	response *guardrailResponseWriter
+}
+
+func (s *guardrailState) addVerdicts(verdicts []GuardrailVerdict) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.verdicts = append(s.verdicts, verdicts...)
+}
+
+func (s *guardrailState) getVerdicts() []GuardrailVerdict {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]GuardrailVerdict(nil), s.verdicts...)
+}
+
+func getGuardrailState(c *gin.Context) *guardrailState {
+	if state, ok := common.GetContextKeyType[*guardrailState](c, constant.ContextKeyGuardrailState); ok {
+		return state
+	}
+	state := &guardrailState{}
+	common.SetContextKey(c, constant.ContextKeyGuardrailState, state)
+	return state
+}
+
+func getGuardrailHooks(c *gin.Context, stage string) []operation_setting.GuardrailHook {
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	return operation_setting.GetGuardrailSetting().GetHooks(stage, tokenId, group)
+}
+
+func newGuardrailHookRequest(c *gin.Context, stage string, text string, body []byte) *guardrailHookRequest {
+	request := &guardrailHookRequest{
+		Stage:     stage,
+		RequestId: c.GetString(common.RequestIdKey),
+		UserId:    common.GetContextKeyInt(c, constant.ContextKeyUserId),
+		TokenId:   common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		Group:     common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+		Model:     common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		Text:      text,
+	}
+	if json.Valid(body) {
+		request.Body = body
+	}
+	return request
+}
+
+// resolveGuardrailChannelHook 使用渠道作为钩子时，改为调用渠道地址的 OpenAI Moderation 接口并使用渠道密钥鉴权
+func resolveGuardrailChannelHook(hook operation_setting.GuardrailHook) (operation_setting.GuardrailHook, error) {
+	if hook.ChannelId == 0 {
+		return hook, nil
+	}
+	channel, err := model.CacheGetChannel(hook.ChannelId)
+	if err != nil {
+		return hook, err
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return hook, fmt.Errorf("guardrail channel #%d is disabled", channel.Id)
+	}
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = constant.ChannelBaseURLs[channel.Type]
+	}
+	key, _, newAPIError := channel.GetNextEnabledKey()
+	if newAPIError != nil {
+		return hook, newAPIError
+	}
+	hook.Url = strings.TrimSuffix(baseURL, "/") + "/v1/moderations"
+	hook.Format = operation_setting.GuardrailFormatOpenAIModeration
+	hook.Headers = map[string]string{"Authorization": "Bearer " + key}
+	return hook, nil
+}
+
+// callGuardrailHook 调用单个钩子，返回审核结果
+func callGuardrailHook(ctx context.Context, hook operation_setting.GuardrailHook, payload *guardrailHookRequest) (*guardrailHookResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.GetTimeout())
+	defer cancel()
+
+	hook, err := resolveGuardrailChannelHook(hook)
+	if err != nil {
+		return nil, err
+	}
+
+	var requestBody []byte
+	if hook.Format == operation_setting.GuardrailFormatOpenAIModeration {
+		moderationRequest := gin.H{"input": payload.Text}
+		if hook.Model != "" {
+			moderationRequest["model"] = hook.Model
+		}
+		requestBody, err = common.Marshal(moderationRequest)
+	} else {
+		requestBody, err = common.Marshal(payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, string(responseBody))
+	}
+
+	if hook.Format == operation_setting.GuardrailFormatOpenAIModeration {
+		return parseOpenAIModerationResponse(responseBody)
+	}
+	var hookResponse guardrailHookResponse
+	if err := common.Unmarshal(responseBody, &hookResponse); err != nil {
+		return nil, err
+	}
+	switch hookResponse.Action {
+	case GuardrailActionAllow, GuardrailActionBlock:
+	case GuardrailActionRewrite:
+		if payload.Stage != operation_setting.GuardrailStagePreRequest || !json.Valid(hookResponse.Body) {
+			return nil, errors.New("rewrite requires a valid json body in pre_request stage")
+		}
+	default:
+		return nil, fmt.Errorf("invalid guardrail action: %q", hookResponse.Action)
+	}
+	return &hookResponse, nil
+}
+
+// parseOpenAIModerationResponse 任意结果被标记时拦截，原因为被标记的类别
+func parseOpenAIModerationResponse(body []byte) (*guardrailHookResponse, error) {
+	results := gjson.GetBytes(body, "results")
+	if !results.IsArray() {
+		return nil, errors.New("invalid moderation response: missing results")
+	}
+	var categories []string
+	flagged := false
+	for _, result := range results.Array() {
+		if !result.Get("flagged").Bool() {
+			continue
+		}
+		flagged = true
+		result.Get("categories").ForEach(func(key, value gjson.Result) bool {
+			if value.Bool() {
+				categories = append(categories, key.String())
+			}
+			return true
+		})
+	}
+	if !flagged {
+		return &guardrailHookResponse{Action: GuardrailActionAllow}, nil
+	}
+	return &guardrailHookResponse{Action: GuardrailActionBlock, Reason: strings.Join(RemoveDuplicate(categories), ", ")}, nil
+}
+
+// runGuardrailHooks 依次调用钩子，遇到拦截时停止；改写后的请求体会传给之后的钩子
+func runGuardrailHooks(c *gin.Context, hooks []operation_setting.GuardrailHook, payload *guardrailHookRequest) (verdicts []GuardrailVerdict, blocked *GuardrailVerdict, rewritten json.RawMessage) {
+	for _, hook := range hooks {
+		start := time.Now()
+		hookResponse, err := callGuardrailHook(c.Request.Context(), hook, payload)
+		verdict := GuardrailVerdict{
+			Hook:      hook.Name,
+			Stage:     payload.Stage,
+			LatencyMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("guardrail hook %s failed: %s", hook.Name, err.Error()))
+			verdict.Error = err.Error()
+			verdict.Action = GuardrailActionBlock
+			if hook.FailOpen {
+				verdict.Action = GuardrailActionAllow
+			}
+		} else {
+			verdict.Action = hookResponse.Action
+			verdict.Reason = hookResponse.Reason
+		}
+		verdicts = append(verdicts, verdict)
+		switch verdict.Action {
+		case GuardrailActionBlock:
+			return verdicts, &verdicts[len(verdicts)-1], rewritten
+		case GuardrailActionRewrite:
+			rewritten = hookResponse.Body
+			payload.Body = hookResponse.Body
+		}
+	}
+	return verdicts, nil, rewritten
+}
+
+func guardrailBlockedError(verdict *GuardrailVerdict) *types.NewAPIError {
+	if verdict.Error != "" {
+		return types.NewErrorWithStatusCode(fmt.Errorf("guardrail %s unavailable", verdict.Hook), types.ErrorCodeGuardrailUnavailable, http.StatusServiceUnavailable, types.ErrOptionWithSkipRetry())
+	}
+	message := fmt.Sprintf("blocked by guardrail %s", verdict.Hook)
+	if verdict.Reason != "" {
+		message += ": " + verdict.Reason
+	}
+	return types.NewErrorWithStatusCode(errors.New(message), types.ErrorCodeGuardrailBlocked, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+}
+
+// recordGuardrailBlockLog 被拦截的请求不产生消费日志，单独记录一条错误日志
+func recordGuardrailBlockLog(c *gin.Context, info *relaycommon.RelayInfo, verdicts []GuardrailVerdict, newAPIError *types.NewAPIError) {
+	if !constant.ErrorLogEnabled {
+		return
+	}
+	other := map[string]interface{}{
+		"error_code":  newAPIError.GetErrorCode(),
+		"status_code": newAPIError.StatusCode,
+		"guardrail":   verdicts,
+	}
+	model.RecordErrorLog(c, info.UserId, 0, info.OriginModelName, c.GetString("token_name"), newAPIError.Error(), info.TokenId, 0, info.IsStream, info.UsingGroup, other)
+}
+
+// ApplyPreRequestGuardrails 调用请求阶段的护栏钩子，拦截时返回错误，改写时替换请求
+func ApplyPreRequestGuardrails(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	hooks := getGuardrailHooks(c, operation_setting.GuardrailStagePreRequest)
+	if len(hooks) == 0 {
+		return nil
+	}
+	var body []byte
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		var err error
+		body, err = common.GetRequestBody(c)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeReadRequestBodyFailed, types.ErrOptionWithSkipRetry())
+		}
+	}
+	payload := newGuardrailHookRequest(c, operation_setting.GuardrailStagePreRequest, info.Request.GetTokenCountMeta().CombineText, body)
+	verdicts, blocked, rewritten := runGuardrailHooks(c, hooks, payload)
+	getGuardrailState(c).addVerdicts(verdicts)
+	if blocked != nil {
+		newAPIError := guardrailBlockedError(blocked)
+		recordGuardrailBlockLog(c, info, verdicts, newAPIError)
+		return newAPIError
+	}
+	if rewritten != nil {
+		if body == nil {
+			return types.NewErrorWithStatusCode(errors.New("guardrail rewrite is only supported for json requests"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+		}
+		// 模型和流式参数决定计费和渠道选择，不允许钩子修改，保持原请求的值
+		rewritten, err := restoreGuardrailProtectedFields(body, rewritten)
+		if err != nil {
+			return types.NewError(fmt.Errorf("invalid guardrail rewritten request: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+		}
+		// 按原请求的类型解析改写后的请求体，透传请求体的渠道使用改写后的内容
+		request := reflect.New(reflect.TypeOf(info.Request).Elem()).Interface().(dto.Request)
+		if err := common.Unmarshal(rewritten, request); err != nil {
+			return types.NewError(fmt.Errorf("invalid guardrail rewritten request: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+		}
+		c.Set(common.KeyRequestBody, []byte(rewritten))
+		info.Request = request
+		logger.LogInfo(c, "request rewritten by guardrail")
+	}
+	return nil
+}
+
+// guardrailProtectedFields 改写请求时不允许修改的字段
+var guardrailProtectedFields = []string{"model", "stream"}
+
+// restoreGuardrailProtectedFields 将改写后请求体中的模型和流式参数恢复为原请求的值
+func restoreGuardrailProtectedFields(original []byte, rewritten []byte) ([]byte, error) {
+	var err error
+	for _, field := range guardrailProtectedFields {
+		value := gjson.GetBytes(original, field)
+		if value.Raw == gjson.GetBytes(rewritten, field).Raw {
+			continue
+		}
+		if value.Exists() {
+			rewritten, err = sjson.SetRawBytes(rewritten, field, []byte(value.Raw))
+		} else {
+			rewritten, err = sjson.DeleteBytes(rewritten, field)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rewritten, nil
+}
+
+// GetGuardrailVerdicts 获取本次请求的护栏审核结果，会先完成响应阶段的审核
+func GetGuardrailVerdicts(c *gin.Context) []GuardrailVerdict {
+	state, ok := common.GetContextKeyType[*guardrailState](c, constant.ContextKeyGuardrailState)
+	if !ok {
+		return nil
+	}
+	if state.response != nil {
+		state.response.evaluate()
+	}
+	return state.getVerdicts()
+}
+
+// guardrailResponseWriter 在响应阶段调用护栏钩子，非流式响应审核通过后才写出；
+// 流式响应默认边审核边发送，拦截时在末尾追加错误事件，钩子开启 buffer_stream 时缓存到审核通过后再发送
+type guardrailResponseWriter struct {
+	gin.ResponseWriter
+	c            *gin.Context
+	relayFormat  types.RelayFormat
+	hooks        []operation_setting.GuardrailHook
+	state        *guardrailState
+	mode         moderationMode
+	bufferStream bool
+
+	mutex   sync.Mutex
+	body    bytes.Buffer
+	once    sync.Once
+	blocked *GuardrailVerdict
+}
+
+// StartGuardrails 初始化护栏审核状态，存在响应阶段的钩子时包装响应写入器，返回结束时需要调用的函数
+func StartGuardrails(c *gin.Context, relayFormat types.RelayFormat) func() {
+	state := getGuardrailState(c)
+	hooks := getGuardrailHooks(c, operation_setting.GuardrailStagePostResponse)
+	if len(hooks) == 0 {
+		return nil
+	}
+	w := &guardrailResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		relayFormat:    relayFormat,
+		hooks:          hooks,
+		state:          state,
+		bufferStream: lo.ContainsBy(hooks, func(hook operation_setting.GuardrailHook) bool {
+			return hook.BufferStream
+		}),
+	}
+	state.response = w
+	c.Writer = w
+	return w.finish
+}
+
+func (w *guardrailResponseWriter) detectMode() moderationMode {
+	if w.mode == moderationModeUnknown {
+		w.mode = detectModerationMode(w.Header())
+	}
+	return w.mode
+}
+
+// buffering 判断是否缓存响应到审核完成后再写出
+func (w *guardrailResponseWriter) buffering() bool {
+	mode := w.detectMode()
+	return mode == moderationModeBuffer || (mode == moderationModeStream && w.bufferStream)
+}
+
+func (w *guardrailResponseWriter) Write(data []byte) (int, error) {
+	if w.buffering() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		return w.body.Write(data)
+	}
+	switch w.detectMode() {
+	case moderationModeStream:
+		w.mutex.Lock()
+		if remaining := guardrailMaxStreamBytes - w.body.Len(); remaining > 0 {
+			w.body.Write(data[:min(len(data), remaining)])
+		}
+		w.mutex.Unlock()
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *guardrailResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow 缓存的响应在审核完成后才写出响应头
+func (w *guardrailResponseWriter) WriteHeaderNow() {
+	if !w.buffering() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *guardrailResponseWriter) Flush() {
+	if !w.buffering() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// evaluate 调用响应阶段的钩子，只执行一次；结算时调用以便把结果记录到消费日志中
+func (w *guardrailResponseWriter) evaluate() {
+	w.once.Do(func() {
+		if w.mode == moderationModeUnknown || w.mode == moderationModePassthrough || w.Status() != http.StatusOK {
+			return
+		}
+		w.mutex.Lock()
+		body := bytes.Clone(w.body.Bytes())
+		w.mutex.Unlock()
+		text := extractResponseText(body, w.mode == moderationModeStream)
+		if text == "" {
+			return
+		}
+		payload := newGuardrailHookRequest(w.c, operation_setting.GuardrailStagePostResponse, text, body)
+		verdicts, blocked, _ := runGuardrailHooks(w.c, w.hooks, payload)
+		w.state.addVerdicts(verdicts)
+		if blocked != nil {
+			logger.LogWarn(w.c, fmt.Sprintf("response blocked by guardrail %s: %s", blocked.Hook, blocked.Reason))
+			w.blocked = blocked
+		}
+	})
+}
+
+// blockedErrorBody 拦截时返回给客户端的错误内容
+func (w *guardrailResponseWriter) blockedErrorBody() (*types.NewAPIError, []byte) {
+	newAPIError := guardrailBlockedError(w.blocked)
+	newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), w.c.GetString(common.RequestIdKey)))
+	var body []byte
+	if w.relayFormat == types.RelayFormatClaude {
+		body, _ = common.Marshal(gin.H{"type": "error", "error": newAPIError.ToClaudeError()})
+	} else {
+		body, _ = common.Marshal(gin.H{"error": newAPIError.ToOpenAIError()})
+	}
+	return newAPIError, body
+}
+
+func (w *guardrailResponseWriter) finish() {
+	w.evaluate()
+	switch w.mode {
+	case moderationModeStream:
+		w.finishStream()
+	case moderationModeBuffer:
+		body := w.body.Bytes()
+		if w.blocked != nil {
+			var newAPIError *types.NewAPIError
+			newAPIError, body = w.blockedErrorBody()
+			w.WriteHeader(newAPIError.StatusCode)
+		}
+		if w.Header().Get("Content-Length") != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.ResponseWriter.WriteHeaderNow()
+		_, _ = w.ResponseWriter.Write(body)
+	}
+}
+
+// finishStream 流式响应被拦截时发送错误事件；缓存的流式响应审核通过后才写出
+func (w *guardrailResponseWriter) finishStream() {
+	if w.blocked == nil {
+		if w.bufferStream {
+			w.ResponseWriter.WriteHeaderNow()
+			_, _ = w.ResponseWriter.Write(w.body.Bytes())
+			w.ResponseWriter.Flush()
+		}
+		return
+	}
+	_, body := w.blockedErrorBody()
+	eventType := ""
+	if w.relayFormat == types.RelayFormatClaude {
+		eventType = "error"
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	_, _ = w.ResponseWriter.Write([]byte(formatSSEEvent(eventType, string(body))))
+	w.ResponseWriter.Flush()
+}
+
+// extractResponseText 提取响应中的文本内容，支持 OpenAI、Claude、Gemini 和 Responses 格式
+func extractResponseText(body []byte, stream bool) string {
+	if stream {
+		return gjson.GetBytes(reassembleStreamResponse(body), "content").String()
+	}
+	if !gjson.ValidBytes(body) {
+		return ""
+	}
+	var texts []string
+	for _, path := range []string{
+		"choices.#.message.content",
+		"choices.#.text",
+		"content.#.text",
+		"candidates.#.content.parts.#.text",
+		"output.#.content.#.text",
+	} {
+		collectStrings(gjson.GetBytes(body, path), &texts)
+	}
+	return strings.Join(texts, "\n")
+}
+
+func collectStrings(result gjson.Result, texts *[]string) {
+	if result.IsArray() {
+		for _, item := range result.Array() {
+			collectStrings(item, texts)
+		}
+		return
+	}
+	if result.Type == gjson.String && result.String() != "" {
+		*texts = append(*texts, result.String())
+	}
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newGuardrailStub 启动本地钩子服务，handler 返回钩子的响应
+func newGuardrailStub(t *testing.T, handler func(request map[string]any) (int, string)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]any
+		body, _ := io.ReadAll(r.Body)
+		_ = common.Unmarshal(body, &request)
+		status, response := handler(request)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func setGuardrailHooks(t *testing.T, hooks ...operation_setting.GuardrailHook) {
+	t.Helper()
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	setting := operation_setting.GetGuardrailSetting()
+	original := *setting
+	setting.Enabled = true
+	setting.Hooks = hooks
+	t.Cleanup(func() { *setting = original })
+}
+
+func newGuardrailContext(body string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	return c, recorder
+}
+
+func newGuardrailRelayInfo(t *testing.T, body string) *relaycommon.RelayInfo {
+	t.Helper()
+	request := &dto.GeneralOpenAIRequest{}
+	if err := common.UnmarshalJsonStr(body, request); err != nil {
+		t.Fatal(err)
+	}
+	return &relaycommon.RelayInfo{Request: request}
+}
+
+func TestPreRequestGuardrailRewriteKeepsModelAndStream(t *testing.T) {
+	server := newGuardrailStub(t, func(request map[string]any) (int, string) {
+		if request["stage"] != operation_setting.GuardrailStagePreRequest || !strings.Contains(request["text"].(string), "secret") {
+			return http.StatusBadRequest, `{}`
+		}
+		return http.StatusOK, `{"action":"rewrite","body":{"model":"expensive-model","stream":true,"messages":[{"role":"user","content":"[redacted]"}]}}`
+	})
+	setGuardrailHooks(t, operation_setting.GuardrailHook{Name: "rewrite", Url: server.URL, Stages: []string{operation_setting.GuardrailStagePreRequest}})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"my secret"}]}`
+	c, _ := newGuardrailContext(body)
+	info := newGuardrailRelayInfo(t, body)
+	if newAPIError := ApplyPreRequestGuardrails(c, info); newAPIError != nil {
+		t.Fatal(newAPIError)
+	}
+	request := info.Request.(*dto.GeneralOpenAIRequest)
+	if request.Model != "gpt-4o-mini" || request.Stream {
+		t.Fatalf("rewrite should not change model or stream, got %s %v", request.Model, request.Stream)
+	}
+	if request.Messages[0].StringContent() != "[redacted]" {
+		t.Fatalf("expected rewritten content, got %s", request.Messages[0].StringContent())
+	}
+	rewrittenBody, _ := common.GetRequestBody(c)
+	if strings.Contains(string(rewrittenBody), "expensive-model") || strings.Contains(string(rewrittenBody), "stream") {
+		t.Fatalf("protected fields should be restored in body: %s", rewrittenBody)
+	}
+	if verdicts := GetGuardrailVerdicts(c); len(verdicts) != 1 || verdicts[0].Action != GuardrailActionRewrite {
+		t.Fatalf("unexpected verdicts: %+v", verdicts)
+	}
+}
+
+func TestPreRequestGuardrailBlockAndFailPolicy(t *testing.T) {
+	blockServer := newGuardrailStub(t, func(request map[string]any) (int, string) {
+		return http.StatusOK, `{"action":"block","reason":"pii"}`
+	})
+	failServer := newGuardrailStub(t, func(request map[string]any) (int, string) {
+		return http.StatusInternalServerError, `{"error":"down"}`
+	})
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hello"}]}`
+	stages := []string{operation_setting.GuardrailStagePreRequest}
+
+	cases := []struct {
+		name   string
+		hook   operation_setting.GuardrailHook
+		status int
+		code   types.ErrorCode
+	}{
+		{"block", operation_setting.GuardrailHook{Name: "block", Url: blockServer.URL, Stages: stages}, http.StatusBadRequest, types.ErrorCodeGuardrailBlocked},
+		{"fail closed", operation_setting.GuardrailHook{Name: "closed", Url: failServer.URL, Stages: stages}, http.StatusServiceUnavailable, types.ErrorCodeGuardrailUnavailable},
+		{"fail open", operation_setting.GuardrailHook{Name: "open", Url: failServer.URL, Stages: stages, FailOpen: true}, 0, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setGuardrailHooks(t, tc.hook)
+			c, _ := newGuardrailContext(body)
+			newAPIError := ApplyPreRequestGuardrails(c, newGuardrailRelayInfo(t, body))
+			if tc.status == 0 {
+				if newAPIError != nil {
+					t.Fatalf("expected request to pass, got %v", newAPIError)
+				}
+				return
+			}
+			if newAPIError == nil || newAPIError.StatusCode != tc.status || newAPIError.GetErrorCode() != tc.code {
+				t.Fatalf("expected %d %s, got %v", tc.status, tc.code, newAPIError)
+			}
+		})
+	}
+}
+
+func TestPostResponseGuardrailOpenAIModeration(t *testing.T) {
+	server := newGuardrailStub(t, func(request map[string]any) (int, string) {
+		if strings.Contains(request["input"].(string), "bad") {
+			return http.StatusOK, `{"results":[{"flagged":true,"categories":{"violence":true,"hate":false}}]}`
+		}
+		return http.StatusOK, `{"results":[{"flagged":false}]}`
+	})
+	hook := operation_setting.GuardrailHook{
+		Name:   "moderation",
+		Url:    server.URL,
+		Format: operation_setting.GuardrailFormatOpenAIModeration,
+		Stages: []string{operation_setting.GuardrailStagePostResponse},
+	}
+	streamBody := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"bad words\"}}]}\n\ndata: [DONE]\n\n"
+
+	t.Run("json", func(t *testing.T) {
+		setGuardrailHooks(t, hook)
+		c, recorder := newGuardrailContext(`{}`)
+		finish := StartGuardrails(c, types.RelayFormatOpenAI)
+		c.Header("Content-Type", "application/json")
+		c.Writer.WriteHeader(http.StatusOK)
+		_, _ = c.Writer.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"bad words"}}]}`))
+		finish()
+		if recorder.Code != http.StatusBadRequest || strings.Contains(recorder.Body.String(), "bad words") ||
+			!strings.Contains(recorder.Body.String(), "violence") {
+			t.Fatalf("expected blocked response, got %d %s", recorder.Code, recorder.Body.String())
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		setGuardrailHooks(t, hook)
+		c, recorder := newGuardrailContext(`{}`)
+		finish := StartGuardrails(c, types.RelayFormatOpenAI)
+		c.Header("Content-Type", "text/event-stream")
+		_, _ = c.Writer.Write([]byte(streamBody))
+		finish()
+		body := recorder.Body.String()
+		if !strings.Contains(body, "bad words") || !strings.HasSuffix(body, "\n\n") || !strings.Contains(body, `"code":"guardrail_blocked"`) {
+			t.Fatalf("expected error event appended to stream, got %s", body)
+		}
+	})
+
+	t.Run("buffered stream", func(t *testing.T) {
+		buffered := hook
+		buffered.BufferStream = true
+		setGuardrailHooks(t, buffered)
+		c, recorder := newGuardrailContext(`{}`)
+		finish := StartGuardrails(c, types.RelayFormatOpenAI)
+		c.Header("Content-Type", "text/event-stream")
+		_, _ = c.Writer.Write([]byte(streamBody))
+		c.Writer.Flush()
+		if recorder.Body.Len() != 0 {
+			t.Fatalf("buffered stream should not be sent before moderation: %s", recorder.Body.String())
+		}
+		finish()
+		body := recorder.Body.String()
+		if strings.Contains(body, "bad words") || !strings.Contains(body, "guardrail_blocked") {
+			t.Fatalf("expected only error event, got %s", body)
+		}
+	})
+}
+
+func TestGuardrailHookUsesChannel(t *testing.T) {
+	var authorization, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, path = r.Header.Get("Authorization"), r.URL.Path
+		_, _ = w.Write([]byte(`{"results":[{"flagged":true,"categories":{"self-harm":true}}]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	db, err := gorm.Open(sqlite.Open("file:guardrail_channel?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalDB := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = originalDB })
+	if err := db.AutoMigrate(&model.Channel{}); err != nil {
+		t.Fatal(err)
+	}
+	channel := &model.Channel{Name: "moderation", Key: "sk-moderation", Status: common.ChannelStatusEnabled, BaseURL: common.GetPointer(server.URL + "/")}
+	if err := db.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	setGuardrailHooks(t, operation_setting.GuardrailHook{Name: "channel", ChannelId: channel.Id, Stages: []string{operation_setting.GuardrailStagePreRequest}})
+
+	body := `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"hello"}]}`
+	c, _ := newGuardrailContext(body)
+	newAPIError := ApplyPreRequestGuardrails(c, newGuardrailRelayInfo(t, body))
+	if newAPIError == nil || newAPIError.GetErrorCode() != types.ErrorCodeGuardrailBlocked {
+		t.Fatalf("expected request to be blocked by channel moderation, got %v", newAPIError)
+	}
+	if path != "/v1/moderations" || authorization != "Bearer sk-moderation" {
+		t.Fatalf("unexpected moderation request: %s %s", path, authorization)
+	}
+}
@@ -0,0 +1,415 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+const logArchiveDayLayout = "2006-01-02"
+
+var logArchiveRunning atomic.Bool
+
+// LogArchiveFilter 查询归档日志的条件，时间范围按天定位归档文件后再精确过滤
+type LogArchiveFilter struct {
+	Type           int
+	StartTimestamp int64
+	EndTimestamp   int64
+	Username       string
+	TokenName      string
+	ModelName      string
+	ChannelId      int
+	Group          string
+}
+
+func (f *LogArchiveFilter) match(log *model.Log) bool {
+	if f.Type != model.LogTypeUnknown && log.Type != f.Type {
+		return false
+	}
+	if f.StartTimestamp != 0 && log.CreatedAt < f.StartTimestamp {
+		return false
+	}
+	if f.EndTimestamp != 0 && log.CreatedAt > f.EndTimestamp {
+		return false
+	}
+	if f.Username != "" && log.Username != f.Username {
+		return false
+	}
+	if f.TokenName != "" && log.TokenName != f.TokenName {
+		return false
+	}
+	if f.ModelName != "" && log.ModelName != f.ModelName {
+		return false
+	}
+	if f.ChannelId != 0 && log.ChannelId != f.ChannelId {
+		return false
+	}
+	if f.Group != "" && log.Group != f.Group {
+		return false
+	}
+	return true
+}
+
+// LogArchiveQueryResult 归档日志的查询结果，汇总字段统计全部匹配的日志
+type LogArchiveQueryResult struct {
+	Items            []*model.Log `json:"items"`
+	Total            int          `json:"total"`
+	Quota            int64        `json:"quota"`
+	PromptTokens     int64        `json:"prompt_tokens"`
+	CompletionTokens int64        `json:"completion_tokens"`
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// IsLogArchiveRunning 日志归档任务是否正在执行
+func IsLogArchiveRunning() bool {
+	return logArchiveRunning.Load()
+}
+
+// ArchiveLogs 将早于保留天数的日志按天归档到存储后，再从数据库中删除，返回归档的日志条数
+func ArchiveLogs(ctx context.Context) (int64, error) {
+	if !logArchiveRunning.CompareAndSwap(false, true) {
+		return 0, errors.New("log archive is already running")
+	}
+	defer logArchiveRunning.Store(false)
+
+	setting := operation_setting.GetLogArchiveSetting()
+	if setting.RetentionDays <= 0 {
+		return 0, errors.New("log archive retention days must be positive")
+	}
+	store, err := getLogArchiveStore(setting)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	cutoff := startOfDay(now.AddDate(0, 0, -setting.RetentionDays))
+	// 账单从使用日志汇总，尚未结束的月份不归档
+	if monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()); cutoff.After(monthStart) {
+		cutoff = monthStart
+	}
+	oldest, err := model.GetOldestLogTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	if oldest == 0 || oldest >= cutoff.Unix() {
+		return 0, nil
+	}
+	if err := generateBillingStatementsBeforeArchive(time.Unix(oldest, 0), cutoff); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for day := startOfDay(time.Unix(oldest, 0)); day.Before(cutoff); day = day.AddDate(0, 0, 1) {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		count, err := archiveLogDay(ctx, store, day, setting.GetBatchSize())
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// generateBillingStatementsBeforeArchive 日志从数据库删除前，先为待归档日志所在的月份生成账单
+func generateBillingStatementsBeforeArchive(oldest time.Time, cutoff time.Time) error {
+	last := cutoff.AddDate(0, 0, -1)
+	for month := time.Date(oldest.Year(), oldest.Month(), 1, 0, 0, 0, 0, oldest.Location()); !month.After(last); month = month.AddDate(0, 1, 0) {
+		period := month.Format(billingStatementPeriodLayout)
+		if _, err := GenerateBillingStatements(period); err != nil {
+			return fmt.Errorf("failed to generate billing statements %s before archiving logs: %w", period, err)
+		}
+	}
+	return nil
+}
+
+// archiveLogDay 归档一天的日志，每批写入一个文件，写入成功后删除对应的日志
+func archiveLogDay(ctx context.Context, store *logArchiveStore, day time.Time, batchSize int) (int64, error) {
+	start := day.Unix()
+	end := day.AddDate(0, 0, 1).Unix()
+	afterId := 0
+	var total int64
+	for {
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+		logs, err := model.GetLogsForArchive(start, end, afterId, batchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(logs) == 0 {
+			return total, nil
+		}
+		ids := make([]int, 0, len(logs))
+		for _, log := range logs {
+			ids = append(ids, log.Id)
+		}
+		// 从归档恢复的日志已在归档中，直接删除
+		restoredIds, err := model.GetRestoredLogIds(ids)
+		if err != nil {
+			return total, err
+		}
+		pending := logs
+		if len(restoredIds) > 0 {
+			restored := make(map[int]struct{}, len(restoredIds))
+			for _, id := range restoredIds {
+				restored[id] = struct{}{}
+			}
+			pending = make([]*model.Log, 0, len(logs))
+			for _, log := range logs {
+				if _, ok := restored[log.Id]; !ok {
+					pending = append(pending, log)
+				}
+			}
+		}
+		if len(pending) > 0 {
+			data, err := encodeLogArchive(pending)
+			if err != nil {
+				return total, err
+			}
+			firstId, lastId := pending[0].Id, pending[len(pending)-1].Id
+			name := fmt.Sprintf("day=%s/logs-%d-%d.ndjson.gz", day.Format(logArchiveDayLayout), firstId, lastId)
+			if err := store.Put(ctx, name, data); err != nil {
+				return total, fmt.Errorf("failed to upload log archive %s: %w", name, err)
+			}
+			common.SysLog(fmt.Sprintf("archived %d logs to %s", len(pending), name))
+		}
+		deleted, err := model.DeleteLogsByIds(ids)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if err := model.DeleteRestoredLogMarks(restoredIds); err != nil {
+			return total, err
+		}
+		if len(logs) < batchSize {
+			return total, nil
+		}
+		afterId = logs[len(logs)-1].Id
+	}
+}
+
+// encodeLogArchive 将日志编码为 gzip 压缩的 NDJSON
+func encodeLogArchive(logs []*model.Log) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	for _, log := range logs {
+		line, err := common.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeLogArchive(data []byte, visit func(log *model.Log) error) error {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	lines := bufio.NewReader(reader)
+	for {
+		line, err := lines.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var log model.Log
+			if unmarshalErr := common.Unmarshal(line, &log); unmarshalErr != nil {
+				return unmarshalErr
+			}
+			if visitErr := visit(&log); visitErr != nil {
+				return visitErr
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// logArchiveDayRange 将时间戳范围转换为归档的日期分区范围，未指定时不限制
+func logArchiveDayRange(startTimestamp int64, endTimestamp int64) (string, string) {
+	startDay, endDay := "", ""
+	if startTimestamp > 0 {
+		startDay = time.Unix(startTimestamp, 0).Format(logArchiveDayLayout)
+	}
+	if endTimestamp > 0 {
+		endDay = time.Unix(endTimestamp, 0).Format(logArchiveDayLayout)
+	}
+	return startDay, endDay
+}
+
+// commonPrefix 返回两个字符串的公共前缀
+func commonPrefix(a string, b string) string {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return a[:i]
+		}
+	}
+	return a[:n]
+}
+
+// ListLogArchives 列出日期范围内的归档文件
+func ListLogArchives(ctx context.Context, startTimestamp int64, endTimestamp int64) ([]LogArchiveObject, error) {
+	store, err := getLogArchiveStore(operation_setting.GetLogArchiveSetting())
+	if err != nil {
+		return nil, err
+	}
+	return listLogArchives(ctx, store, startTimestamp, endTimestamp)
+}
+
+func listLogArchives(ctx context.Context, store *logArchiveStore, startTimestamp int64, endTimestamp int64) ([]LogArchiveObject, error) {
+	startDay, endDay := logArchiveDayRange(startTimestamp, endTimestamp)
+	prefix := "day="
+	if startDay != "" && endDay != "" {
+		prefix += commonPrefix(startDay, endDay)
+	}
+	objects, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]LogArchiveObject, 0, len(objects))
+	for _, object := range objects {
+		if object.Day == "" || (startDay != "" && object.Day < startDay) || (endDay != "" && object.Day > endDay) {
+			continue
+		}
+		filtered = append(filtered, object)
+	}
+	return filtered, nil
+}
+
+// scanLogArchives 依次读取日期范围内的归档文件，对满足条件的日志调用 visit
+func scanLogArchives(ctx context.Context, filter *LogArchiveFilter, visit func(log *model.Log) error) error {
+	store, err := getLogArchiveStore(operation_setting.GetLogArchiveSetting())
+	if err != nil {
+		return err
+	}
+	objects, err := listLogArchives(ctx, store, filter.StartTimestamp, filter.EndTimestamp)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		data, err := store.Get(ctx, object.Name)
+		if err != nil {
+			return fmt.Errorf("failed to read log archive %s: %w", object.Name, err)
+		}
+		err = decodeLogArchive(data, func(log *model.Log) error {
+			if !filter.match(log) {
+				return nil
+			}
+			return visit(log)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to decode log archive %s: %w", object.Name, err)
+		}
+	}
+	return nil
+}
+
+// QueryLogArchives 查询归档日志，返回分页结果以及全部匹配日志的额度与 token 汇总
+func QueryLogArchives(ctx context.Context, filter *LogArchiveFilter, startIdx int, pageSize int) (*LogArchiveQueryResult, error) {
+	result := &LogArchiveQueryResult{Items: make([]*model.Log, 0)}
+	err := scanLogArchives(ctx, filter, func(log *model.Log) error {
+		if result.Total >= startIdx && len(result.Items) < pageSize {
+			result.Items = append(result.Items, log)
+		}
+		result.Total++
+		result.Quota += int64(log.Quota)
+		result.PromptTokens += int64(log.PromptTokens)
+		result.CompletionTokens += int64(log.CompletionTokens)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RestoreLogArchives 将时间范围内的归档日志写回数据库，返回写入的条数
+func RestoreLogArchives(ctx context.Context, filter *LogArchiveFilter) (int64, error) {
+	var total int64
+	batch := make([]*model.Log, 0, 500)
+	flush := func() error {
+		count, err := model.RestoreLogs(batch)
+		total += count
+		batch = batch[:0]
+		return err
+	}
+	err := scanLogArchives(ctx, filter, func(log *model.Log) error {
+		batch = append(batch, log)
+		if len(batch) >= cap(batch) {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+	return total, flush()
+}
+
+var logArchiveCSVHeader = []string{
+	"id", "created_at", "type", "user_id", "username", "token_id", "token_name", "model_name", "group", "channel",
+	"quota", "prompt_tokens", "completion_tokens", "use_time", "is_stream", "ip",
+}
+
+// ExportLogArchives 以 CSV 格式导出归档日志，便于财务对账等离线分析
+func ExportLogArchives(ctx context.Context, filter *LogArchiveFilter, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(logArchiveCSVHeader); err != nil {
+		return err
+	}
+	err := scanLogArchives(ctx, filter, func(log *model.Log) error {
+		return writer.Write([]string{
+			strconv.Itoa(log.Id),
+			time.Unix(log.CreatedAt, 0).Format(time.RFC3339),
+			strconv.Itoa(log.Type),
+			strconv.Itoa(log.UserId),
+			log.Username,
+			strconv.Itoa(log.TokenId),
+			log.TokenName,
+			log.ModelName,
+			log.Group,
+			strconv.Itoa(log.ChannelId),
+			strconv.Itoa(log.Quota),
+			strconv.Itoa(log.PromptTokens),
+			strconv.Itoa(log.CompletionTokens),
+			strconv.Itoa(log.UseTime),
+			strconv.FormatBool(log.IsStream),
+			log.Ip,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// LogArchiveObject 一个日志归档文件
+type LogArchiveObject struct {
+	Name    string `json:"name"`
+	Day     string `json:"day"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+// logArchiveStore 日志归档的存储，对象名形如 day=2006-01-02/logs-<起始ID>-<结束ID>.ndjson.gz，
+// 保存在对象存储的 prefix 前缀下
+type logArchiveStore struct {
+	storage ObjectStorage
+	prefix  string
+}
+
+// getLogArchiveStore 根据归档配置创建存储，S3 访问密钥从环境变量
+// LOG_ARCHIVE_S3_ACCESS_KEY_ID、LOG_ARCHIVE_S3_SECRET_ACCESS_KEY 读取，避免保存在选项中
+func getLogArchiveStore(setting *operation_setting.LogArchiveSetting) (*logArchiveStore, error) {
+	switch setting.Storage {
+	case operation_setting.LogArchiveStorageS3:
+		if setting.S3Endpoint == "" || setting.S3Bucket == "" {
+			return nil, errors.New("log archive s3 endpoint and bucket are required")
+		}
+		s3Config := S3Config{
+			Endpoint:        setting.S3Endpoint,
+			Region:          setting.S3Region,
+			Bucket:          setting.S3Bucket,
+			AccessKeyId:     common.GetEnvOrDefaultString("LOG_ARCHIVE_S3_ACCESS_KEY_ID", ""),
+			SecretAccessKey: common.GetEnvOrDefaultString("LOG_ARCHIVE_S3_SECRET_ACCESS_KEY", ""),
+			SessionToken:    common.GetEnvOrDefaultString("LOG_ARCHIVE_S3_SESSION_TOKEN", ""),
+			ForcePathStyle:  setting.S3PathStyle,
+		}
+		if s3Config.AccessKeyId == "" || s3Config.SecretAccessKey == "" {
+			return nil, errors.New("LOG_ARCHIVE_S3_ACCESS_KEY_ID and LOG_ARCHIVE_S3_SECRET_ACCESS_KEY are required for s3 log archive")
+		}
+		storage, err := NewS3Storage(s3Config)
+		if err != nil {
+			return nil, err
+		}
+		return &logArchiveStore{storage: storage, prefix: strings.Trim(setting.S3Prefix, "/")}, nil
+	case operation_setting.LogArchiveStorageLocal, "":
+		if setting.LocalDir == "" {
+			return nil, errors.New("log archive local directory is not configured")
+		}
+		return &logArchiveStore{storage: NewLocalStorage(setting.LocalDir)}, nil
+	}
+	return nil, fmt.Errorf("unknown log archive storage: %s", setting.Storage)
+}
+
+// logArchiveDay 从对象名中解析日期分区
+func logArchiveDay(name string) string {
+	day, _, found := strings.Cut(name, "/")
+	if !found {
+		return ""
+	}
+	return strings.TrimPrefix(day, "day=")
+}
+
+func (s *logArchiveStore) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *logArchiveStore) Put(ctx context.Context, name string, data []byte) error {
+	return s.storage.Put(ctx, s.key(name), data, "application/gzip")
+}
+
+func (s *logArchiveStore) Get(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.storage.Get(ctx, s.key(name))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// List 返回名称以 prefix 开头的归档文件
+func (s *logArchiveStore) List(ctx context.Context, prefix string) ([]LogArchiveObject, error) {
+	objects, err := s.storage.List(ctx, s.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]LogArchiveObject, 0, len(objects))
+	for _, object := range objects {
+		name := object.Key
+		if s.prefix != "" {
+			name = strings.TrimPrefix(name, s.prefix+"/")
+		}
+		if !strings.HasPrefix(name, "day=") || !strings.HasSuffix(name, ".ndjson.gz") {
+			continue
+		}
+		result = append(result, LogArchiveObject{
+			Name:    name,
+			Day:     logArchiveDay(name),
+			Size:    object.Size,
+			ModTime: object.ModTime,
+		})
+	}
+	return result, nil
+}
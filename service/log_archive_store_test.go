@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+func TestLogArchiveStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := &logArchiveStore{storage: NewLocalStorage(t.TempDir()), prefix: "archives"}
+	data, err := encodeLogArchive([]*model.Log{{Id: 1, ModelName: "gpt-4o"}, {Id: 2, ModelName: "claude"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "day=2026-09-01/logs-1-2.ndjson.gz"
+	if err := store.Put(ctx, name, data); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := store.List(ctx, "day=2026-09")
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("expected one archive, got %+v %v", objects, err)
+	}
+	if objects[0].Name != name || objects[0].Day != "2026-09-01" || objects[0].Size != int64(len(data)) {
+		t.Fatalf("unexpected archive object: %+v", objects[0])
+	}
+	read, err := store.Get(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var models []string
+	if err := decodeLogArchive(read, func(log *model.Log) error {
+		models = append(models, log.ModelName)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || models[0] != "gpt-4o" {
+		t.Fatalf("unexpected decoded logs: %v", models)
+	}
+}
@@ -50,13 +50,37 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
 
+	if relayInfo.IsBatch {
+		other["batch"] = true
+		other["batch_ratio"] = relayInfo.BatchRatio
+		// 计费时分组倍率已叠加批处理倍率，日志中分开记录
+		if relayInfo.BatchRatio > 0 {
+			other["group_ratio"] = groupRatio / relayInfo.BatchRatio
+		}
+	}
+
+	if relayInfo.ResponseCacheHit {
+		other["response_cache_hit"] = true
+		other["response_cache_ratio"] = relayInfo.ResponseCacheRatio
+		if relayInfo.ResponseCacheSimilarity > 0 {
+			other["response_cache_similarity"] = relayInfo.ResponseCacheSimilarity
+		}
+	}
+
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
 		other["is_system_prompt_overwritten"] = true
 	}
 
+	if verdicts := GetGuardrailVerdicts(ctx); len(verdicts) > 0 {
+		other["guardrail"] = verdicts
+	}
+
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
+	if relayInfo.Hedge != nil && relayInfo.Hedge.IsFired() {
+		adminInfo["hedged"] = true
+	}
 	isMultiKey := common.GetContextKeyBool(ctx, constant.ContextKeyChannelIsMultiKey)
 	if isMultiKey {
 		adminInfo["is_multi_key"] = true
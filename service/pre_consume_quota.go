@@ -1,10 +1,12 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/metrics"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
@@ -17,8 +19,11 @@ import (
 func ReturnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
 	if relayInfo.FinalPreConsumedQuota != 0 {
 		logger.LogInfo(c, fmt.Sprintf("用户 %d 请求失败, 返还预扣费额度 %s", relayInfo.UserId, logger.FormatQuota(relayInfo.FinalPreConsumedQuota)))
+		metrics.RecordQuotaReturned(relayInfo.OriginModelName, relayInfo.UsingGroup, relayInfo.FinalPreConsumedQuota)
 		gopool.Go(func() {
 			relayInfoCopy := *relayInfo
+			// 预扣费只发生一次，无论哪一方在对冲竞速中落败都需要退还
+			relayInfoCopy.Hedge = nil
 
 			err := PostConsumeQuota(&relayInfoCopy, -relayInfoCopy.FinalPreConsumedQuota, 0, false)
 			if err != nil {
@@ -31,8 +36,12 @@ func ReturnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
 // PreConsumeQuota checks if the user has enough quota to pre-consume.
 // It returns the pre-consumed quota if successful, or an error if not.
 func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	// 组织令牌使用组织额度池，可用额度同时受成员每月上限限制
+	userQuota, memberLimited, err := model.GetBillingQuota(relayInfo.UserId, relayInfo.OrganizationId)
 	if err != nil {
+		if relayInfo.OrganizationId != 0 {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
 		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
 	}
 	if userQuota <= 0 {
@@ -45,7 +54,8 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 	trustQuota := common.GetTrustQuota()
 
 	relayInfo.UserQuota = userQuota
-	if userQuota > trustQuota {
+	// 配置了预算周期的令牌和设置了每月上限的组织成员需要预扣费，保证预算和上限不会被并发请求超出
+	if userQuota > trustQuota && !relayInfo.TokenBudgetLimited && !memberLimited {
 		// 用户额度充足，判断令牌额度是否充足
 		if !relayInfo.TokenUnlimited {
 			// 非无限令牌，判断令牌额度是否充足
@@ -63,17 +73,44 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		}
 	}
 
+	if preConsumedQuota == 0 && relayInfo.TokenBudgetLimited {
+		// 不需要预扣费时仍然检查预算是否已用尽
+		err := PreConsumeTokenQuota(relayInfo, 0)
+		if err != nil {
+			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+	}
 	if preConsumedQuota > 0 {
 		err := PreConsumeTokenQuota(relayInfo, preConsumedQuota)
 		if err != nil {
 			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
-		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+		err = model.ConsumeBillingQuota(relayInfo.UserId, relayInfo.OrganizationId, preConsumedQuota)
 		if err != nil {
+			// 已预扣的令牌额度和预算需要退还
+			returnPreConsumedTokenQuota(relayInfo, preConsumedQuota)
+			if errors.Is(err, model.ErrOrganizationQuotaExceeded) {
+				return types.NewErrorWithStatusCode(err, types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+			}
 			return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
 		}
 		logger.LogInfo(c, fmt.Sprintf("用户 %d 预扣费 %s, 预扣费后剩余额度: %s", relayInfo.UserId, logger.FormatQuota(preConsumedQuota), logger.FormatQuota(userQuota-preConsumedQuota)))
+		metrics.RecordQuotaPreConsumed(relayInfo.OriginModelName, relayInfo.UsingGroup, preConsumedQuota)
 	}
 	relayInfo.FinalPreConsumedQuota = preConsumedQuota
 	return nil
 }
+
+func returnPreConsumedTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) {
+	if relayInfo.IsPlayground {
+		return
+	}
+	if err := model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota); err != nil {
+		common.SysLog("error return pre-consumed token quota: " + err.Error())
+	}
+	if relayInfo.TokenBudgetLimited {
+		if err := model.AdjustTokenBudget(relayInfo.TokenId, -quota); err != nil {
+			common.SysLog("error release token budget: " + err.Error())
+		}
+	}
+}
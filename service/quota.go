@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/tracing"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
@@ -117,7 +118,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, _, err := model.GetBillingQuota(relayInfo.UserId, relayInfo.OrganizationId)
 	if err != nil {
 		return err
 	}
@@ -181,8 +182,24 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	return nil
 }
 
+// ShouldSettle 判断本次尝试是否需要结算，对冲竞速中落败的尝试不计费、不记录日志，由胜出的一方结算
+// 各结算入口在计算费用前调用；PostConsumeQuota 和消费日志另有同样的检查兜底
+func ShouldSettle(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) bool {
+	if !relayInfo.IsHedgeLost() {
+		return true
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("hedge attempt on channel #%d lost, skip billing", relayInfo.ChannelId))
+	return false
+}
+
 func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string,
 	usage *dto.RealtimeUsage, extraContent string) {
+	if !ShouldSettle(ctx, relayInfo) {
+		return
+	}
+	_, span := tracing.Start(ctx.Request.Context(), "quota.settle", tracing.ChannelAttributes(relayInfo.ChannelId, relayInfo.UpstreamModelName)...)
+	defer span.End()
+	ReconcileTPMLimit(relayInfo, usage.InputTokens, usage.OutputTokens)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.InputTokenDetails.TextTokens
@@ -263,6 +280,13 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 }
 
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) {
+	if !ShouldSettle(ctx, relayInfo) {
+		return
+	}
+	_, span := tracing.Start(ctx.Request.Context(), "quota.settle", tracing.ChannelAttributes(relayInfo.ChannelId, relayInfo.UpstreamModelName)...)
+	defer span.End()
+	ReconcileTPMLimit(relayInfo, usage.PromptTokens, usage.CompletionTokens)
+	AdjustModeratedCompletionUsage(ctx, relayInfo, usage)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
@@ -311,8 +335,11 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		calculateQuota = modelPrice * common.QuotaPerUnit * groupRatio
 	}
+	// 命中响应缓存时单独叠加缓存倍率，分组倍率保持不变
+	responseCacheRatio := relayInfo.GetResponseCacheRatio()
+	calculateQuota *= responseCacheRatio
 
-	if modelRatio != 0 && calculateQuota <= 0 {
+	if modelRatio != 0 && responseCacheRatio != 0 && calculateQuota <= 0 {
 		calculateQuota = 1
 	}
 
@@ -402,6 +429,12 @@ func CalcOpenRouterCacheCreateTokens(usage dto.Usage, priceData types.PriceData)
 }
 
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
+	if !ShouldSettle(ctx, relayInfo) {
+		return
+	}
+	_, span := tracing.Start(ctx.Request.Context(), "quota.settle", tracing.ChannelAttributes(relayInfo.ChannelId, relayInfo.UpstreamModelName)...)
+	defer span.End()
+	ReconcileTPMLimit(relayInfo, usage.PromptTokens, usage.CompletionTokens)
 
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
@@ -521,19 +554,55 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", logger.FormatQuota(token.RemainQuota), logger.FormatQuota(quota))
 	}
+	if relayInfo.TokenBudgetLimited {
+		if err = preConsumeTokenBudget(token, quota); err != nil {
+			return err
+		}
+	}
+	if quota == 0 {
+		return nil
+	}
 	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
 	if err != nil {
+		// 令牌额度扣除失败时释放已占用的预算，避免预算被永久占用
+		if relayInfo.TokenBudgetLimited {
+			if budgetErr := model.AdjustTokenBudget(relayInfo.TokenId, -quota); budgetErr != nil {
+				common.SysLog("error release token budget: " + budgetErr.Error())
+			}
+		}
 		return err
 	}
 	return nil
 }
 
+// preConsumeTokenBudget 在令牌的每日/每周/每月预算中预扣额度
+func preConsumeTokenBudget(token *model.Token, quota int) error {
+	period, err := model.ConsumeTokenBudget(token, quota)
+	if err != nil {
+		return err
+	}
+	if period == "" {
+		return nil
+	}
+	for _, limit := range token.GetBudgetLimits() {
+		if limit.Period == period {
+			_, resetAt := model.GetTokenBudgetWindow(period, time.Now())
+			return fmt.Errorf("token %s budget is not enough, budget: %s, need quota: %s, resets at %s", period, logger.FormatQuota(limit.Limit), logger.FormatQuota(quota), resetAt.Format("2006-01-02 15:04:05"))
+		}
+	}
+	return fmt.Errorf("token %s budget is not enough", period)
+}
+
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
+	// 所有扣费都经过这里，对冲竞速中落败的尝试在此统一跳过；退还预扣费时已清除对冲状态，不受影响
+	if relayInfo.IsHedgeLost() {
+		return nil
+	}
 
 	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
+		err = model.DecreaseBillingQuota(relayInfo.UserId, relayInfo.OrganizationId, quota)
 	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
+		err = model.IncreaseBillingQuota(relayInfo.UserId, relayInfo.OrganizationId, -quota)
 	}
 	if err != nil {
 		return err
@@ -548,9 +617,15 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 		if err != nil {
 			return err
 		}
+		if relayInfo.TokenBudgetLimited {
+			if err = model.AdjustTokenBudget(relayInfo.TokenId, quota); err != nil {
+				return err
+			}
+		}
 	}
 
-	if sendEmail {
+	// 组织额度池的余额不属于个人，不发送个人额度提醒
+	if sendEmail && relayInfo.OrganizationId == 0 {
 		if (quota + preConsumedQuota) != 0 {
 			checkAndSendQuotaNotify(relayInfo, quota, preConsumedQuota)
 		}
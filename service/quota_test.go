@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// setupQuotaTestDB 使用内存 SQLite 初始化数据库，从节点不执行完整迁移
+func setupQuotaTestDB(t *testing.T) {
+	t.Helper()
+	originalDB, sqlitePath, usingSQLite, isMasterNode, redisEnabled := model.DB, common.SQLitePath, common.UsingSQLite, common.IsMasterNode, common.RedisEnabled
+	t.Cleanup(func() {
+		model.DB, common.SQLitePath, common.UsingSQLite, common.IsMasterNode, common.RedisEnabled = originalDB, sqlitePath, usingSQLite, isMasterNode, redisEnabled
+	})
+	t.Setenv("SQL_DSN", "")
+	common.SQLitePath = "file:service_quota?mode=memory&cache=shared"
+	common.IsMasterNode = false
+	common.RedisEnabled = false
+	if err := model.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.DB.AutoMigrate(&model.Token{}, &model.TokenBudget{}, &model.Organization{}, &model.OrganizationMember{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreConsumeTokenQuotaReleasesBudgetOnFailure(t *testing.T) {
+	setupQuotaTestDB(t)
+	token := &model.Token{Key: "budget-release", Name: "budget", RemainQuota: 1000, DailyQuotaLimit: 500}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+	info := &relaycommon.RelayInfo{TokenId: token.Id, TokenKey: token.Key, TokenBudgetLimited: true}
+
+	// 令牌额度扣除失败
+	err := model.DB.Callback().Update().Before("gorm:update").Register("test:fail_token_update", func(tx *gorm.DB) {
+		if tx.Statement.Table == "tokens" {
+			_ = tx.AddError(errors.New("token update failed"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PreConsumeTokenQuota(info, 100); err == nil {
+		t.Fatal("expected token quota decrease to fail")
+	}
+	usages, err := model.GetTokenBudgetUsage(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usages[0].Used != 0 {
+		t.Fatalf("budget should be released after failure, used %d", usages[0].Used)
+	}
+
+	if err := model.DB.Callback().Update().Remove("test:fail_token_update"); err != nil {
+		t.Fatal(err)
+	}
+	if err := PreConsumeTokenQuota(info, 100); err != nil {
+		t.Fatal(err)
+	}
+	usages, _ = model.GetTokenBudgetUsage(token)
+	if usages[0].Used != 100 {
+		t.Fatalf("budget should be consumed, used %d", usages[0].Used)
+	}
+}
+
+func TestPreConsumeQuotaReturnsTokenQuotaWhenOrganizationLimitExceeded(t *testing.T) {
+	setupQuotaTestDB(t)
+	organization, err := model.CreateOrganization("limited", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.SetOrganizationQuota(organization.Id, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.InviteOrganizationMember(organization.Id, 2, 1, model.OrganizationRoleMember, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.AcceptOrganizationInvitation(organization.Id, 2); err != nil {
+		t.Fatal(err)
+	}
+	token := &model.Token{Key: "organization-limited", Name: "organization", UserId: 2, RemainQuota: 1000, DailyQuotaLimit: 500, OrganizationId: organization.Id}
+	if err := model.DB.Create(token).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set("token_quota", token.RemainQuota)
+	info := &relaycommon.RelayInfo{UserId: 2, TokenId: token.Id, TokenKey: token.Key, TokenBudgetLimited: true, OrganizationId: organization.Id}
+	if newAPIError := PreConsumeQuota(c, 40, info); newAPIError != nil {
+		t.Fatal(newAPIError)
+	}
+	// 并发请求使组织额度扣除失败时，已预扣的令牌额度和预算需要退还
+	err = model.DB.Callback().Update().Before("gorm:update").Register("test:fail_member_update", func(tx *gorm.DB) {
+		if tx.Statement.Table == "organization_members" {
+			_ = tx.AddError(model.ErrOrganizationQuotaExceeded)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = model.DB.Callback().Update().Remove("test:fail_member_update") })
+	if newAPIError := PreConsumeQuota(c, 5, info); newAPIError == nil || newAPIError.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected organization quota to be exceeded, got %v", newAPIError)
+	}
+	if err := model.DB.First(token, token.Id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if token.RemainQuota != 960 {
+		t.Fatalf("token quota should be returned after failure, got %d", token.RemainQuota)
+	}
+	usages, _ := model.GetTokenBudgetUsage(token)
+	if usages[0].Used != 40 {
+		t.Fatalf("token budget should be released after failure, used %d", usages[0].Used)
+	}
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const responseCacheKeyPrefix = "response_cache:"
+
+// responseCacheIgnoredFields 不影响响应内容的请求字段，计算缓存 key 时忽略
+var responseCacheIgnoredFields = []string{
+	"stream",
+	"stream_options",
+	"user",
+	"metadata",
+	"safety_identifier",
+	"prompt_cache_key",
+	"store",
+}
+
+// ResponseCacheEntry 缓存的响应内容及其用量
+type ResponseCacheEntry struct {
+	Stream      bool      `json:"stream"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Usage       dto.Usage `json:"usage"`
+	CreatedAt   int64     `json:"created_at"`
+}
+
+type memoryResponseCacheItem struct {
+	entry    *ResponseCacheEntry
+	expireAt time.Time
+}
+
+var (
+	memoryResponseCache     = make(map[string]memoryResponseCacheItem)
+	memoryResponseCacheLock sync.Mutex
+)
+
+// ResponseCacheKey 一次请求的缓存 key，开启语义缓存时同时记录用于相似度查找的对话文本
+type ResponseCacheKey struct {
+	Key string
+	TTL time.Duration
+
+	semanticScope string    // 除对话文本外其余参数相同的请求共享同一个语义索引
+	semanticText  string    // 对话文本，包含非文本内容或工具调用时为空，不参与语义缓存
+	embedding     []float32 // 查找语义缓存时计算的向量，写入缓存时复用
+}
+
+// GetResponseCacheKey 根据归一化后的请求计算缓存 key，请求不可缓存时返回 nil
+func GetResponseCacheKey(c *gin.Context, info *relaycommon.RelayInfo, request any) *ResponseCacheKey {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled {
+		return nil
+	}
+	ttl := setting.GetTTLSeconds(info.UsingGroup, info.TokenId)
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := common.Marshal(request)
+	if err != nil {
+		return nil
+	}
+	var normalized map[string]any
+	if err = common.Unmarshal(data, &normalized); err != nil {
+		return nil
+	}
+	if setting.RequireZeroTemperature {
+		temperature, ok := normalized["temperature"].(float64)
+		if !ok || temperature != 0 {
+			return nil
+		}
+	}
+	for _, field := range responseCacheIgnoredFields {
+		delete(normalized, field)
+	}
+	normalized["model"] = info.OriginModelName
+	// map 序列化时按 key 排序，字段顺序不同的相同请求得到相同的 key
+	data, err = common.Marshal(normalized)
+	if err != nil {
+		return nil
+	}
+	scope := "global"
+	if !setting.ShareAcrossUsers {
+		scope = fmt.Sprintf("user:%d", info.UserId)
+	}
+	prefix := fmt.Sprintf("%s:%s:%t", scope, info.RelayFormat, info.IsStream)
+	hash := sha256.Sum256(data)
+	cacheKey := &ResponseCacheKey{
+		Key: responseCacheKeyPrefix + prefix + ":" + hex.EncodeToString(hash[:]),
+		TTL: time.Duration(ttl) * time.Second,
+	}
+	if setting.IsSemanticEnabled() {
+		cacheKey.semanticScope, cacheKey.semanticText = getResponseCacheSemanticScope(prefix, normalized)
+	}
+	return cacheKey
+}
+
+// GetResponseCache 读取缓存，未命中时返回 nil
+func GetResponseCache(key string) *ResponseCacheEntry {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return nil
+		}
+		var entry ResponseCacheEntry
+		if err = common.UnmarshalJsonStr(value, &entry); err != nil {
+			return nil
+		}
+		return &entry
+	}
+	memoryResponseCacheLock.Lock()
+	defer memoryResponseCacheLock.Unlock()
+	item, ok := memoryResponseCache[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(item.expireAt) {
+		delete(memoryResponseCache, key)
+		return nil
+	}
+	return item.entry
+}
+
+// SetResponseCache 写入缓存
+func SetResponseCache(key string, entry *ResponseCacheEntry, ttl time.Duration) error {
+	if common.RedisEnabled {
+		data, err := common.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return common.RedisSet(key, string(data), ttl)
+	}
+	maxEntries := operation_setting.GetResponseCacheSetting().MemoryMaxEntries
+	memoryResponseCacheLock.Lock()
+	defer memoryResponseCacheLock.Unlock()
+	if _, ok := memoryResponseCache[key]; !ok && len(memoryResponseCache) >= maxEntries {
+		evictMemoryResponseCache(maxEntries)
+		if len(memoryResponseCache) >= maxEntries {
+			return nil
+		}
+	}
+	memoryResponseCache[key] = memoryResponseCacheItem{
+		entry:    entry,
+		expireAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+// evictMemoryResponseCache 清理过期条目，仍然超出上限时淘汰最早过期的条目
+func evictMemoryResponseCache(maxEntries int) {
+	now := time.Now()
+	oldestKey := ""
+	var oldestExpireAt time.Time
+	for key, item := range memoryResponseCache {
+		if now.After(item.expireAt) {
+			delete(memoryResponseCache, key)
+			continue
+		}
+		if oldestKey == "" || item.expireAt.Before(oldestExpireAt) {
+			oldestKey = key
+			oldestExpireAt = item.expireAt
+		}
+	}
+	if len(memoryResponseCache) >= maxEntries && oldestKey != "" {
+		delete(memoryResponseCache, oldestKey)
+	}
+}
+
+// ResponseCaptureWriter 在写出响应的同时记录响应体，用于写入响应缓存
+type ResponseCaptureWriter struct {
+	gin.ResponseWriter
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// CaptureResponseForCache 替换 c.Writer 开始记录响应体，重试时复用已有的 writer
+func CaptureResponseForCache(c *gin.Context) *ResponseCaptureWriter {
+	if writer, ok := c.Writer.(*ResponseCaptureWriter); ok {
+		writer.Reset()
+		return writer
+	}
+	writer := &ResponseCaptureWriter{
+		ResponseWriter: c.Writer,
+		limit:          operation_setting.GetResponseCacheSetting().MaxEntryKB << 10,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *ResponseCaptureWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.buf.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.buf.Reset()
+		return
+	}
+	w.buf.Write(data)
+}
+
+func (w *ResponseCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *ResponseCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Reset 丢弃已记录的内容，渠道重试时使用
+func (w *ResponseCaptureWriter) Reset() {
+	w.buf.Reset()
+	w.overflow = false
+}
+
+// Captured 返回记录的响应体，超出大小限制时返回 nil
+func (w *ResponseCaptureWriter) Captured() []byte {
+	if w.overflow || w.buf.Len() == 0 {
+		return nil
+	}
+	return bytes.Clone(w.buf.Bytes())
+}
+
+// SaveCapturedResponse 将本次成功的响应写入缓存
+func SaveCapturedResponse(c *gin.Context, info *relaycommon.RelayInfo, cacheKey *ResponseCacheKey, usage *dto.Usage) {
+	writer, ok := c.Writer.(*ResponseCaptureWriter)
+	if !ok || usage == nil || writer.Status() != 200 {
+		return
+	}
+	body := writer.Captured()
+	if body == nil {
+		return
+	}
+	entry := &ResponseCacheEntry{
+		Stream:      info.IsStream,
+		ContentType: writer.Header().Get("Content-Type"),
+		Body:        body,
+		Usage:       *usage,
+		CreatedAt:   time.Now().Unix(),
+	}
+	if err := SetResponseCache(cacheKey.Key, entry, cacheKey.TTL); err != nil {
+		common.SysError("failed to save response cache: " + err.Error())
+		return
+	}
+	saveSemanticResponseCache(c, cacheKey)
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const responseCacheSemanticKeyPrefix = "response_cache_semantic:"
+
+// responseCacheEmbeddingContextKey 渠道重试时复用已计算的向量
+const responseCacheEmbeddingContextKey = "response_cache_embedding"
+
+// responseCacheSemanticFields 对话文本所在的字段，其余字段完全相同的请求才会按相似度匹配
+var responseCacheSemanticFields = []string{"system", "messages", "prompt", "input"}
+
+// semanticCacheItem 语义索引中的一条记录，指向精确匹配缓存的 key
+type semanticCacheItem struct {
+	Key       string    `json:"key"`
+	Embedding []float32 `json:"embedding"`
+	ExpireAt  int64     `json:"expire_at"`
+}
+
+var (
+	memorySemanticCache     = make(map[string][]semanticCacheItem)
+	memorySemanticCacheLock sync.Mutex
+)
+
+// getResponseCacheSemanticScope 拆分出对话文本和其余参数，返回语义索引的 key 和对话文本，不支持语义缓存时返回空字符串
+func getResponseCacheSemanticScope(prefix string, normalized map[string]any) (string, string) {
+	params := make(map[string]any, len(normalized))
+	for key, value := range normalized {
+		params[key] = value
+	}
+	var builder strings.Builder
+	for _, field := range responseCacheSemanticFields {
+		value, ok := params[field]
+		if !ok {
+			continue
+		}
+		delete(params, field)
+		if !appendResponseCacheSemanticText(&builder, value) {
+			return "", ""
+		}
+	}
+	text := strings.TrimSpace(builder.String())
+	if text == "" {
+		return "", ""
+	}
+	data, err := common.Marshal(params)
+	if err != nil {
+		return "", ""
+	}
+	hash := sha256.Sum256(data)
+	return responseCacheSemanticKeyPrefix + prefix + ":" + hex.EncodeToString(hash[:]), text
+}
+
+// appendResponseCacheSemanticText 按顺序拼接对话文本，遇到图片、音频等非文本内容或工具调用时返回 false
+func appendResponseCacheSemanticText(builder *strings.Builder, value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		builder.WriteString(v)
+		builder.WriteString("\n")
+		return true
+	case []any:
+		for _, item := range v {
+			if !appendResponseCacheSemanticText(builder, item) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		for _, key := range []string{"tool_calls", "tool_call_id", "function_call"} {
+			if _, ok := v[key]; ok {
+				return false
+			}
+		}
+		if contentType, ok := v["type"].(string); ok {
+			switch contentType {
+			case "text", "input_text", "output_text", "message":
+			default:
+				return false
+			}
+		}
+		if role, ok := v["role"].(string); ok {
+			builder.WriteString(role)
+			builder.WriteString(": ")
+		}
+		for _, key := range []string{"content", "text"} {
+			if item, ok := v[key]; ok && !appendResponseCacheSemanticText(builder, item) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// getResponseCacheEmbedding 调用向量渠道的 OpenAI Embeddings 接口计算对话文本的向量
+func getResponseCacheEmbedding(c *gin.Context, text string) ([]float32, error) {
+	if embedding, ok := c.Get(responseCacheEmbeddingContextKey); ok {
+		return embedding.([]float32), nil
+	}
+	setting := operation_setting.GetResponseCacheSetting()
+	channel, err := model.CacheGetChannel(setting.SemanticChannelId)
+	if err != nil {
+		return nil, err
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return nil, fmt.Errorf("embedding channel #%d is disabled", channel.Id)
+	}
+	key, _, newAPIError := channel.GetNextEnabledKey()
+	if newAPIError != nil {
+		return nil, newAPIError
+	}
+	requestBody, err := common.Marshal(dto.EmbeddingRequest{
+		Model: setting.SemanticModel,
+		Input: text,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), setting.GetSemanticTimeout())
+	defer cancel()
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = constant.ChannelBaseURLs[channel.Type]
+	}
+	url := strings.TrimSuffix(baseURL, "/") + "/v1/embeddings"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, string(responseBody))
+	}
+	var embeddingResponse dto.OpenAIEmbeddingResponse
+	if err = common.Unmarshal(responseBody, &embeddingResponse); err != nil {
+		return nil, err
+	}
+	if len(embeddingResponse.Data) == 0 || len(embeddingResponse.Data[0].Embedding) == 0 {
+		return nil, errors.New("empty embedding response")
+	}
+	embedding := make([]float32, len(embeddingResponse.Data[0].Embedding))
+	for i, value := range embeddingResponse.Data[0].Embedding {
+		embedding[i] = float32(value)
+	}
+	c.Set(responseCacheEmbeddingContextKey, embedding)
+	return embedding, nil
+}
+
+// cosineSimilarity 计算两个向量的余弦相似度，维度不同时返回 0
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// GetSemanticResponseCache 精确匹配未命中时，在参数相同的请求中查找对话文本相似度达到阈值的缓存，返回缓存及相似度
+func GetSemanticResponseCache(c *gin.Context, cacheKey *ResponseCacheKey) (*ResponseCacheEntry, float64) {
+	if cacheKey.semanticText == "" {
+		return nil, 0
+	}
+	embedding, err := getResponseCacheEmbedding(c, cacheKey.semanticText)
+	if err != nil {
+		// 计算向量失败时按未命中处理，不影响正常请求
+		logger.LogWarn(c, "failed to get response cache embedding: "+err.Error())
+		return nil, 0
+	}
+	cacheKey.embedding = embedding
+
+	type candidate struct {
+		key        string
+		similarity float64
+	}
+	threshold := operation_setting.GetResponseCacheSetting().SemanticThreshold
+	now := time.Now().Unix()
+	var candidates []candidate
+	for _, item := range getSemanticCacheItems(cacheKey.semanticScope) {
+		if item.ExpireAt <= now {
+			continue
+		}
+		if similarity := cosineSimilarity(embedding, item.Embedding); similarity >= threshold {
+			candidates = append(candidates, candidate{key: item.Key, similarity: similarity})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+	// 索引中的记录可能已被淘汰，依次尝试相似度更低的候选
+	for _, candidate := range candidates {
+		if entry := GetResponseCache(candidate.key); entry != nil {
+			return entry, candidate.similarity
+		}
+	}
+	return nil, 0
+}
+
+func getSemanticCacheItems(scope string) []semanticCacheItem {
+	if common.RedisEnabled {
+		values, err := common.RDB.LRange(context.Background(), scope, 0, -1).Result()
+		if err != nil {
+			return nil
+		}
+		items := make([]semanticCacheItem, 0, len(values))
+		for _, value := range values {
+			var item semanticCacheItem
+			if err = common.UnmarshalJsonStr(value, &item); err == nil {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	memorySemanticCacheLock.Lock()
+	defer memorySemanticCacheLock.Unlock()
+	return append([]semanticCacheItem(nil), memorySemanticCache[scope]...)
+}
+
+// saveSemanticResponseCache 将本次请求的向量加入语义索引，最新的记录排在最前
+func saveSemanticResponseCache(c *gin.Context, cacheKey *ResponseCacheKey) {
+	if cacheKey.embedding == nil {
+		return
+	}
+	setting := operation_setting.GetResponseCacheSetting()
+	maxEntries := setting.SemanticMaxEntries
+	if maxEntries <= 0 {
+		return
+	}
+	item := semanticCacheItem{
+		Key:       cacheKey.Key,
+		Embedding: cacheKey.embedding,
+		ExpireAt:  time.Now().Add(cacheKey.TTL).Unix(),
+	}
+	if common.RedisEnabled {
+		data, err := common.Marshal(item)
+		if err != nil {
+			return
+		}
+		ctx := context.Background()
+		pipe := common.RDB.TxPipeline()
+		pipe.LPush(ctx, cacheKey.semanticScope, string(data))
+		pipe.LTrim(ctx, cacheKey.semanticScope, 0, int64(maxEntries-1))
+		pipe.Expire(ctx, cacheKey.semanticScope, cacheKey.TTL)
+		if _, err = pipe.Exec(ctx); err != nil {
+			logger.LogWarn(c, "failed to save semantic response cache: "+err.Error())
+		}
+		return
+	}
+	now := time.Now().Unix()
+	memorySemanticCacheLock.Lock()
+	defer memorySemanticCacheLock.Unlock()
+	if _, ok := memorySemanticCache[cacheKey.semanticScope]; !ok && len(memorySemanticCache) >= setting.MemoryMaxEntries {
+		evictMemorySemanticCache(now)
+		if len(memorySemanticCache) >= setting.MemoryMaxEntries {
+			return
+		}
+	}
+	items := []semanticCacheItem{item}
+	for _, existing := range memorySemanticCache[cacheKey.semanticScope] {
+		if len(items) >= maxEntries {
+			break
+		}
+		if existing.ExpireAt > now && existing.Key != item.Key {
+			items = append(items, existing)
+		}
+	}
+	memorySemanticCache[cacheKey.semanticScope] = items
+}
+
+// evictMemorySemanticCache 清理过期记录，删除没有有效记录的索引
+func evictMemorySemanticCache(now int64) {
+	for scope, items := range memorySemanticCache {
+		valid := items[:0]
+		for _, item := range items {
+			if item.ExpireAt > now {
+				valid = append(valid, item)
+			}
+		}
+		if len(valid) == 0 {
+			delete(memorySemanticCache, scope)
+		} else {
+			memorySemanticCache[scope] = valid
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// setupSemanticCacheTest 启用语义缓存，向量渠道指向按输入文本返回固定向量的测试服务
+func setupSemanticCacheTest(t *testing.T) *int {
+	t.Helper()
+	setupQuotaTestDB(t)
+	InitHttpClient()
+	if err := model.DB.AutoMigrate(&model.Channel{}); err != nil {
+		t.Fatal(err)
+	}
+	calls := new(int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		body, _ := io.ReadAll(r.Body)
+		embedding := "[0,1,0]"
+		if strings.Contains(strings.ToLower(gjson.GetBytes(body, "input").String()), "weather") {
+			embedding = "[1,0.05,0]"
+		}
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"object":"embedding","index":0,"embedding":` + embedding + `}]}`))
+	}))
+	t.Cleanup(server.Close)
+	channel := &model.Channel{Name: "embedding", Key: "sk-test", Status: common.ChannelStatusEnabled, BaseURL: common.GetPointer(server.URL)}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	setting := operation_setting.GetResponseCacheSetting()
+	original := *setting
+	t.Cleanup(func() { *setting = original })
+	setting.Enabled = true
+	setting.SemanticEnabled = true
+	setting.SemanticChannelId = channel.Id
+	return calls
+}
+
+func newSemanticCacheRequest(t *testing.T, request *dto.GeneralOpenAIRequest) (*gin.Context, *ResponseCacheKey) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	request.Model = "gpt-4o"
+	request.Temperature = common.GetPointer(0.0)
+	info := &relaycommon.RelayInfo{UserId: 1, OriginModelName: "gpt-4o", RelayFormat: types.RelayFormatOpenAI}
+	return c, GetResponseCacheKey(c, info, request)
+}
+
+func textMessage(text string) *dto.GeneralOpenAIRequest {
+	return &dto.GeneralOpenAIRequest{Messages: []dto.Message{{Role: "user", Content: text}}}
+}
+
+func TestSemanticResponseCache(t *testing.T) {
+	calls := setupSemanticCacheTest(t)
+
+	c, cacheKey := newSemanticCacheRequest(t, textMessage("What's the weather in Paris?"))
+	if entry, _ := GetSemanticResponseCache(c, cacheKey); entry != nil {
+		t.Fatal("empty cache should not hit")
+	}
+	// 渠道重试时复用已计算的向量
+	GetSemanticResponseCache(c, cacheKey)
+	if *calls != 1 {
+		t.Fatalf("embedding should be computed once per request, got %d calls", *calls)
+	}
+	if err := SetResponseCache(cacheKey.Key, &ResponseCacheEntry{Body: []byte("cached")}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	saveSemanticResponseCache(c, cacheKey)
+
+	c, similarKey := newSemanticCacheRequest(t, textMessage("Tell me the weather in Paris"))
+	if similarKey.Key == cacheKey.Key {
+		t.Fatal("different text should have a different exact key")
+	}
+	entry, similarity := GetSemanticResponseCache(c, similarKey)
+	if entry == nil || string(entry.Body) != "cached" || similarity < 0.95 {
+		t.Fatalf("similar request should hit, got %v %v", entry, similarity)
+	}
+
+	c, otherKey := newSemanticCacheRequest(t, textMessage("Tell me a joke"))
+	if entry, _ := GetSemanticResponseCache(c, otherKey); entry != nil {
+		t.Fatal("dissimilar request should not hit")
+	}
+
+	// 其余参数不同的请求不参与语义匹配
+	request := textMessage("Tell me the weather in Paris")
+	request.MaxTokens = 10
+	c, paramsKey := newSemanticCacheRequest(t, request)
+	if entry, _ := GetSemanticResponseCache(c, paramsKey); entry != nil {
+		t.Fatal("request with different parameters should not hit")
+	}
+
+	// 包含图片的请求只做精确匹配
+	_, imageKey := newSemanticCacheRequest(t, &dto.GeneralOpenAIRequest{Messages: []dto.Message{{Role: "user", Content: []any{
+		map[string]any{"type": "text", "text": "What's the weather in this photo?"},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/a.png"}},
+	}}}})
+	if imageKey.semanticText != "" {
+		t.Fatalf("image request should not use semantic cache, got %q", imageKey.semanticText)
+	}
+}
+
+func TestSemanticResponseCacheDisabledByDefault(t *testing.T) {
+	setting := operation_setting.GetResponseCacheSetting()
+	original := *setting
+	t.Cleanup(func() { *setting = original })
+	setting.Enabled = true
+	if setting.IsSemanticEnabled() {
+		t.Fatal("semantic cache should be disabled by default")
+	}
+	_, cacheKey := newSemanticCacheRequest(t, textMessage("What's the weather in Paris?"))
+	if cacheKey == nil || cacheKey.semanticText != "" {
+		t.Fatalf("only exact key should be computed, got %+v", cacheKey)
+	}
+}
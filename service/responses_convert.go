@@ -0,0 +1,544 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+)
+
+// ResponsesToOpenAIRequest 将 Responses 请求转换为 Chat Completions 请求，用于不支持 Responses API 的渠道
+// 仅支持无状态的请求，previous_response_id 和内置工具需要上游保存上下文或执行工具，无法转换
+func ResponsesToOpenAIRequest(responsesRequest dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	if responsesRequest.PreviousResponseID != "" {
+		return nil, fmt.Errorf("previous_response_id is not supported on this channel")
+	}
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:     responsesRequest.Model,
+		Stream:    responsesRequest.Stream,
+		MaxTokens: responsesRequest.MaxOutputTokens,
+		TopP:      responsesRequest.TopP,
+		User:      responsesRequest.User,
+		Metadata:  responsesRequest.Metadata,
+	}
+	if responsesRequest.Temperature != 0 {
+		openAIRequest.Temperature = common.GetPointer(responsesRequest.Temperature)
+	}
+	if responsesRequest.Reasoning != nil && responsesRequest.Reasoning.Effort != "" {
+		openAIRequest.ReasoningEffort = responsesRequest.Reasoning.Effort
+	}
+	if len(responsesRequest.ParallelToolCalls) > 0 {
+		var parallel bool
+		if err := common.Unmarshal(responsesRequest.ParallelToolCalls, &parallel); err == nil {
+			openAIRequest.ParallelTooCalls = &parallel
+		}
+	}
+
+	// instructions 转为 system 消息
+	if len(responsesRequest.Instructions) > 0 {
+		var instructions string
+		if err := common.Unmarshal(responsesRequest.Instructions, &instructions); err != nil {
+			return nil, fmt.Errorf("invalid instructions: %w", err)
+		}
+		if instructions != "" {
+			openAIRequest.Messages = append(openAIRequest.Messages, dto.Message{
+				Role:    "system",
+				Content: instructions,
+			})
+		}
+	}
+
+	messages, err := responsesInputToMessages(responsesRequest.Input)
+	if err != nil {
+		return nil, err
+	}
+	openAIRequest.Messages = append(openAIRequest.Messages, messages...)
+
+	for _, tool := range responsesRequest.GetToolsMap() {
+		toolType := common.Interface2String(tool["type"])
+		if toolType != "function" {
+			return nil, fmt.Errorf("tool type %s is not supported on this channel", toolType)
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        common.Interface2String(tool["name"]),
+				Description: common.Interface2String(tool["description"]),
+				Parameters:  tool["parameters"],
+			},
+		})
+	}
+
+	if len(responsesRequest.ToolChoice) > 0 {
+		switch common.GetJsonType(responsesRequest.ToolChoice) {
+		case "string":
+			var toolChoice string
+			_ = common.Unmarshal(responsesRequest.ToolChoice, &toolChoice)
+			openAIRequest.ToolChoice = toolChoice
+		case "object":
+			var toolChoice map[string]any
+			_ = common.Unmarshal(responsesRequest.ToolChoice, &toolChoice)
+			if common.Interface2String(toolChoice["type"]) == "function" {
+				openAIRequest.ToolChoice = map[string]any{
+					"type": "function",
+					"function": map[string]any{
+						"name": toolChoice["name"],
+					},
+				}
+			}
+		}
+	}
+
+	if len(responsesRequest.Text) > 0 {
+		var text struct {
+			Format map[string]any `json:"format"`
+		}
+		if err := common.Unmarshal(responsesRequest.Text, &text); err == nil && text.Format != nil {
+			switch common.Interface2String(text.Format["type"]) {
+			case "json_object":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+			case "json_schema":
+				schema := map[string]any{
+					"name":   text.Format["name"],
+					"schema": text.Format["schema"],
+				}
+				if strict, ok := text.Format["strict"]; ok {
+					schema["strict"] = strict
+				}
+				if description, ok := text.Format["description"]; ok {
+					schema["description"] = description
+				}
+				schemaData, err := common.Marshal(schema)
+				if err != nil {
+					return nil, err
+				}
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_schema", JsonSchema: schemaData}
+			}
+		}
+	}
+	return &openAIRequest, nil
+}
+
+func responsesInputToMessages(input json.RawMessage) ([]dto.Message, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	if common.GetJsonType(input) == "string" {
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		return []dto.Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []dto.Input
+	if err := common.Unmarshal(input, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	messages := make([]dto.Message, 0, len(items))
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			message := dto.Message{Role: role}
+			if err := setResponsesMessageContent(&message, item.Content); err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+		case "function_call":
+			toolCall := dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// 连续的工具调用合并到同一条 assistant 消息中
+			if len(messages) > 0 && messages[len(messages)-1].Role == "assistant" {
+				last := &messages[len(messages)-1]
+				last.SetToolCalls(append(last.ParseToolCalls(), toolCall))
+				continue
+			}
+			message := dto.Message{Role: "assistant"}
+			message.SetNullContent()
+			message.SetToolCalls([]dto.ToolCallRequest{toolCall})
+			messages = append(messages, message)
+		case "function_call_output":
+			messages = append(messages, dto.Message{
+				Role:       "tool",
+				ToolCallId: item.CallId,
+				Content:    responsesOutputToString(item.Output),
+			})
+		case "reasoning":
+			// 思考内容只对生成它的模型有意义，不再转发
+			continue
+		default:
+			return nil, fmt.Errorf("input item type %s is not supported on this channel", item.Type)
+		}
+	}
+	return messages, nil
+}
+
+func setResponsesMessageContent(message *dto.Message, content json.RawMessage) error {
+	if len(content) == 0 {
+		message.SetStringContent("")
+		return nil
+	}
+	if common.GetJsonType(content) == "string" {
+		var text string
+		if err := common.Unmarshal(content, &text); err != nil {
+			return err
+		}
+		message.SetStringContent(text)
+		return nil
+	}
+	var parts []map[string]any
+	if err := common.Unmarshal(content, &parts); err != nil {
+		return fmt.Errorf("invalid input content: %w", err)
+	}
+	mediaContents := make([]dto.MediaContent, 0, len(parts))
+	for _, part := range parts {
+		switch common.Interface2String(part["type"]) {
+		case "input_text", "output_text", "text":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: common.Interface2String(part["text"]),
+			})
+		case "refusal":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: common.Interface2String(part["refusal"]),
+			})
+		case "input_image":
+			imageUrl := ""
+			switch v := part["image_url"].(type) {
+			case string:
+				imageUrl = v
+			case map[string]any:
+				imageUrl = common.Interface2String(v["url"])
+			}
+			if imageUrl == "" {
+				return fmt.Errorf("input_image without image_url is not supported on this channel")
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeImageURL,
+				ImageUrl: &dto.MessageImageUrl{
+					Url:    imageUrl,
+					Detail: common.Interface2String(part["detail"]),
+				},
+			})
+		case "input_file":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeFile,
+				File: &dto.MessageFile{
+					FileName: common.Interface2String(part["filename"]),
+					FileData: common.Interface2String(part["file_data"]),
+					FileId:   common.Interface2String(part["file_id"]),
+				},
+			})
+		default:
+			return fmt.Errorf("input content type %s is not supported on this channel", common.Interface2String(part["type"]))
+		}
+	}
+	message.SetMediaContent(mediaContents)
+	return nil
+}
+
+// responsesOutputToString function_call_output 的 output 可以是字符串或内容数组，统一转为字符串
+func responsesOutputToString(output json.RawMessage) string {
+	if len(output) == 0 {
+		return ""
+	}
+	if common.GetJsonType(output) == "string" {
+		var text string
+		_ = common.Unmarshal(output, &text)
+		return text
+	}
+	var parts []map[string]any
+	if err := common.Unmarshal(output, &parts); err != nil {
+		return string(output)
+	}
+	var builder strings.Builder
+	for _, part := range parts {
+		builder.WriteString(common.Interface2String(part["text"]))
+	}
+	return builder.String()
+}
+
+func responsesUsage(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	responsesUsage := *usage
+	responsesUsage.InputTokens = usage.PromptTokens
+	responsesUsage.OutputTokens = usage.CompletionTokens
+	responsesUsage.InputTokensDetails = &dto.InputTokenDetails{
+		CachedTokens: usage.PromptTokensDetails.CachedTokens,
+	}
+	return &responsesUsage
+}
+
+func newResponsesResponse(id string, createdAt int, model string) *dto.OpenAIResponsesResponse {
+	return &dto.OpenAIResponsesResponse{
+		ID:        id,
+		Object:    "response",
+		CreatedAt: createdAt,
+		Status:    "in_progress",
+		Model:     model,
+		Output:    []dto.ResponsesOutput{},
+	}
+}
+
+// ResponseOpenAI2Responses 将 Chat Completions 非流式响应转换为 Responses 响应
+func ResponseOpenAI2Responses(openAIResponse *dto.OpenAITextResponse, info *relaycommon.RelayInfo, usage *dto.Usage) *dto.OpenAIResponsesResponse {
+	response := newResponsesResponse("resp_"+common.GetRandomString(24), int(common.GetTimestamp()), openAIResponse.Model)
+	response.Status = "completed"
+	for _, choice := range openAIResponse.Choices {
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:    "reasoning",
+				ID:      "rs_" + common.GetRandomString(24),
+				Status:  "completed",
+				Content: []dto.ResponsesOutputContent{},
+				Summary: []dto.ResponsesOutputContent{{Type: "summary_text", Text: reasoning}},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:    "message",
+				ID:      "msg_" + common.GetRandomString(24),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []dto.ResponsesOutputContent{{Type: "output_text", Text: text, Annotations: []interface{}{}}},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:      "function_call",
+				ID:        "fc_" + common.GetRandomString(24),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+		if choice.FinishReason == "length" {
+			response.Status = "incomplete"
+			response.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+		}
+	}
+	response.Usage = responsesUsage(usage)
+	return response
+}
+
+func nextResponsesEvent(info *relaycommon.RelayInfo, eventType string) dto.ResponsesStreamResponse {
+	event := dto.ResponsesStreamResponse{
+		Type:           eventType,
+		SequenceNumber: info.ResponsesConvertInfo.SequenceNumber,
+	}
+	info.ResponsesConvertInfo.SequenceNumber++
+	return event
+}
+
+func currentResponsesResponse(info *relaycommon.RelayInfo) *dto.OpenAIResponsesResponse {
+	convertInfo := info.ResponsesConvertInfo
+	response := newResponsesResponse(convertInfo.ResponseId, convertInfo.CreatedAt, convertInfo.Model)
+	response.Output = append(response.Output, convertInfo.Output...)
+	return response
+}
+
+// addResponsesOutputItem 新增一个 output 项并返回 output_item.added 事件
+func addResponsesOutputItem(info *relaycommon.RelayInfo, item dto.ResponsesOutput) (int, dto.ResponsesStreamResponse) {
+	convertInfo := info.ResponsesConvertInfo
+	index := len(convertInfo.Output)
+	convertInfo.Output = append(convertInfo.Output, item)
+	// 后续 delta 会修改 Output 中的内容，事件中的 item 需要使用独立的副本
+	added := item
+	added.Content = slices.Clone(item.Content)
+	added.Summary = slices.Clone(item.Summary)
+	event := nextResponsesEvent(info, dto.ResponsesOutputTypeItemAdded)
+	event.OutputIndex = common.GetPointer(index)
+	event.Item = &added
+	return index, event
+}
+
+// closeResponsesOutputItem 结束一个 output 项，返回对应的 done 事件
+func closeResponsesOutputItem(info *relaycommon.RelayInfo, index int) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	item := &convertInfo.Output[index]
+	if item.Status == "completed" {
+		return nil
+	}
+	item.Status = "completed"
+	var events []dto.ResponsesStreamResponse
+	switch item.Type {
+	case "message":
+		part := item.Content[0]
+		event := nextResponsesEvent(info, dto.ResponsesEventOutputTextDone)
+		event.ItemId, event.OutputIndex, event.ContentIndex, event.Text = item.ID, common.GetPointer(index), common.GetPointer(0), part.Text
+		events = append(events, event)
+		event = nextResponsesEvent(info, dto.ResponsesEventContentPartDone)
+		event.ItemId, event.OutputIndex, event.ContentIndex, event.Part = item.ID, common.GetPointer(index), common.GetPointer(0), &part
+		events = append(events, event)
+	case "reasoning":
+		part := item.Summary[0]
+		event := nextResponsesEvent(info, dto.ResponsesEventReasoningSummaryTextDone)
+		event.ItemId, event.OutputIndex, event.SummaryIndex, event.Text = item.ID, common.GetPointer(index), common.GetPointer(0), part.Text
+		events = append(events, event)
+		event = nextResponsesEvent(info, dto.ResponsesEventReasoningSummaryPartDone)
+		event.ItemId, event.OutputIndex, event.SummaryIndex, event.Part = item.ID, common.GetPointer(index), common.GetPointer(0), &part
+		events = append(events, event)
+	case "function_call":
+		event := nextResponsesEvent(info, dto.ResponsesEventFunctionCallArgumentsDone)
+		event.ItemId, event.OutputIndex, event.Arguments = item.ID, common.GetPointer(index), item.Arguments
+		events = append(events, event)
+	}
+	done := *item
+	event := nextResponsesEvent(info, dto.ResponsesOutputTypeItemDone)
+	event.OutputIndex = common.GetPointer(index)
+	event.Item = &done
+	return append(events, event)
+}
+
+// StreamResponseOpenAI2Responses 将 Chat Completions 流式响应块转换为 Responses 事件
+func StreamResponseOpenAI2Responses(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	var events []dto.ResponsesStreamResponse
+	if !convertInfo.Started {
+		convertInfo.Started = true
+		if convertInfo.Model == "" {
+			convertInfo.Model = openAIResponse.Model
+		}
+		event := nextResponsesEvent(info, dto.ResponsesEventCreated)
+		event.Response = currentResponsesResponse(info)
+		events = append(events, event)
+		event = nextResponsesEvent(info, dto.ResponsesEventInProgress)
+		event.Response = currentResponsesResponse(info)
+		events = append(events, event)
+	}
+
+	for _, choice := range openAIResponse.Choices {
+		if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+			if convertInfo.ReasoningIndex < 0 {
+				index, event := addResponsesOutputItem(info, dto.ResponsesOutput{
+					Type:    "reasoning",
+					ID:      "rs_" + common.GetRandomString(24),
+					Status:  "in_progress",
+					Content: []dto.ResponsesOutputContent{},
+					Summary: []dto.ResponsesOutputContent{{Type: "summary_text"}},
+				})
+				convertInfo.ReasoningIndex = index
+				events = append(events, event)
+				event = nextResponsesEvent(info, dto.ResponsesEventReasoningSummaryPartAdded)
+				event.ItemId, event.OutputIndex, event.SummaryIndex = convertInfo.Output[index].ID, common.GetPointer(index), common.GetPointer(0)
+				event.Part = &dto.ResponsesOutputContent{Type: "summary_text"}
+				events = append(events, event)
+			}
+			item := &convertInfo.Output[convertInfo.ReasoningIndex]
+			item.Summary[0].Text += reasoning
+			event := nextResponsesEvent(info, dto.ResponsesEventReasoningSummaryTextDelta)
+			event.ItemId, event.OutputIndex, event.SummaryIndex, event.Delta = item.ID, common.GetPointer(convertInfo.ReasoningIndex), common.GetPointer(0), reasoning
+			events = append(events, event)
+		}
+
+		if content := choice.Delta.GetContentString(); content != "" {
+			if convertInfo.ReasoningIndex >= 0 {
+				events = append(events, closeResponsesOutputItem(info, convertInfo.ReasoningIndex)...)
+				convertInfo.ReasoningIndex = -1
+			}
+			if convertInfo.MessageIndex < 0 {
+				index, event := addResponsesOutputItem(info, dto.ResponsesOutput{
+					Type:    "message",
+					ID:      "msg_" + common.GetRandomString(24),
+					Status:  "in_progress",
+					Role:    "assistant",
+					Content: []dto.ResponsesOutputContent{{Type: "output_text", Annotations: []interface{}{}}},
+				})
+				convertInfo.MessageIndex = index
+				events = append(events, event)
+				event = nextResponsesEvent(info, dto.ResponsesEventContentPartAdded)
+				event.ItemId, event.OutputIndex, event.ContentIndex = convertInfo.Output[index].ID, common.GetPointer(index), common.GetPointer(0)
+				event.Part = &dto.ResponsesOutputContent{Type: "output_text", Annotations: []interface{}{}}
+				events = append(events, event)
+			}
+			item := &convertInfo.Output[convertInfo.MessageIndex]
+			item.Content[0].Text += content
+			event := nextResponsesEvent(info, dto.ResponsesEventOutputTextDelta)
+			event.ItemId, event.OutputIndex, event.ContentIndex, event.Delta = item.ID, common.GetPointer(convertInfo.MessageIndex), common.GetPointer(0), content
+			events = append(events, event)
+		}
+
+		for i, toolCall := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if toolCall.Index != nil {
+				toolIndex = *toolCall.Index
+			}
+			outputIndex, ok := convertInfo.ToolIndexes[toolIndex]
+			if !ok {
+				// 工具调用开始后，之前的思考和文本输出结束
+				for _, index := range []int{convertInfo.ReasoningIndex, convertInfo.MessageIndex} {
+					if index >= 0 {
+						events = append(events, closeResponsesOutputItem(info, index)...)
+					}
+				}
+				convertInfo.ReasoningIndex, convertInfo.MessageIndex = -1, -1
+				var event dto.ResponsesStreamResponse
+				outputIndex, event = addResponsesOutputItem(info, dto.ResponsesOutput{
+					Type:   "function_call",
+					ID:     "fc_" + common.GetRandomString(24),
+					Status: "in_progress",
+					CallId: toolCall.ID,
+					Name:   toolCall.Function.Name,
+				})
+				convertInfo.ToolIndexes[toolIndex] = outputIndex
+				events = append(events, event)
+			}
+			if toolCall.Function.Arguments == "" {
+				continue
+			}
+			item := &convertInfo.Output[outputIndex]
+			item.Arguments += toolCall.Function.Arguments
+			event := nextResponsesEvent(info, dto.ResponsesEventFunctionCallArgumentsDelta)
+			event.ItemId, event.OutputIndex, event.Delta = item.ID, common.GetPointer(outputIndex), toolCall.Function.Arguments
+			events = append(events, event)
+		}
+
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			convertInfo.FinishReason = *choice.FinishReason
+		}
+	}
+	return events
+}
+
+// StreamResponseOpenAI2ResponsesDone 结束所有未完成的 output 项并生成 response.completed 事件
+func StreamResponseOpenAI2ResponsesDone(info *relaycommon.RelayInfo, usage *dto.Usage) []dto.ResponsesStreamResponse {
+	convertInfo := info.ResponsesConvertInfo
+	events := StreamResponseOpenAI2Responses(&dto.ChatCompletionsStreamResponse{}, info)
+	for index := range convertInfo.Output {
+		events = append(events, closeResponsesOutputItem(info, index)...)
+	}
+	response := currentResponsesResponse(info)
+	response.Usage = responsesUsage(usage)
+	eventType := dto.ResponsesEventCompleted
+	response.Status = "completed"
+	if convertInfo.FinishReason == "length" {
+		eventType = dto.ResponsesEventIncomplete
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+	}
+	event := nextResponsesEvent(info, eventType)
+	event.Response = response
+	return append(events, event)
+}
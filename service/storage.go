@@ -0,0 +1,381 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const (
+	StorageTypeLocal = "local"
+	StorageTypeS3    = "s3"
+)
+
+var ErrStorageObjectNotFound = errors.New("storage object not found")
+
+// ObjectStorage 对象存储抽象，用于保存上传文件、日志归档等二进制内容
+type ObjectStorage interface {
+	Type() string
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// List 返回键以 prefix 开头的对象，按键排序
+	List(ctx context.Context, prefix string) ([]StorageObject, error)
+}
+
+// StorageObject 对象存储中的一个对象
+type StorageObject struct {
+	Key     string
+	Size    int64
+	ModTime int64
+}
+
+// S3Config S3 兼容存储（AWS S3、MinIO、R2 等）的连接配置
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	ForcePathStyle  bool
+}
+
+var (
+	fileStorage     ObjectStorage
+	fileStorageOnce sync.Once
+)
+
+// GetFileStorage 返回根据环境变量初始化的文件存储
+func GetFileStorage() ObjectStorage {
+	fileStorageOnce.Do(func() {
+		storage, err := NewObjectStorage(constant.FileStorageType, constant.FileStoragePath, defaultS3Config())
+		if err != nil {
+			common.SysError("failed to init file storage, fallback to local: " + err.Error())
+			storage = NewLocalStorage(constant.FileStoragePath)
+		}
+		fileStorage = storage
+	})
+	return fileStorage
+}
+
+func defaultS3Config() S3Config {
+	return S3Config{
+		Endpoint:        constant.S3Endpoint,
+		Region:          constant.S3Region,
+		Bucket:          constant.S3Bucket,
+		AccessKeyId:     constant.S3AccessKeyId,
+		SecretAccessKey: constant.S3SecretAccessKey,
+		ForcePathStyle:  constant.S3ForcePathStyle,
+	}
+}
+
+// NewObjectStorage 根据存储类型创建对象存储，localDir 仅在本地存储时使用
+func NewObjectStorage(storageType string, localDir string, s3Config S3Config) (ObjectStorage, error) {
+	switch strings.ToLower(storageType) {
+	case "", StorageTypeLocal:
+		return NewLocalStorage(localDir), nil
+	case StorageTypeS3:
+		return NewS3Storage(s3Config)
+	default:
+		return nil, fmt.Errorf("unsupported storage type: %s", storageType)
+	}
+}
+
+type localStorage struct {
+	baseDir string
+}
+
+func NewLocalStorage(baseDir string) ObjectStorage {
+	return &localStorage{baseDir: baseDir}
+}
+
+func (s *localStorage) Type() string {
+	return StorageTypeLocal
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid storage key: %s", key)
+	}
+	return filepath.Join(s.baseDir, cleaned), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免读到写了一半的内容
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrStorageObjectNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	objects := make([]StorageObject, 0)
+	root := filepath.Clean(s.baseDir)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, StorageObject{Key: key, Size: info.Size(), ModTime: info.ModTime().Unix()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// s3Storage 使用 SigV4 签名的最小 S3 客户端，只依赖 PUT/GET/DELETE/ListObjectsV2
+type s3Storage struct {
+	config S3Config
+	signer *v4.Signer
+}
+
+func NewS3Storage(config S3Config) (ObjectStorage, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for s3 storage")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if !strings.HasPrefix(config.Endpoint, "http://") && !strings.HasPrefix(config.Endpoint, "https://") {
+		config.Endpoint = "https://" + config.Endpoint
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &s3Storage{config: config, signer: v4.NewSigner()}, nil
+}
+
+func (s *s3Storage) Type() string {
+	return StorageTypeS3
+}
+
+func (s *s3Storage) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	escapedKey := (&url.URL{Path: strings.TrimPrefix(key, "/")}).EscapedPath()
+	if s.config.ForcePathStyle {
+		u.Path = "/" + s.config.Bucket + "/" + strings.TrimPrefix(key, "/")
+		u.RawPath = "/" + s.config.Bucket + "/" + escapedKey
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = "/" + strings.TrimPrefix(key, "/")
+		u.RawPath = "/" + escapedKey
+	}
+	return u, nil
+}
+
+func (s *s3Storage) do(ctx context.Context, method string, u *url.URL, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials := aws.Credentials{
+		AccessKeyID:     s.config.AccessKeyId,
+		SecretAccessKey: s.config.SecretAccessKey,
+		SessionToken:    s.config.SessionToken,
+	}
+	if err := s.signer.SignHTTP(ctx, credentials, req, payloadHash, "s3", s.config.Region, time.Now()); err != nil {
+		return nil, err
+	}
+	return GetHttpClient().Do(req)
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, u, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("s3 put object failed: status %d, %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, u, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrStorageObjectNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 get object failed: status %d, %s", resp.StatusCode, string(respBody))
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, u, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("s3 delete object failed: status %d, %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+func (s *s3Storage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	objects := make([]StorageObject, 0)
+	continuationToken := ""
+	for {
+		u, err := s.objectURL("")
+		if err != nil {
+			return nil, err
+		}
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+		u.RawQuery = query.Encode()
+		resp, err := s.do(ctx, http.MethodGet, u, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("s3 list objects failed: status %d, %s", resp.StatusCode, string(respBody))
+		}
+		result, err := parseS3ListResult(respBody)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.objects...)
+		if !result.truncated || result.nextToken == "" {
+			break
+		}
+		continuationToken = result.nextToken
+	}
+	return objects, nil
+}
+
+type s3ListResult struct {
+	objects   []StorageObject
+	truncated bool
+	nextToken string
+}
+
+func parseS3ListResult(body []byte) (*s3ListResult, error) {
+	var parsed struct {
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+		Contents              []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+	}
+	if err := xml.Unmarshal(body, &parsed); err != nil {
+		return nil, err
+	}
+	result := &s3ListResult{
+		truncated: parsed.IsTruncated,
+		nextToken: parsed.NextContinuationToken,
+	}
+	for _, content := range parsed.Contents {
+		result.objects = append(result.objects, StorageObject{
+			Key:     content.Key,
+			Size:    content.Size,
+			ModTime: content.LastModified.Unix(),
+		})
+	}
+	return result, nil
+}
@@ -450,3 +450,15 @@ func CountTextToken(text string, model string) int {
 		return EstimateTokenByModel(model, text)
 	}
 }
+
+// CountRequestTokenLocally 在上游不支持 count_tokens 时本地估算输入 token 数，
+// 非 OpenAI 模型按请求格式所属厂商的系数估算
+func CountRequestTokenLocally(provider Provider, model string, meta *types.TokenCountMeta) int {
+	if meta == nil {
+		return 0
+	}
+	if common.IsOpenAITextModel(model) {
+		return CountTokenInput(meta.CombineText, model)
+	}
+	return EstimateToken(provider, meta.CombineText)
+}
@@ -0,0 +1,1136 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	toolCallOpenTag  = "<tool_call>"
+	toolCallCloseTag = "</tool_call>"
+)
+
+// ToolCallEmulation 为不支持原生函数调用的模型模拟工具调用：请求中的工具定义转换为提示词，
+// 工具调用历史转换为文本，模型以 <tool_call> 标签输出的调用再解析回 OpenAI tool_calls 或 Claude tool_use
+type ToolCallEmulation struct {
+	toolNames map[string]bool
+}
+
+type emulatedTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type emulatedToolCall struct {
+	name      string
+	arguments string // JSON 对象
+}
+
+// ShouldEmulateToolCalls 渠道或模型开启了工具调用模拟
+func ShouldEmulateToolCalls(info *relaycommon.RelayInfo) bool {
+	if info.ChannelSetting.ToolCallEmulation {
+		return true
+	}
+	return model_setting.ShouldEmulateToolCalls(info.UpstreamModelName) ||
+		model_setting.ShouldEmulateToolCalls(info.OriginModelName)
+}
+
+func newToolCallEmulation(tools []emulatedTool) *ToolCallEmulation {
+	emulation := &ToolCallEmulation{toolNames: make(map[string]bool, len(tools))}
+	for _, tool := range tools {
+		emulation.toolNames[tool.Name] = true
+	}
+	return emulation
+}
+
+// buildToolCallPrompt 生成描述工具及调用格式的提示词，choice 为 auto、none、required 或指定的工具名
+func buildToolCallPrompt(tools []emulatedTool, choice string) string {
+	var builder strings.Builder
+	builder.WriteString("# Tools\n\nYou may call one or more tools to assist with the user query. The available tools are described below as JSON schemas:\n<tools>\n")
+	for _, tool := range tools {
+		data, err := common.Marshal(tool)
+		if err != nil {
+			continue
+		}
+		builder.Write(data)
+		builder.WriteString("\n")
+	}
+	builder.WriteString("</tools>\n\n")
+	builder.WriteString("To call a tool, reply with a JSON object containing the tool name and arguments inside <tool_call></tool_call> tags:\n")
+	builder.WriteString("<tool_call>\n{\"name\": \"<tool-name>\", \"arguments\": {<arguments-json-object>}}\n</tool_call>\n")
+	builder.WriteString("You may include several <tool_call> blocks in one reply. After the tool calls, stop and wait: the results will be provided inside <tool_response></tool_response> tags in the next message.")
+	switch choice {
+	case "", "auto":
+	case "none":
+		builder.WriteString("\nDo not call any tools in this reply; answer directly.")
+	case "required":
+		builder.WriteString("\nYou must call at least one tool in this reply.")
+	default:
+		builder.WriteString(fmt.Sprintf("\nYou must call the tool \"%s\" in this reply.", choice))
+	}
+	return builder.String()
+}
+
+func formatEmulatedToolCall(name string, arguments string) string {
+	if !gjson.Valid(arguments) {
+		arguments = "{}"
+	}
+	return fmt.Sprintf("%s\n{\"name\": %s, \"arguments\": %s}\n%s", toolCallOpenTag, strconv.Quote(name), arguments, toolCallCloseTag)
+}
+
+func formatEmulatedToolResponse(name string, content string, isError bool) string {
+	attrs := ""
+	if name != "" {
+		attrs += fmt.Sprintf(" name=%s", strconv.Quote(name))
+	}
+	if isError {
+		attrs += " error=\"true\""
+	}
+	return fmt.Sprintf("<tool_response%s>\n%s\n</tool_response>", attrs, content)
+}
+
+func openAIToolChoice2Emulated(toolChoice any) string {
+	switch choice := toolChoice.(type) {
+	case string:
+		return choice
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// EmulateOpenAIToolCalls 将 OpenAI 请求中的工具定义与调用历史转换为提示词，未开启模拟或请求不涉及工具时返回 nil
+func EmulateOpenAIToolCalls(info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) *ToolCallEmulation {
+	if !ShouldEmulateToolCalls(info) {
+		return nil
+	}
+	hasToolMessages := false
+	for _, message := range request.Messages {
+		if message.Role == "tool" || message.ToolCalls != nil {
+			hasToolMessages = true
+			break
+		}
+	}
+	if len(request.Tools) == 0 && !hasToolMessages {
+		return nil
+	}
+
+	tools := make([]emulatedTool, 0, len(request.Tools))
+	for _, tool := range request.Tools {
+		if tool.Type != "" && tool.Type != "function" {
+			continue
+		}
+		tools = append(tools, emulatedTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+
+	toolNamesById := make(map[string]string)
+	messages := make([]dto.Message, 0, len(request.Messages))
+	lastIsToolResponse := false
+	for _, message := range request.Messages {
+		switch {
+		case message.Role == "tool":
+			name := toolNamesById[message.ToolCallId]
+			if message.Name != nil && *message.Name != "" {
+				name = *message.Name
+			}
+			block := formatEmulatedToolResponse(name, message.StringContent(), false)
+			// 连续的工具结果合并为一条用户消息，保持角色交替
+			if lastIsToolResponse {
+				last := &messages[len(messages)-1]
+				last.SetStringContent(last.StringContent() + "\n" + block)
+			} else {
+				messages = append(messages, dto.Message{Role: "user", Content: block})
+			}
+			lastIsToolResponse = true
+			continue
+		case message.Role == "assistant" && message.ToolCalls != nil:
+			parts := make([]string, 0)
+			if text := message.StringContent(); text != "" {
+				parts = append(parts, text)
+			}
+			for _, call := range message.ParseToolCalls() {
+				toolNamesById[call.ID] = call.Function.Name
+				parts = append(parts, formatEmulatedToolCall(call.Function.Name, call.Function.Arguments))
+			}
+			message.ToolCalls = nil
+			message.SetStringContent(strings.Join(parts, "\n"))
+		}
+		messages = append(messages, message)
+		lastIsToolResponse = false
+	}
+
+	if len(tools) > 0 {
+		prompt := buildToolCallPrompt(tools, openAIToolChoice2Emulated(request.ToolChoice))
+		systemRole := request.GetSystemRoleName()
+		systemIndex := -1
+		for i, message := range messages {
+			if message.Role == systemRole || message.Role == "system" {
+				systemIndex = i
+				break
+			}
+		}
+		if systemIndex >= 0 {
+			system := &messages[systemIndex]
+			if system.IsStringContent() {
+				system.SetStringContent(system.StringContent() + "\n\n" + prompt)
+			} else {
+				contents := system.ParseContent()
+				contents = append(contents, dto.MediaContent{Type: dto.ContentTypeText, Text: prompt})
+				system.SetMediaContent(contents)
+			}
+		} else {
+			// 渠道系统提示词只在请求没有系统消息时添加，这里一并带上；允许覆盖时由后续流程拼接
+			if info.ChannelSetting.SystemPrompt != "" && !info.ChannelSetting.SystemPromptOverride {
+				prompt = info.ChannelSetting.SystemPrompt + "\n\n" + prompt
+			}
+			messages = append([]dto.Message{{Role: systemRole, Content: prompt}}, messages...)
+		}
+	}
+
+	request.Messages = messages
+	request.Tools = nil
+	request.ToolChoice = nil
+	request.ParallelTooCalls = nil
+	return newToolCallEmulation(tools)
+}
+
+func claudeToolResultText(block *dto.ClaudeMediaMessage) string {
+	if block.Content == nil {
+		return ""
+	}
+	if block.IsStringContent() {
+		return block.GetStringContent()
+	}
+	var builder strings.Builder
+	for _, content := range block.ParseMediaContent() {
+		if content.Type == dto.ContentTypeText {
+			builder.WriteString(content.GetText())
+		}
+	}
+	return builder.String()
+}
+
+// EmulateClaudeToolCalls 将 Claude 请求中的工具定义与 tool_use、tool_result 内容块转换为提示词，
+// 未开启模拟或请求不涉及工具时返回 nil
+func EmulateClaudeToolCalls(info *relaycommon.RelayInfo, request *dto.ClaudeRequest) *ToolCallEmulation {
+	if !ShouldEmulateToolCalls(info) {
+		return nil
+	}
+
+	tools := make([]emulatedTool, 0)
+	if request.Tools != nil {
+		claudeTools, err := common.Any2Type[[]map[string]any](request.Tools)
+		if err == nil {
+			for _, tool := range claudeTools {
+				// web_search 等服务端工具无法模拟
+				if toolType, _ := tool["type"].(string); toolType != "" && toolType != "custom" {
+					continue
+				}
+				name, _ := tool["name"].(string)
+				description, _ := tool["description"].(string)
+				tools = append(tools, emulatedTool{
+					Name:        name,
+					Description: description,
+					Parameters:  tool["input_schema"],
+				})
+			}
+		}
+	}
+
+	hasToolBlocks := false
+	toolNamesById := make(map[string]string)
+	for i := range request.Messages {
+		message := &request.Messages[i]
+		if message.IsStringContent() {
+			continue
+		}
+		blocks, err := message.ParseContent()
+		if err != nil {
+			continue
+		}
+		converted := make([]dto.ClaudeMediaMessage, 0, len(blocks))
+		changed := false
+		for j := range blocks {
+			block := blocks[j]
+			switch block.Type {
+			case "tool_use":
+				toolNamesById[block.Id] = block.Name
+				arguments, _ := common.Marshal(block.Input)
+				text := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+				text.SetText(formatEmulatedToolCall(block.Name, string(arguments)))
+				converted = append(converted, text)
+				changed = true
+			case "tool_result":
+				text := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+				text.SetText(formatEmulatedToolResponse(toolNamesById[block.ToolUseId], claudeToolResultText(&block), block.IsError))
+				converted = append(converted, text)
+				// 工具结果中的图片等内容保留在文本之后
+				if block.Content != nil && !block.IsStringContent() {
+					for _, content := range block.ParseMediaContent() {
+						if content.Type != dto.ContentTypeText {
+							converted = append(converted, content)
+						}
+					}
+				}
+				changed = true
+			default:
+				converted = append(converted, block)
+			}
+		}
+		if changed {
+			hasToolBlocks = true
+			message.SetContent(converted)
+		}
+	}
+	if len(tools) == 0 && !hasToolBlocks {
+		return nil
+	}
+
+	if len(tools) > 0 {
+		choice := ""
+		if request.ToolChoice != nil {
+			if toolChoice, err := common.Any2Type[dto.ClaudeToolChoice](request.ToolChoice); err == nil {
+				switch toolChoice.Type {
+				case "any":
+					choice = "required"
+				case "tool":
+					choice = toolChoice.Name
+				default:
+					choice = toolChoice.Type
+				}
+			}
+		}
+		prompt := buildToolCallPrompt(tools, choice)
+		if request.System == nil {
+			request.SetStringSystem(prompt)
+		} else if request.IsStringSystem() {
+			request.SetStringSystem(request.GetStringSystem() + "\n\n" + prompt)
+		} else {
+			system := dto.ClaudeMediaMessage{Type: dto.ContentTypeText}
+			system.SetText(prompt)
+			request.System = append(request.ParseSystem(), system)
+		}
+	}
+
+	request.Tools = nil
+	request.ToolChoice = nil
+	return newToolCallEmulation(tools)
+}
+
+var (
+	toolCallXMLNameRegex      = regexp.MustCompile(`(?s)<name>\s*(.*?)\s*</name>`)
+	toolCallXMLArgumentsRegex = regexp.MustCompile(`(?s)<(arguments|parameters)>\s*(.*?)\s*</(?:arguments|parameters)>`)
+	toolCallXMLInvokeRegex    = regexp.MustCompile(`(?s)<invoke\s+name="([^"]+)"\s*>(.*?)</invoke>`)
+	toolCallXMLParameterRegex = regexp.MustCompile(`(?s)<parameter\s+name="([^"]+)"\s*>(.*?)</parameter>`)
+)
+
+// bareToolCallPrefixes 模型未使用标签、直接输出 JSON 时可能的开头（已去除空白）
+var bareToolCallPrefixes = []string{`{"name"`, `{"tool_calls"`, `{"function"`, `[{"name"`}
+
+// toolCallParser 从模型输出的文本中解析工具调用，流式输出时保留可能属于工具调用的文本直到可以判断
+type toolCallParser struct {
+	names   map[string]bool
+	pending string
+	inCall  bool
+	decided bool // 已判断输出是否为不带标签的 JSON
+	bare    bool // 输出为不带标签的 JSON，保留全部文本到结束时解析
+}
+
+func newToolCallParser(names map[string]bool) *toolCallParser {
+	return &toolCallParser{names: names}
+}
+
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		text = text[newline+1:]
+	} else {
+		text = strings.TrimLeft(text, "abcdefghijklmnopqrstuvwxyz")
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// bareToolCallState 判断文本开头是否为不带标签的 JSON 工具调用，返回 0 表示尚无法判断，1 表示可能是，-1 表示不是
+func bareToolCallState(text string) int {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix("```", text) {
+		return 0
+	}
+	if strings.HasPrefix(text, "```") {
+		newline := strings.Index(text, "\n")
+		if newline < 0 {
+			return 0
+		}
+		text = text[newline+1:]
+	}
+	compact := strings.Join(strings.Fields(text), "")
+	if compact == "" {
+		return 0
+	}
+	for _, prefix := range bareToolCallPrefixes {
+		if strings.HasPrefix(compact, prefix) {
+			return 1
+		}
+		if strings.HasPrefix(prefix, compact) {
+			return 0
+		}
+	}
+	return -1
+}
+
+// partialSuffixLength 返回 text 末尾可能是 tag 开头的部分的长度
+func partialSuffixLength(text string, tag string) int {
+	for n := min(len(text), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// toolCallSegment 解析结果中按输出顺序排列的一段文本或一个工具调用
+type toolCallSegment struct {
+	text string
+	call *emulatedToolCall
+}
+
+func appendTextSegment(segments []toolCallSegment, text string) []toolCallSegment {
+	if text == "" {
+		return segments
+	}
+	if n := len(segments); n > 0 && segments[n-1].call == nil {
+		segments[n-1].text += text
+		return segments
+	}
+	return append(segments, toolCallSegment{text: text})
+}
+
+func appendCallSegments(segments []toolCallSegment, calls ...emulatedToolCall) []toolCallSegment {
+	for i := range calls {
+		segments = append(segments, toolCallSegment{call: &calls[i]})
+	}
+	return segments
+}
+
+// joinToolCallSegments 合并全部文本并取出工具调用，用于文本与工具调用分开表示的 OpenAI 格式
+func joinToolCallSegments(segments []toolCallSegment) (string, []emulatedToolCall) {
+	var builder strings.Builder
+	var calls []emulatedToolCall
+	for _, segment := range segments {
+		if segment.call != nil {
+			calls = append(calls, *segment.call)
+		} else {
+			builder.WriteString(segment.text)
+		}
+	}
+	return builder.String(), calls
+}
+
+// feed 输入一段输出文本，返回可以输出的文本与解析出的工具调用
+func (p *toolCallParser) feed(text string) []toolCallSegment {
+	p.pending += text
+	if !p.decided {
+		switch bareToolCallState(p.pending) {
+		case 0:
+			return nil
+		case 1:
+			p.bare = true
+		}
+		p.decided = true
+	}
+	if p.bare {
+		return nil
+	}
+	var segments []toolCallSegment
+	for {
+		if !p.inCall {
+			start := strings.Index(p.pending, toolCallOpenTag)
+			if start < 0 {
+				keep := partialSuffixLength(p.pending, toolCallOpenTag)
+				segments = appendTextSegment(segments, p.pending[:len(p.pending)-keep])
+				p.pending = p.pending[len(p.pending)-keep:]
+				break
+			}
+			segments = appendTextSegment(segments, p.pending[:start])
+			p.pending = p.pending[start+len(toolCallOpenTag):]
+			p.inCall = true
+		}
+		end := strings.Index(p.pending, toolCallCloseTag)
+		if end < 0 {
+			break
+		}
+		body := p.pending[:end]
+		p.pending = strings.TrimLeft(p.pending[end+len(toolCallCloseTag):], " \t\r\n")
+		p.inCall = false
+		if call, ok := p.parseCall(body); ok {
+			segments = appendCallSegments(segments, call)
+		} else {
+			segments = appendTextSegment(segments, toolCallOpenTag+body+toolCallCloseTag)
+		}
+	}
+	return segments
+}
+
+// flush 输出结束时调用，返回剩余的文本与工具调用
+func (p *toolCallParser) flush() []toolCallSegment {
+	text := p.pending
+	p.pending = ""
+	if p.bare || !p.decided {
+		p.decided = true
+		p.bare = false
+		if calls, ok := p.parseBare(text); ok {
+			return appendCallSegments(nil, calls...)
+		}
+		return appendTextSegment(nil, text)
+	}
+	if p.inCall {
+		p.inCall = false
+		// 模型可能省略结束标签
+		if call, ok := p.parseCall(text); ok {
+			return appendCallSegments(nil, call)
+		}
+		return appendTextSegment(nil, toolCallOpenTag+text)
+	}
+	return appendTextSegment(nil, text)
+}
+
+// parseAll 解析完整的输出文本
+func (p *toolCallParser) parseAll(text string) []toolCallSegment {
+	segments := p.feed(text)
+	for _, segment := range p.flush() {
+		if segment.call != nil {
+			segments = appendCallSegments(segments, *segment.call)
+		} else {
+			segments = appendTextSegment(segments, segment.text)
+		}
+	}
+	return segments
+}
+
+func (p *toolCallParser) acceptCall(name string, arguments string) (emulatedToolCall, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || (len(p.names) > 0 && !p.names[name]) {
+		return emulatedToolCall{}, false
+	}
+	arguments = strings.TrimSpace(arguments)
+	if arguments == "" {
+		arguments = "{}"
+	}
+	if !gjson.Valid(arguments) || !gjson.Parse(arguments).IsObject() {
+		return emulatedToolCall{}, false
+	}
+	return emulatedToolCall{name: name, arguments: arguments}, true
+}
+
+func (p *toolCallParser) parseJSONCall(result gjson.Result) (emulatedToolCall, bool) {
+	if function := result.Get("function"); function.IsObject() {
+		result = function
+	}
+	name := result.Get("name")
+	if !name.Exists() {
+		name = result.Get("tool")
+	}
+	arguments := ""
+	for _, key := range []string{"arguments", "parameters", "input", "args"} {
+		value := result.Get(key)
+		if !value.Exists() {
+			continue
+		}
+		if value.Type == gjson.String {
+			arguments = value.String()
+		} else {
+			arguments = value.Raw
+		}
+		break
+	}
+	return p.acceptCall(name.String(), arguments)
+}
+
+// parseCall 解析 <tool_call> 标签中的内容，支持 JSON 与 XML 两种写法
+func (p *toolCallParser) parseCall(body string) (emulatedToolCall, bool) {
+	body = stripCodeFence(body)
+	if gjson.Valid(body) {
+		return p.parseJSONCall(gjson.Parse(body))
+	}
+	if match := toolCallXMLInvokeRegex.FindStringSubmatch(body); match != nil {
+		arguments := "{}"
+		for _, parameter := range toolCallXMLParameterRegex.FindAllStringSubmatch(match[2], -1) {
+			value := strings.TrimSpace(parameter[2])
+			if gjson.Valid(value) {
+				arguments, _ = sjson.SetRaw(arguments, parameter[1], value)
+			} else {
+				arguments, _ = sjson.Set(arguments, parameter[1], value)
+			}
+		}
+		return p.acceptCall(match[1], arguments)
+	}
+	if match := toolCallXMLNameRegex.FindStringSubmatch(body); match != nil {
+		arguments := ""
+		if args := toolCallXMLArgumentsRegex.FindStringSubmatch(body); args != nil {
+			arguments = args[2]
+		}
+		return p.acceptCall(match[1], arguments)
+	}
+	return emulatedToolCall{}, false
+}
+
+// parseBare 解析不带标签、整段输出为 JSON 的工具调用
+func (p *toolCallParser) parseBare(text string) ([]emulatedToolCall, bool) {
+	text = stripCodeFence(text)
+	if !gjson.Valid(text) {
+		return nil, false
+	}
+	result := gjson.Parse(text)
+	if toolCalls := result.Get("tool_calls"); toolCalls.IsArray() {
+		result = toolCalls
+	}
+	items := []gjson.Result{result}
+	if result.IsArray() {
+		items = result.Array()
+	}
+	if len(items) == 0 {
+		return nil, false
+	}
+	calls := make([]emulatedToolCall, 0, len(items))
+	for _, item := range items {
+		call, ok := p.parseJSONCall(item)
+		if !ok {
+			return nil, false
+		}
+		calls = append(calls, call)
+	}
+	return calls, true
+}
+
+// toolCallEmulationWriter 将模型输出中的模拟工具调用转换为客户端格式的工具调用
+type toolCallEmulationWriter struct {
+	gin.ResponseWriter
+	emulation *ToolCallEmulation
+	format    types.RelayFormat
+	stream    *bool
+
+	pending []byte
+	body    bytes.Buffer
+
+	// OpenAI 格式
+	parsers      map[int64]*toolCallParser
+	callCounts   map[int64]int
+	lastTemplate string // 最近一个包含 choices 的事件，用于构造补发的事件
+
+	// Claude 格式
+	parser       *toolCallParser
+	textUpstream int64 // 上游文本块的序号，-1 表示没有打开的文本块
+	textOpen     bool
+	textIndex    int64
+	nextIndex    int64
+	indexMap     map[int64]int64 // 上游非文本内容块序号到输出序号的映射
+	claudeCalls  int
+}
+
+// StartToolCallEmulation 包装响应写入器以转换模拟的工具调用，返回在响应处理完成后需要调用的函数，
+// 该函数可以重复调用，调用方应同时 defer 以便处理响应时 panic 也能恢复 c.Writer；请求未定义工具时返回 nil
+func StartToolCallEmulation(c *gin.Context, emulation *ToolCallEmulation, format types.RelayFormat) func() {
+	if emulation == nil || len(emulation.toolNames) == 0 {
+		return nil
+	}
+	if format != types.RelayFormatOpenAI && format != types.RelayFormatClaude {
+		return nil
+	}
+	w := &toolCallEmulationWriter{
+		ResponseWriter: c.Writer,
+		emulation:      emulation,
+		format:         format,
+		parsers:        make(map[int64]*toolCallParser),
+		callCounts:     make(map[int64]int),
+		textUpstream:   -1,
+		indexMap:       make(map[int64]int64),
+	}
+	c.Writer = w
+	finished := false
+	return func() {
+		if finished {
+			return
+		}
+		finished = true
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+		w.finish()
+	}
+}
+
+// isStream 非 JSON 的响应按非流式缓存，结束时原样写出
+func (w *toolCallEmulationWriter) isStream() bool {
+	if w.stream == nil {
+		stream := strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+		w.stream = &stream
+	}
+	return *w.stream
+}
+
+func (w *toolCallEmulationWriter) Write(data []byte) (int, error) {
+	if !w.isStream() {
+		return w.body.Write(data)
+	}
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.Index(w.pending, []byte("\n\n"))
+		if end < 0 {
+			break
+		}
+		event := string(w.pending[:end+2])
+		w.pending = w.pending[end+2:]
+		if _, err := w.ResponseWriter.Write([]byte(w.convertStreamEvent(event))); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *toolCallEmulationWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow 非流式响应在转换完成后才写出响应头
+func (w *toolCallEmulationWriter) WriteHeaderNow() {
+	if w.isStream() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *toolCallEmulationWriter) Flush() {
+	if w.isStream() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *toolCallEmulationWriter) finish() {
+	if w.stream == nil {
+		return
+	}
+	if *w.stream {
+		if len(w.pending) > 0 {
+			_, _ = w.ResponseWriter.Write([]byte(w.convertStreamEvent(string(w.pending) + "\n\n")))
+			w.pending = nil
+		}
+		if w.format == types.RelayFormatOpenAI {
+			_, _ = w.ResponseWriter.Write([]byte(w.flushOpenAI()))
+		}
+		w.ResponseWriter.Flush()
+		return
+	}
+	body := w.body.Bytes()
+	if w.Status() == http.StatusOK && strings.Contains(w.Header().Get("Content-Type"), "json") && gjson.ValidBytes(body) {
+		if w.format == types.RelayFormatClaude {
+			body = w.convertClaudeBody(body)
+		} else {
+			body = w.convertOpenAIBody(body)
+		}
+	}
+	if w.Header().Get("Content-Length") != "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	// 响应头随数据一起写出，外层写入器（如 Responses 转换）可以在写出前再修改响应头
+	if len(body) == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+func newOpenAIToolCallId() string {
+	return "call_" + common.GetUUID()
+}
+
+func newClaudeToolUseId() string {
+	return "toolu_" + common.GetUUID()
+}
+
+func (w *toolCallEmulationWriter) convertOpenAIBody(body []byte) []byte {
+	data := string(body)
+	for i, choice := range gjson.Get(data, "choices").Array() {
+		content := choice.Get("message.content")
+		if content.Type != gjson.String {
+			continue
+		}
+		text, calls := joinToolCallSegments(newToolCallParser(w.emulation.toolNames).parseAll(content.String()))
+		if len(calls) == 0 {
+			continue
+		}
+		prefix := fmt.Sprintf("choices.%d.", i)
+		if strings.TrimSpace(text) == "" {
+			data, _ = sjson.SetRaw(data, prefix+"message.content", "null")
+		} else {
+			data, _ = sjson.Set(data, prefix+"message.content", strings.TrimSpace(text))
+		}
+		toolCalls := make([]dto.ToolCallResponse, 0, len(calls))
+		for _, call := range calls {
+			toolCalls = append(toolCalls, dto.ToolCallResponse{
+				ID:   newOpenAIToolCallId(),
+				Type: "function",
+				Function: dto.FunctionResponse{
+					Name:      call.name,
+					Arguments: call.arguments,
+				},
+			})
+		}
+		data, _ = sjson.Set(data, prefix+"message.tool_calls", toolCalls)
+		data, _ = sjson.Set(data, prefix+"finish_reason", "tool_calls")
+	}
+	return []byte(data)
+}
+
+func (w *toolCallEmulationWriter) convertClaudeBody(body []byte) []byte {
+	data := string(body)
+	content := gjson.Get(data, "content")
+	if !content.IsArray() {
+		return body
+	}
+	blocks := make([]any, 0)
+	callCount := 0
+	for _, block := range content.Array() {
+		if block.Get("type").String() != dto.ContentTypeText {
+			blocks = append(blocks, block.Value())
+			continue
+		}
+		segments := newToolCallParser(w.emulation.toolNames).parseAll(block.Get("text").String())
+		if _, calls := joinToolCallSegments(segments); len(calls) == 0 {
+			blocks = append(blocks, block.Value())
+			continue
+		}
+		for _, segment := range segments {
+			if segment.call == nil {
+				if text := strings.TrimSpace(segment.text); text != "" {
+					blocks = append(blocks, map[string]any{"type": dto.ContentTypeText, "text": text})
+				}
+				continue
+			}
+			var input any
+			_ = common.UnmarshalJsonStr(segment.call.arguments, &input)
+			blocks = append(blocks, map[string]any{
+				"type":  "tool_use",
+				"id":    newClaudeToolUseId(),
+				"name":  segment.call.name,
+				"input": input,
+			})
+			callCount++
+		}
+	}
+	if callCount == 0 {
+		return body
+	}
+	data, _ = sjson.Set(data, "content", blocks)
+	data, _ = sjson.Set(data, "stop_reason", "tool_use")
+	return []byte(data)
+}
+
+func parseSSEEvent(event string) (eventType string, data string, hasData bool) {
+	for _, line := range strings.Split(strings.TrimRight(event, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if value, ok := strings.CutPrefix(line, "event:"); ok {
+			eventType = strings.TrimSpace(value)
+		} else if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = strings.TrimSpace(value)
+			hasData = true
+		}
+	}
+	return
+}
+
+func (w *toolCallEmulationWriter) convertStreamEvent(event string) string {
+	eventType, data, hasData := parseSSEEvent(event)
+	if !hasData {
+		return event
+	}
+	if w.format == types.RelayFormatOpenAI {
+		if data == "[DONE]" {
+			return w.flushOpenAI() + event
+		}
+		if !gjson.Valid(data) {
+			return event
+		}
+		return w.convertOpenAIEvent(event, data)
+	}
+	if !gjson.Valid(data) {
+		return event
+	}
+	return w.convertClaudeEvent(event, eventType, data)
+}
+
+func (w *toolCallEmulationWriter) openAIParser(index int64) *toolCallParser {
+	parser, ok := w.parsers[index]
+	if !ok {
+		parser = newToolCallParser(w.emulation.toolNames)
+		w.parsers[index] = parser
+	}
+	return parser
+}
+
+// openAIChoiceEvent 以最近的事件为模板构造只包含一个 choice 的事件
+func (w *toolCallEmulationWriter) openAIChoiceEvent(index int64, delta any, finishReason any) string {
+	data, _ := sjson.Set(w.lastTemplate, "choices", []map[string]any{
+		{
+			"index":         index,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		},
+	})
+	data, _ = sjson.Delete(data, "usage")
+	return formatSSEEvent("", data)
+}
+
+func (w *toolCallEmulationWriter) openAIToolCallEvents(index int64, calls []emulatedToolCall) string {
+	var builder strings.Builder
+	for _, call := range calls {
+		builder.WriteString(w.openAIChoiceEvent(index, map[string]any{
+			"role": "assistant",
+			"tool_calls": []map[string]any{
+				{
+					"index": w.callCounts[index],
+					"id":    newOpenAIToolCallId(),
+					"type":  "function",
+					"function": map[string]any{
+						"name":      call.name,
+						"arguments": call.arguments,
+					},
+				},
+			},
+		}, nil))
+		w.callCounts[index]++
+	}
+	return builder.String()
+}
+
+func (w *toolCallEmulationWriter) convertOpenAIEvent(event string, data string) string {
+	choices := gjson.Get(data, "choices")
+	if !choices.IsArray() || len(choices.Array()) == 0 {
+		return event
+	}
+	w.lastTemplate = data
+	var extra strings.Builder
+	for i, choice := range choices.Array() {
+		index := choice.Get("index").Int()
+		parser := w.openAIParser(index)
+		prefix := fmt.Sprintf("choices.%d.", i)
+		var calls []emulatedToolCall
+		if content := choice.Get("delta.content"); content.Type == gjson.String {
+			var text string
+			text, calls = joinToolCallSegments(parser.feed(content.String()))
+			data, _ = sjson.Set(data, prefix+"delta.content", text)
+		}
+		finishReason := choice.Get("finish_reason")
+		if finishReason.Type == gjson.String && finishReason.String() != "" {
+			rest, restCalls := joinToolCallSegments(parser.flush())
+			if rest != "" {
+				data, _ = sjson.Set(data, prefix+"delta.content", gjson.Get(data, prefix+"delta.content").String()+rest)
+			}
+			calls = append(calls, restCalls...)
+			delete(w.parsers, index)
+			if len(calls) > 0 || w.callCounts[index] > 0 {
+				// 工具调用需要在结束原因之前输出
+				data, _ = sjson.SetRaw(data, prefix+"finish_reason", "null")
+				extra.WriteString(w.openAIToolCallEvents(index, calls))
+				extra.WriteString(w.openAIChoiceEvent(index, map[string]any{}, "tool_calls"))
+				continue
+			}
+		}
+		extra.WriteString(w.openAIToolCallEvents(index, calls))
+	}
+	return formatSSEEvent("", data) + extra.String()
+}
+
+// flushOpenAI 上游未返回结束原因时，在结束前输出剩余的文本与工具调用
+func (w *toolCallEmulationWriter) flushOpenAI() string {
+	if w.lastTemplate == "" {
+		return ""
+	}
+	var builder strings.Builder
+	for index, parser := range w.parsers {
+		text, calls := joinToolCallSegments(parser.flush())
+		if text != "" {
+			builder.WriteString(w.openAIChoiceEvent(index, map[string]any{"content": text}, nil))
+		}
+		builder.WriteString(w.openAIToolCallEvents(index, calls))
+		if w.callCounts[index] > 0 {
+			builder.WriteString(w.openAIChoiceEvent(index, map[string]any{}, "tool_calls"))
+		}
+		delete(w.parsers, index)
+	}
+	return builder.String()
+}
+
+func claudeStreamEvent(eventType string, payload any) string {
+	data, err := common.Marshal(payload)
+	if err != nil {
+		return ""
+	}
+	return formatSSEEvent(eventType, string(data))
+}
+
+// claudeText 输出文本，需要时打开新的文本块
+func (w *toolCallEmulationWriter) claudeText(text string) string {
+	if text == "" {
+		return ""
+	}
+	var builder strings.Builder
+	if !w.textOpen {
+		w.textOpen = true
+		w.textIndex = w.nextIndex
+		w.nextIndex++
+		builder.WriteString(claudeStreamEvent("content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         w.textIndex,
+			"content_block": map[string]any{"type": dto.ContentTypeText, "text": ""},
+		}))
+	}
+	builder.WriteString(claudeStreamEvent("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": w.textIndex,
+		"delta": map[string]any{"type": "text_delta", "text": text},
+	}))
+	return builder.String()
+}
+
+func (w *toolCallEmulationWriter) closeClaudeText() string {
+	if !w.textOpen {
+		return ""
+	}
+	w.textOpen = false
+	return claudeStreamEvent("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": w.textIndex,
+	})
+}
+
+// claudeToolUses 关闭当前文本块并输出完整的 tool_use 内容块
+func (w *toolCallEmulationWriter) claudeToolUses(calls []emulatedToolCall) string {
+	var builder strings.Builder
+	builder.WriteString(w.closeClaudeText())
+	for _, call := range calls {
+		index := w.nextIndex
+		w.nextIndex++
+		builder.WriteString(claudeStreamEvent("content_block_start", map[string]any{
+			"type":  "content_block_start",
+			"index": index,
+			"content_block": map[string]any{
+				"type":  "tool_use",
+				"id":    newClaudeToolUseId(),
+				"name":  call.name,
+				"input": map[string]any{},
+			},
+		}))
+		builder.WriteString(claudeStreamEvent("content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]any{"type": "input_json_delta", "partial_json": call.arguments},
+		}))
+		builder.WriteString(claudeStreamEvent("content_block_stop", map[string]any{
+			"type":  "content_block_stop",
+			"index": index,
+		}))
+	}
+	w.claudeCalls += len(calls)
+	return builder.String()
+}
+
+// flushClaudeText 上游文本块结束时输出剩余的文本与工具调用
+func (w *toolCallEmulationWriter) flushClaudeText() string {
+	if w.textUpstream < 0 {
+		return ""
+	}
+	w.textUpstream = -1
+	return w.claudeSegments(w.parser.flush()) + w.closeClaudeText()
+}
+
+// convertClaudeEvent 转换 Claude 事件；插入 tool_use 内容块后，之后的内容块序号依次后移
+func (w *toolCallEmulationWriter) convertClaudeEvent(event string, eventType string, data string) string {
+	index := gjson.Get(data, "index").Int()
+	switch gjson.Get(data, "type").String() {
+	case "content_block_start":
+		if gjson.Get(data, "content_block.type").String() == dto.ContentTypeText {
+			prefix := w.flushClaudeText()
+			w.textUpstream = index
+			w.parser = newToolCallParser(w.emulation.toolNames)
+			return prefix + w.feedClaudeText(gjson.Get(data, "content_block.text").String())
+		}
+		prefix := w.flushClaudeText()
+		w.indexMap[index] = w.nextIndex
+		w.nextIndex++
+		data, _ = sjson.Set(data, "index", w.indexMap[index])
+		return prefix + formatSSEEvent(eventType, data)
+	case "content_block_delta":
+		if index == w.textUpstream && w.textUpstream >= 0 {
+			if gjson.Get(data, "delta.type").String() == "text_delta" {
+				return w.feedClaudeText(gjson.Get(data, "delta.text").String())
+			}
+			if !w.textOpen {
+				return ""
+			}
+			data, _ = sjson.Set(data, "index", w.textIndex)
+			return formatSSEEvent(eventType, data)
+		}
+		if mapped, ok := w.indexMap[index]; ok {
+			data, _ = sjson.Set(data, "index", mapped)
+		}
+		return formatSSEEvent(eventType, data)
+	case "content_block_stop":
+		if index == w.textUpstream && w.textUpstream >= 0 {
+			return w.flushClaudeText()
+		}
+		if mapped, ok := w.indexMap[index]; ok {
+			data, _ = sjson.Set(data, "index", mapped)
+		}
+		return formatSSEEvent(eventType, data)
+	case "message_delta":
+		prefix := w.flushClaudeText()
+		if w.claudeCalls > 0 {
+			data, _ = sjson.Set(data, "delta.stop_reason", "tool_use")
+		}
+		return prefix + formatSSEEvent(eventType, data)
+	}
+	return event
+}
+
+func (w *toolCallEmulationWriter) feedClaudeText(text string) string {
+	return w.claudeSegments(w.parser.feed(text))
+}
+
+// claudeSegments 按顺序输出文本与 tool_use 内容块
+func (w *toolCallEmulationWriter) claudeSegments(segments []toolCallSegment) string {
+	var builder strings.Builder
+	for _, segment := range segments {
+		if segment.call != nil {
+			builder.WriteString(w.claudeToolUses([]emulatedToolCall{*segment.call}))
+		} else {
+			builder.WriteString(w.claudeText(segment.text))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func newToolCallEmulationContext() (*gin.Context, *httptest.ResponseRecorder, *ToolCallEmulation) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	info.ChannelSetting.ToolCallEmulation = true
+	request := &dto.GeneralOpenAIRequest{
+		Messages: []dto.Message{{Role: "user", Content: "weather?"}},
+		Tools:    []dto.ToolCallRequest{{Type: "function", Function: dto.FunctionRequest{Name: "get_weather"}}},
+	}
+	return c, recorder, EmulateOpenAIToolCalls(info, request)
+}
+
+func TestToolCallEmulationConvertsJSONBody(t *testing.T) {
+	c, recorder, emulation := newToolCallEmulationContext()
+	original := c.Writer
+	finish := StartToolCallEmulation(c, emulation, types.RelayFormatOpenAI)
+	if finish == nil {
+		t.Fatal("emulation should be started")
+	}
+	c.Header("Content-Type", "application/json")
+	c.Status(http.StatusOK)
+	_, _ = c.Writer.Write([]byte(`{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"<tool_call>{\"name\":\"get_weather\",\"arguments\":{\"city\":\"Paris\"}}</tool_call>"},"finish_reason":"stop"}]}`))
+	if recorder.Body.Len() != 0 {
+		t.Fatal("non-stream body should be buffered until finish")
+	}
+	finish()
+	finish()
+	if c.Writer != original {
+		t.Fatal("writer should be restored")
+	}
+	body := recorder.Body.String()
+	if gjson.Get(body, "choices.0.message.tool_calls.0.function.name").String() != "get_weather" ||
+		gjson.Get(body, "choices.0.finish_reason").String() != "tool_calls" {
+		t.Fatalf("tool call should be converted, got %s", body)
+	}
+}
+
+func TestToolCallEmulationPassesThroughNonJSON(t *testing.T) {
+	c, recorder, emulation := newToolCallEmulationContext()
+	finish := StartToolCallEmulation(c, emulation, types.RelayFormatOpenAI)
+	c.Header("Content-Type", "text/plain")
+	text := "<tool_call>{\"name\":\"get_weather\",\"arguments\":{}}</tool_call>\n\nplain"
+	_, _ = c.Writer.Write([]byte(text))
+	finish()
+	if recorder.Body.String() != text {
+		t.Fatalf("non-JSON body should be written unchanged, got %q", recorder.Body.String())
+	}
+}
+
+func TestToolCallEmulationRestoresWriterOnPanic(t *testing.T) {
+	c, _, emulation := newToolCallEmulationContext()
+	original := c.Writer
+	func() {
+		defer func() {
+			_ = recover()
+		}()
+		finish := StartToolCallEmulation(c, emulation, types.RelayFormatOpenAI)
+		defer finish()
+		panic("adaptor panic")
+	}()
+	if c.Writer != original {
+		t.Fatal("writer should be restored after panic")
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TPM 令牌桶按 token·秒 计量：容量为 TPM*60，每秒补充 TPM，消耗 n 个 token 扣减 n*60，
+// 与 ModelRequestRateLimit 中的令牌桶用法一致
+const (
+	tpmWindowSeconds = 60
+	tpmKeyTTLSeconds = tpmWindowSeconds * 2
+)
+
+// collectTPMBuckets 收集请求涉及的令牌桶，分组和模型的限制按用户分别计算
+func collectTPMBuckets(info *relaycommon.RelayInfo) ([]relaycommon.TPMBucket, []relaycommon.TPMBucket) {
+	setting := operation_setting.GetTPMLimitSetting()
+	scopes := []struct {
+		key   string
+		limit operation_setting.TPMLimit
+	}{
+		{fmt.Sprintf("token:%d", info.TokenId), setting.GetTokenLimit(info.TokenId)},
+		{fmt.Sprintf("user:%d", info.UserId), setting.GetUserLimit(info.UserId)},
+		{fmt.Sprintf("group:%s:%d", info.UsingGroup, info.UserId), setting.GetGroupLimit(info.UsingGroup)},
+		{fmt.Sprintf("model:%s:%d", info.OriginModelName, info.UserId), setting.GetModelLimit(info.OriginModelName)},
+	}
+	var inputBuckets, outputBuckets []relaycommon.TPMBucket
+	for _, scope := range scopes {
+		if scope.limit.InputTPM > 0 {
+			inputBuckets = append(inputBuckets, relaycommon.TPMBucket{Key: "tpm:input:" + scope.key, TPM: scope.limit.InputTPM})
+		}
+		if scope.limit.OutputTPM > 0 {
+			outputBuckets = append(outputBuckets, relaycommon.TPMBucket{Key: "tpm:output:" + scope.key, TPM: scope.limit.OutputTPM})
+		}
+	}
+	return inputBuckets, outputBuckets
+}
+
+func takeTPMBucket(bucket relaycommon.TPMBucket, tokens int, force bool) (bool, error) {
+	opts := []limiter.Option{
+		limiter.WithCapacity(int64(bucket.TPM) * tpmWindowSeconds),
+		limiter.WithRate(int64(bucket.TPM)),
+		limiter.WithRequested(int64(tokens) * tpmWindowSeconds),
+		limiter.WithTTL(tpmKeyTTLSeconds),
+	}
+	if force {
+		opts = append(opts, limiter.WithForce())
+	}
+	if common.RedisEnabled {
+		ctx := context.Background()
+		return limiter.New(ctx, common.RDB).Allow(ctx, bucket.Key, opts...)
+	}
+	return limiter.NewMemory().Allow(bucket.Key, opts...), nil
+}
+
+// CheckTPMLimit 按估算的输入 token 数预先扣减输入 TPM，并检查输出 TPM 是否已透支
+func CheckTPMLimit(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int) *types.NewAPIError {
+	if !operation_setting.GetTPMLimitSetting().Enabled {
+		return nil
+	}
+	inputBuckets, outputBuckets := collectTPMBuckets(info)
+	if len(inputBuckets) == 0 && len(outputBuckets) == 0 {
+		return nil
+	}
+
+	// 超过每分钟限制的请求即使等待也无法通过，直接返回不可重试的错误
+	for _, bucket := range inputBuckets {
+		if promptTokens > bucket.TPM {
+			return types.NewErrorWithStatusCode(fmt.Errorf("本次请求预计 %d tokens，超过每分钟输入 token 数限制：%d", promptTokens, bucket.TPM), types.ErrorCodeInvalidRequest, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+	}
+
+	// 输出 token 数只能在请求完成后得知，这里只检查余量是否为负
+	for _, bucket := range outputBuckets {
+		allowed, err := takeTPMBucket(bucket, 0, false)
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeRateLimitExceeded, types.ErrOptionWithSkipRetry())
+		}
+		if !allowed {
+			return types.NewErrorWithStatusCode(fmt.Errorf("已达到每分钟输出 token 数限制：%d", bucket.TPM), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+	}
+
+	for i, bucket := range inputBuckets {
+		allowed, err := takeTPMBucket(bucket, promptTokens, false)
+		if err == nil && allowed {
+			continue
+		}
+		// 退还已经扣减的令牌桶
+		for _, taken := range inputBuckets[:i] {
+			if _, refundErr := takeTPMBucket(taken, -promptTokens, true); refundErr != nil {
+				common.SysError("failed to refund tpm limit: " + refundErr.Error())
+			}
+		}
+		if err != nil {
+			return types.NewError(err, types.ErrorCodeRateLimitExceeded, types.ErrOptionWithSkipRetry())
+		}
+		return types.NewErrorWithStatusCode(fmt.Errorf("已达到每分钟输入 token 数限制：%d，本次请求预计 %d tokens", bucket.TPM, promptTokens), types.ErrorCodeRateLimitExceeded, http.StatusTooManyRequests, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+
+	info.TPMLimit = &relaycommon.TPMLimitInfo{
+		InputBuckets:        inputBuckets,
+		OutputBuckets:       outputBuckets,
+		ReservedInputTokens: promptTokens,
+	}
+	return nil
+}
+
+// ReconcileTPMLimit 结算后按实际用量校正令牌桶，输入按与预扣的差值扣减，输出全部扣减
+func ReconcileTPMLimit(info *relaycommon.RelayInfo, promptTokens int, completionTokens int) {
+	if info.TPMLimit == nil {
+		return
+	}
+	inputDelta := promptTokens - info.TPMLimit.ReservedInputTokens
+	info.TPMLimit.ReservedInputTokens = 0
+	if inputDelta != 0 {
+		for _, bucket := range info.TPMLimit.InputBuckets {
+			if _, err := takeTPMBucket(bucket, inputDelta, true); err != nil {
+				common.SysError("failed to reconcile tpm limit: " + err.Error())
+			}
+		}
+	}
+	if completionTokens > 0 {
+		for _, bucket := range info.TPMLimit.OutputBuckets {
+			if _, err := takeTPMBucket(bucket, completionTokens, true); err != nil {
+				common.SysError("failed to reconcile tpm limit: " + err.Error())
+			}
+		}
+	}
+}
+
+// RefundTPMLimit 请求失败时退还预扣的输入额度
+func RefundTPMLimit(info *relaycommon.RelayInfo) {
+	if info.TPMLimit == nil || info.TPMLimit.ReservedInputTokens == 0 {
+		return
+	}
+	ReconcileTPMLimit(info, 0, 0)
+}
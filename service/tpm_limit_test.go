@@ -0,0 +1,134 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupTPMLimitTest 使用单机令牌桶，每个测试使用不同的令牌和用户，避免共享桶状态
+func setupTPMLimitTest(t *testing.T, tokenId int, tokenLimit operation_setting.TPMLimit, userLimit operation_setting.TPMLimit) (*gin.Context, *relaycommon.RelayInfo) {
+	t.Helper()
+	redisEnabled := common.RedisEnabled
+	setting := operation_setting.GetTPMLimitSetting()
+	original := *setting
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+		*setting = original
+	})
+	common.RedisEnabled = false
+	setting.Enabled = true
+	setting.TokenLimits = map[string]operation_setting.TPMLimit{strconv.Itoa(tokenId): tokenLimit}
+	setting.UserLimits = map[string]operation_setting.TPMLimit{strconv.Itoa(tokenId): userLimit}
+	setting.GroupLimits = map[string]operation_setting.TPMLimit{}
+	setting.ModelLimits = map[string]operation_setting.TPMLimit{}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	return c, &relaycommon.RelayInfo{TokenId: tokenId, UserId: tokenId, UsingGroup: "default", OriginModelName: "gpt-4o"}
+}
+
+func TestCheckTPMLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokenLimit operation_setting.TPMLimit
+		userLimit  operation_setting.TPMLimit
+		run        func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo)
+	}{
+		{
+			name:       "prompt larger than limit is not retryable",
+			tokenLimit: operation_setting.TPMLimit{InputTPM: 100},
+			run: func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo) {
+				err := CheckTPMLimit(c, info, 101)
+				if err == nil || err.StatusCode != http.StatusRequestEntityTooLarge || !types.IsSkipRetryError(err) {
+					t.Fatalf("expected non-retryable 413, got %v", err)
+				}
+				// 未扣减任何令牌桶
+				if err := CheckTPMLimit(c, info, 100); err != nil {
+					t.Fatalf("prompt within limit should pass, got %v", err)
+				}
+			},
+		},
+		{
+			name:       "exhausted limit is rate limited",
+			tokenLimit: operation_setting.TPMLimit{InputTPM: 100},
+			run: func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo) {
+				if err := CheckTPMLimit(c, info, 100); err != nil {
+					t.Fatal(err)
+				}
+				if err := CheckTPMLimit(c, info, 50); err == nil || err.StatusCode != http.StatusTooManyRequests {
+					t.Fatalf("expected 429, got %v", err)
+				}
+			},
+		},
+		{
+			name:       "partial failure rolls back earlier buckets",
+			tokenLimit: operation_setting.TPMLimit{InputTPM: 100},
+			userLimit:  operation_setting.TPMLimit{InputTPM: 100},
+			run: func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo) {
+				_, _ = takeTPMBucket(relaycommon.TPMBucket{Key: "tpm:input:user:" + strconv.Itoa(info.UserId), TPM: 100}, 100, false)
+				if err := CheckTPMLimit(c, info, 50); err == nil || err.StatusCode != http.StatusTooManyRequests {
+					t.Fatalf("user bucket is exhausted, expected 429, got %v", err)
+				}
+				// 令牌的桶已退还，仍可一次用满
+				if allowed, _ := takeTPMBucket(relaycommon.TPMBucket{Key: "tpm:input:token:" + strconv.Itoa(info.TokenId), TPM: 100}, 100, false); !allowed {
+					t.Fatal("token bucket should be refunded after the user bucket rejected the request")
+				}
+			},
+		},
+		{
+			name:       "refund on failure",
+			tokenLimit: operation_setting.TPMLimit{InputTPM: 100},
+			run: func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo) {
+				if err := CheckTPMLimit(c, info, 100); err != nil {
+					t.Fatal(err)
+				}
+				RefundTPMLimit(info)
+				// 重复退还不会超额补充
+				RefundTPMLimit(info)
+				if err := CheckTPMLimit(c, info, 100); err != nil {
+					t.Fatalf("refunded bucket should allow a full request, got %v", err)
+				}
+			},
+		},
+		{
+			name:       "reconcile refunds unused input",
+			tokenLimit: operation_setting.TPMLimit{InputTPM: 100},
+			run: func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo) {
+				if err := CheckTPMLimit(c, info, 100); err != nil {
+					t.Fatal(err)
+				}
+				ReconcileTPMLimit(info, 40, 0)
+				if err := CheckTPMLimit(c, info, 60); err != nil {
+					t.Fatalf("unused input should be refunded, got %v", err)
+				}
+			},
+		},
+		{
+			name:       "output overdraft blocks next request",
+			tokenLimit: operation_setting.TPMLimit{OutputTPM: 100},
+			run: func(t *testing.T, c *gin.Context, info *relaycommon.RelayInfo) {
+				if err := CheckTPMLimit(c, info, 10); err != nil {
+					t.Fatal(err)
+				}
+				// 输出 token 数在结算时强制扣减，允许透支
+				ReconcileTPMLimit(info, 10, 200)
+				if err := CheckTPMLimit(c, info, 10); err == nil || err.StatusCode != http.StatusTooManyRequests {
+					t.Fatalf("overdrawn output bucket should reject, got %v", err)
+				}
+			},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, info := setupTPMLimitTest(t, 9000+i, tt.tokenLimit, tt.userLimit)
+			tt.run(t, c, info)
+		})
+	}
+}
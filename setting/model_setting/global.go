@@ -9,6 +9,8 @@ import (
 type GlobalSettings struct {
 	PassThroughRequestEnabled bool     `json:"pass_through_request_enabled"`
 	ThinkingModelBlacklist    []string `json:"thinking_model_blacklist"`
+	// 以提示词模拟工具调用的模型，用于不支持原生函数调用的模型
+	ToolCallEmulationModels []string `json:"tool_call_emulation_models"`
 }
 
 // 默认配置
@@ -18,6 +20,7 @@ var defaultOpenaiSettings = GlobalSettings{
 		"moonshotai/kimi-k2-thinking",
 		"kimi-k2-thinking",
 	},
+	ToolCallEmulationModels: []string{},
 }
 
 // 全局实例
@@ -46,3 +49,18 @@ func ShouldPreserveThinkingSuffix(modelName string) bool {
 	}
 	return false
 }
+
+// ShouldEmulateToolCalls 判断模型是否配置为以提示词模拟工具调用
+func ShouldEmulateToolCalls(modelName string) bool {
+	target := strings.TrimSpace(modelName)
+	if target == "" {
+		return false
+	}
+
+	for _, entry := range globalSettings.ToolCallEmulationModels {
+		if strings.TrimSpace(entry) == target {
+			return true
+		}
+	}
+	return false
+}
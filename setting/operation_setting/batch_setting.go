@@ -0,0 +1,30 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+type BatchSetting struct {
+	Enabled bool `json:"enabled"`
+	// MaxConcurrency 本地执行批处理时单个批次的最大并发请求数
+	MaxConcurrency int `json:"max_concurrency"`
+	// MaxRequestsPerBatch 单个批次允许的最大请求行数
+	MaxRequestsPerBatch int `json:"max_requests_per_batch"`
+	// PreferUpstream 输入文件绑定的渠道支持批处理时，优先转发到上游执行
+	PreferUpstream bool `json:"prefer_upstream"`
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:             true,
+	MaxConcurrency:      8,
+	MaxRequestsPerBatch: 50000,
+	PreferUpstream:      true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
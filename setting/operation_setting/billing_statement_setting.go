@@ -0,0 +1,26 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// BillingStatementSetting 月度账单配置
+type BillingStatementSetting struct {
+	AutoGenerate bool   `json:"auto_generate"` // 每月初自动为上月有账务往来的用户和所有组织生成账单
+	IssuerName   string `json:"issuer_name"`   // 账单抬头中的开具方名称
+	IssuerInfo   string `json:"issuer_info"`   // 开具方地址、税号等信息，显示在账单抬头
+}
+
+// 默认配置
+var billingStatementSetting = BillingStatementSetting{
+	AutoGenerate: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("billing_statement_setting", &billingStatementSetting)
+}
+
+func GetBillingStatementSetting() *BillingStatementSetting {
+	return &billingStatementSetting
+}
@@ -0,0 +1,99 @@
+package operation_setting
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 请求/响应体采集的存储方式
+const (
+	BodyCaptureStorageDB   = "db"   // 保存到日志数据库（配置了 LOG_SQL_DSN 时为独立的日志库）
+	BodyCaptureStorageFile = "file" // 以 JSONL 格式保存到本地文件，按天和大小轮转，仅支持单节点部署
+)
+
+// BodyCaptureSetting 请求/响应体采集配置，仅对开启的用户、令牌和分组生效
+type BodyCaptureSetting struct {
+	Enabled        bool            `json:"enabled"`
+	Users          map[string]bool `json:"users"`            // 开启采集的用户 ID
+	Tokens         map[string]bool `json:"tokens"`           // 开启采集的令牌 ID
+	Groups         map[string]bool `json:"groups"`           // 开启采集的分组
+	Storage        string          `json:"storage"`          // 存储方式：db / file
+	FileDir        string          `json:"file_dir"`         // 文件存储目录
+	FileMaxSizeMB  int             `json:"file_max_size_mb"` // 单个文件大小上限，超过后轮转
+	MaxBodyBytes   int             `json:"max_body_bytes"`   // 请求体和响应体各自最多保存的字节数
+	RetentionDays  int             `json:"retention_days"`   // 保留天数，过期后自动清理，0 表示不清理
+	RedactFields   []string        `json:"redact_fields"`    // 需要脱敏的 JSON 字段名，不区分大小写
+	RedactPatterns []string        `json:"redact_patterns"`  // 需要脱敏的正则表达式，如邮箱、手机号等个人信息
+	HashImages     bool            `json:"hash_images"`      // 将图片等 base64 数据替换为 sha256 摘要
+}
+
+// 默认配置
+var bodyCaptureSetting = BodyCaptureSetting{
+	Enabled:       false,
+	Users:         map[string]bool{},
+	Tokens:        map[string]bool{},
+	Groups:        map[string]bool{},
+	Storage:       BodyCaptureStorageDB,
+	FileDir:       "./logs/captures",
+	FileMaxSizeMB: 100,
+	MaxBodyBytes:  256 << 10,
+	RetentionDays: 7,
+	RedactFields: []string{
+		"api_key", "apikey", "authorization", "x-api-key", "password", "secret", "access_token", "refresh_token",
+	},
+	RedactPatterns: []string{
+		`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`, // 邮箱
+		`\b1[3-9]\d{9}\b`,         // 手机号
+		`\b\d{17}[\dXx]\b`,        // 身份证号
+		`\b(?:\d[ \-]?){13,19}\b`, // 银行卡号
+	},
+	HashImages: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("body_capture_setting", &bodyCaptureSetting)
+}
+
+func GetBodyCaptureSetting() *BodyCaptureSetting {
+	return &bodyCaptureSetting
+}
+
+// ShouldCapture 判断用户、令牌或分组是否开启了请求/响应体采集
+func (s *BodyCaptureSetting) ShouldCapture(userId int, tokenId int, group string) bool {
+	if !s.Enabled {
+		return false
+	}
+	return s.Users[strconv.Itoa(userId)] || s.Tokens[strconv.Itoa(tokenId)] || s.Groups[group]
+}
+
+// GetMaxBodyBytes 获取单个请求体或响应体最多保存的字节数，未配置时使用 256KB
+func (s *BodyCaptureSetting) GetMaxBodyBytes() int {
+	if s.MaxBodyBytes <= 0 {
+		return 256 << 10
+	}
+	return s.MaxBodyBytes
+}
+
+// GetFileMaxSize 获取单个采集文件的大小上限（字节），未配置时使用 100MB
+func (s *BodyCaptureSetting) GetFileMaxSize() int64 {
+	if s.FileMaxSizeMB <= 0 {
+		return 100 << 20
+	}
+	return int64(s.FileMaxSizeMB) << 20
+}
+
+// IsBodyCaptureFileStorageAllowed 本地文件只能在写入的节点上查询，从节点或启用 Redis 的多节点部署只能保存到数据库
+func IsBodyCaptureFileStorageAllowed() bool {
+	return common.IsMasterNode && !common.RedisEnabled
+}
+
+// GetStorage 获取实际使用的存储方式，多节点部署时即使配置了文件存储也保存到数据库
+func (s *BodyCaptureSetting) GetStorage() string {
+	if s.Storage == BodyCaptureStorageFile && IsBodyCaptureFileStorageAllowed() {
+		return BodyCaptureStorageFile
+	}
+	return BodyCaptureStorageDB
+}
@@ -0,0 +1,26 @@
+package operation_setting
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+func TestBodyCaptureStorageRequiresDBOnMultiNode(t *testing.T) {
+	isMasterNode, redisEnabled := common.IsMasterNode, common.RedisEnabled
+	t.Cleanup(func() { common.IsMasterNode, common.RedisEnabled = isMasterNode, redisEnabled })
+	setting := &BodyCaptureSetting{Storage: BodyCaptureStorageFile}
+
+	common.IsMasterNode, common.RedisEnabled = true, false
+	if setting.GetStorage() != BodyCaptureStorageFile {
+		t.Fatal("single node should use file storage")
+	}
+	common.IsMasterNode, common.RedisEnabled = false, false
+	if setting.GetStorage() != BodyCaptureStorageDB || IsBodyCaptureFileStorageAllowed() {
+		t.Fatal("slave node should use db storage")
+	}
+	common.IsMasterNode, common.RedisEnabled = true, true
+	if setting.GetStorage() != BodyCaptureStorageDB {
+		t.Fatal("redis backed multi node deployment should use db storage")
+	}
+}
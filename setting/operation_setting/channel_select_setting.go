@@ -0,0 +1,61 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// 渠道选择策略，仅作用于同一优先级内的渠道
+const (
+	ChannelSelectStrategyWeighted   = "weighted"    // 按权重随机，默认行为
+	ChannelSelectStrategyLatency    = "latency"     // 按 EWMA 延迟加权，越快的渠道分到的流量越多
+	ChannelSelectStrategyLeastBusy  = "least_busy"  // 选择进行中请求最少的渠道
+	ChannelSelectStrategyLowestCost = "lowest_cost" // 选择成本倍率最低的渠道
+)
+
+type ChannelSelectSetting struct {
+	// Strategy 默认策略
+	Strategy string `json:"strategy"`
+	// GroupStrategies 按分组覆盖默认策略
+	GroupStrategies map[string]string `json:"group_strategies"`
+	// ModelStrategies 按模型覆盖策略，优先级高于分组
+	ModelStrategies map[string]string `json:"model_strategies"`
+	// LatencyEWMAAlpha 延迟 EWMA 的平滑系数，越大越偏向最近的请求
+	LatencyEWMAAlpha float64 `json:"latency_ewma_alpha"`
+}
+
+// 默认配置
+var channelSelectSetting = ChannelSelectSetting{
+	Strategy:         ChannelSelectStrategyWeighted,
+	GroupStrategies:  map[string]string{},
+	ModelStrategies:  map[string]string{},
+	LatencyEWMAAlpha: 0.3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_select_setting", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+func IsValidChannelSelectStrategy(strategy string) bool {
+	switch strategy {
+	case ChannelSelectStrategyWeighted, ChannelSelectStrategyLatency, ChannelSelectStrategyLeastBusy, ChannelSelectStrategyLowestCost:
+		return true
+	}
+	return false
+}
+
+// GetChannelSelectStrategy 获取分组和模型对应的渠道选择策略，模型配置优先于分组配置
+func GetChannelSelectStrategy(group string, model string) string {
+	if strategy, ok := channelSelectSetting.ModelStrategies[model]; ok && IsValidChannelSelectStrategy(strategy) {
+		return strategy
+	}
+	if strategy, ok := channelSelectSetting.GroupStrategies[group]; ok && IsValidChannelSelectStrategy(strategy) {
+		return strategy
+	}
+	if IsValidChannelSelectStrategy(channelSelectSetting.Strategy) {
+		return channelSelectSetting.Strategy
+	}
+	return ChannelSelectStrategyWeighted
+}
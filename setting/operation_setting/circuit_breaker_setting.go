@@ -0,0 +1,59 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/common/breaker"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// CircuitBreakerSetting 渠道及多 key 熔断配置，熔断状态只保存在内存或 Redis 中，不会修改渠道状态
+type CircuitBreakerSetting struct {
+	Enabled            bool    `json:"enabled"`
+	FailureThreshold   int     `json:"failure_threshold"`    // 连续失败次数阈值，0 表示不按连续失败熔断
+	ErrorRateThreshold float64 `json:"error_rate_threshold"` // 错误率阈值，0 表示不按错误率熔断
+	MinRequests        int     `json:"min_requests"`         // 计算错误率所需的最少请求数
+	WindowSeconds      int     `json:"window_seconds"`       // 错误率统计的滑动窗口
+	CooldownSeconds    int     `json:"cooldown_seconds"`     // 熔断冷却时间
+	HalfOpenProbes     int     `json:"half_open_probes"`     // 半开状态的探测请求数
+}
+
+// 默认配置
+var circuitBreakerSetting = CircuitBreakerSetting{
+	Enabled:            false,
+	FailureThreshold:   5,
+	ErrorRateThreshold: 0.5,
+	MinRequests:        20,
+	WindowSeconds:      60,
+	CooldownSeconds:    30,
+	HalfOpenProbes:     3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("circuit_breaker_setting", &circuitBreakerSetting)
+}
+
+func GetCircuitBreakerSetting() *CircuitBreakerSetting {
+	return &circuitBreakerSetting
+}
+
+// BreakerConfig 转换为熔断器参数，非法值使用默认值
+func (s *CircuitBreakerSetting) BreakerConfig() breaker.Config {
+	cfg := breaker.Config{
+		FailureThreshold:   s.FailureThreshold,
+		ErrorRateThreshold: s.ErrorRateThreshold,
+		MinRequests:        s.MinRequests,
+		WindowSeconds:      s.WindowSeconds,
+		CooldownSeconds:    s.CooldownSeconds,
+		HalfOpenProbes:     s.HalfOpenProbes,
+	}
+	if cfg.WindowSeconds <= 0 {
+		cfg.WindowSeconds = 60
+	}
+	if cfg.CooldownSeconds <= 0 {
+		cfg.CooldownSeconds = 30
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	return cfg
+}
@@ -0,0 +1,60 @@
+package operation_setting
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ConcurrencyLimitSetting 令牌和用户的并发请求数限制，渠道的并发限制在渠道设置中配置
+type ConcurrencyLimitSetting struct {
+	Enabled             bool           `json:"enabled"`
+	DefaultTokenLimit   int            `json:"default_token_limit"`   // 令牌默认最大并发数，0 表示不限制
+	DefaultUserLimit    int            `json:"default_user_limit"`    // 用户默认最大并发数，0 表示不限制
+	TokenLimits         map[string]int `json:"token_limits"`          // 按令牌 ID 覆盖默认值
+	UserLimits          map[string]int `json:"user_limits"`           // 按用户 ID 覆盖默认值
+	QueueTimeoutSeconds int            `json:"queue_timeout_seconds"` // 超过并发数时的最长排队时间，0 表示直接拒绝
+	LeaseSeconds        int            `json:"lease_seconds"`         // 单个请求最长占用名额的时间，防止节点异常退出后名额无法释放
+}
+
+// 默认配置
+var concurrencyLimitSetting = ConcurrencyLimitSetting{
+	Enabled:             false,
+	DefaultTokenLimit:   0,
+	DefaultUserLimit:    0,
+	TokenLimits:         map[string]int{},
+	UserLimits:          map[string]int{},
+	QueueTimeoutSeconds: 0,
+	LeaseSeconds:        600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("concurrency_limit_setting", &concurrencyLimitSetting)
+}
+
+func GetConcurrencyLimitSetting() *ConcurrencyLimitSetting {
+	return &concurrencyLimitSetting
+}
+
+func (s *ConcurrencyLimitSetting) GetTokenLimit(tokenId int) int {
+	if limit, ok := s.TokenLimits[strconv.Itoa(tokenId)]; ok {
+		return limit
+	}
+	return s.DefaultTokenLimit
+}
+
+func (s *ConcurrencyLimitSetting) GetUserLimit(userId int) int {
+	if limit, ok := s.UserLimits[strconv.Itoa(userId)]; ok {
+		return limit
+	}
+	return s.DefaultUserLimit
+}
+
+// GetLeaseSeconds 获取名额占用时间，未配置时使用 10 分钟
+func (s *ConcurrencyLimitSetting) GetLeaseSeconds() int {
+	if s.LeaseSeconds <= 0 {
+		return 600
+	}
+	return s.LeaseSeconds
+}
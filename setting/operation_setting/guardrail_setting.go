@@ -0,0 +1,97 @@
+package operation_setting
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 护栏钩子的调用阶段
+const (
+	GuardrailStagePreRequest   = "pre_request"   // 转发上游之前审核请求，可放行、拦截或改写请求
+	GuardrailStagePostResponse = "post_response" // 返回客户端之前审核响应，流式响应默认在结束时追加错误事件
+)
+
+// 护栏钩子的接口格式
+const (
+	GuardrailFormatDefault          = "default"           // 自定义协议，返回 action 为 allow / block / rewrite
+	GuardrailFormatOpenAIModeration = "openai_moderation" // 兼容 OpenAI /v1/moderations 的接口，flagged 时拦截
+)
+
+// GuardrailHook 外部护栏钩子配置
+type GuardrailHook struct {
+	Name      string            `json:"name"`
+	Url       string            `json:"url"`
+	ChannelId int               `json:"channel_id"` // 使用已配置的 OpenAI Moderation 兼容渠道，调用渠道地址的 /v1/moderations，设置后忽略 url 和 format
+	Headers   map[string]string `json:"headers"`    // 请求头，如 Authorization
+	Format    string            `json:"format"`     // 接口格式：default / openai_moderation
+	Model     string            `json:"model"`      // openai_moderation 格式使用的模型
+	Stages    []string          `json:"stages"`     // 调用阶段：pre_request / post_response
+	Groups    map[string]bool   `json:"groups"`     // 生效的分组，与令牌都为空时对所有请求生效
+	Tokens    map[string]bool   `json:"tokens"`     // 生效的令牌 ID
+	TimeoutMs int               `json:"timeout_ms"` // 超时时间（毫秒）
+	FailOpen  bool              `json:"fail_open"`  // 调用失败或超时时放行请求，否则拦截
+	// 流式响应缓存到审核完成后再发送，拦截时只返回错误事件；不开启时流式响应边审核边发送，拦截时只能在末尾追加错误事件
+	BufferStream bool `json:"buffer_stream"`
+}
+
+// GuardrailSetting 外部护栏钩子配置，按配置顺序依次调用
+type GuardrailSetting struct {
+	Enabled bool            `json:"enabled"`
+	Hooks   []GuardrailHook `json:"hooks"`
+}
+
+// 默认配置
+var guardrailSetting = GuardrailSetting{
+	Enabled: false,
+	Hooks:   []GuardrailHook{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("guardrail_setting", &guardrailSetting)
+}
+
+func GetGuardrailSetting() *GuardrailSetting {
+	return &guardrailSetting
+}
+
+// GetHooks 获取指定阶段对令牌和分组生效的钩子
+func (s *GuardrailSetting) GetHooks(stage string, tokenId int, group string) []GuardrailHook {
+	if !s.Enabled {
+		return nil
+	}
+	var hooks []GuardrailHook
+	for _, hook := range s.Hooks {
+		if (hook.Url != "" || hook.ChannelId != 0) && hook.HasStage(stage) && hook.AppliesTo(tokenId, group) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+func (h *GuardrailHook) HasStage(stage string) bool {
+	for _, s := range h.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// AppliesTo 判断钩子是否对令牌或分组生效，未限定分组和令牌时对所有请求生效
+func (h *GuardrailHook) AppliesTo(tokenId int, group string) bool {
+	if len(h.Groups) == 0 && len(h.Tokens) == 0 {
+		return true
+	}
+	return h.Groups[group] || h.Tokens[strconv.Itoa(tokenId)]
+}
+
+// GetTimeout 获取钩子的超时时间，未配置时为 3 秒
+func (h *GuardrailHook) GetTimeout() time.Duration {
+	if h.TimeoutMs <= 0 {
+		return 3 * time.Second
+	}
+	return time.Duration(h.TimeoutMs) * time.Millisecond
+}
@@ -0,0 +1,71 @@
+package operation_setting
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// HedgeSetting 对冲请求配置，仅对开启的分组和令牌生效
+// 主渠道在延迟内未产生首个内容时，向另一个渠道发起相同请求，先产生内容的一方胜出
+type HedgeSetting struct {
+	Enabled         bool            `json:"enabled"`
+	Groups          map[string]bool `json:"groups"`           // 开启对冲的分组
+	Tokens          map[string]bool `json:"tokens"`           // 开启对冲的令牌 ID
+	DelayPercentile float64         `json:"delay_percentile"` // 按主渠道最近延迟的该百分位数确定对冲延迟
+	DefaultDelayMs  int             `json:"default_delay_ms"` // 渠道延迟样本不足时使用的对冲延迟
+	MinDelayMs      int             `json:"min_delay_ms"`     // 对冲延迟下限
+	MaxDelayMs      int             `json:"max_delay_ms"`     // 对冲延迟上限
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled:         false,
+	Groups:          map[string]bool{},
+	Tokens:          map[string]bool{},
+	DelayPercentile: 95,
+	DefaultDelayMs:  2000,
+	MinDelayMs:      200,
+	MaxDelayMs:      10000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// IsHedgeEnabled 判断分组或令牌是否开启了对冲请求
+func (s *HedgeSetting) IsHedgeEnabled(group string, tokenId int) bool {
+	if !s.Enabled {
+		return false
+	}
+	return s.Groups[group] || s.Tokens[strconv.Itoa(tokenId)]
+}
+
+// GetDelayPercentile 获取对冲延迟使用的百分位数，未配置时使用 P95
+func (s *HedgeSetting) GetDelayPercentile() float64 {
+	if s.DelayPercentile <= 0 || s.DelayPercentile > 100 {
+		return 95
+	}
+	return s.DelayPercentile
+}
+
+// GetHedgeDelay 根据渠道延迟的百分位数计算对冲延迟，latencyMs 为 0 表示样本不足
+func (s *HedgeSetting) GetHedgeDelay(latencyMs int64) time.Duration {
+	delayMs := int64(s.DefaultDelayMs)
+	if latencyMs > 0 {
+		delayMs = latencyMs
+	}
+	if s.MinDelayMs > 0 {
+		delayMs = max(delayMs, int64(s.MinDelayMs))
+	}
+	if s.MaxDelayMs > 0 {
+		delayMs = min(delayMs, int64(s.MaxDelayMs))
+	}
+	return time.Duration(delayMs) * time.Millisecond
+}
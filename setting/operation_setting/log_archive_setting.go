@@ -0,0 +1,55 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// 日志归档的存储方式
+const (
+	LogArchiveStorageLocal = "local" // 保存到本地目录
+	LogArchiveStorageS3    = "s3"    // 保存到 S3 兼容的对象存储（如 MinIO），访问密钥通过环境变量配置
+)
+
+// LogArchiveSetting 日志归档配置，超过保留天数的使用日志按天压缩为 NDJSON 文件归档后从数据库删除
+type LogArchiveSetting struct {
+	Enabled       bool   `json:"enabled"`
+	RetentionDays int    `json:"retention_days"` // 数据库中保留的天数，更早的日志会被归档
+	Storage       string `json:"storage"`        // 存储方式：local / s3
+	LocalDir      string `json:"local_dir"`      // 本地存储目录
+	S3Endpoint    string `json:"s3_endpoint"`    // 如 https://s3.us-east-1.amazonaws.com 或 http://127.0.0.1:9000
+	S3Region      string `json:"s3_region"`
+	S3Bucket      string `json:"s3_bucket"`
+	S3Prefix      string `json:"s3_prefix"`     // 对象名前缀
+	S3PathStyle   bool   `json:"s3_path_style"` // 使用路径风格访问存储桶，MinIO 需要开启
+	BatchSize     int    `json:"batch_size"`    // 单个归档文件最多包含的日志条数
+	RunHour       int    `json:"run_hour"`      // 每天执行归档的时间（小时，0-23）
+}
+
+// 默认配置
+var logArchiveSetting = LogArchiveSetting{
+	Enabled:       false,
+	RetentionDays: 30,
+	Storage:       LogArchiveStorageLocal,
+	LocalDir:      "./logs/archives",
+	S3Region:      "us-east-1",
+	S3Prefix:      "new-api/logs",
+	BatchSize:     50000,
+	RunHour:       3,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("log_archive_setting", &logArchiveSetting)
+}
+
+func GetLogArchiveSetting() *LogArchiveSetting {
+	return &logArchiveSetting
+}
+
+// GetBatchSize 获取单个归档文件最多包含的日志条数，未配置时使用 50000
+func (s *LogArchiveSetting) GetBatchSize() int {
+	if s.BatchSize <= 0 {
+		return 50000
+	}
+	return s.BatchSize
+}
@@ -0,0 +1,79 @@
+package operation_setting
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ResponseCacheSetting 响应缓存配置，对完全相同的请求直接返回缓存的响应，
+// 开启语义缓存后，精确匹配未命中时再按对话文本的向量相似度查找参数相同的请求
+type ResponseCacheSetting struct {
+	Enabled                bool           `json:"enabled"`
+	DefaultTTLSeconds      int            `json:"default_ttl_seconds"`      // 默认缓存时间，0 表示不缓存
+	GroupTTLSeconds        map[string]int `json:"group_ttl_seconds"`        // 按分组覆盖缓存时间，0 表示该分组不缓存
+	TokenTTLSeconds        map[string]int `json:"token_ttl_seconds"`        // 按令牌 ID 覆盖缓存时间，优先级高于分组
+	HitRatio               float64        `json:"hit_ratio"`                // 命中缓存时的计费倍率
+	RequireZeroTemperature bool           `json:"require_zero_temperature"` // 仅缓存 temperature 为 0 的请求
+	ShareAcrossUsers       bool           `json:"share_across_users"`       // 是否在不同用户之间共享缓存
+	MaxEntryKB             int            `json:"max_entry_kb"`             // 单条缓存的最大响应体大小
+	MemoryMaxEntries       int            `json:"memory_max_entries"`       // 未启用 Redis 时内存中最多保存的条目数
+	SemanticEnabled        bool           `json:"semantic_enabled"`         // 是否开启语义缓存，仅对纯文本对话生效
+	SemanticChannelId      int            `json:"semantic_channel_id"`      // 计算向量使用的 OpenAI Embeddings 兼容渠道
+	SemanticModel          string         `json:"semantic_model"`           // 向量模型
+	SemanticThreshold      float64        `json:"semantic_threshold"`       // 余弦相似度不低于该值时视为命中
+	SemanticMaxEntries     int            `json:"semantic_max_entries"`     // 每组请求参数下最多保存的向量条数，查找时逐条比较
+	SemanticTimeoutMs      int            `json:"semantic_timeout_ms"`      // 计算向量的超时时间（毫秒），超时视为未命中
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:                false,
+	DefaultTTLSeconds:      3600,
+	GroupTTLSeconds:        map[string]int{},
+	TokenTTLSeconds:        map[string]int{},
+	HitRatio:               0.1,
+	RequireZeroTemperature: true,
+	ShareAcrossUsers:       false,
+	MaxEntryKB:             512,
+	MemoryMaxEntries:       10000,
+	SemanticEnabled:        false,
+	SemanticChannelId:      0,
+	SemanticModel:          "text-embedding-3-small",
+	SemanticThreshold:      0.95,
+	SemanticMaxEntries:     500,
+	SemanticTimeoutMs:      3000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
+
+// GetTTLSeconds 获取缓存时间，令牌配置优先于分组配置，都未配置时使用默认值
+func (s *ResponseCacheSetting) GetTTLSeconds(group string, tokenId int) int {
+	if ttl, ok := s.TokenTTLSeconds[strconv.Itoa(tokenId)]; ok {
+		return ttl
+	}
+	if ttl, ok := s.GroupTTLSeconds[group]; ok {
+		return ttl
+	}
+	return s.DefaultTTLSeconds
+}
+
+// IsSemanticEnabled 语义缓存需要同时配置向量渠道
+func (s *ResponseCacheSetting) IsSemanticEnabled() bool {
+	return s.Enabled && s.SemanticEnabled && s.SemanticChannelId > 0 && s.SemanticThreshold > 0
+}
+
+func (s *ResponseCacheSetting) GetSemanticTimeout() time.Duration {
+	if s.SemanticTimeoutMs <= 0 {
+		return 3 * time.Second
+	}
+	return time.Duration(s.SemanticTimeoutMs) * time.Millisecond
+}
@@ -0,0 +1,48 @@
+package operation_setting
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// StreamFailoverSetting 流式请求首个内容到达前的故障转移配置
+// 开启后在收到首个内容增量前暂存上游数据，期间上游出错、超时或返回错误事件时切换渠道重试
+type StreamFailoverSetting struct {
+	Enabled                  bool `json:"enabled"`
+	FirstTokenTimeoutSeconds int  `json:"first_token_timeout_seconds"` // 等待首个内容的最长时间，0 表示使用 STREAMING_TIMEOUT
+	MaxBufferBytes           int  `json:"max_buffer_bytes"`            // 首个内容前最多暂存的字节数，超过后不再等待直接转发
+}
+
+// 默认配置
+var streamFailoverSetting = StreamFailoverSetting{
+	Enabled:                  false,
+	FirstTokenTimeoutSeconds: 30,
+	MaxBufferBytes:           1 << 20,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("stream_failover_setting", &streamFailoverSetting)
+}
+
+func GetStreamFailoverSetting() *StreamFailoverSetting {
+	return &streamFailoverSetting
+}
+
+// GetFirstTokenTimeout 获取等待首个内容的超时时间
+func (s *StreamFailoverSetting) GetFirstTokenTimeout() time.Duration {
+	if s.FirstTokenTimeoutSeconds > 0 {
+		return time.Duration(s.FirstTokenTimeoutSeconds) * time.Second
+	}
+	return time.Duration(constant.StreamingTimeout) * time.Second
+}
+
+// GetMaxBufferBytes 获取首个内容前最多暂存的字节数，未配置时使用 1MB
+func (s *StreamFailoverSetting) GetMaxBufferBytes() int {
+	if s.MaxBufferBytes <= 0 {
+		return 1 << 20
+	}
+	return s.MaxBufferBytes
+}
@@ -0,0 +1,28 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// SubscriptionSetting 订阅套餐配置
+type SubscriptionSetting struct {
+	Enabled           bool `json:"enabled"`
+	ExpireUnusedQuota bool `json:"expire_unused_quota"` // 周期结束时收回未用完的套餐额度
+	StripeGraceHours  int  `json:"stripe_grace_hours"`  // Stripe 订阅周期结束后等待续费成功的小时数，超时后订阅失效
+}
+
+// 默认配置
+var subscriptionSetting = SubscriptionSetting{
+	Enabled:           false,
+	ExpireUnusedQuota: true,
+	StripeGraceHours:  72,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("subscription_setting", &subscriptionSetting)
+}
+
+func GetSubscriptionSetting() *SubscriptionSetting {
+	return &subscriptionSetting
+}
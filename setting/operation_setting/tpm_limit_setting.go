@@ -0,0 +1,56 @@
+package operation_setting
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// TPMLimit 每分钟 token 数限制，0 表示不限制
+type TPMLimit struct {
+	InputTPM  int `json:"input_tpm"`
+	OutputTPM int `json:"output_tpm"`
+}
+
+// TPMLimitSetting 按 token 数限流的配置，各维度的限制同时生效
+type TPMLimitSetting struct {
+	Enabled     bool                `json:"enabled"`
+	TokenLimits map[string]TPMLimit `json:"token_limits"` // 按令牌 ID 限制
+	UserLimits  map[string]TPMLimit `json:"user_limits"`  // 按用户 ID 限制
+	GroupLimits map[string]TPMLimit `json:"group_limits"` // 按分组限制，分组内每个用户单独计算
+	ModelLimits map[string]TPMLimit `json:"model_limits"` // 按模型限制，每个用户单独计算
+}
+
+// 默认配置
+var tpmLimitSetting = TPMLimitSetting{
+	Enabled:     false,
+	TokenLimits: map[string]TPMLimit{},
+	UserLimits:  map[string]TPMLimit{},
+	GroupLimits: map[string]TPMLimit{},
+	ModelLimits: map[string]TPMLimit{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("tpm_limit_setting", &tpmLimitSetting)
+}
+
+func GetTPMLimitSetting() *TPMLimitSetting {
+	return &tpmLimitSetting
+}
+
+func (s *TPMLimitSetting) GetTokenLimit(tokenId int) TPMLimit {
+	return s.TokenLimits[strconv.Itoa(tokenId)]
+}
+
+func (s *TPMLimitSetting) GetUserLimit(userId int) TPMLimit {
+	return s.UserLimits[strconv.Itoa(userId)]
+}
+
+func (s *TPMLimitSetting) GetGroupLimit(group string) TPMLimit {
+	return s.GroupLimits[group]
+}
+
+func (s *TPMLimitSetting) GetModelLimit(model string) TPMLimit {
+	return s.ModelLimits[model]
+}
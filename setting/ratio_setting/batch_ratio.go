@@ -0,0 +1,51 @@
+package ratio_setting
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// BatchRatioDefaultKey 未单独配置的模型使用该键对应的批处理倍率
+const BatchRatioDefaultKey = "default"
+
+// defaultBatchRatio 批处理请求的计费倍率，与 OpenAI Batch API 的五折保持一致
+var defaultBatchRatio = map[string]float64{
+	BatchRatioDefaultKey: 0.5,
+}
+
+var batchRatioMap map[string]float64
+var batchRatioMapMutex sync.RWMutex
+
+// BatchRatio2JSONString converts the batch ratio map to a JSON string
+func BatchRatio2JSONString() string {
+	batchRatioMapMutex.RLock()
+	defer batchRatioMapMutex.RUnlock()
+	jsonBytes, err := json.Marshal(batchRatioMap)
+	if err != nil {
+		common.SysLog("error marshalling batch ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// UpdateBatchRatioByJSONString updates the batch ratio map from a JSON string
+func UpdateBatchRatioByJSONString(jsonStr string) error {
+	batchRatioMapMutex.Lock()
+	defer batchRatioMapMutex.Unlock()
+	batchRatioMap = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &batchRatioMap)
+}
+
+// GetBatchRatio returns the batch ratio for a model, falling back to the default entry
+func GetBatchRatio(name string) float64 {
+	batchRatioMapMutex.RLock()
+	defer batchRatioMapMutex.RUnlock()
+	if ratio, ok := batchRatioMap[FormatMatchingModelName(name)]; ok {
+		return ratio
+	}
+	if ratio, ok := batchRatioMap[BatchRatioDefaultKey]; ok {
+		return ratio
+	}
+	return 1
+}
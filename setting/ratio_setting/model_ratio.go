@@ -357,6 +357,11 @@ func InitRatioSettings() {
 	cacheRatioMap = defaultCacheRatio
 	cacheRatioMapMutex.Unlock()
 
+	// initialize batchRatioMap
+	batchRatioMapMutex.Lock()
+	batchRatioMap = defaultBatchRatio
+	batchRatioMapMutex.Unlock()
+
 	// initialize imageRatioMap
 	imageRatioMapMutex.Lock()
 	imageRatioMap = defaultImageRatio
@@ -5,11 +5,21 @@ import "strings"
 var CheckSensitiveEnabled = true
 var CheckSensitiveOnPromptEnabled = true
 
-//var CheckSensitiveOnCompletionEnabled = true
+var CheckSensitiveOnCompletionEnabled = false
 
 // StopOnSensitiveEnabled 如果检测到敏感词，是否立刻停止生成，否则替换敏感词
 var StopOnSensitiveEnabled = true
 
+// 模型输出命中敏感词时的处理方式
+const (
+	CompletionSensitiveActionMask     = "mask"     // 替换敏感词后继续输出
+	CompletionSensitiveActionTruncate = "truncate" // 在敏感词之前截断输出，结束原因为 content_filter
+	CompletionSensitiveActionAbort    = "abort"    // 中止输出并返回错误
+)
+
+// CompletionSensitiveAction 模型输出命中敏感词时的处理方式
+var CompletionSensitiveAction = CompletionSensitiveActionMask
+
 // StreamCacheQueueLength 流模式缓存队列长度，0表示无缓存
 var StreamCacheQueueLength = 0
 
@@ -38,6 +48,15 @@ func ShouldCheckPromptSensitive() bool {
 	return CheckSensitiveEnabled && CheckSensitiveOnPromptEnabled
 }
 
-//func ShouldCheckCompletionSensitive() bool {
-//	return CheckSensitiveEnabled && CheckSensitiveOnCompletionEnabled
-//}
+func ShouldCheckCompletionSensitive() bool {
+	return CheckSensitiveEnabled && CheckSensitiveOnCompletionEnabled
+}
+
+// GetCompletionSensitiveAction 获取模型输出命中敏感词时的处理方式，未知的配置按替换处理
+func GetCompletionSensitiveAction() string {
+	switch CompletionSensitiveAction {
+	case CompletionSensitiveActionTruncate, CompletionSensitiveActionAbort:
+		return CompletionSensitiveAction
+	}
+	return CompletionSensitiveActionMask
+}